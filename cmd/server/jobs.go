@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/services"
+)
+
+// registerJobs adds the maintenance jobs to the scheduler. Jobs that depend
+// on Redis are skipped when it is unavailable.
+func registerJobs(js *services.JobScheduler, ussd *services.USSDService, qr *services.QRService, h hsm.HSMInterface,
+	keys *services.HSMKeyService, limits *services.LimitsService, settlement *services.SettlementWorker, redisClient *redis.Client) {
+
+	register := func(name, spec string, run services.JobFunc) {
+		if err := js.Register(name, spec, run); err != nil {
+			log.Fatalf("Failed to register job: %v", err)
+		}
+	}
+
+	register("ussd-code-cleanup", "*/15 * * * *", ussd.CleanupExpiredCodes)
+	register("qr-code-expiry", "*/5 * * * *", qr.ExpireCodes)
+
+	register("hsm-key-rotation", "30 2 * * *", func(ctx context.Context) error {
+		if err := h.RotateKeys(); err != nil {
+			return err
+		}
+		return keys.SyncKeysToDatabase()
+	})
+
+	register("daily-limit-reset", "5 0 * * *", limits.ResetDailySpend)
+
+	if settlement != nil {
+		register("settlement-redrive", "@hourly", func(ctx context.Context) error {
+			_, err := settlement.RedriveDeadLetters(ctx)
+			return err
+		})
+	}
+
+	if redisClient != nil {
+		register("redis-key-sweep", "0 * * * *", func(ctx context.Context) error {
+			sweeps := []struct {
+				pattern string
+				ttl     time.Duration
+			}{
+				{"nonce:*", 10 * time.Minute},
+				{"idempotency:*", 24 * time.Hour},
+			}
+			for _, sweep := range sweeps {
+				if _, err := services.ExpireOrphanedKeys(ctx, redisClient, sweep.pattern, sweep.ttl); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
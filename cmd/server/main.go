@@ -14,6 +14,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/ruralpay/backend/docs"
+	"github.com/ruralpay/backend/internal/config"
 	"github.com/ruralpay/backend/internal/database"
 	"github.com/ruralpay/backend/internal/handlers"
 	"github.com/ruralpay/backend/internal/hsm"
@@ -57,8 +58,11 @@ func main() {
 	viper.BindEnv("hsm.master_key", "HSM_MASTER_KEY")
 	viper.BindEnv("hsm.salt", "HSM_SALT")
 	viper.BindEnv("hsm.key_store_path", "HSM_KEY_STORE_PATH")
-	viper.BindEnv("jwt.secret_key", "JWT_SECRET_KEY")
-	viper.BindEnv("jwt.expiry_hours", "JWT_EXPIRY_HOURS")
+	viper.BindEnv("hsm.key_reload_interval", "HSM_KEY_RELOAD_INTERVAL")
+	viper.BindEnv("audit.spill_file", "AUDIT_SPILL_FILE")
+	viper.BindEnv("jwt.signing_key_id", "JWT_SIGNING_KEY_ID")
+	viper.BindEnv("jwt.access_ttl", "JWT_ACCESS_TTL")
+	viper.BindEnv("jwt.refresh_ttl", "JWT_REFRESH_TTL")
 	viper.BindEnv("argon2.time", "ARGON2_TIME")
 	viper.BindEnv("argon2.memory", "ARGON2_MEMORY")
 	viper.BindEnv("argon2.threads", "ARGON2_THREADS")
@@ -81,11 +85,24 @@ func main() {
 	db := database.InitDatabase()
 	defer db.Close()
 
+	// CLI subcommands run against the database and exit
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		code := runReconcile(db, os.Args[2:])
+		db.Close()
+		os.Exit(code)
+	}
+
 	redisClient := database.InitRedis()
 	if redisClient != nil {
 		defer redisClient.Close()
 	}
 
+	// Persist audit events to the hash-chained audit_events table
+	auditStore := hsm.NewAuditStore(db, viper.GetString("audit.spill_file"))
+	auditStore.Start()
+	hsm.SetAuditStore(auditStore)
+	defer auditStore.Close()
+
 	hsm, err := hsm.InitHSM(hsm.Config{
 		MasterKey:       viper.GetString("hsm.master_key"),
 		KeyStorePath:    viper.GetString("hsm.key_store_path"),
@@ -96,6 +113,16 @@ func main() {
 		log.Fatalf("Failed to initialize HSM: %v", err)
 	}
 
+	// Key rotation runs on the leader only; every replica re-reads the
+	// shared key store to follow it
+	keyReloadInterval := viper.GetDuration("hsm.key_reload_interval")
+	if keyReloadInterval <= 0 {
+		keyReloadInterval = time.Minute
+	}
+	keyWatchCtx, stopKeyWatch := context.WithCancel(context.Background())
+	defer stopKeyWatch()
+	go hsm.WatchKeys(keyWatchCtx, keyReloadInterval)
+
 	// Sync HSM keys to database
 	hsmKeyService := services.NewHSMKeyService(db, hsm)
 	if err := hsmKeyService.SyncKeysToDatabase(); err != nil {
@@ -111,20 +138,56 @@ func main() {
 		}
 	}()
 
+	// OTPs must never be diverted to the file sink by a provider typo
+	if _, err := services.NewOTPSender(config.LoadOTPConfig()); err != nil {
+		log.Fatalf("Invalid OTP delivery configuration: %v", err)
+	}
+
 	transactionService := services.NewTransactionService(db, redisClient, hsm)
 	provisioningService := services.NewCardProvisioningService(db, hsm)
 	iso20022Service := services.NewISO20022Service()
-	authService := services.NewAuthService(db, redisClient)
-	ussdService := services.NewUSSDService(db, redisClient)
+	jwtSigningKeyID := viper.GetString("jwt.signing_key_id")
+	if jwtSigningKeyID == "" {
+		jwtSigningKeyID = services.DefaultJWTSigningKeyID
+	}
+	jwtKeys, err := services.NewJWTKeyring(hsm, jwtSigningKeyID)
+	if err != nil {
+		log.Fatalf("Failed to initialize JWT signing keys: %v", err)
+	}
+	authService := services.NewAuthService(db, redisClient, jwtKeys)
+	stepUpHandler := handlers.NewStepUpHandler(services.NewStepUpService(db, redisClient, hsm))
+	messageDeliveryHandler := handlers.NewMessageDeliveryHandler(services.NewOTPService(db, redisClient))
+	ussdService := services.NewUSSDService(db, redisClient, hsm)
 	ussdHandler := handlers.NewUSSDHandler(ussdService)
-	qrService := services.NewQRService(db, redisClient)
+	ussdGatewayHandler := handlers.NewUSSDGatewayHandler(services.NewUSSDSessionService(db, redisClient, ussdService, transactionService))
+	qrService := services.NewQRService(db, redisClient, hsm)
 	qrHandler := handlers.NewQRHandler(qrService)
 	bankService := services.NewBankService()
-	voiceService := services.NewVoiceBankingService()
+	beneficiaryHandler := handlers.NewBeneficiaryHandler(services.NewBeneficiaryService(db, transactionService, bankService))
+	voiceService := services.NewVoiceBankingService(db, redisClient, hsm, transactionService, ussdService, provisioningService)
 	defer voiceService.Close()
+	reconciliationService := services.NewReconciliationService(db)
+
+	// Settlement worker drains the queue filled by completed payments
+	var settlementWorker *services.SettlementWorker
+	if redisClient != nil {
+		settlementWorker = services.NewSettlementWorker(db, redisClient, iso20022Service, iso20022Service.Transport())
+		settlementWorker.Start()
+	}
+
+	// Maintenance jobs run on the elected leader replica only
+	jobScheduler := services.NewJobScheduler(db, redisClient)
+	registerJobs(jobScheduler, ussdService, qrService, hsm, hsmKeyService, services.NewLimitsService(db), settlementWorker, redisClient)
+	jobScheduler.Start()
 
 	// Initialize auth middleware with Redis
-	mW.InitAuthMiddleware(redisClient)
+	mW.InitAuthMiddleware(redisClient, jwtKeys.Keyfunc)
+
+	// Forwarding headers are only believed from these proxies
+	trustedProxies, err := mW.ParseTrustedProxies(config.TrustedProxies())
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Setup router
 	r := chi.NewRouter()
@@ -133,7 +196,7 @@ func main() {
 	r.Use(mW.SecurityHeaders)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.RealIP)
+	r.Use(mW.RealIP(trustedProxies))
 	r.Use(middleware.Timeout(60 * time.Second))
 
 	// CORS
@@ -151,6 +214,9 @@ func main() {
 		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 	})
 
+	// Public keys for verifying access tokens
+	r.Get("/.well-known/jwks.json", jwtKeys.ServeJWKS)
+
 	// Swagger documentation
 	r.Get("/swagger/*", httpSwagger.Handler(
 		httpSwagger.URL("http://localhost:8080/swagger/doc.json"),
@@ -171,15 +237,39 @@ func main() {
 		r.Post("/auth/register", authService.Register)
 		r.Post("/auth/login", authService.Login)
 		r.Post("/auth/logout", authService.Logout)
+		r.Post("/auth/refresh", authService.Refresh)
+		r.Post("/auth/unlock", authService.RequestUnlock)
+		r.Post("/auth/unlock/verify", authService.VerifyUnlock)
 		r.Get("/banks", bankService.GetAllBanks)
 		r.Post("/accounts/validate-bvn", authService.ValidateBVN)
 		r.Post("/accounts/verify-otp", authService.VerifyOTP)
 
+		// Inbound settlement callbacks (authenticated by HMAC signature)
+		r.Post("/iso20022/status-report", transactionService.ProcessStatusReport)
+
+		// USSD aggregator session callback (authenticated by shared gateway token)
+		r.Post("/ussd/callback", ussdGatewayHandler.Callback)
+
+		// SMS and email provider delivery reports (authenticated by shared callback token)
+		r.Post("/notifications/delivery-report", messageDeliveryHandler.DeliveryReport)
+
 		// Protected endpoints (auth required)
 		r.Group(func(r chi.Router) {
 			r.Use(mW.AuthMiddleware)
 
 			r.Get("/auth/account", authService.GetUserAccount)
+			r.Get("/auth/sessions", authService.GetSessions)
+			r.Delete("/auth/sessions/{sessionId}", authService.DeleteSession)
+			r.Delete("/auth/devices/{deviceId}/sessions", authService.DeleteDeviceSessions)
+
+			// Transaction PIN and biometric step-up
+			r.Post("/auth/pin", stepUpHandler.SetPIN)
+			r.Put("/auth/pin", stepUpHandler.ChangePIN)
+			r.Post("/auth/pin/reset", stepUpHandler.RequestPINReset)
+			r.Post("/auth/pin/reset/verify", stepUpHandler.ResetPIN)
+			r.Post("/auth/step-up/challenge", stepUpHandler.Challenge)
+			r.Post("/auth/biometric", stepUpHandler.EnableBiometric)
+			r.Delete("/auth/biometric/{deviceId}", stepUpHandler.DisableBiometric)
 
 			r.Get("/transactions", transactionService.ListTransactions)
 			r.Get("/transactions/{txId}", transactionService.GetTransaction)
@@ -187,6 +277,8 @@ func main() {
 			r.Post("/transactions/batch", transactionService.BatchTransactions)
 			r.Post("/transactions/external", transactionService.ExternalBankTransfer)
 			r.Get("/transactions/recent", transactionService.GetRecentTransactions)
+			r.Post("/transactions/{txId}/reverse", transactionService.ReverseTransaction)
+			r.Post("/transactions/{txId}/refund", transactionService.RefundTransaction)
 
 			// User account endpoint
 
@@ -200,6 +292,7 @@ func main() {
 			r.Get("/cards/{cardId}", provisioningService.GetCard)
 			r.Put("/cards/{cardId}/suspend", provisioningService.SuspendCard)
 			r.Put("/cards/{cardId}/reinstate", provisioningService.ReinstateCard)
+			r.Post("/cards/{cardId}/sync", transactionService.SyncCard)
 
 			// ISO 20022 endpoints
 			r.Post("/iso20022/convert", iso20022Service.ConvertToISO20022)
@@ -213,9 +306,30 @@ func main() {
 			// QR endpoints
 			r.Post("/qr/generate", qrHandler.GenerateQR)
 			r.Post("/qr/process", qrHandler.ProcessQR)
+			r.Post("/qr/pay", qrHandler.PayQR)
+			r.Get("/qr/{reference}/status", qrHandler.QRStatus)
+
+			// Beneficiary endpoints
+			r.Get("/beneficiaries", beneficiaryHandler.ListBeneficiaries)
+			r.Post("/beneficiaries", beneficiaryHandler.CreateBeneficiary)
+			r.Get("/beneficiaries/{id}", beneficiaryHandler.GetBeneficiary)
+			r.Patch("/beneficiaries/{id}", beneficiaryHandler.UpdateBeneficiary)
+			r.Delete("/beneficiaries/{id}", beneficiaryHandler.DeleteBeneficiary)
 
 			// Voice banking endpoints
 			r.Post("/transactions/voice-transcribe", voiceService.TranscribeAudio)
+			r.Post("/voice/command", voiceService.VoiceCommand)
+			r.Post("/voice/confirm", voiceService.ConfirmVoiceCommand)
+			r.Put("/voice/settings", voiceService.UpdateVoiceSettings)
+
+			// Admin endpoints
+			r.Group(func(r chi.Router) {
+				r.Use(mW.RequireRole(db, "admin"))
+
+				r.Get("/admin/ledger/trial-balance", reconciliationService.TrialBalance)
+				r.Get("/admin/jobs", jobScheduler.JobStatus)
+				r.Get("/admin/messages/{id}", messageDeliveryHandler.GetDelivery)
+			})
 		})
 	})
 
@@ -254,5 +368,11 @@ func main() {
 		log.Fatal("Server forced to shutdown:", err)
 	}
 
+	jobScheduler.Stop()
+
+	if settlementWorker != nil {
+		settlementWorker.Stop()
+	}
+
 	log.Println("Server stopped")
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ruralpay/backend/internal/services"
+)
+
+// runReconcile implements the "reconcile" subcommand. It prints the ledger
+// trial balance and exits non-zero when discrepancies are found so it can
+// gate the end-of-day close.
+//
+//	server reconcile [-format json|csv] [-out file] [-timeout 5m]
+func runReconcile(db *sql.DB, args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	format := fs.String("format", "json", "report format: json or csv")
+	out := fs.String("out", "", "write the report to this file instead of stdout")
+	timeout := fs.Duration("timeout", 5*time.Minute, "maximum time to spend on the check")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "json" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report, err := services.NewReconciliationService(db).Run(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "trial balance failed: %v\n", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", *out, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "csv" {
+		err = report.WriteCSV(w)
+	} else {
+		err = report.WriteJSON(w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "checked %d accounts and %d transactions: %d discrepancies\n",
+		report.AccountsChecked, report.TransactionsChecked, len(report.Discrepancies))
+	if !report.Balanced {
+		return 3
+	}
+	return 0
+}
@@ -25,7 +25,7 @@ func main() {
 	}
 
 	fmt.Println("=== Creating pacs.008 (FI to FI Customer Credit Transfer) ===")
-	
+
 	// Create pacs.008 message
 	pacs008, err := iso20022Service.CreatePacs008(tx)
 	if err != nil {
@@ -41,7 +41,7 @@ func main() {
 	fmt.Printf("pacs.008 XML:\n%s\n\n", xml.Header+string(xmlData))
 
 	fmt.Println("=== Creating pacs.002 (Payment Status Report) ===")
-	
+
 	// Create pacs.002 status report
 	pacs002, err := iso20022Service.CreatePacs002(tx, "ACCP")
 	if err != nil {
@@ -57,12 +57,15 @@ func main() {
 	fmt.Printf("pacs.002 XML:\n%s\n\n", xml.Header+string(xmlData2))
 
 	fmt.Println("=== Sending to Settlement ===")
-	
+
 	// Send to settlement system
-	err = iso20022Service.SendToSettlement(pacs008)
+	ack, err := iso20022Service.SendToSettlement(pacs008)
 	if err != nil {
 		log.Fatalf("Failed to send to settlement: %v", err)
 	}
+	if !ack.Accepted() {
+		log.Fatalf("Settlement rejected: %s %s", ack.ReasonCode, ack.Reason)
+	}
 
 	fmt.Println("Transaction successfully processed and sent to settlement!")
-}
\ No newline at end of file
+}
@@ -0,0 +1,7 @@
+package config
+
+// InstitutionCode returns our NIBSS institution code. Accounts held with
+// us carry it as their bank code, in QR codes and saved beneficiaries.
+func InstitutionCode() string {
+	return getEnv("QR_INSTITUTION_CODE", "999999")
+}
@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+// LoginConfig controls login lockout. Once an account, phone number or IP
+// reaches its failure limit, each further failure doubles the lockout,
+// starting at LockoutBase and capped at LockoutMax.
+type LoginConfig struct {
+	MaxAttempts   int
+	IPMaxAttempts int
+	LockoutBase   time.Duration
+	LockoutMax    time.Duration
+	// FailureWindow is how long failures are counted per phone number and IP
+	FailureWindow time.Duration
+}
+
+func LoadLoginConfig() *LoginConfig {
+	return &LoginConfig{
+		MaxAttempts:   getEnvAsInt("LOGIN_MAX_ATTEMPTS", 5),
+		IPMaxAttempts: getEnvAsInt("LOGIN_IP_MAX_ATTEMPTS", 20),
+		LockoutBase:   getEnvAsDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+		LockoutMax:    getEnvAsDuration("LOGIN_LOCKOUT_MAX", 24*time.Hour),
+		FailureWindow: getEnvAsDuration("LOGIN_FAILURE_WINDOW", 24*time.Hour),
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// OTPConfig controls one-time codes and the providers that deliver them
+// and transaction alerts. A recipient is sent at most one message per
+// ResendInterval and MaxSends per SendWindow.
+type OTPConfig struct {
+	TTL time.Duration
+	// MaxAttempts wrong codes discard an OTP
+	MaxAttempts int
+
+	ResendInterval time.Duration
+	MaxSends       int
+	SendWindow     time.Duration
+
+	// SMSProvider is http or file; EmailProvider is smtp or file. Both
+	// must be set, so the file sink is never used by accident
+	SMSProvider   string
+	SMSURL        string
+	SMSAPIKey     string
+	SMSSenderID   string
+	EmailProvider string
+	SMTPHost      string
+	SMTPPort      int
+	SMTPUsername  string
+	SMTPPassword  string
+	EmailFrom     string
+	// FileSink is where the file provider appends messages; empty writes
+	// them to the console
+	FileSink        string
+	ProviderTimeout time.Duration
+
+	// CallbackToken authenticates delivery reports from the providers
+	CallbackToken string
+}
+
+func LoadOTPConfig() *OTPConfig {
+	return &OTPConfig{
+		TTL:             getEnvAsDuration("OTP_TTL", 10*time.Minute),
+		MaxAttempts:     getEnvAsInt("OTP_MAX_ATTEMPTS", 3),
+		ResendInterval:  getEnvAsDuration("OTP_RESEND_INTERVAL", time.Minute),
+		MaxSends:        getEnvAsInt("OTP_MAX_SENDS", 5),
+		SendWindow:      getEnvAsDuration("OTP_SEND_WINDOW", time.Hour),
+		SMSProvider:     strings.ToLower(getEnv("OTP_SMS_PROVIDER", "")),
+		SMSURL:          getEnv("OTP_SMS_URL", ""),
+		SMSAPIKey:       getEnv("OTP_SMS_API_KEY", ""),
+		SMSSenderID:     getEnv("OTP_SMS_SENDER_ID", "RuralPay"),
+		EmailProvider:   strings.ToLower(getEnv("OTP_EMAIL_PROVIDER", "")),
+		SMTPHost:        getEnv("OTP_SMTP_HOST", ""),
+		SMTPPort:        getEnvAsInt("OTP_SMTP_PORT", 587),
+		SMTPUsername:    getEnv("OTP_SMTP_USERNAME", ""),
+		SMTPPassword:    getEnv("OTP_SMTP_PASSWORD", ""),
+		EmailFrom:       getEnv("OTP_EMAIL_FROM", "no-reply@ruralpay.ng"),
+		FileSink:        getEnv("OTP_FILE_SINK", ""),
+		ProviderTimeout: getEnvAsDuration("OTP_PROVIDER_TIMEOUT", 10*time.Second),
+		CallbackToken:   getEnv("OTP_CALLBACK_TOKEN", ""),
+	}
+}
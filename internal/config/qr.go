@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+type QRConfig struct {
+	MerchantGUID     string
+	InstitutionCode  string
+	MerchantCategory string
+	MerchantCity     string
+	SignatureGUID    string
+	SigningKeyID     string
+	DynamicTimeout   time.Duration
+}
+
+func LoadQRConfig() *QRConfig {
+	return &QRConfig{
+		MerchantGUID:     getEnv("QR_MERCHANT_GUID", "NG.COM.NIBSS-PLC.QRCODE"),
+		InstitutionCode:  InstitutionCode(),
+		MerchantCategory: getEnv("QR_MERCHANT_CATEGORY", "0000"),
+		MerchantCity:     getEnv("QR_MERCHANT_CITY", "Lagos"),
+		SignatureGUID:    getEnv("QR_SIGNATURE_GUID", "NG.RURALPAY.QRSIG"),
+		SigningKeyID:     getEnv("QR_SIGNING_KEY_ID", "transaction_signing"),
+		DynamicTimeout:   getEnvAsDuration("QR_DYNAMIC_TIMEOUT", 5*time.Minute),
+	}
+}
@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+// StepUpConfig controls step-up authentication of payments. Payments above
+// Threshold, in kobo, need the payer's transaction PIN or a biometric
+// assertion as well as an access token.
+type StepUpConfig struct {
+	Threshold int64
+	// ChallengeTTL is how long a biometric challenge may be signed
+	ChallengeTTL time.Duration
+
+	// PINMaxAttempts wrong PINs within PINLockout lock the PIN for PINLockout
+	PINMaxAttempts int
+	PINLockout     time.Duration
+}
+
+func LoadStepUpConfig() *StepUpConfig {
+	return &StepUpConfig{
+		Threshold:      int64(getEnvAsInt("STEP_UP_THRESHOLD", 500000)),
+		ChallengeTTL:   getEnvAsDuration("STEP_UP_CHALLENGE_TTL", 2*time.Minute),
+		PINMaxAttempts: getEnvAsInt("PIN_MAX_ATTEMPTS", 5),
+		PINLockout:     getEnvAsDuration("PIN_LOCKOUT", 30*time.Minute),
+	}
+}
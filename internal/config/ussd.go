@@ -16,6 +16,8 @@ type USSDConfig struct {
 	HashIterations       int
 	DialPrefix           string
 	DialSuffix           string
+	SessionTimeout       time.Duration
+	GatewayToken         string
 }
 
 func LoadUSSDConfig() *USSDConfig {
@@ -24,11 +26,13 @@ func LoadUSSDConfig() *USSDConfig {
 		CodeTimeout:          getEnvAsDuration("USSD_CODE_TIMEOUT", 5*time.Minute),
 		MaxGenerationPerUser: getEnvAsInt("USSD_MAX_GEN_PER_USER", 5),
 		RateLimitWindow:      getEnvAsDuration("USSD_RATE_LIMIT_WINDOW", 1*time.Hour),
-		PushCodePrefix:       getEnv("USSD_PUSH_PREFIX", "PUSH"),
-		PullCodePrefix:       getEnv("USSD_PULL_PREFIX", "PULL"),
+		PushCodePrefix:       getEnv("USSD_PUSH_PREFIX", "1"),
+		PullCodePrefix:       getEnv("USSD_PULL_PREFIX", "2"),
 		HashIterations:       getEnvAsInt("USSD_HASH_ITERATIONS", 10000),
 		DialPrefix:           getEnv("USSD_DIAL_PREFIX", "*565*1*"),
 		DialSuffix:           getEnv("USSD_DIAL_SUFFIX", "#"),
+		SessionTimeout:       getEnvAsDuration("USSD_SESSION_TIMEOUT", 3*time.Minute),
+		GatewayToken:         getEnv("USSD_GATEWAY_TOKEN", ""),
 	}
 }
 
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+type VoiceConfig struct {
+	CommandTTL      time.Duration
+	ConfirmAttempts int
+
+	// SpeechBackend names the engine used for languages without a route:
+	// google, local or fixture.
+	SpeechBackend string
+	// SpeechRoutes maps a language code (en-NG, yo) to an engine name
+	SpeechRoutes       map[string]string
+	LocalEngineURL     string
+	LocalEngineTimeout time.Duration
+	FixtureFile        string
+	FixtureDefault     string
+}
+
+func LoadVoiceConfig() *VoiceConfig {
+	return &VoiceConfig{
+		CommandTTL:         getEnvAsDuration("VOICE_COMMAND_TTL", 2*time.Minute),
+		ConfirmAttempts:    getEnvAsInt("VOICE_CONFIRM_ATTEMPTS", 3),
+		SpeechBackend:      strings.ToLower(getEnv("VOICE_SPEECH_BACKEND", "google")),
+		SpeechRoutes:       parseSpeechRoutes(getEnv("VOICE_SPEECH_ROUTES", "")),
+		LocalEngineURL:     getEnv("VOICE_LOCAL_ENGINE_URL", "http://localhost:8081/inference"),
+		LocalEngineTimeout: getEnvAsDuration("VOICE_LOCAL_ENGINE_TIMEOUT", 30*time.Second),
+		FixtureFile:        getEnv("VOICE_FIXTURE_FILE", ""),
+		FixtureDefault:     getEnv("VOICE_FIXTURE_DEFAULT", ""),
+	}
+}
+
+// parseSpeechRoutes reads "en-NG=google,yo=local" into a map keyed by the
+// lower-cased language code. Malformed entries are skipped.
+func parseSpeechRoutes(value string) map[string]string {
+	routes := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		lang, engine, ok := strings.Cut(entry, "=")
+		lang, engine = strings.ToLower(strings.TrimSpace(lang)), strings.ToLower(strings.TrimSpace(engine))
+		if !ok || lang == "" || engine == "" {
+			continue
+		}
+		routes[lang] = engine
+	}
+	return routes
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ruralpay/backend/internal/services"
+)
+
+type BeneficiaryHandler struct {
+	service   *services.BeneficiaryService
+	validator *services.ValidationHelper
+}
+
+func NewBeneficiaryHandler(service *services.BeneficiaryService) *BeneficiaryHandler {
+	return &BeneficiaryHandler{
+		service:   service,
+		validator: services.NewValidationHelper(),
+	}
+}
+
+// ListBeneficiaries lists the caller's saved beneficiaries
+// @Summary List Beneficiaries
+// @Description List saved transfer beneficiaries, nicknamed ones first
+// @Tags Beneficiaries
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} services.Beneficiary
+// @Failure 401 {object} services.ErrorResponse
+// @Router /beneficiaries [get]
+func (h *BeneficiaryHandler) ListBeneficiaries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	beneficiaries, err := h.service.List(r.Context(), userID)
+	if err != nil {
+		log.Printf("[BENEFICIARY] List failed for user %s: %v", userID, err)
+		services.SendErrorResponse(w, "Failed to load beneficiaries", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(beneficiaries)
+}
+
+// CreateBeneficiary saves a beneficiary after a name enquiry
+// @Summary Add Beneficiary
+// @Description Verify the account by name enquiry and save it with the verified account name and bank. The nickname is optional and unique per user, ignoring case
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{accountId=string,bankCode=string,nickname=string} true "Beneficiary details"
+// @Success 201 {object} services.Beneficiary
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Failure 409 {object} services.ErrorResponse
+// @Router /beneficiaries [post]
+func (h *BeneficiaryHandler) CreateBeneficiary(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req struct {
+		AccountID string `json:"accountId" validate:"required,numeric,min=10,max=20"`
+		BankCode  string `json:"bankCode" validate:"required,alphanum,min=3,max=6"`
+		Nickname  string `json:"nickname" validate:"max=30"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if err := h.validator.ValidateStruct(&req); err != nil {
+		services.SendErrorResponse(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	beneficiary, err := h.service.Create(r.Context(), userID, req.AccountID, req.BankCode, req.Nickname)
+	if err != nil {
+		sendBeneficiaryError(w, userID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(beneficiary)
+}
+
+// GetBeneficiary returns one saved beneficiary
+// @Summary Get Beneficiary
+// @Tags Beneficiaries
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Beneficiary ID"
+// @Success 200 {object} services.Beneficiary
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Router /beneficiaries/{id} [get]
+func (h *BeneficiaryHandler) GetBeneficiary(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	id, ok := beneficiaryID(w, r)
+	if !ok {
+		return
+	}
+
+	beneficiary, err := h.service.Get(r.Context(), userID, id)
+	if err != nil {
+		sendBeneficiaryError(w, userID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(beneficiary)
+}
+
+// UpdateBeneficiary changes a beneficiary's nickname
+// @Summary Rename Beneficiary
+// @Description Set the nickname of a beneficiary. An empty nickname removes it. Account details cannot be changed; delete and re-add the beneficiary instead
+// @Tags Beneficiaries
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Beneficiary ID"
+// @Param request body object{nickname=string} true "New nickname"
+// @Success 200 {object} services.Beneficiary
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Failure 409 {object} services.ErrorResponse
+// @Router /beneficiaries/{id} [patch]
+func (h *BeneficiaryHandler) UpdateBeneficiary(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	id, ok := beneficiaryID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Nickname string `json:"nickname" validate:"max=30"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if err := h.validator.ValidateStruct(&req); err != nil {
+		services.SendErrorResponse(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	beneficiary, err := h.service.Rename(r.Context(), userID, id, req.Nickname)
+	if err != nil {
+		sendBeneficiaryError(w, userID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(beneficiary)
+}
+
+// DeleteBeneficiary removes a saved beneficiary
+// @Summary Delete Beneficiary
+// @Tags Beneficiaries
+// @Security BearerAuth
+// @Param id path int true "Beneficiary ID"
+// @Success 204
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Router /beneficiaries/{id} [delete]
+func (h *BeneficiaryHandler) DeleteBeneficiary(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	id, ok := beneficiaryID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), userID, id); err != nil {
+		sendBeneficiaryError(w, userID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func beneficiaryID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		services.SendErrorResponse(w, "Invalid beneficiary ID", http.StatusBadRequest, nil)
+		return 0, false
+	}
+	return id, true
+}
+
+// decodeRequest reads a single JSON object with no unknown fields into dst
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		services.SendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return false
+	}
+
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		services.SendErrorResponse(w, "Request body must only contain a single JSON object", http.StatusBadRequest, nil)
+		return false
+	}
+	return true
+}
+
+func sendBeneficiaryError(w http.ResponseWriter, userID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrBeneficiaryNotFound):
+		services.SendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+	case errors.Is(err, services.ErrAccountNotFound):
+		services.SendErrorResponse(w, services.ErrAccountNotFound.Error(), http.StatusNotFound, nil)
+	case errors.Is(err, services.ErrBeneficiaryExists), errors.Is(err, services.ErrNicknameTaken):
+		services.SendErrorResponse(w, err.Error(), http.StatusConflict, nil)
+	case errors.Is(err, services.ErrUnknownBank), errors.Is(err, services.ErrInvalidNickname), errors.Is(err, services.ErrAccountNotActive):
+		services.SendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+	default:
+		log.Printf("[BENEFICIARY] Request failed for user %s: %v", userID, err)
+		services.SendErrorResponse(w, "Failed to process beneficiary", http.StatusInternalServerError, nil)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ruralpay/backend/internal/services"
+)
+
+type MessageDeliveryHandler struct {
+	service *services.OTPService
+}
+
+func NewMessageDeliveryHandler(service *services.OTPService) *MessageDeliveryHandler {
+	return &MessageDeliveryHandler{service: service}
+}
+
+// DeliveryReport records an SMS or email provider's delivery report
+// @Summary Message Delivery Report
+// @Description Update the delivery status of an OTP or alert from its provider's report. Only delivered and failed outcomes are recorded; interim statuses are acknowledged and ignored
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Param X-Delivery-Token header string true "Shared callback token"
+// @Param request body object{message_id=string,status=string} true "Delivery report"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Failure 503 {object} services.ErrorResponse
+// @Router /notifications/delivery-report [post]
+func (h *MessageDeliveryHandler) DeliveryReport(w http.ResponseWriter, r *http.Request) {
+	token := h.service.CallbackToken()
+	if token == "" {
+		log.Printf("[OTP] OTP_CALLBACK_TOKEN is not configured")
+		services.SendErrorResponse(w, "Delivery reports are not configured", http.StatusServiceUnavailable, nil)
+		return
+	}
+
+	presented := r.Header.Get("X-Delivery-Token")
+	if presented == "" {
+		presented = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+		log.Printf("[OTP] Rejected delivery report with invalid token from %s", r.RemoteAddr)
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	// Provider reports carry more fields than these, so unknown ones are allowed
+	var req struct {
+		MessageID string `json:"message_id"`
+		Status    string `json:"status"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		services.SendErrorResponse(w, "message_id and status are required", http.StatusBadRequest, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	status, ok := services.ParseDeliveryStatus(req.Status)
+	if !ok {
+		json.NewEncoder(w).Encode(map[string]string{"message": "Status ignored"})
+		return
+	}
+
+	err := h.service.UpdateDeliveryStatus(r.Context(), req.MessageID, status, req.Status)
+	if errors.Is(err, services.ErrDeliveryNotFound) {
+		services.SendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[OTP] Failed to record delivery report for %s: %v", req.MessageID, err)
+		services.SendErrorResponse(w, "Failed to record delivery report", http.StatusInternalServerError, nil)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "Delivery status updated"})
+}
+
+// GetDelivery returns the delivery status of a message
+// @Summary Get Message Delivery
+// @Tags Notifications
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Message ID"
+// @Success 200 {object} services.MessageDelivery
+// @Failure 404 {object} services.ErrorResponse
+// @Router /admin/messages/{id} [get]
+func (h *MessageDeliveryHandler) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, err := h.service.Delivery(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, services.ErrDeliveryNotFound) {
+		services.SendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[OTP] Failed to load delivery: %v", err)
+		services.SendErrorResponse(w, "Failed to load message delivery", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delivery)
+}
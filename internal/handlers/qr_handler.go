@@ -2,12 +2,21 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/ruralpay/backend/internal/models"
 	"github.com/ruralpay/backend/internal/services"
 )
 
+// maxQRStatusWait caps how long a status request may long-poll. It stays
+// below the server write timeout.
+const maxQRStatusWait = 10 * time.Second
+
 type QRHandler struct {
 	service   *services.QRService
 	validator *services.ValidationHelper
@@ -20,15 +29,15 @@ func NewQRHandler(service *services.QRService) *QRHandler {
 	}
 }
 
-// GenerateQR generates a QR code with amount
+// GenerateQR generates a signed EMVCo merchant QR code
 // @Summary Generate QR Code
-// @Description Generate a QR code for payment with specified amount
+// @Description Generate a signed EMVCo (NQR) merchant-presented QR code. Omit the amount, or send 0, for a reusable static code; a positive amount yields a single-use dynamic code
 // @Tags QR
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param request body object{amount=int64} true "QR generation request"
-// @Success 200 {object} object{qrCode=string}
+// @Success 200 {object} object{qrCode=string,qrImage=string,type=string,reference=string,expiresAt=string}
 // @Failure 400 {object} services.ErrorResponse
 // @Failure 401 {object} services.ErrorResponse
 // @Router /qr/generate [post]
@@ -40,7 +49,7 @@ func (h *QRHandler) GenerateQR(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Amount int64 `json:"amount" validate:"required,gt=0"`
+		Amount int64 `json:"amount" validate:"gte=0"`
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
@@ -62,7 +71,7 @@ func (h *QRHandler) GenerateQR(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	qrCode, qrImage, err := h.service.GenerateQRCode(r.Context(), userID, req.Amount)
+	qr, err := h.service.GenerateQRCode(r.Context(), userID, req.Amount)
 	if err != nil {
 		services.SendErrorResponse(w, err.Error(), http.StatusInternalServerError, nil)
 		return
@@ -70,21 +79,24 @@ func (h *QRHandler) GenerateQR(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"success": true,
-		"qrCode":  qrCode,
-		"qrImage": qrImage,
+		"success":   true,
+		"qrCode":    qr.QRCode,
+		"qrImage":   qr.QRImage,
+		"type":      qr.Type,
+		"reference": qr.Reference,
+		"expiresAt": qr.ExpiresAt,
 	})
 }
 
 // ProcessQR processes a scanned QR code
 // @Summary Process QR Code
-// @Description Process a scanned QR code data
+// @Description Verify the CRC and signature of a scanned EMVCo QR payload and return its merchant, amount and payment state for the scanner to confirm
 // @Tags QR
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param request body object{qrData=string} true "QR processing request"
-// @Success 200 {object} object{userId=string,amount=int64}
+// @Success 200 {object} object{userId=string,amount=int64,type=string,merchantName=string,accountNumber=string,bankCode=string,reference=string,status=string}
 // @Failure 400 {object} services.ErrorResponse
 // @Router /qr/process [post]
 func (h *QRHandler) ProcessQR(w http.ResponseWriter, r *http.Request) {
@@ -123,3 +135,121 @@ func (h *QRHandler) ProcessQR(w http.ResponseWriter, r *http.Request) {
 		"data":    result,
 	})
 }
+
+// PayQR pays a scanned QR code
+// @Summary Pay QR Code
+// @Description Confirm payment of a scanned QR code. The scanner's account is debited and the code owner's credited through the ledger. Amount is required for static codes and must match dynamic ones
+// @Tags QR
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{qrData=string,amount=int64,narration=string,pin=string,biometric=models.BiometricAssertion} true "QR payment request; amounts above the step-up threshold need pin or biometric"
+// @Success 200 {object} services.QRReceipt
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 403 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Failure 409 {object} services.ErrorResponse
+// @Failure 422 {object} services.ErrorResponse
+// @Failure 423 {object} services.ErrorResponse
+// @Router /qr/pay [post]
+func (h *QRHandler) PayQR(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req struct {
+		QRData    string `json:"qrData" validate:"required"`
+		Amount    int64  `json:"amount" validate:"gte=0"`
+		Narration string `json:"narration" validate:"max=200"`
+		models.StepUpProof
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		services.SendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		services.SendErrorResponse(w, "Request body must only contain a single JSON object", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := h.validator.ValidateStruct(&req); err != nil {
+		services.SendErrorResponse(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	receipt, err := h.service.PayQRCode(r.Context(), userID, req.QRData, req.Amount, req.Narration, req.StepUpProof)
+	if err != nil {
+		log.Printf("[QR] PayQR - Payment failed: %v", err)
+		if services.SendStepUpErrorResponse(w, err) {
+			return
+		}
+		var limitErr *services.LimitError
+		switch {
+		case errors.As(err, &limitErr):
+			services.SendLimitErrorResponse(w, limitErr)
+		case errors.Is(err, services.ErrQRNotFound):
+			services.SendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+		case errors.Is(err, services.ErrQRAlreadyPaid), errors.Is(err, services.ErrQRExpired):
+			services.SendErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		default:
+			services.SendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// QRStatus reports the payment state of a generated QR code
+// @Summary QR Code Status
+// @Description Get the payment state of one of the caller's QR codes. Pass wait (e.g. 10s, the maximum) to long-poll a pending dynamic code until it is paid
+// @Tags QR
+// @Produce json
+// @Security BearerAuth
+// @Param reference path string true "QR code reference"
+// @Param wait query string false "How long to wait for payment, as a Go duration"
+// @Success 200 {object} services.QRCodeStatus
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Router /qr/{reference}/status [get]
+func (h *QRHandler) QRStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var wait time.Duration
+	if raw := r.URL.Query().Get("wait"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			services.SendErrorResponse(w, "Invalid wait duration", http.StatusBadRequest, nil)
+			return
+		}
+		wait = min(parsed, maxQRStatusWait)
+	}
+
+	status, err := h.service.CodeStatus(r.Context(), userID, chi.URLParam(r, "reference"), wait)
+	if errors.Is(err, services.ErrQRNotFound) {
+		services.SendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		services.SendErrorResponse(w, err.Error(), http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ruralpay/backend/internal/services"
+)
+
+type StepUpHandler struct {
+	service   *services.StepUpService
+	validator *services.ValidationHelper
+}
+
+func NewStepUpHandler(service *services.StepUpService) *StepUpHandler {
+	return &StepUpHandler{
+		service:   service,
+		validator: services.NewValidationHelper(),
+	}
+}
+
+// SetPIN sets the caller's transaction PIN
+// @Summary Set Transaction PIN
+// @Description Set the 4 to 6 digit PIN that confirms payments above the step-up threshold. Use change or reset once a PIN is set
+// @Tags Security
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{pin=string} true "New PIN"
+// @Success 201 {object} map[string]string
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 409 {object} services.ErrorResponse
+// @Router /auth/pin [post]
+func (h *StepUpHandler) SetPIN(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req struct {
+		PIN string `json:"pin" validate:"required,numeric,min=4,max=6"`
+	}
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	err := h.service.SetPIN(r.Context(), userID, req.PIN)
+	if errors.Is(err, services.ErrPINAlreadySet) {
+		services.SendErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[STEP_UP] SetPIN failed for user %s: %v", userID, err)
+		services.SendErrorResponse(w, "Failed to set transaction PIN", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Transaction PIN set"})
+}
+
+// ChangePIN replaces the caller's transaction PIN
+// @Summary Change Transaction PIN
+// @Description Replace the transaction PIN. A wrong current PIN counts towards the PIN lockout
+// @Tags Security
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{currentPin=string,newPin=string} true "Current and new PIN"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 403 {object} services.ErrorResponse
+// @Failure 423 {object} services.ErrorResponse
+// @Router /auth/pin [put]
+func (h *StepUpHandler) ChangePIN(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req struct {
+		CurrentPIN string `json:"currentPin" validate:"required,numeric,min=4,max=6"`
+		NewPIN     string `json:"newPin" validate:"required,numeric,min=4,max=6"`
+	}
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.service.ChangePIN(r.Context(), userID, req.CurrentPIN, req.NewPIN); err != nil {
+		if !services.SendStepUpErrorResponse(w, err) {
+			log.Printf("[STEP_UP] ChangePIN failed for user %s: %v", userID, err)
+			services.SendErrorResponse(w, "Failed to change transaction PIN", http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Transaction PIN changed"})
+}
+
+// RequestPINReset sends an OTP that resets a forgotten transaction PIN
+// @Summary Request Transaction PIN Reset
+// @Description Send an OTP to the caller's phone number for /auth/pin/reset/verify
+// @Tags Security
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 429 {object} services.ErrorResponse
+// @Failure 500 {object} services.ErrorResponse
+// @Failure 502 {object} services.ErrorResponse
+// @Router /auth/pin/reset [post]
+func (h *StepUpHandler) RequestPINReset(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	err := h.service.RequestPINReset(r.Context(), userID)
+	if services.SendOTPThrottleResponse(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrOTPDeliveryFailed) {
+		services.SendErrorResponse(w, "Failed to send PIN reset code", http.StatusBadGateway, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[STEP_UP] RequestPINReset failed for user %s: %v", userID, err)
+		services.SendErrorResponse(w, "Failed to send PIN reset code", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "A PIN reset code has been sent to your phone"})
+}
+
+// ResetPIN sets a new transaction PIN with a reset OTP
+// @Summary Reset Transaction PIN
+// @Description Set a new transaction PIN with the OTP from /auth/pin/reset, lifting any PIN lockout. Too many wrong codes discard the OTP
+// @Tags Security
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{otp=string,newPin=string} true "Reset OTP and new PIN"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Router /auth/pin/reset/verify [post]
+func (h *StepUpHandler) ResetPIN(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req struct {
+		OTP    string `json:"otp" validate:"required,len=8"`
+		NewPIN string `json:"newPin" validate:"required,numeric,min=4,max=6"`
+	}
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	err := h.service.ResetPIN(r.Context(), userID, req.OTP, req.NewPIN)
+	if errors.Is(err, services.ErrOTPInvalid) {
+		services.SendErrorResponse(w, "Invalid or expired OTP", http.StatusBadRequest, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[STEP_UP] ResetPIN failed for user %s: %v", userID, err)
+		services.SendErrorResponse(w, "Failed to reset transaction PIN", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Transaction PIN reset"})
+}
+
+// Challenge issues a challenge for biometric step-up
+// @Summary Step-up Challenge
+// @Description Issue a single-use challenge. The app signs it with the device's biometric key and sends it as the biometric assertion of a payment
+// @Tags Security
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{challenge=string,expiresIn=int}
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 500 {object} services.ErrorResponse
+// @Router /auth/step-up/challenge [post]
+func (h *StepUpHandler) Challenge(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	challenge, err := h.service.IssueChallenge(r.Context(), userID)
+	if err != nil {
+		log.Printf("[STEP_UP] Challenge failed for user %s: %v", userID, err)
+		services.SendErrorResponse(w, "Failed to issue challenge", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"challenge": challenge,
+		"expiresIn": int(h.service.ChallengeTTL().Seconds()),
+	})
+}
+
+// EnableBiometric registers a device key for biometric step-up
+// @Summary Enable Biometric Confirmation
+// @Description Register the PEM public key of an ECDSA P-256 key pair the device only uses after a biometric check. Requires the transaction PIN
+// @Tags Security
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{deviceId=string,publicKey=string,pin=string} true "Device key"
+// @Success 201 {object} map[string]string
+// @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 403 {object} services.ErrorResponse
+// @Failure 423 {object} services.ErrorResponse
+// @Router /auth/biometric [post]
+func (h *StepUpHandler) EnableBiometric(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req struct {
+		DeviceID  string `json:"deviceId" validate:"required,max=255"`
+		PublicKey string `json:"publicKey" validate:"required,max=1024"`
+		PIN       string `json:"pin" validate:"required,numeric,min=4,max=6"`
+	}
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	err := h.service.EnableBiometric(r.Context(), userID, req.PIN, req.DeviceID, req.PublicKey)
+	if errors.Is(err, services.ErrInvalidBiometricKey) {
+		services.SendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+		return
+	}
+	if err != nil {
+		if !services.SendStepUpErrorResponse(w, err) {
+			log.Printf("[STEP_UP] EnableBiometric failed for user %s: %v", userID, err)
+			services.SendErrorResponse(w, "Failed to enable biometric confirmation", http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Biometric confirmation enabled"})
+}
+
+// DisableBiometric removes a device's biometric key
+// @Summary Disable Biometric Confirmation
+// @Tags Security
+// @Security BearerAuth
+// @Param deviceId path string true "Device ID"
+// @Success 204
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 404 {object} services.ErrorResponse
+// @Router /auth/biometric/{deviceId} [delete]
+func (h *StepUpHandler) DisableBiometric(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	err := h.service.DisableBiometric(r.Context(), userID, chi.URLParam(r, "deviceId"))
+	if errors.Is(err, services.ErrBiometricNotFound) {
+		services.SendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[STEP_UP] DisableBiometric failed for user %s: %v", userID, err)
+		services.SendErrorResponse(w, "Failed to disable biometric confirmation", http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *StepUpHandler) decode(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if !decodeRequest(w, r, dst) {
+		return false
+	}
+	if err := h.validator.ValidateStruct(dst); err != nil {
+		services.SendErrorResponse(w, "Validation failed", http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+
+	"github.com/ruralpay/backend/internal/services"
+)
+
+type USSDGatewayHandler struct {
+	session *services.USSDSessionService
+}
+
+func NewUSSDGatewayHandler(session *services.USSDSessionService) *USSDGatewayHandler {
+	return &USSDGatewayHandler{session: session}
+}
+
+// Callback serves the USSD aggregator session callback
+// @Summary USSD Session Callback
+// @Description Advance a menu-driven USSD session. Replies are plain text prefixed with CON (session continues) or END (session over).
+// @Tags USSD
+// @Accept x-www-form-urlencoded
+// @Produce plain
+// @Param X-USSD-Gateway-Token header string true "Shared gateway token"
+// @Param sessionId formData string true "Aggregator session ID"
+// @Param serviceCode formData string true "Dialled service code"
+// @Param phoneNumber formData string true "Subscriber MSISDN"
+// @Param text formData string false "Inputs so far, separated by *"
+// @Success 200 {string} string "CON or END reply"
+// @Failure 400 {string} string
+// @Failure 401 {string} string
+// @Failure 503 {string} string
+// @Router /ussd/callback [post]
+func (h *USSDGatewayHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	token := h.session.GatewayToken()
+	if token == "" {
+		log.Printf("[USSD_GATEWAY] USSD_GATEWAY_TOKEN is not configured")
+		http.Error(w, "USSD gateway is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	presented := r.Header.Get("X-USSD-Gateway-Token")
+	if presented == "" {
+		presented = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+		log.Printf("[USSD_GATEWAY] Rejected callback with invalid token from %s", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req := services.USSDSessionRequest{
+		SessionID:   r.PostForm.Get("sessionId"),
+		ServiceCode: r.PostForm.Get("serviceCode"),
+		PhoneNumber: r.PostForm.Get("phoneNumber"),
+		Text:        r.PostForm.Get("text"),
+	}
+	if req.SessionID == "" || req.PhoneNumber == "" {
+		http.Error(w, "sessionId and phoneNumber are required", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[USSD_GATEWAY] Session %s from %s", req.SessionID, req.ServiceCode)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(h.session.Handle(r.Context(), req)))
+}
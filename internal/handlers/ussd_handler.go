@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 
+	"github.com/ruralpay/backend/internal/models"
 	"github.com/ruralpay/backend/internal/services"
 )
 
@@ -28,10 +30,12 @@ func NewUSSDHandler(service *services.USSDService) *USSDHandler {
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param request body object{type=string,amount=int64,currency=string} true "USSD code request"
+// @Param request body object{type=string,amount=int64,currency=string,pin=string,biometric=models.BiometricAssertion} true "USSD code request; Send codes above the step-up threshold need pin or biometric"
 // @Success 200 {object} object{code=string}
 // @Failure 400 {object} services.ErrorResponse
 // @Failure 401 {object} services.ErrorResponse
+// @Failure 403 {object} services.ErrorResponse
+// @Failure 423 {object} services.ErrorResponse
 // @Failure 500 {object} services.ErrorResponse
 // @Router /ussd/generate [post]
 func (h *USSDHandler) GenerateCode(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +51,7 @@ func (h *USSDHandler) GenerateCode(w http.ResponseWriter, r *http.Request) {
 		Type     string `json:"type" validate:"required,oneof=Send Receive"`
 		Amount   int64  `json:"amount" validate:"required,gt=0"`
 		Currency string `json:"currency,omitempty"`
+		models.StepUpProof
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
@@ -76,13 +81,16 @@ func (h *USSDHandler) GenerateCode(w http.ResponseWriter, r *http.Request) {
 	var code string
 	var err error
 	if req.Type == "Send" {
-		code, err = h.service.GeneratePushCode(r.Context(), userID, req.Amount)
+		code, err = h.service.GeneratePushCode(r.Context(), userID, req.Amount, req.StepUpProof)
 	} else {
 		code, err = h.service.GeneratePullCode(r.Context(), userID, req.Amount)
 	}
 
 	if err != nil {
 		log.Printf("[USSD] GenerateCode - Service error: %v", err)
+		if services.SendStepUpErrorResponse(w, err) {
+			return
+		}
 		services.SendErrorResponse(w, err.Error(), http.StatusInternalServerError, nil)
 		return
 	}
@@ -102,18 +110,30 @@ func (h *USSDHandler) GenerateCode(w http.ResponseWriter, r *http.Request) {
 
 // ValidateCode validates and consumes a USSD code
 // @Summary Validate USSD Code
-// @Description Validate and consume a single-use USSD code
+// @Description Validate and consume a single-use USSD code, moving the funds between the generator and the signed-in redeemer. mobileNo is optional and must be the caller's own number. Redeeming a Receive code above the step-up threshold pays from the redeemer's account and needs their pin or biometric
 // @Tags USSD
 // @Accept json
 // @Produce json
-// @Param request body object{code=string,mobileNo=string} true "Code validation request"
+// @Security BearerAuth
+// @Param request body object{code=string,mobileNo=string,pin=string,biometric=models.BiometricAssertion} true "Code validation request"
 // @Success 200 {object} services.USSDCode
 // @Failure 400 {object} services.ErrorResponse
+// @Failure 401 {object} services.ErrorResponse
+// @Failure 403 {object} services.ErrorResponse
+// @Failure 422 {object} services.ErrorResponse
+// @Failure 423 {object} services.ErrorResponse
 // @Router /ussd/validate [post]
 func (h *USSDHandler) ValidateCode(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		services.SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
 	var req struct {
 		Code     string `json:"code" validate:"required"`
-		MobileNo string `json:"mobileNo" validate:"required"`
+		MobileNo string `json:"mobileNo"`
+		models.StepUpProof
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
@@ -135,14 +155,21 @@ func (h *USSDHandler) ValidateCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	codeType := services.PullPayment
-	if len(req.Code) > 0 && req.Code[0] >= '0' && req.Code[0] <= '9' {
-		// Numeric codes need type detection logic
-		// For now, default to PullPayment unless service provides detection
-	}
-
-	ussdCode, err := h.service.ValidateAndConsume(r.Context(), req.Code, codeType)
+	ussdCode, err := h.service.RedeemForUser(r.Context(), req.Code, userID, req.MobileNo, req.StepUpProof)
 	if err != nil {
+		log.Printf("[USSD] ValidateCode - Redemption failed: %v", err)
+		if errors.Is(err, services.ErrUSSDMobileMismatch) {
+			services.SendErrorResponse(w, err.Error(), http.StatusForbidden, nil)
+			return
+		}
+		var limitErr *services.LimitError
+		if errors.As(err, &limitErr) {
+			services.SendLimitErrorResponse(w, limitErr)
+			return
+		}
+		if services.SendStepUpErrorResponse(w, err) {
+			return
+		}
 		services.SendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
 		return
 	}
@@ -64,4 +64,8 @@ func (a *AuditLogger) LogOperation(transactionID, accountID, operation, details
 func (a *AuditLogger) log(event AuditEvent) {
 	data, _ := json.Marshal(event)
 	log.Printf("AUDIT: %s", string(data))
+
+	if store := currentAuditStore(); store != nil {
+		store.Enqueue(event)
+	}
 }
@@ -0,0 +1,355 @@
+package hsm
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditBatchSize     = 100
+	defaultAuditFlushInterval = time.Second
+	defaultAuditBufferSize    = 4096
+
+	// auditFlushAttempts is how many flushes a failing batch gets before it
+	// is spilled, so one bad event cannot hold up the rest indefinitely
+	auditFlushAttempts = 5
+
+	// auditChainLockID serialises chain appends across server instances
+	auditChainLockID = 7_202_100_305
+)
+
+// AuditStore persists audit events to the audit_events table. Events are
+// buffered and written in batches by a single goroutine; each row carries
+// the hash of the previous row so the table forms a tamper-evident chain.
+// A batch the database refuses is retried on later flushes and, once its
+// attempts are spent, appended to the spill file for replay.
+type AuditStore struct {
+	db            *sql.DB
+	events        chan AuditEvent
+	batchSize     int
+	flushInterval time.Duration
+	spillPath     string
+	failures      int
+	done          chan struct{}
+	mu            sync.RWMutex
+	closed        bool
+}
+
+// ChainVerification reports the outcome of walking the audit hash chain.
+type ChainVerification struct {
+	Valid       bool   `json:"valid"`
+	Checked     int    `json:"checked"`
+	BrokenAtID  int64  `json:"broken_at_id,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	LastHash    string `json:"last_hash,omitempty"`
+	LegacyCount int    `json:"legacy_count"`
+}
+
+var (
+	auditStoreMu sync.RWMutex
+	auditStore   *AuditStore
+)
+
+// SetAuditStore registers the store that every AuditLogger writes to.
+// Passing nil reverts to stdout-only logging.
+func SetAuditStore(store *AuditStore) {
+	auditStoreMu.Lock()
+	defer auditStoreMu.Unlock()
+	auditStore = store
+}
+
+func currentAuditStore() *AuditStore {
+	auditStoreMu.RLock()
+	defer auditStoreMu.RUnlock()
+	return auditStore
+}
+
+// NewAuditStore writes to db, spilling events it cannot persist to
+// spillPath as JSON lines. With no spill path they are written to the log.
+func NewAuditStore(db *sql.DB, spillPath string) *AuditStore {
+	return &AuditStore{
+		db:            db,
+		events:        make(chan AuditEvent, defaultAuditBufferSize),
+		batchSize:     defaultAuditBatchSize,
+		flushInterval: defaultAuditFlushInterval,
+		spillPath:     spillPath,
+		done:          make(chan struct{}),
+	}
+}
+
+// Start launches the background writer.
+func (s *AuditStore) Start() {
+	go s.run()
+}
+
+// Enqueue hands an event to the background writer. It only blocks when the
+// buffer is full, which keeps the payment path off the database round trip.
+// Events arriving after Close are spilled.
+func (s *AuditStore) Enqueue(event AuditEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.spill([]AuditEvent{event})
+		return
+	}
+	s.events <- event
+}
+
+// Close stops accepting events and waits for buffered events to be flushed.
+func (s *AuditStore) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *AuditStore) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, s.batchSize)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				if batch = s.flush(batch); len(batch) > 0 {
+					s.spill(batch)
+				}
+				return
+			}
+			batch = append(batch, event)
+			// While the database is failing, retries wait for the ticker
+			if len(batch) >= s.batchSize && (s.failures == 0 || len(batch) >= defaultAuditBufferSize) {
+				batch = s.flush(batch)
+			}
+		case <-ticker.C:
+			batch = s.flush(batch)
+		}
+	}
+}
+
+// flush writes batch and returns the events still waiting to be written.
+// A failed batch is kept for the next flush until its attempts are spent or
+// it outgrows the buffer, and is then spilled.
+func (s *AuditStore) flush(batch []AuditEvent) []AuditEvent {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := s.writeBatch(batch)
+	if err == nil {
+		s.failures = 0
+		return batch[:0]
+	}
+
+	s.failures++
+	log.Printf("[AUDIT] Failed to persist %d audit events (attempt %d): %v", len(batch), s.failures, err)
+	if s.failures < auditFlushAttempts && len(batch) < defaultAuditBufferSize {
+		return batch
+	}
+	s.spill(batch)
+	s.failures = 0
+	return batch[:0]
+}
+
+// spill appends events to the spill file, falling back to the log when no
+// file is set or it cannot be written
+func (s *AuditStore) spill(events []AuditEvent) {
+	var buf []byte
+	for _, event := range events {
+		data, _ := json.Marshal(event)
+		buf = append(append(buf, data...), '\n')
+	}
+
+	if s.spillPath != "" {
+		f, err := os.OpenFile(s.spillPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = f.Write(buf)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err == nil {
+			log.Printf("[AUDIT] Spilled %d audit events to %s", len(events), s.spillPath)
+			return
+		}
+		log.Printf("[AUDIT] Failed to spill audit events to %s: %v", s.spillPath, err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n") {
+		log.Printf("AUDIT_UNPERSISTED: %s", line)
+	}
+}
+
+func (s *AuditStore) writeBatch(batch []AuditEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	var prevHash sql.NullString
+	err = tx.QueryRow(`SELECT hash FROM audit_events WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read chain head: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO audit_events
+		(timestamp, event_type, transaction_id, account_id, amount, status, details, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	head := prevHash.String
+	for _, event := range batch {
+		event.Timestamp = event.Timestamp.UTC().Truncate(time.Microsecond)
+		details, err := canonicalDetails(event.Details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit details: %w", err)
+		}
+
+		hash := chainHash(head, event, details)
+		if _, err := stmt.Exec(event.Timestamp, event.EventType, event.TransactionID, event.AccountID,
+			event.Amount, event.Status, details, head, hash); err != nil {
+			return err
+		}
+		head = hash
+	}
+
+	return tx.Commit()
+}
+
+// VerifyChain walks audit_events in insertion order, recomputing each hash,
+// and reports the first row whose hash or back-link does not match.
+// Rows written before hashing was introduced are counted but not checked.
+func (s *AuditStore) VerifyChain(ctx context.Context) (*ChainVerification, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, timestamp, event_type, COALESCE(transaction_id, ''), COALESCE(account_id, ''),
+		       COALESCE(amount, 0), status, COALESCE(details::text, 'null'), prev_hash, hash
+		FROM audit_events
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &ChainVerification{Valid: true}
+	head := ""
+	chained := false
+
+	for rows.Next() {
+		var id int64
+		var event AuditEvent
+		var rawDetails string
+		var prevHash, hash sql.NullString
+
+		if err := rows.Scan(&id, &event.Timestamp, &event.EventType, &event.TransactionID, &event.AccountID,
+			&event.Amount, &event.Status, &rawDetails, &prevHash, &hash); err != nil {
+			return nil, err
+		}
+
+		if !hash.Valid && !chained {
+			result.LegacyCount++
+			continue
+		}
+		chained = true
+		result.Checked++
+
+		if !hash.Valid {
+			return result.broken(id, "missing hash"), nil
+		}
+		if prevHash.String != head {
+			return result.broken(id, "previous hash does not match chain"), nil
+		}
+
+		var details any
+		if err := json.Unmarshal([]byte(rawDetails), &details); err != nil {
+			return result.broken(id, "details are not valid JSON"), nil
+		}
+		canonical, err := canonicalDetails(details)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Timestamp = event.Timestamp.UTC().Truncate(time.Microsecond)
+		if chainHash(head, event, canonical) != hash.String {
+			return result.broken(id, "row contents do not match hash"), nil
+		}
+		head = hash.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.LastHash = head
+	return result, nil
+}
+
+func (v *ChainVerification) broken(id int64, reason string) *ChainVerification {
+	v.Valid = false
+	v.BrokenAtID = id
+	v.Reason = reason
+	return v
+}
+
+// canonicalDetails re-encodes details through a generic value so key order
+// matches what is recovered from the JSONB column during verification.
+func canonicalDetails(details any) (string, error) {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return "", err
+	}
+	data, err = json.Marshal(generic)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func chainHash(prevHash string, event AuditEvent, details string) string {
+	h := sha256.New()
+	for _, field := range []string{
+		prevHash,
+		event.Timestamp.Format(time.RFC3339Nano),
+		event.EventType,
+		event.TransactionID,
+		event.AccountID,
+		strconv.FormatInt(event.Amount, 10),
+		event.Status,
+		details,
+	} {
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
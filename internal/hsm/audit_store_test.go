@@ -0,0 +1,181 @@
+package hsm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var auditColumns = []string{
+	"id", "timestamp", "event_type", "transaction_id", "account_id",
+	"amount", "status", "details", "prev_hash", "hash",
+}
+
+func chainedRows(events []AuditEvent) (*sqlmock.Rows, []string) {
+	rows := sqlmock.NewRows(auditColumns)
+	hashes := make([]string, 0, len(events))
+	head := ""
+	for i, event := range events {
+		details, _ := canonicalDetails(event.Details)
+		hash := chainHash(head, event, details)
+		rows.AddRow(int64(i+1), event.Timestamp, event.EventType, event.TransactionID, event.AccountID,
+			event.Amount, event.Status, details, head, hash)
+		hashes = append(hashes, hash)
+		head = hash
+	}
+	return rows, hashes
+}
+
+func sampleEvents() []AuditEvent {
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	return []AuditEvent{
+		{Timestamp: ts, EventType: "TRANSFER", TransactionID: "tx1", Amount: 500, Status: "SUCCESS",
+			Details: map[string]string{"from_account": "A", "to_account": "B"}},
+		{Timestamp: ts.Add(time.Second), EventType: "ERROR", TransactionID: "tx2", AccountID: "A", Status: "FAILED",
+			Details: map[string]string{"error": "insufficient funds"}},
+	}
+}
+
+func TestAuditStore_WriteBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	store := NewAuditStore(db, "")
+	events := sampleEvents()
+	_, hashes := chainedRows(events)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT hash FROM audit_events").WillReturnRows(sqlmock.NewRows([]string{"hash"}))
+	prep := mock.ExpectPrepare("INSERT INTO audit_events")
+	prep.ExpectExec().WithArgs(sqlmock.AnyArg(), "TRANSFER", "tx1", "", int64(500), "SUCCESS",
+		sqlmock.AnyArg(), "", hashes[0]).WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WithArgs(sqlmock.AnyArg(), "ERROR", "tx2", "A", int64(0), "FAILED",
+		sqlmock.AnyArg(), hashes[0], hashes[1]).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, store.writeBatch(events))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditStore_VerifyChain(t *testing.T) {
+	t.Run("intact chain", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		rows, hashes := chainedRows(sampleEvents())
+		mock.ExpectQuery("SELECT id, timestamp, event_type").WillReturnRows(rows)
+
+		result, err := NewAuditStore(db, "").VerifyChain(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, 2, result.Checked)
+		assert.Equal(t, hashes[1], result.LastHash)
+	})
+
+	t.Run("tampered row", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		events := sampleEvents()
+		_, hashes := chainedRows(events)
+		details, _ := canonicalDetails(events[1].Details)
+
+		rows := sqlmock.NewRows(auditColumns).
+			AddRow(int64(1), events[0].Timestamp, events[0].EventType, events[0].TransactionID, "",
+				events[0].Amount, events[0].Status, `{"from_account":"A","to_account":"B"}`, "", hashes[0]).
+			AddRow(int64(2), events[1].Timestamp, events[1].EventType, events[1].TransactionID, events[1].AccountID,
+				int64(999), events[1].Status, details, hashes[0], hashes[1])
+		mock.ExpectQuery("SELECT id, timestamp, event_type").WillReturnRows(rows)
+
+		result, err := NewAuditStore(db, "").VerifyChain(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(2), result.BrokenAtID)
+	})
+
+	t.Run("legacy rows are skipped", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		rows := sqlmock.NewRows(auditColumns).
+			AddRow(int64(1), time.Now(), "TRANSFER", "tx0", "", int64(0), "SUCCESS", "null", nil, nil)
+		mock.ExpectQuery("SELECT id, timestamp, event_type").WillReturnRows(rows)
+
+		result, err := NewAuditStore(db, "").VerifyChain(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, 1, result.LegacyCount)
+	})
+}
+
+func TestAuditStore_Flush(t *testing.T) {
+	t.Run("failed batch is retried", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		store := NewAuditStore(db, filepath.Join(t.TempDir(), "spill.jsonl"))
+		mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+
+		batch := store.flush(sampleEvents())
+		assert.Len(t, batch, 2)
+		assert.Equal(t, 1, store.failures)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("batch is spilled once attempts are spent", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		path := filepath.Join(t.TempDir(), "spill.jsonl")
+		store := NewAuditStore(db, path)
+		batch := sampleEvents()
+		for i := 0; i < auditFlushAttempts; i++ {
+			mock.ExpectBegin().WillReturnError(errors.New("value too long for type character varying(20)"))
+			batch = store.flush(batch)
+		}
+
+		assert.Empty(t, batch)
+		assert.Equal(t, 0, store.failures)
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if assert.Len(t, lines, 2) {
+			var event AuditEvent
+			assert.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+			assert.Equal(t, "tx2", event.TransactionID)
+		}
+	})
+}
+
+func TestAuditStore_EnqueueAfterClose(t *testing.T) {
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	path := filepath.Join(t.TempDir(), "spill.jsonl")
+	store := NewAuditStore(db, path)
+	store.Start()
+	assert.NoError(t, store.Close())
+
+	assert.NotPanics(t, func() { store.Enqueue(sampleEvents()[0]) })
+	assert.NoError(t, store.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"transaction_id":"tx1"`)
+}
@@ -1,6 +1,7 @@
 package hsm
 
 import (
+	"context"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
@@ -15,9 +16,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,8 +34,10 @@ type HSMInterface interface {
 	// Key Management
 	GenerateKeyPair(keyID string) (*KeyPair, error)
 	GetPublicKey(keyID string) (string, error)
+	ListKeys(prefix string) []KeyInfo
 	DeleteKey(keyID string) error
 	RotateKeys() error
+	ReloadKeys() error
 
 	// Encryption/Decryption
 	EncryptData(keyID string, plaintext []byte) ([]byte, error)
@@ -73,9 +79,19 @@ type KeyPair struct {
 	PrivateKey *rsa.PrivateKey
 	CreatedAt  time.Time
 	ExpiresAt  time.Time
+	RetiredAt  time.Time
 	IsActive   bool
 }
 
+// KeyInfo describes a key without exposing its material
+type KeyInfo struct {
+	ID        string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RetiredAt time.Time // Zero while the key is active
+	IsActive  bool
+}
+
 // CardData for NFC card operations
 type CardData struct {
 	CardID      string    `json:"card_id"`
@@ -188,7 +204,9 @@ func (h *HSMServer) GenerateKeyPair(keyID string) (*KeyPair, error) {
 	return keyPair, nil
 }
 
-// GetPublicKey returns the public key in PEM format
+// GetPublicKey returns the public key in PEM format. Keys retired by
+// rotation still have their public key returned so signatures made before
+// the rotation can be verified.
 func (h *HSMServer) GetPublicKey(keyID string) (string, error) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -198,10 +216,6 @@ func (h *HSMServer) GetPublicKey(keyID string) (string, error) {
 		return "", fmt.Errorf("key %s not found", keyID)
 	}
 
-	if !keyPair.IsActive {
-		return "", fmt.Errorf("key %s is not active", keyID)
-	}
-
 	// Encode public key to PEM
 	publicKeyBytes, err := x509.MarshalPKIXPublicKey(keyPair.PublicKey)
 	if err != nil {
@@ -216,6 +230,40 @@ func (h *HSMServer) GetPublicKey(keyID string) (string, error) {
 	return string(publicKeyPEM), nil
 }
 
+// ListKeys returns the key named prefix and the keys rotated from it
+// (prefix_<unix time>), oldest first
+func (h *HSMServer) ListKeys(prefix string) []KeyInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var keys []KeyInfo
+	for id, keyPair := range h.keys {
+		if id != prefix && !strings.HasPrefix(id, prefix+"_") {
+			continue
+		}
+		keys = append(keys, KeyInfo{
+			ID:        id,
+			CreatedAt: keyPair.CreatedAt,
+			ExpiresAt: keyPair.ExpiresAt,
+			RetiredAt: keyPair.RetiredAt,
+			IsActive:  keyPair.IsActive,
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// NewestActiveKey picks the key to sign with from a ListKeys result: the
+// newest active one, so signers follow RotateKeys
+func NewestActiveKey(keys []KeyInfo) (string, bool) {
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i].IsActive {
+			return keys[i].ID, true
+		}
+	}
+	return "", false
+}
+
 // EncryptData encrypts data using AES-GCM
 func (h *HSMServer) EncryptData(keyID string, plaintext []byte) ([]byte, error) {
 	h.mu.RLock()
@@ -339,6 +387,34 @@ func (h *HSMServer) VerifySignature(keyID string, data, signature []byte) (bool,
 	return true, nil
 }
 
+// signWithNewest signs with the newest active rotation of keyID
+func (h *HSMServer) signWithNewest(keyID string, data []byte) ([]byte, error) {
+	newest, ok := NewestActiveKey(h.ListKeys(keyID))
+	if !ok {
+		return nil, fmt.Errorf("no active key for %s", keyID)
+	}
+	return h.SignData(newest, data)
+}
+
+// verifyWithAny checks a signature against keyID and every key rotated from
+// it, newest first, so data signed before a rotation still verifies
+func (h *HSMServer) verifyWithAny(keyID string, data, signature []byte) (bool, error) {
+	keys := h.ListKeys(keyID)
+	if len(keys) == 0 {
+		return false, fmt.Errorf("key %s not found", keyID)
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		valid, err := h.VerifySignature(keys[i].ID, data, signature)
+		if err != nil {
+			return false, err
+		}
+		if valid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // GenerateCardSignature creates a signature for card data
 func (h *HSMServer) GenerateCardSignature(cardData *CardData) (string, error) {
 	// Create data to sign
@@ -351,8 +427,8 @@ func (h *HSMServer) GenerateCardSignature(cardData *CardData) (string, error) {
 		cardData.LastUpdated.Format(time.RFC3339),
 	)
 
-	// Sign with card key
-	signature, err := h.SignData("card_signing", []byte(data))
+	// Sign with the current card key
+	signature, err := h.signWithNewest("card_signing", []byte(data))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign card data: %w", err)
 	}
@@ -380,7 +456,7 @@ func (h *HSMServer) VerifyCardSignature(cardData *CardData, signature string) (b
 	}
 
 	// Verify signature
-	return h.VerifySignature("card_signing", []byte(data), sigBytes)
+	return h.verifyWithAny("card_signing", []byte(data), sigBytes)
 }
 
 // GenerateTransactionID creates a secure transaction ID
@@ -408,8 +484,8 @@ func (h *HSMServer) SignTransaction(transaction *Transaction) (string, error) {
 		transaction.Nonce,
 	)
 
-	// Sign with transaction key
-	signature, err := h.SignData("transaction_signing", []byte(data))
+	// Sign with the current transaction key
+	signature, err := h.signWithNewest("transaction_signing", []byte(data))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign transaction: %w", err)
 	}
@@ -436,7 +512,7 @@ func (h *HSMServer) VerifyTransaction(transaction *Transaction, signature string
 	}
 
 	// Verify signature
-	return h.VerifySignature("transaction_signing", []byte(data), sigBytes)
+	return h.verifyWithAny("transaction_signing", []byte(data), sigBytes)
 }
 
 // HashPIN hashes a PIN using Argon2
@@ -482,17 +558,23 @@ func (h *HSMServer) VerifyPIN(pin string, hashedPIN string) (bool, error) {
 	return subtle.ConstantTimeCompare(inputHash, storedHash) == 1, nil
 }
 
-// RotateKeys rotates expired or compromised keys
+// RotateKeys rotates expired or compromised keys. The key store is re-read
+// first, so the rotation works from what every replica sharing it holds.
 func (h *HSMServer) RotateKeys() error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if err := h.loadKeys(); err != nil {
+		return fmt.Errorf("failed to load keys: %w", err)
+	}
+
 	var rotated []string
 	now := time.Now()
 
 	for keyID, keyPair := range h.keys {
-		// Rotate if expired or due for rotation
-		if now.After(keyPair.ExpiresAt) || !keyPair.IsActive {
+		// Rotate active keys past expiry. Keys already rotated stay inactive,
+		// so repeated runs do not keep replacing them.
+		if keyPair.IsActive && now.After(keyPair.ExpiresAt) {
 			// Generate new key
 			newKeyID := fmt.Sprintf("%s_%d", keyID, now.Unix())
 			newKeyPair, err := h.generateKeyPairInternal(newKeyID)
@@ -501,8 +583,17 @@ func (h *HSMServer) RotateKeys() error {
 				continue
 			}
 
+			if err := h.saveKeyToDisk(newKeyPair); err != nil {
+				h.auditLogger.LogError(keyID, newKeyID, err)
+				continue
+			}
+
 			// Mark old key as inactive
 			keyPair.IsActive = false
+			keyPair.RetiredAt = now
+			if err := h.saveKeyToDisk(keyPair); err != nil {
+				h.auditLogger.LogError(keyID, keyID, err)
+			}
 			h.keys[newKeyID] = newKeyPair
 			rotated = append(rotated, keyID)
 
@@ -515,6 +606,33 @@ func (h *HSMServer) RotateKeys() error {
 	return nil
 }
 
+// ReloadKeys re-reads the key store, picking up keys that another replica
+// sharing it has generated or rotated. Keys already held are replaced by
+// their stored copy, which carries any retirement.
+func (h *HSMServer) ReloadKeys() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.loadKeys()
+}
+
+// WatchKeys calls ReloadKeys every interval until ctx is done. RotateKeys
+// runs on one replica only; the others follow it through this.
+func (h *HSMServer) WatchKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.ReloadKeys(); err != nil {
+				log.Printf("[HSM] Failed to reload keys: %v", err)
+			}
+		}
+	}
+}
+
 // DeleteKey removes a key from the HSM
 func (h *HSMServer) DeleteKey(keyID string) error {
 	h.mu.Lock()
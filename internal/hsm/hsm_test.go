@@ -0,0 +1,73 @@
+package hsm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHSMServer_SignTransactionAcrossRotation(t *testing.T) {
+	h, err := InitHSM(Config{MasterKey: "test-master-key", Salt: []byte("test-salt")})
+	assert.NoError(t, err)
+
+	transaction := &Transaction{ID: "TX1", FromCardID: "CARD1", ToCardID: "CARD2", Amount: 10, Timestamp: time.Now(), Nonce: "n1"}
+	before, err := h.SignTransaction(transaction)
+	assert.NoError(t, err)
+
+	// Expire the key so RotateKeys replaces it
+	h.keys["transaction_signing"].ExpiresAt = time.Now().Add(-time.Hour)
+	assert.NoError(t, h.RotateKeys())
+
+	newest, ok := NewestActiveKey(h.ListKeys("transaction_signing"))
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(newest, "transaction_signing_"))
+
+	after, err := h.SignTransaction(transaction)
+	assert.NoError(t, err)
+	assert.NotEqual(t, before, after)
+
+	// Signatures from before the rotation still verify
+	for _, signature := range []string{before, after} {
+		valid, err := h.VerifyTransaction(transaction, signature)
+		assert.NoError(t, err)
+		assert.True(t, valid)
+	}
+
+	transaction.Amount = 20
+	valid, err := h.VerifyTransaction(transaction, after)
+	assert.NoError(t, err)
+	assert.False(t, valid)
+}
+
+func TestHSMServer_ReloadKeysFollowsRotationOnSharedStore(t *testing.T) {
+	config := Config{MasterKey: "test-master-key", Salt: []byte("test-salt"), KeyStorePath: t.TempDir()}
+	leader, err := InitHSM(config)
+	assert.NoError(t, err)
+	follower, err := InitHSM(config)
+	assert.NoError(t, err)
+
+	// Expire the stored key so the leader's rotation replaces it
+	key := leader.keys["transaction_signing"]
+	key.ExpiresAt = time.Now().Add(-time.Hour)
+	assert.NoError(t, leader.saveKeyToDisk(key))
+	assert.NoError(t, leader.RotateKeys())
+
+	rotated, ok := NewestActiveKey(leader.ListKeys("transaction_signing"))
+	assert.True(t, ok)
+	_, ok = follower.keys[rotated]
+	assert.False(t, ok)
+
+	assert.NoError(t, follower.ReloadKeys())
+	newest, ok := NewestActiveKey(follower.ListKeys("transaction_signing"))
+	assert.True(t, ok)
+	assert.Equal(t, rotated, newest)
+
+	transaction := &Transaction{ID: "TX1", FromCardID: "CARD1", ToCardID: "CARD2", Amount: 10, Timestamp: time.Now(), Nonce: "n1"}
+	signature, err := leader.SignTransaction(transaction)
+	assert.NoError(t, err)
+	valid, err := follower.VerifyTransaction(transaction, signature)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+}
@@ -2,19 +2,28 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/spf13/viper"
 )
 
-var redisClient *redis.Client
+// revokedSessionKey is set by AuthService when a session is revoked
+const revokedSessionKey = "session:revoked:%s"
 
-func InitAuthMiddleware(redis *redis.Client) {
+var (
+	redisClient *redis.Client
+	keyfunc     jwt.Keyfunc
+)
+
+// InitAuthMiddleware sets the Redis client holding revoked sessions and the
+// function that resolves a token's kid header to its verification key
+func InitAuthMiddleware(redis *redis.Client, keys jwt.Keyfunc) {
 	redisClient = redis
+	keyfunc = keys
 }
 
 func AuthMiddleware(next http.Handler) http.Handler {
@@ -35,42 +44,43 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		token := parts[1]
 
-		// Check if token is blacklisted
+		userID, sessionID, err := validateToken(token)
+		if err != nil {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		// Check if the token's session has been revoked
 		if redisClient != nil {
-			ctx := context.Background()
-			key := fmt.Sprintf("blacklist:%s", token)
-			if exists, _ := redisClient.Exists(ctx, key).Result(); exists > 0 {
+			key := fmt.Sprintf(revokedSessionKey, sessionID)
+			if exists, _ := redisClient.Exists(r.Context(), key).Result(); exists > 0 {
 				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
 				return
 			}
 		}
 
-		// Validate token (implement your JWT validation here)
-		userID, err := validateToken(token)
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		// Add user ID to context
+		// Add user and session IDs to context
 		ctx := context.WithValue(r.Context(), "userID", userID)
+		ctx = context.WithValue(ctx, "sessionID", sessionID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func validateToken(tokenString string) (string, error) {
+// validateToken returns the user and session IDs of a valid access token.
+// Tokens without a session id predate sessions and are rejected.
+func validateToken(tokenString string) (string, string, error) {
 	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(viper.GetString("jwt.secret_key")), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyfunc, jwt.WithValidMethods([]string{"RS256"}))
 
 	if err != nil || !token.Valid {
-		return "", err
+		return "", "", err
+	}
+
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" {
+		return "", "", errors.New("token has no session")
 	}
 
 	userID := claims["user_id"]
-	return fmt.Sprintf("%v", userID), nil
+	return fmt.Sprintf("%v", userID), sessionID, nil
 }
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// RequireRole allows the request only when the authenticated user holds one
+// of roles. It must run after AuthMiddleware.
+func RequireRole(db *sql.DB, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value("userID").(string)
+			if !ok || userID == "" {
+				http.Error(w, "User not authenticated", http.StatusUnauthorized)
+				return
+			}
+
+			var role string
+			err := db.QueryRowContext(r.Context(), `SELECT role FROM users WHERE id = $1`, userID).Scan(&role)
+			if err != nil && err != sql.ErrNoRows {
+				http.Error(w, "Failed to verify permissions", http.StatusInternalServerError)
+				return
+			}
+
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		})
+	}
+}
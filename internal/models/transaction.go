@@ -21,6 +21,11 @@ type ExternalBankTransfer struct {
 	Reference   string    `json:"reference"`
 	Narration   string    `json:"narration" validate:"max=200"`
 	Location    *Location `json:"location"`
+	// BeneficiaryID names a saved beneficiary in place of ToAccount and
+	// ToBankCode
+	BeneficiaryID int64 `json:"beneficiaryId,omitempty"`
+	// Amounts above the step-up threshold need a PIN or biometric assertion
+	StepUpProof
 }
 
 // Transaction represents a payment transaction
@@ -37,6 +42,8 @@ type Transaction struct {
 	Status        string     `json:"status" db:"status"`
 	Type          string     `json:"type" db:"type"`
 	Signature     string     `json:"signature" db:"signature"`
+	Counter       uint32     `json:"counter,omitempty" db:"counter"`
+	Timestamp     int64      `json:"timestamp,omitempty"`
 	DeviceID      string     `json:"device_id" db:"device_id"`
 	Location      Location   `json:"location" db:"location"`
 	SyncStatus    string     `json:"sync_status" db:"sync_status"`
@@ -14,9 +14,26 @@ type User struct {
 	Role                string `gorm:"default:'user'"`
 	BiometricEnabled    bool   `gorm:"default:false"`
 	VoiceBankingEnabled bool   `gorm:"default:false"`
+	TransactionPINHash  string `json:"-"`
 	FailedLoginAttempts int    `gorm:"default:0"`
 	LockedUntil         *time.Time
 	LastLogin           *time.Time
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 }
+
+// StepUpProof confirms a payment above the step-up threshold with either
+// the payer's transaction PIN or a biometric assertion
+type StepUpProof struct {
+	PIN       string              `json:"pin,omitempty" validate:"omitempty,numeric,min=4,max=6"`
+	Biometric *BiometricAssertion `json:"biometric,omitempty"`
+}
+
+// BiometricAssertion is a step-up challenge signed on a registered device
+// with its biometric-protected key. Signature is a base64 ASN.1 ECDSA
+// P-256 signature over the SHA-256 of the challenge.
+type BiometricAssertion struct {
+	DeviceID  string `json:"deviceId" validate:"required,max=255"`
+	Challenge string `json:"challenge" validate:"required,max=64"`
+	Signature string `json:"signature" validate:"required,max=512"`
+}
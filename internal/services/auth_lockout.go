@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys for login failure counting and lockout. Failures are counted
+// per phone number, whether or not it is registered, so lockout does not
+// reveal which numbers have accounts.
+const (
+	loginFailuresPhoneKey = "login:failures:phone:%s"
+	loginFailuresIPKey    = "login:failures:ip:%s"
+	loginLockPhoneKey     = "login:lock:phone:%s"
+	loginLockIPKey        = "login:lock:ip:%s"
+)
+
+// UnlockRequest asks for an OTP that unlocks a locked account
+// @Description Account unlock request structure
+type UnlockRequest struct {
+	PhoneNumber string `json:"phoneNumber" validate:"required" example:"+2348012345678"`
+}
+
+// UnlockVerifyRequest unlocks an account with the OTP sent to it
+// @Description Account unlock verification structure
+type UnlockVerifyRequest struct {
+	PhoneNumber string `json:"phoneNumber" validate:"required" example:"+2348012345678"`
+	OTP         string `json:"otp" validate:"required,len=8" example:"12345678"`
+}
+
+// loginLockout returns how long login stays refused for phone or ip, and
+// the status to refuse it with
+func (s *AuthService) loginLockout(ctx context.Context, phone, ip string) (time.Duration, int) {
+	if s.redis == nil {
+		return 0, 0
+	}
+	if ttl, err := s.redis.TTL(ctx, fmt.Sprintf(loginLockIPKey, ip)).Result(); err == nil && ttl > 0 {
+		return ttl, http.StatusTooManyRequests
+	}
+	if ttl, err := s.redis.TTL(ctx, fmt.Sprintf(loginLockPhoneKey, phone)).Result(); err == nil && ttl > 0 {
+		return ttl, http.StatusLocked
+	}
+	return 0, 0
+}
+
+// recordLoginFailure counts a failed login for phone and ip, and for the
+// account when userID is set. It locks whichever has reached its limit and
+// returns how long the account or phone number is now locked for.
+func (s *AuthService) recordLoginFailure(ctx context.Context, userID int, phone, ip string) time.Duration {
+	var attempts int
+	if s.redis != nil {
+		attempts = s.countLoginFailure(ctx, fmt.Sprintf(loginFailuresPhoneKey, phone))
+
+		ipFailures := s.countLoginFailure(ctx, fmt.Sprintf(loginFailuresIPKey, ip))
+		if ipFailures >= s.login.IPMaxAttempts {
+			lockout := s.lockoutFor(ipFailures, s.login.IPMaxAttempts)
+			if err := s.redis.Set(ctx, fmt.Sprintf(loginLockIPKey, ip), ipFailures, lockout).Err(); err != nil {
+				log.Printf("[AUTH] Failed to lock IP %s: %v", ip, err)
+			}
+			s.audit.LogOperation("", "", "LOGIN_IP_LOCKED", fmt.Sprintf("ip=%s failures=%d lockout=%s", ip, ipFailures, lockout))
+		}
+	}
+
+	if userID != 0 {
+		err := s.db.QueryRowContext(ctx, `
+			UPDATE users SET failed_login_attempts = failed_login_attempts + 1 WHERE id = $1
+			RETURNING failed_login_attempts
+		`, userID).Scan(&attempts)
+		if err != nil {
+			log.Printf("[AUTH] Failed to record login failure for user %d: %v", userID, err)
+		}
+	}
+
+	if attempts < s.login.MaxAttempts {
+		return 0
+	}
+
+	lockout := s.lockoutFor(attempts, s.login.MaxAttempts)
+	if userID != 0 {
+		if _, err := s.db.ExecContext(ctx, `UPDATE users SET locked_until = $1 WHERE id = $2`, time.Now().Add(lockout), userID); err != nil {
+			log.Printf("[AUTH] Failed to lock user %d: %v", userID, err)
+		}
+	}
+	if s.redis != nil {
+		if err := s.redis.Set(ctx, fmt.Sprintf(loginLockPhoneKey, phone), attempts, lockout).Err(); err != nil {
+			log.Printf("[AUTH] Failed to lock phone %s: %v", maskAccountID(phone), err)
+		}
+	}
+
+	accountID := ""
+	if userID != 0 {
+		accountID = strconv.Itoa(userID)
+	}
+	s.audit.LogOperation("", accountID, "LOGIN_LOCKED", fmt.Sprintf("phone=%s ip=%s failures=%d lockout=%s", maskAccountID(phone), ip, attempts, lockout))
+	return lockout
+}
+
+func (s *AuthService) countLoginFailure(ctx context.Context, key string) int {
+	n, err := countWithin(ctx, s.redis, key, s.login.FailureWindow)
+	if err != nil {
+		log.Printf("[AUTH] Failed to count login failure: %v", err)
+	}
+	return n
+}
+
+// countWithin increments a counter whose window starts at its first
+// increment
+func countWithin(ctx context.Context, rdb *redis.Client, key string, window time.Duration) (int, error) {
+	n, err := rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		rdb.Expire(ctx, key, window)
+	}
+	return int(n), nil
+}
+
+// lockoutFor doubles the base lockout for every failure past limit
+func (s *AuthService) lockoutFor(failures, limit int) time.Duration {
+	doublings := failures - limit
+	if doublings < 0 {
+		doublings = 0
+	}
+	lockout := time.Duration(float64(s.login.LockoutBase) * math.Pow(2, float64(doublings)))
+	if lockout <= 0 || lockout > s.login.LockoutMax {
+		return s.login.LockoutMax
+	}
+	return lockout
+}
+
+// clearLoginFailures resets the account and phone number after a successful
+// login or unlock. Failures counted against the IP are left to expire.
+func (s *AuthService) clearLoginFailures(ctx context.Context, userID int, phone string, loggedIn bool) error {
+	query := `UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1`
+	if loggedIn {
+		query = `UPDATE users SET failed_login_attempts = 0, locked_until = NULL, last_login = NOW() WHERE id = $1`
+	}
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	if s.redis != nil {
+		s.redis.Del(ctx, fmt.Sprintf(loginFailuresPhoneKey, phone), fmt.Sprintf(loginLockPhoneKey, phone))
+	}
+	return nil
+}
+
+func sendLockoutResponse(w http.ResponseWriter, retryAfter time.Duration, status int) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "Too many failed login attempts. Try again later or unlock the account with an OTP"
+	if status == http.StatusTooManyRequests {
+		message = "Too many failed login attempts from this network. Try again later"
+	}
+	SendErrorResponse(w, message, status, nil)
+}
+
+// remoteHost is the client address without its port. RemoteAddr is the
+// socket peer unless a trusted proxy forwarded the request, see
+// middleware.RealIP.
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func (s *AuthService) decodeAuthRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		s.sendErrorResponse(w, "Invalid request", http.StatusBadRequest, nil)
+		return false
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		s.sendErrorResponse(w, "Request body must only contain a single JSON object", http.StatusBadRequest, nil)
+		return false
+	}
+	if err := s.validator.Struct(dst); err != nil {
+		s.sendErrorResponse(w, "Validation failed", http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// RequestUnlock sends an OTP that unlocks a locked account
+// @Summary Request account unlock
+// @Description Send an OTP that clears the login lockout of an account. The response is the same whether or not the number is registered
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body UnlockRequest true "Unlock request"
+// @Success 200 {object} map[string]string "Unlock code sent"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 503 {object} ErrorResponse "Unlock unavailable"
+// @Router /auth/unlock [post]
+func (s *AuthService) RequestUnlock(w http.ResponseWriter, r *http.Request) {
+	var req UnlockRequest
+	if !s.decodeAuthRequest(w, r, &req) {
+		return
+	}
+	if s.redis == nil {
+		s.sendErrorResponse(w, "Account unlock is unavailable", http.StatusServiceUnavailable, nil)
+		return
+	}
+
+	ctx := r.Context()
+	var userID int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM users WHERE phone_number = $1`, req.PhoneNumber).Scan(&userID)
+	switch {
+	case err == sql.ErrNoRows:
+		log.Printf("[AUTH] Unlock requested for unregistered phone %s", maskAccountID(req.PhoneNumber))
+	case err != nil:
+		log.Printf("[AUTH] Unlock lookup failed for phone %s: %v", maskAccountID(req.PhoneNumber), err)
+		s.sendErrorResponse(w, "Failed to send unlock code", http.StatusInternalServerError, nil)
+		return
+	default:
+		_, err := s.otp.Issue(ctx, OTPRequest{
+			Purpose:   OTPPurposeLoginUnlock,
+			Subject:   req.PhoneNumber,
+			Channel:   OTPChannelSMS,
+			Recipient: req.PhoneNumber,
+			UserID:    strconv.Itoa(userID),
+		})
+		var throttleErr *OTPThrottleError
+		switch {
+		case errors.As(err, &throttleErr):
+			// Answered as if sent, so throttling does not reveal which numbers are registered
+			log.Printf("[AUTH] Unlock OTP for user %d throttled: %v", userID, err)
+		case err != nil:
+			log.Printf("[AUTH] Failed to send unlock OTP for user %d: %v", userID, err)
+			s.sendErrorResponse(w, "Failed to send unlock code", http.StatusInternalServerError, nil)
+			return
+		default:
+			s.audit.LogOperation("", strconv.Itoa(userID), "LOGIN_UNLOCK_REQUESTED", fmt.Sprintf("ip=%s", remoteHost(r)))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "If the number is registered, an unlock code has been sent"})
+}
+
+// VerifyUnlock clears the login lockout of an account
+// @Summary Unlock account
+// @Description Clear the login lockout of an account with the OTP from /auth/unlock. Too many wrong codes discard the OTP. Lockout of the caller's network is not cleared
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body UnlockVerifyRequest true "Unlock verification"
+// @Success 200 {object} map[string]string "Account unlocked"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Invalid or expired OTP"
+// @Failure 503 {object} ErrorResponse "Unlock unavailable"
+// @Router /auth/unlock/verify [post]
+func (s *AuthService) VerifyUnlock(w http.ResponseWriter, r *http.Request) {
+	var req UnlockVerifyRequest
+	if !s.decodeAuthRequest(w, r, &req) {
+		return
+	}
+	if s.redis == nil {
+		s.sendErrorResponse(w, "Account unlock is unavailable", http.StatusServiceUnavailable, nil)
+		return
+	}
+
+	ctx := r.Context()
+	err := s.otp.Verify(ctx, OTPPurposeLoginUnlock, req.PhoneNumber, req.OTP)
+	if errors.Is(err, ErrOTPAttemptsExceeded) {
+		s.audit.LogOperation("", "", "LOGIN_UNLOCK_FAILED", fmt.Sprintf("phone=%s ip=%s attempts exceeded", maskAccountID(req.PhoneNumber), remoteHost(r)))
+	}
+	if errors.Is(err, ErrOTPInvalid) {
+		s.sendErrorResponse(w, "Invalid or expired OTP", http.StatusUnauthorized, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[AUTH] Failed to verify unlock OTP: %v", err)
+		s.sendErrorResponse(w, "Failed to unlock account", http.StatusInternalServerError, nil)
+		return
+	}
+
+	var userID int
+	if err := s.db.QueryRowContext(ctx, `SELECT id FROM users WHERE phone_number = $1`, req.PhoneNumber).Scan(&userID); err != nil {
+		log.Printf("[AUTH] Unlock lookup failed for phone %s: %v", maskAccountID(req.PhoneNumber), err)
+		s.sendErrorResponse(w, "Failed to unlock account", http.StatusInternalServerError, nil)
+		return
+	}
+	if err := s.clearLoginFailures(ctx, userID, req.PhoneNumber, false); err != nil {
+		log.Printf("[AUTH] Failed to unlock user %d: %v", userID, err)
+		s.sendErrorResponse(w, "Failed to unlock account", http.StatusInternalServerError, nil)
+		return
+	}
+	s.audit.LogOperation("", strconv.Itoa(userID), "LOGIN_UNLOCKED", fmt.Sprintf("method=OTP ip=%s", remoteHost(r)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Account unlocked"})
+}
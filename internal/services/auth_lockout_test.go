@@ -0,0 +1,250 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLockoutService(t *testing.T) (*AuthService, sqlmock.Sqlmock, redismock.ClientMock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	setupAuthConfig()
+	redisClient, redisMock := redismock.NewClientMock()
+	service := NewAuthService(db, redisClient, testJWTKeyring(t))
+	service.login = &config.LoginConfig{
+		MaxAttempts:   5,
+		IPMaxAttempts: 20,
+		LockoutBase:   time.Minute,
+		LockoutMax:    time.Hour,
+		FailureWindow: 24 * time.Hour,
+	}
+	service.otp, _ = newTestOTPService(db, redisClient)
+	return service, mock, redisMock
+}
+
+func loginRequest(phone, password string) *http.Request {
+	body, _ := json.Marshal(LoginRequest{PhoneNumber: phone, Password: password, DeviceID: "device-1"})
+	r := httptest.NewRequest("POST", "/auth/login", bytes.NewBuffer(body))
+	r.RemoteAddr = "10.0.0.1:5000"
+	return r
+}
+
+func expectNoLoginLock(redisMock redismock.ClientMock, phone string) {
+	redisMock.ExpectTTL("login:lock:ip:10.0.0.1").SetVal(-2)
+	redisMock.ExpectTTL("login:lock:phone:" + phone).SetVal(-2)
+}
+
+func TestAuthService_LockoutFor(t *testing.T) {
+	service, _, _ := newTestLockoutService(t)
+
+	assert.Equal(t, time.Minute, service.lockoutFor(5, 5))
+	assert.Equal(t, 2*time.Minute, service.lockoutFor(6, 5))
+	assert.Equal(t, 16*time.Minute, service.lockoutFor(9, 5))
+	assert.Equal(t, time.Hour, service.lockoutFor(12, 5))
+	assert.Equal(t, time.Hour, service.lockoutFor(500, 5))
+}
+
+func TestAuthService_LoginLockout(t *testing.T) {
+	hashedPassword, _ := hashPassword("password123")
+	userRows := func(lockedUntil any) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "password", "account_id", "locked_until"}).
+			AddRow(1, "test@example.com", "John", "Doe", hashedPassword, "0123456789", lockedUntil)
+	}
+
+	t.Run("wrong password below the limit", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		expectNoLoginLock(redisMock, "0801")
+		mock.ExpectQuery("SELECT id, email").WithArgs("0801").WillReturnRows(userRows(nil))
+		redisMock.ExpectIncr("login:failures:phone:0801").SetVal(1)
+		redisMock.ExpectExpire("login:failures:phone:0801", 24*time.Hour).SetVal(true)
+		redisMock.ExpectIncr("login:failures:ip:10.0.0.1").SetVal(3)
+		mock.ExpectQuery("UPDATE users SET failed_login_attempts = failed_login_attempts \\+ 1").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(2))
+
+		w := httptest.NewRecorder()
+		service.Login(w, loginRequest("0801", "wrong-password"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("failure at the limit locks the account", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		expectNoLoginLock(redisMock, "0801")
+		mock.ExpectQuery("SELECT id, email").WithArgs("0801").WillReturnRows(userRows(nil))
+		redisMock.ExpectIncr("login:failures:phone:0801").SetVal(5)
+		redisMock.ExpectIncr("login:failures:ip:10.0.0.1").SetVal(5)
+		mock.ExpectQuery("UPDATE users SET failed_login_attempts = failed_login_attempts \\+ 1").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(6))
+		mock.ExpectExec("UPDATE users SET locked_until").
+			WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		redisMock.ExpectSet("login:lock:phone:0801", 6, 2*time.Minute).SetVal("OK")
+
+		w := httptest.NewRecorder()
+		service.Login(w, loginRequest("0801", "wrong-password"))
+
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.Equal(t, "120", w.Header().Get("Retry-After"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("unregistered phone locks like an account", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		expectNoLoginLock(redisMock, "0809")
+		mock.ExpectQuery("SELECT id, email").WithArgs("0809").WillReturnRows(sqlmock.NewRows(nil))
+		redisMock.ExpectIncr("login:failures:phone:0809").SetVal(5)
+		redisMock.ExpectIncr("login:failures:ip:10.0.0.1").SetVal(5)
+		redisMock.ExpectSet("login:lock:phone:0809", 5, time.Minute).SetVal("OK")
+
+		w := httptest.NewRecorder()
+		service.Login(w, loginRequest("0809", "password123"))
+
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("locked account refuses the right password", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		expectNoLoginLock(redisMock, "0801")
+		mock.ExpectQuery("SELECT id, email").WithArgs("0801").WillReturnRows(userRows(time.Now().Add(10 * time.Minute)))
+
+		w := httptest.NewRecorder()
+		service.Login(w, loginRequest("0801", "password123"))
+
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("locked IP", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		redisMock.ExpectTTL("login:lock:ip:10.0.0.1").SetVal(30 * time.Second)
+
+		w := httptest.NewRecorder()
+		service.Login(w, loginRequest("0801", "password123"))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("IP reaching its limit is locked", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		expectNoLoginLock(redisMock, "0809")
+		mock.ExpectQuery("SELECT id, email").WithArgs("0809").WillReturnRows(sqlmock.NewRows(nil))
+		redisMock.ExpectIncr("login:failures:phone:0809").SetVal(1)
+		redisMock.ExpectExpire("login:failures:phone:0809", 24*time.Hour).SetVal(true)
+		redisMock.ExpectIncr("login:failures:ip:10.0.0.1").SetVal(21)
+		redisMock.ExpectSet("login:lock:ip:10.0.0.1", 21, 2*time.Minute).SetVal("OK")
+
+		w := httptest.NewRecorder()
+		service.Login(w, loginRequest("0809", "password123"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
+
+func TestAuthService_RequestUnlock(t *testing.T) {
+	unlockRequest := func() *http.Request {
+		body, _ := json.Marshal(UnlockRequest{PhoneNumber: "0801"})
+		return httptest.NewRequest("POST", "/auth/unlock", bytes.NewBuffer(body))
+	}
+
+	t.Run("registered phone is sent a code", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		mock.ExpectQuery("SELECT id FROM users").WithArgs("0801").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectOTPIssue(mock, redisMock, OTPPurposeLoginUnlock, "0801", "0801")
+
+		w := httptest.NewRecorder()
+		service.RequestUnlock(w, unlockRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, service.otp.sender.(*recordingSender).sent, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("throttled request looks like a send", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		mock.ExpectQuery("SELECT id FROM users").WithArgs("0801").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		redisMock.ExpectSetNX("otp:resend:sms:0801", 1, time.Minute).SetVal(false)
+		redisMock.ExpectTTL("otp:resend:sms:0801").SetVal(30 * time.Second)
+
+		w := httptest.NewRecorder()
+		service.RequestUnlock(w, unlockRequest())
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, service.otp.sender.(*recordingSender).sent)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
+
+func TestAuthService_VerifyUnlock(t *testing.T) {
+	unlockRequest := func(otp string) *http.Request {
+		body, _ := json.Marshal(UnlockVerifyRequest{PhoneNumber: "0801", OTP: otp})
+		return httptest.NewRequest("POST", "/auth/unlock/verify", bytes.NewBuffer(body))
+	}
+
+	t.Run("correct code unlocks", func(t *testing.T) {
+		service, mock, redisMock := newTestLockoutService(t)
+		redisMock.ExpectGet("otp:login_unlock:0801").SetVal(hashOTP("12345678"))
+		redisMock.ExpectDel("otp:login_unlock:0801", "otp:attempts:login_unlock:0801").SetVal(2)
+		mock.ExpectQuery("SELECT id FROM users").WithArgs("0801").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec("UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id").
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		redisMock.ExpectDel("login:failures:phone:0801", "login:lock:phone:0801").SetVal(2)
+
+		w := httptest.NewRecorder()
+		service.VerifyUnlock(w, unlockRequest("12345678"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		service, _, redisMock := newTestLockoutService(t)
+		redisMock.ExpectGet("otp:login_unlock:0801").SetVal(hashOTP("12345678"))
+		redisMock.ExpectIncr("otp:attempts:login_unlock:0801").SetVal(1)
+		redisMock.ExpectExpire("otp:attempts:login_unlock:0801", 10*time.Minute).SetVal(true)
+
+		w := httptest.NewRecorder()
+		service.VerifyUnlock(w, unlockRequest("87654321"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("last wrong code discards the OTP", func(t *testing.T) {
+		service, _, redisMock := newTestLockoutService(t)
+		redisMock.ExpectGet("otp:login_unlock:0801").SetVal(hashOTP("12345678"))
+		redisMock.ExpectIncr("otp:attempts:login_unlock:0801").SetVal(3)
+		redisMock.ExpectDel("otp:login_unlock:0801", "otp:attempts:login_unlock:0801").SetVal(2)
+
+		w := httptest.NewRecorder()
+		service.VerifyUnlock(w, unlockRequest("87654321"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
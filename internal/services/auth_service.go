@@ -1,11 +1,11 @@
 package services
 
 import (
-	"context"
 	cryptorand "crypto/rand"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -17,6 +17,8 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/hsm"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/argon2"
 )
@@ -25,6 +27,10 @@ type AuthService struct {
 	db        *sql.DB
 	redis     *redis.Client
 	validator *validator.Validate
+	audit     *hsm.AuditLogger
+	keys      *JWTKeyring
+	login     *config.LoginConfig
+	otp       *OTPService
 }
 
 // LoginRequest represents the login request payload
@@ -32,6 +38,8 @@ type AuthService struct {
 type LoginRequest struct {
 	PhoneNumber string `json:"phoneNumber" validate:"required" example:"+2348012345678"` // User phone number
 	Password    string `json:"password" validate:"required,min=6" example:"password123"` // User password
+	DeviceID    string `json:"deviceId" validate:"required,max=255" example:"a1b2c3"`    // Device the session is bound to
+	DeviceName  string `json:"deviceName" validate:"max=100" example:"Tecno Spark 10"`   // Optional label shown in the session list
 }
 
 // RegisterRequest represents the registration request payload
@@ -43,13 +51,15 @@ type RegisterRequest struct {
 	LastName    string `json:"LastName" validate:"required,min=2" example:"Doe"`           // User last name
 	BVN         string `json:"BVN" validate:"required,len=11" example:"12345678901"`       // Bank Verification Number
 	PhoneNumber string `json:"PhoneNumber" validate:"required" example:"+2348012345678"`   // Phone number
+	DeviceID    string `json:"DeviceId" validate:"required,max=255" example:"a1b2c3"`      // Device the session is bound to
+	DeviceName  string `json:"DeviceName" validate:"max=100" example:"Tecno Spark 10"`     // Optional label shown in the session list
 }
 
 // AuthResponse represents the authentication response
 // @Description Authentication response structure
 type AuthResponse struct {
-	Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."` // JWT token
-	User  User   `json:"user"`                                                    // User information
+	AuthTokens
+	User User `json:"user"` // User information
 }
 
 // User represents user information
@@ -65,11 +75,15 @@ type User struct {
 	DeviceID    string `json:"device_id"`
 }
 
-func NewAuthService(db *sql.DB, redisClient *redis.Client) *AuthService {
+func NewAuthService(db *sql.DB, redisClient *redis.Client, keys *JWTKeyring) *AuthService {
 	return &AuthService{
 		db:        db,
 		redis:     redisClient,
 		validator: validator.New(),
+		audit:     hsm.NewAuditLogger(),
+		keys:      keys,
+		login:     config.LoadLoginConfig(),
+		otp:       NewOTPService(db, redisClient),
 	}
 }
 
@@ -167,16 +181,16 @@ func (s *AuthService) Register(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[AUTH] User created successfully - ID: %d, Email: %s", userID, req.Email)
 
-	token, err := generateJWT(userID)
+	tokens, err := s.startSession(r.Context(), userID, req.DeviceID, req.DeviceName, r)
 	if err != nil {
-		log.Printf("[AUTH] JWT generation failed for user %d: %v", userID, err)
+		log.Printf("[AUTH] Session creation failed for user %d: %v", userID, err)
 		s.sendErrorResponse(w, "Failed to generate token", http.StatusInternalServerError, nil)
 		return
 	}
 
 	response := AuthResponse{
-		Token: token,
-		User:  User{ID: userID, Email: req.Email, FirstName: req.FirstName, LastName: req.LastName, AccountId: accountID},
+		AuthTokens: *tokens,
+		User:       User{ID: userID, Email: req.Email, FirstName: req.FirstName, LastName: req.LastName, AccountId: accountID, DeviceID: req.DeviceID},
 	}
 
 	log.Printf("[AUTH] Registration successful for user %d", userID)
@@ -226,34 +240,61 @@ func (s *AuthService) Login(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[AUTH] Login request for phone number: %s", req.PhoneNumber)
 
+	ctx := r.Context()
+	ip := remoteHost(r)
+	if retryAfter, status := s.loginLockout(ctx, req.PhoneNumber, ip); retryAfter > 0 {
+		log.Printf("[AUTH] Login refused for %s from %s: locked for %s", req.PhoneNumber, ip, retryAfter)
+		sendLockoutResponse(w, retryAfter, status)
+		return
+	}
+
 	var user User
 	var hashedPassword string
-	err := s.db.QueryRow("SELECT id, email, first_name, last_name, password, account_id FROM users WHERE phone_number = $1",
-		req.PhoneNumber).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &hashedPassword, &user.AccountId)
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT id, email, first_name, last_name, password, account_id, locked_until FROM users WHERE phone_number = $1",
+		req.PhoneNumber).Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &hashedPassword, &user.AccountId, &lockedUntil)
 	if err != nil {
 		log.Printf("[AUTH] User not found for phone number: %s", req.PhoneNumber)
+		if lockout := s.recordLoginFailure(ctx, 0, req.PhoneNumber, ip); lockout > 0 {
+			sendLockoutResponse(w, lockout, http.StatusLocked)
+			return
+		}
 		s.sendErrorResponse(w, "Invalid credentials", http.StatusUnauthorized, nil)
 		return
 	}
 
+	if lockedUntil.Valid && time.Now().Before(lockedUntil.Time) {
+		log.Printf("[AUTH] Login refused for locked user %d", user.ID)
+		sendLockoutResponse(w, time.Until(lockedUntil.Time), http.StatusLocked)
+		return
+	}
+
 	if !verifyPassword(req.Password, hashedPassword) {
 		log.Printf("[AUTH] Invalid password for user: %s", req.PhoneNumber)
+		if lockout := s.recordLoginFailure(ctx, user.ID, req.PhoneNumber, ip); lockout > 0 {
+			sendLockoutResponse(w, lockout, http.StatusLocked)
+			return
+		}
 		s.sendErrorResponse(w, "Invalid credentials", http.StatusUnauthorized, nil)
 		return
 	}
 
 	log.Printf("[AUTH] Password verified for user ID: %d", user.ID)
+	if err := s.clearLoginFailures(ctx, user.ID, req.PhoneNumber, true); err != nil {
+		log.Printf("[AUTH] Failed to reset login failures for user %d: %v", user.ID, err)
+	}
 
-	token, err := generateJWT(user.ID)
+	tokens, err := s.startSession(ctx, user.ID, req.DeviceID, req.DeviceName, r)
 	if err != nil {
-		log.Printf("[AUTH] JWT generation failed for user %d: %v", user.ID, err)
+		log.Printf("[AUTH] Session creation failed for user %d: %v", user.ID, err)
 		s.sendErrorResponse(w, "Failed to generate token", http.StatusInternalServerError, nil)
 		return
 	}
+	user.DeviceID = req.DeviceID
 
 	response := AuthResponse{
-		Token: token,
-		User:  user,
+		AuthTokens: *tokens,
+		User:       user,
 	}
 
 	log.Printf("[AUTH] Login successful for user %d", user.ID)
@@ -263,7 +304,7 @@ func (s *AuthService) Login(w http.ResponseWriter, r *http.Request) {
 
 // Logout handles user logout
 // @Summary Logout user
-// @Description Logout user and blacklist token
+// @Description Logout user and revoke the session of the token, including its refresh token. An expired access token is accepted
 // @Tags auth
 // @Produce json
 // @Success 200 {object} map[string]string "Logout successful"
@@ -273,13 +314,9 @@ func (s *AuthService) Logout(w http.ResponseWriter, r *http.Request) {
 	if token != "" && len(token) > 7 {
 		token = token[7:] // Remove "Bearer " prefix
 
-		if s.redis != nil {
-			ctx := context.Background()
-			key := fmt.Sprintf("blacklist:%s", token)
-			// Blacklist token until its expiration
-			expiry := time.Duration(viper.GetInt("jwt.expiry_hours")) * time.Hour
-			if err := s.redis.Set(ctx, key, "1", expiry).Err(); err != nil {
-				log.Printf("[AUTH] Failed to blacklist token: %v", err)
+		if sessionID, err := s.sessionFromToken(token); err == nil {
+			if err := s.revokeSession(r.Context(), sessionID, RevokeReasonLogout); err != nil {
+				log.Printf("[AUTH] Failed to revoke session %s: %v", sessionID, err)
 			}
 		}
 	}
@@ -290,19 +327,22 @@ func (s *AuthService) Logout(w http.ResponseWriter, r *http.Request) {
 
 // ValidateBVN validates a BVN number and sends OTP
 // @Summary Validate BVN
-// @Description Validate a Bank Verification Number and send OTP
+// @Description Validate a Bank Verification Number and send an OTP to the phone number, or to the email address when channel is email. Codes to the same recipient are throttled
 // @Tags accounts
 // @Accept json
 // @Produce json
-// @Param request body map[string]string true "BVN validation request"
+// @Param request body object{bvn=string,phoneNumber=string,email=string,channel=string} true "BVN validation request"
 // @Success 200 {object} map[string]interface{} "OTP sent successfully"
 // @Failure 400 {string} string "Invalid request"
+// @Failure 429 {object} ErrorResponse "Too many code requests"
+// @Failure 502 {object} ErrorResponse "OTP could not be delivered"
 // @Router /accounts/validate-bvn [post]
 func (s *AuthService) ValidateBVN(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		BVN         string `json:"bvn" validate:"required,len=11"`
 		PhoneNumber string `json:"phoneNumber" validate:"required"`
 		Email       string `json:"email" validate:"required,email"`
+		Channel     string `json:"channel" validate:"omitempty,oneof=sms email"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -315,19 +355,24 @@ func (s *AuthService) ValidateBVN(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	otp := generateOTP()
-	key := fmt.Sprintf("bvn_otp:%s", req.BVN)
-
-	if s.redis != nil {
-		ctx := context.Background()
-		if err := s.redis.Set(ctx, key, otp, 10*time.Minute).Err(); err != nil {
-			log.Printf("[AUTH] Failed to store OTP in Redis: %v", err)
+	otpReq := OTPRequest{Purpose: OTPPurposeBVN, Subject: req.BVN, Channel: OTPChannelSMS, Recipient: req.PhoneNumber}
+	if req.Channel == OTPChannelEmail {
+		otpReq.Channel, otpReq.Recipient = OTPChannelEmail, req.Email
+	}
+	delivery, err := s.otp.Issue(r.Context(), otpReq)
+	if err != nil {
+		switch {
+		case SendOTPThrottleResponse(w, err):
+		case errors.Is(err, ErrOTPDeliveryFailed):
+			s.sendErrorResponse(w, "Failed to send OTP", http.StatusBadGateway, nil)
+		default:
+			log.Printf("[AUTH] Failed to issue BVN OTP: %v", err)
 			s.sendErrorResponse(w, "Failed to generate OTP", http.StatusInternalServerError, nil)
-			return
 		}
+		return
 	}
 
-	log.Printf("[AUTH] OTP generated for BVN %s: %s (Phone: %s, Email: %s)", req.BVN, otp, req.PhoneNumber, req.Email)
+	log.Printf("[AUTH] BVN OTP sent by %s to %s (delivery %s)", delivery.Channel, delivery.Recipient, delivery.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
@@ -338,7 +383,7 @@ func (s *AuthService) ValidateBVN(w http.ResponseWriter, r *http.Request) {
 
 // VerifyOTP verifies the OTP for BVN validation
 // @Summary Verify OTP
-// @Description Verify OTP sent for BVN validation
+// @Description Verify OTP sent for BVN validation. Too many wrong codes discard the OTP
 // @Tags accounts
 // @Accept json
 // @Produce json
@@ -363,27 +408,22 @@ func (s *AuthService) VerifyOTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := fmt.Sprintf("bvn_otp:%s", req.BVN)
-
-	if s.redis != nil {
-		ctx := context.Background()
-		storedOTP, err := s.redis.Get(ctx, key).Result()
-		if err != nil {
-			log.Printf("[AUTH] OTP not found or expired for BVN %s", req.BVN)
-			s.sendErrorResponse(w, "Invalid or expired OTP", http.StatusUnauthorized, nil)
-			return
-		}
-
-		if storedOTP != req.OTP {
-			log.Printf("[AUTH] Invalid OTP for BVN %s", req.BVN)
-			s.sendErrorResponse(w, "Invalid or expired OTP", http.StatusUnauthorized, nil)
-			return
-		}
-
-		s.redis.Del(ctx, key)
+	err := s.otp.Verify(r.Context(), OTPPurposeBVN, req.BVN, req.OTP)
+	switch {
+	case errors.Is(err, ErrOTPInvalid):
+		log.Printf("[AUTH] Invalid OTP for BVN %s: %v", maskAccountID(req.BVN), err)
+		s.sendErrorResponse(w, "Invalid or expired OTP", http.StatusUnauthorized, nil)
+		return
+	case errors.Is(err, ErrOTPUnavailable):
+		s.sendErrorResponse(w, "OTP verification is unavailable", http.StatusServiceUnavailable, nil)
+		return
+	case err != nil:
+		log.Printf("[AUTH] OTP verification failed: %v", err)
+		s.sendErrorResponse(w, "Failed to verify OTP", http.StatusInternalServerError, nil)
+		return
 	}
 
-	log.Printf("[AUTH] OTP verified successfully for BVN %s", req.BVN)
+	log.Printf("[AUTH] OTP verified successfully for BVN %s", maskAccountID(req.BVN))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
@@ -431,14 +471,13 @@ func (s *AuthService) GetUserAccount(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-func generateJWT(userID int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+func (s *AuthService) generateJWT(userID int, sessionID string) (string, error) {
+	return s.keys.Sign(jwt.MapClaims{
 		"user_id": userID,
 		"nameid":  userID,
-		"exp":     time.Now().Add(time.Duration(viper.GetInt("jwt.expiry_hours")) * time.Hour).Unix(),
+		"sid":     sessionID,
+		"exp":     time.Now().Add(accessTokenTTL()).Unix(),
 	})
-
-	return token.SignedString([]byte(viper.GetString("jwt.secret_key")))
 }
 
 func hashPassword(password string) (string, error) {
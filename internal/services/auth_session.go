@@ -0,0 +1,497 @@
+package services
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// revokedSessionKey marks a session id as revoked for AuthMiddleware,
+	// which reads the same key. It lives as long as an access token, after
+	// which every token carrying the id has expired anyway.
+	revokedSessionKey = "session:revoked:%s"
+)
+
+// Reasons recorded in user_sessions.revoke_reason
+const (
+	RevokeReasonLogout         = "LOGOUT"
+	RevokeReasonUser           = "REVOKED_BY_USER"
+	RevokeReasonSignedInAgain  = "SIGNED_IN_AGAIN"
+	RevokeReasonTokenReuse     = "REFRESH_TOKEN_REUSE"
+	RevokeReasonDeviceMismatch = "DEVICE_MISMATCH"
+)
+
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used; session revoked")
+)
+
+// AuthTokens is a short-lived access token and the single-use refresh token
+// that renews it
+// @Description Access and refresh token pair
+type AuthTokens struct {
+	Token        string `json:"token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."` // Access token
+	RefreshToken string `json:"refreshToken" example:"3q2-7wAAAAA..."`                   // Refresh token, valid once
+	ExpiresIn    int64  `json:"expiresIn" example:"900"`                                 // Access token lifetime in seconds
+}
+
+// RefreshRequest represents the token refresh payload
+// @Description Token refresh request structure
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required,max=128"`              // Refresh token from login or the last refresh
+	DeviceID     string `json:"deviceId" validate:"required,max=255" example:"a1b2c3"` // Device the session was started on
+}
+
+// Session is a signed-in device
+// @Description Active session structure
+type Session struct {
+	ID         string    `json:"id"`
+	DeviceID   string    `json:"deviceId"`
+	DeviceName string    `json:"deviceName,omitempty"`
+	IPAddress  string    `json:"ipAddress,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"` // Session of the token making the request
+}
+
+func accessTokenTTL() time.Duration {
+	if ttl := viper.GetDuration("jwt.access_ttl"); ttl > 0 {
+		return ttl
+	}
+	return defaultAccessTokenTTL
+}
+
+func refreshTokenTTL() time.Duration {
+	if ttl := viper.GetDuration("jwt.refresh_ttl"); ttl > 0 {
+		return ttl
+	}
+	return defaultRefreshTokenTTL
+}
+
+// sessionQuerier is satisfied by both *sql.DB and *sql.Tx
+type sessionQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// startSession signs userID in on deviceID. An earlier session on the same
+// device is revoked, so each device holds at most one session.
+func (s *AuthService) startSession(ctx context.Context, userID int, deviceID, deviceName string, r *http.Request) (*AuthTokens, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	replaced, err := revokeSessions(ctx, tx, RevokeReasonSignedInAgain, "user_id = $2 AND device_id = $3", userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_sessions (id, user_id, device_id, device_name, ip_address, user_agent, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+	`, sessionID, userID, deviceID, deviceName, truncate(r.RemoteAddr, 64), truncate(r.UserAgent(), 255), time.Now().Add(refreshTokenTTL()))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET device_id = $1 WHERE id = $2`, deviceID, userID); err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := issueRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.markRevoked(ctx, replaced...)
+
+	s.audit.LogOperation("", fmt.Sprint(userID), "SESSION_STARTED", fmt.Sprintf("session=%s device=%s", sessionID, deviceID))
+	return s.signTokens(userID, sessionID, refreshToken)
+}
+
+// rotateRefreshToken spends refreshToken and issues the next one in its
+// session. Presenting a token that was already spent, or from another
+// device, means it has leaked, so the whole session is revoked.
+func (s *AuthService) rotateRefreshToken(ctx context.Context, refreshToken, deviceID string) (*AuthTokens, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var sessionID string
+	var spent bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT session_id, used_at IS NOT NULL FROM refresh_tokens
+		WHERE token_hash = $1 AND expires_at > NOW()
+		FOR UPDATE
+	`, hashRefreshToken(refreshToken)).Scan(&sessionID, &spent)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var userID int
+	var sessionDevice string
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, device_id FROM user_sessions
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`, sessionID).Scan(&userID, &sessionDevice)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if spent || sessionDevice != deviceID {
+		reason := RevokeReasonTokenReuse
+		if !spent {
+			reason = RevokeReasonDeviceMismatch
+		}
+		tx.Rollback()
+		if err := s.revokeSession(ctx, sessionID, reason); err != nil {
+			return nil, err
+		}
+		s.audit.LogOperation("", fmt.Sprint(userID), "SESSION_COMPROMISED", fmt.Sprintf("session=%s reason=%s device=%s", sessionID, reason, deviceID))
+		if spent {
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1`, hashRefreshToken(refreshToken)); err != nil {
+		return nil, err
+	}
+	next, err := issueRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_sessions SET last_used_at = NOW(), expires_at = $1 WHERE id = $2
+	`, time.Now().Add(refreshTokenTTL()), sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.signTokens(userID, sessionID, next)
+}
+
+// ListSessions returns the user's active sessions, most recently used first,
+// optionally only those on deviceID
+func (s *AuthService) ListSessions(ctx context.Context, userID, deviceID string) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, device_id, COALESCE(device_name, ''), COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+			created_at, last_used_at, expires_at
+		FROM user_sessions
+		WHERE user_id::text = $1 AND ($2 = '' OR device_id = $2)
+			AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var sess Session
+		if err := rows.Scan(&sess.ID, &sess.DeviceID, &sess.DeviceName, &sess.IPAddress, &sess.UserAgent,
+			&sess.CreatedAt, &sess.LastUsedAt, &sess.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeUserSession signs one of the user's sessions out
+func (s *AuthService) RevokeUserSession(ctx context.Context, userID, sessionID string) error {
+	ids, err := revokeSessions(ctx, s.db, RevokeReasonUser, "id = $2 AND user_id::text = $3", sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return ErrSessionNotFound
+	}
+	s.markRevoked(ctx, ids...)
+	s.audit.LogOperation("", userID, "SESSION_REVOKED", fmt.Sprintf("session=%s", sessionID))
+	return nil
+}
+
+// RevokeDeviceSessions signs the user out of every session on deviceID and
+// returns how many were revoked
+func (s *AuthService) RevokeDeviceSessions(ctx context.Context, userID, deviceID string) (int, error) {
+	ids, err := revokeSessions(ctx, s.db, RevokeReasonUser, "user_id::text = $2 AND device_id = $3", userID, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	s.markRevoked(ctx, ids...)
+	if len(ids) > 0 {
+		s.audit.LogOperation("", userID, "SESSION_REVOKED", fmt.Sprintf("device=%s sessions=%d", deviceID, len(ids)))
+	}
+	return len(ids), nil
+}
+
+func (s *AuthService) revokeSession(ctx context.Context, sessionID, reason string) error {
+	ids, err := revokeSessions(ctx, s.db, reason, "id = $2", sessionID)
+	if err != nil {
+		return err
+	}
+	s.markRevoked(ctx, ids...)
+	return nil
+}
+
+// revokeSessions revokes the active sessions matching where, whose
+// placeholders start at $2, and returns their ids
+func revokeSessions(ctx context.Context, q sessionQuerier, reason, where string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		UPDATE user_sessions SET revoked_at = NOW(), revoke_reason = $1
+		WHERE revoked_at IS NULL AND `+where+`
+		RETURNING id
+	`, append([]any{reason}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// markRevoked tells AuthMiddleware to reject access tokens of the sessions
+// straight away rather than when they expire
+func (s *AuthService) markRevoked(ctx context.Context, sessionIDs ...string) {
+	if s.redis == nil {
+		return
+	}
+	for _, id := range sessionIDs {
+		if err := s.redis.Set(ctx, fmt.Sprintf(revokedSessionKey, id), "1", accessTokenTTL()).Err(); err != nil {
+			log.Printf("[AUTH] Failed to mark session %s revoked: %v", id, err)
+		}
+	}
+}
+
+func issueRefreshToken(ctx context.Context, q sessionQuerier, sessionID string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)
+	`, hashRefreshToken(token), sessionID, time.Now().Add(refreshTokenTTL()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *AuthService) signTokens(userID int, sessionID, refreshToken string) (*AuthTokens, error) {
+	token, err := s.generateJWT(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthTokens{Token: token, RefreshToken: refreshToken, ExpiresIn: int64(accessTokenTTL().Seconds())}, nil
+}
+
+// sessionFromToken returns the session id of a signed access token, expired
+// or not, so an expired token can still be logged out
+func (s *AuthService) sessionFromToken(tokenString string) (string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keys.Keyfunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return "", err
+	}
+	sid, _ := claims["sid"].(string)
+	if sid == "" {
+		return "", errors.New("token has no session")
+	}
+	return sid, nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
+
+// Refresh exchanges a refresh token for a new token pair
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and refresh token. Each refresh token is valid once; presenting a used one, or one from another device, revokes the session
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body RefreshRequest true "Refresh request"
+// @Success 200 {object} AuthTokens "Tokens refreshed"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Invalid, expired or reused refresh token"
+// @Router /auth/refresh [post]
+func (s *AuthService) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if !s.decodeAuthRequest(w, r, &req) {
+		return
+	}
+
+	tokens, err := s.rotateRefreshToken(r.Context(), req.RefreshToken, req.DeviceID)
+	switch {
+	case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrRefreshTokenReused):
+		log.Printf("[AUTH] Refresh rejected for device %s: %v", req.DeviceID, err)
+		s.sendErrorResponse(w, err.Error(), http.StatusUnauthorized, nil)
+		return
+	case err != nil:
+		log.Printf("[AUTH] Refresh failed for device %s: %v", req.DeviceID, err)
+		s.sendErrorResponse(w, "Failed to refresh token", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// GetSessions lists the caller's active sessions
+// @Summary List sessions
+// @Description List active sessions, most recently used first. Filter to one device with deviceId
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param deviceId query string false "Only sessions on this device"
+// @Success 200 {array} Session
+// @Failure 401 {object} ErrorResponse
+// @Router /auth/sessions [get]
+func (s *AuthService) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		s.sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	sessions, err := s.ListSessions(r.Context(), userID, strings.TrimSpace(r.URL.Query().Get("deviceId")))
+	if err != nil {
+		log.Printf("[AUTH] Listing sessions failed for user %s: %v", userID, err)
+		s.sendErrorResponse(w, "Failed to load sessions", http.StatusInternalServerError, nil)
+		return
+	}
+	current, _ := r.Context().Value("sessionID").(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// DeleteSession revokes one of the caller's sessions
+// @Summary Revoke session
+// @Description Sign out one session. Its access and refresh tokens stop working immediately
+// @Tags auth
+// @Security BearerAuth
+// @Param sessionId path string true "Session ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /auth/sessions/{sessionId} [delete]
+func (s *AuthService) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		s.sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	err := s.RevokeUserSession(r.Context(), userID, chi.URLParam(r, "sessionId"))
+	if errors.Is(err, ErrSessionNotFound) {
+		s.sendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+		return
+	}
+	if err != nil {
+		log.Printf("[AUTH] Revoking session failed for user %s: %v", userID, err)
+		s.sendErrorResponse(w, "Failed to revoke session", http.StatusInternalServerError, nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteDeviceSessions revokes every session on one of the caller's devices
+// @Summary Revoke device sessions
+// @Description Sign out every session on a device
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param deviceId path string true "Device ID"
+// @Success 200 {object} map[string]int "Number of sessions revoked"
+// @Failure 401 {object} ErrorResponse
+// @Router /auth/devices/{deviceId}/sessions [delete]
+func (s *AuthService) DeleteDeviceSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		s.sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	revoked, err := s.RevokeDeviceSessions(r.Context(), userID, chi.URLParam(r, "deviceId"))
+	if err != nil {
+		log.Printf("[AUTH] Revoking device sessions failed for user %s: %v", userID, err)
+		s.sendErrorResponse(w, "Failed to revoke sessions", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
@@ -9,37 +10,64 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v8"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestAuthService_Register(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	// Setup viper config
+func setupAuthConfig() {
 	viper.Set("argon2.salt_length", 16)
 	viper.Set("argon2.time", 1)
 	viper.Set("argon2.memory", 64*1024)
 	viper.Set("argon2.threads", 4)
 	viper.Set("argon2.key_length", 32)
-	viper.Set("jwt.secret_key", "test-secret")
-	viper.Set("jwt.expiry_hours", 24)
+	viper.Set("jwt.access_ttl", "15m")
+	viper.Set("jwt.refresh_ttl", "720h")
+}
+
+func expectSessionStart(mock sqlmock.Sqlmock, userID int, deviceID string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE user_sessions SET revoked_at").
+		WithArgs(RevokeReasonSignedInAgain, userID, deviceID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec("INSERT INTO user_sessions").
+		WithArgs(sqlmock.AnyArg(), userID, deviceID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET device_id").
+		WithArgs(deviceID, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refresh_tokens").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestAuthService_Register(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
 
-	service := NewAuthService(db)
+	setupAuthConfig()
+	service := NewAuthService(db, nil, testJWTKeyring(t))
 
 	t.Run("successful registration", func(t *testing.T) {
 		req := RegisterRequest{
-			Email:     "test@example.com",
-			Password:  "password123",
-			FirstName: "John",
-			LastName:  "Doe",
+			Email:       "test@example.com",
+			Password:    "password123",
+			FirstName:   "John",
+			LastName:    "Doe",
+			BVN:         "12345678901",
+			PhoneNumber: "+2348012345678",
+			DeviceID:    "device-1",
 		}
 
+		mock.ExpectBegin()
 		mock.ExpectQuery("INSERT INTO users").
-			WithArgs(req.Email, sqlmock.AnyArg(), req.FirstName, req.LastName).
+			WithArgs(req.Email, sqlmock.AnyArg(), req.FirstName, req.LastName, sqlmock.AnyArg(), req.BVN, req.PhoneNumber).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec("INSERT INTO accounts").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectSessionStart(mock, 1, "device-1")
 
 		body, _ := json.Marshal(req)
 		r := httptest.NewRequest("POST", "/auth/register", bytes.NewBuffer(body))
@@ -51,7 +79,10 @@ func TestAuthService_Register(t *testing.T) {
 		var response AuthResponse
 		json.Unmarshal(w.Body.Bytes(), &response)
 		assert.NotEmpty(t, response.Token)
+		assert.NotEmpty(t, response.RefreshToken)
+		assert.Equal(t, int64(900), response.ExpiresIn)
 		assert.Equal(t, req.Email, response.User.Email)
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 
 	t.Run("invalid request body", func(t *testing.T) {
@@ -69,22 +100,25 @@ func TestAuthService_Login(t *testing.T) {
 	assert.NoError(t, err)
 	defer db.Close()
 
-	viper.Set("jwt.secret_key", "test-secret")
-	viper.Set("jwt.expiry_hours", 24)
-
-	service := NewAuthService(db)
+	setupAuthConfig()
+	service := NewAuthService(db, nil, testJWTKeyring(t))
 
 	t.Run("successful login", func(t *testing.T) {
 		hashedPassword, _ := hashPassword("password123")
 
-		mock.ExpectQuery("SELECT id, email, first_name, last_name, password FROM users").
+		mock.ExpectQuery("SELECT id, email, first_name, last_name, password, account_id, locked_until FROM users").
 			WithArgs("4359502429542").
-			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "password"}).
-				AddRow(1, "test@example.com", "John", "Doe", hashedPassword))
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "first_name", "last_name", "password", "account_id", "locked_until"}).
+				AddRow(1, "test@example.com", "John", "Doe", hashedPassword, "0123456789", nil))
+		mock.ExpectExec("UPDATE users SET failed_login_attempts = 0, locked_until = NULL, last_login = NOW\\(\\)").
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectSessionStart(mock, 1, "device-1")
 
 		req := LoginRequest{
 			PhoneNumber: "4359502429542",
 			Password:    "password123",
+			DeviceID:    "device-1",
 		}
 
 		body, _ := json.Marshal(req)
@@ -97,16 +131,30 @@ func TestAuthService_Login(t *testing.T) {
 		var response AuthResponse
 		json.Unmarshal(w.Body.Bytes(), &response)
 		assert.NotEmpty(t, response.Token)
+		assert.NotEmpty(t, response.RefreshToken)
+		assert.Equal(t, "device-1", response.User.DeviceID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("missing device", func(t *testing.T) {
+		body, _ := json.Marshal(LoginRequest{PhoneNumber: "4359502429542", Password: "password123"})
+		r := httptest.NewRequest("POST", "/auth/login", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+
+		service.Login(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 
 	t.Run("user not found", func(t *testing.T) {
-		mock.ExpectQuery("SELECT id, email, first_name, last_name, password FROM users").
+		mock.ExpectQuery("SELECT id, email, first_name, last_name, password, account_id, locked_until FROM users").
 			WithArgs("34324920424942").
 			WillReturnError(sql.ErrNoRows)
 
 		req := LoginRequest{
 			PhoneNumber: "34324920424942",
 			Password:    "password123",
+			DeviceID:    "device-1",
 		}
 
 		body, _ := json.Marshal(req)
@@ -119,12 +167,141 @@ func TestAuthService_Login(t *testing.T) {
 	})
 }
 
+func TestAuthService_RotateRefreshToken(t *testing.T) {
+	setupAuthConfig()
+	tokenHash := hashRefreshToken("refresh-1")
+
+	t.Run("rotates to a new token", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		service := NewAuthService(db, nil, testJWTKeyring(t))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT session_id, used_at IS NOT NULL FROM refresh_tokens").
+			WithArgs(tokenHash).
+			WillReturnRows(sqlmock.NewRows([]string{"session_id", "spent"}).AddRow("sess-1", false))
+		mock.ExpectQuery("SELECT user_id, device_id FROM user_sessions").
+			WithArgs("sess-1").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "device_id"}).AddRow(7, "device-1"))
+		mock.ExpectExec("UPDATE refresh_tokens SET used_at").
+			WithArgs(tokenHash).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO refresh_tokens").
+			WithArgs(sqlmock.AnyArg(), "sess-1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE user_sessions SET last_used_at").
+			WithArgs(sqlmock.AnyArg(), "sess-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		tokens, err := service.rotateRefreshToken(context.Background(), "refresh-1", "device-1")
+		assert.NoError(t, err)
+		assert.NotEqual(t, "refresh-1", tokens.RefreshToken)
+
+		sid, err := service.sessionFromToken(tokens.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, "sess-1", sid)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reused token revokes the session", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		service := NewAuthService(db, redisClient, testJWTKeyring(t))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT session_id, used_at IS NOT NULL FROM refresh_tokens").
+			WithArgs(tokenHash).
+			WillReturnRows(sqlmock.NewRows([]string{"session_id", "spent"}).AddRow("sess-1", true))
+		mock.ExpectQuery("SELECT user_id, device_id FROM user_sessions").
+			WithArgs("sess-1").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "device_id"}).AddRow(7, "device-1"))
+		mock.ExpectRollback()
+		mock.ExpectQuery("UPDATE user_sessions SET revoked_at").
+			WithArgs(RevokeReasonTokenReuse, "sess-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("sess-1"))
+		redisMock.ExpectSet("session:revoked:sess-1", "1", accessTokenTTL()).SetVal("OK")
+
+		_, err := service.rotateRefreshToken(context.Background(), "refresh-1", "device-1")
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("token from another device revokes the session", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		service := NewAuthService(db, nil, testJWTKeyring(t))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT session_id, used_at IS NOT NULL FROM refresh_tokens").
+			WithArgs(tokenHash).
+			WillReturnRows(sqlmock.NewRows([]string{"session_id", "spent"}).AddRow("sess-1", false))
+		mock.ExpectQuery("SELECT user_id, device_id FROM user_sessions").
+			WithArgs("sess-1").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "device_id"}).AddRow(7, "device-1"))
+		mock.ExpectRollback()
+		mock.ExpectQuery("UPDATE user_sessions SET revoked_at").
+			WithArgs(RevokeReasonDeviceMismatch, "sess-1").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("sess-1"))
+
+		_, err := service.rotateRefreshToken(context.Background(), "refresh-1", "device-2")
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		service := NewAuthService(db, nil, testJWTKeyring(t))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT session_id, used_at IS NOT NULL FROM refresh_tokens").
+			WithArgs(tokenHash).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := service.rotateRefreshToken(context.Background(), "refresh-1", "device-1")
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAuthService_RevokeUserSession(t *testing.T) {
+	setupAuthConfig()
+
+	t.Run("revokes the session", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		service := NewAuthService(db, redisClient, testJWTKeyring(t))
+
+		mock.ExpectQuery("UPDATE user_sessions SET revoked_at").
+			WithArgs(RevokeReasonUser, "sess-1", "7").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("sess-1"))
+		redisMock.ExpectSet("session:revoked:sess-1", "1", accessTokenTTL()).SetVal("OK")
+
+		assert.NoError(t, service.RevokeUserSession(context.Background(), "7", "sess-1"))
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("another user's session", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		service := NewAuthService(db, nil, testJWTKeyring(t))
+
+		mock.ExpectQuery("UPDATE user_sessions SET revoked_at").
+			WithArgs(RevokeReasonUser, "sess-1", "8").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		err := service.RevokeUserSession(context.Background(), "8", "sess-1")
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+}
+
 func TestPasswordHashing(t *testing.T) {
-	viper.Set("argon2.salt_length", 16)
-	viper.Set("argon2.time", 1)
-	viper.Set("argon2.memory", 64*1024)
-	viper.Set("argon2.threads", 4)
-	viper.Set("argon2.key_length", 32)
+	setupAuthConfig()
 
 	password := "testpassword"
 
@@ -137,10 +314,14 @@ func TestPasswordHashing(t *testing.T) {
 }
 
 func TestGenerateJWT(t *testing.T) {
-	viper.Set("jwt.secret_key", "test-secret")
-	viper.Set("jwt.expiry_hours", 24)
+	setupAuthConfig()
+	service := NewAuthService(nil, nil, testJWTKeyring(t))
 
-	token, err := generateJWT(123)
+	token, err := service.generateJWT(123, "sess-1")
 	assert.NoError(t, err)
 	assert.NotEmpty(t, token)
+
+	sid, err := service.sessionFromToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "sess-1", sid)
 }
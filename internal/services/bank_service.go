@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+
+	"github.com/ruralpay/backend/internal/config"
 )
 
 type Bank struct {
@@ -129,10 +131,20 @@ var nigerianBanks = []Bank{
 	{Code: "090399", Name: "Ndiorah MFB"},
 }
 
-type BankService struct{}
+// ownBankName is the name saved for beneficiaries held with us
+const ownBankName = "RuralPay"
+
+type BankService struct {
+	institutionCode string
+}
 
 func NewBankService() *BankService {
-	return &BankService{}
+	return &BankService{institutionCode: config.InstitutionCode()}
+}
+
+// InstitutionCode returns the bank code of accounts held with us
+func (bs *BankService) InstitutionCode() string {
+	return bs.institutionCode
 }
 
 func (bs *BankService) GetAllBanks(w http.ResponseWriter, r *http.Request) {
@@ -148,6 +160,19 @@ func (bs *BankService) GetAllBanks(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(banks)
 }
 
+// BankName returns the name of the bank with the given code
+func (bs *BankService) BankName(code string) (string, bool) {
+	if code == bs.institutionCode {
+		return ownBankName, true
+	}
+	for _, bank := range nigerianBanks {
+		if bank.Code == code {
+			return bank.Name, true
+		}
+	}
+	return "", false
+}
+
 func (bs *BankService) LoadLogo(code string) string {
 	filename, ok := bankLogos[code]
 	if !ok {
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ruralpay/backend/internal/hsm"
+)
+
+const maxBeneficiaryNickname = 30
+
+var (
+	ErrBeneficiaryNotFound = errors.New("beneficiary not found")
+	ErrBeneficiaryExists   = errors.New("beneficiary already saved")
+	ErrNicknameTaken       = errors.New("nickname already used for another beneficiary")
+	ErrInvalidNickname     = errors.New("nickname must be at most 30 characters")
+	ErrUnknownBank         = errors.New("unknown bank code")
+)
+
+// Beneficiary is a saved transfer recipient. AccountName and BankName are
+// the values verified when it was added, not what the user typed.
+type Beneficiary struct {
+	ID          int64     `json:"id"`
+	AccountID   string    `json:"accountId"`
+	AccountName string    `json:"accountName"`
+	BankCode    string    `json:"bankCode"`
+	BankName    string    `json:"bankName"`
+	Nickname    string    `json:"nickname,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// BeneficiaryService stores the recipients a user transfers to so they can
+// be paid by ID, or by nickname over voice and USSD.
+type BeneficiaryService struct {
+	db       *sql.DB
+	accounts *TransactionService
+	banks    *BankService
+	audit    *hsm.AuditLogger
+}
+
+func NewBeneficiaryService(db *sql.DB, accounts *TransactionService, banks *BankService) *BeneficiaryService {
+	return &BeneficiaryService{
+		db:       db,
+		accounts: accounts,
+		banks:    banks,
+		audit:    hsm.NewAuditLogger(),
+	}
+}
+
+const beneficiaryColumns = `id, account_id, account_name, bank_code, bank_name, COALESCE(nickname, ''), created_at, updated_at`
+
+// Create verifies the account by name enquiry and saves it for userID.
+// Accounts with our institution code are looked up among our own; any
+// other bank's are checked through the external name enquiry.
+func (s *BeneficiaryService) Create(ctx context.Context, userID, accountID, bankCode, nickname string) (*Beneficiary, error) {
+	nickname, err := normalizeNickname(nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	bankName, ok := s.banks.BankName(bankCode)
+	if !ok {
+		return nil, ErrUnknownBank
+	}
+	var accountName string
+	if bankCode == s.banks.InstitutionCode() {
+		accountName, err = s.accounts.localAccountName(accountID)
+	} else {
+		accountName, err = s.accounts.externalAccountName(accountID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkNickname(ctx, userID, nickname, 0); err != nil {
+		return nil, err
+	}
+
+	b := &Beneficiary{AccountID: accountID, AccountName: accountName, BankCode: bankCode, BankName: bankName, Nickname: nickname}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO beneficiaries (user_id, account_id, bank_code, bank_name, account_name, nickname)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (user_id, account_id, bank_code) DO NOTHING
+		RETURNING id, created_at, updated_at
+	`, userID, accountID, bankCode, bankName, accountName, nickname).Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrBeneficiaryExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.LogOperation("", userID, "BENEFICIARY_ADDED", fmt.Sprintf("%s at %s", maskAccountID(accountID), bankCode))
+	return b, nil
+}
+
+// List returns the user's beneficiaries, nicknamed ones first
+func (s *BeneficiaryService) List(ctx context.Context, userID string) ([]Beneficiary, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+beneficiaryColumns+`
+		FROM beneficiaries
+		WHERE user_id::text = $1
+		ORDER BY nickname IS NULL, LOWER(COALESCE(nickname, account_name))
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	beneficiaries := []Beneficiary{}
+	for rows.Next() {
+		b, err := scanBeneficiary(rows)
+		if err != nil {
+			return nil, err
+		}
+		beneficiaries = append(beneficiaries, *b)
+	}
+	return beneficiaries, rows.Err()
+}
+
+// Get returns one of the user's beneficiaries
+func (s *BeneficiaryService) Get(ctx context.Context, userID string, id int64) (*Beneficiary, error) {
+	return findBeneficiary(ctx, s.db, userID, id)
+}
+
+// FindByNickname returns the beneficiary the user nicknamed nickname,
+// ignoring case.
+func (s *BeneficiaryService) FindByNickname(ctx context.Context, userID, nickname string) (*Beneficiary, error) {
+	b, err := scanBeneficiary(s.db.QueryRowContext(ctx, `
+		SELECT `+beneficiaryColumns+`
+		FROM beneficiaries
+		WHERE user_id::text = $1 AND LOWER(nickname) = LOWER($2)
+	`, userID, strings.Join(strings.Fields(nickname), " ")))
+	if err == sql.ErrNoRows {
+		return nil, ErrBeneficiaryNotFound
+	}
+	return b, err
+}
+
+// Rename sets the nickname of a beneficiary; an empty nickname removes it
+func (s *BeneficiaryService) Rename(ctx context.Context, userID string, id int64, nickname string) (*Beneficiary, error) {
+	nickname, err := normalizeNickname(nickname)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkNickname(ctx, userID, nickname, id); err != nil {
+		return nil, err
+	}
+
+	b, err := scanBeneficiary(s.db.QueryRowContext(ctx, `
+		UPDATE beneficiaries SET nickname = NULLIF($1, ''), updated_at = NOW()
+		WHERE id = $2 AND user_id::text = $3
+		RETURNING `+beneficiaryColumns, nickname, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrBeneficiaryNotFound
+	}
+	return b, err
+}
+
+// Delete removes one of the user's beneficiaries
+func (s *BeneficiaryService) Delete(ctx context.Context, userID string, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM beneficiaries WHERE id = $1 AND user_id::text = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrBeneficiaryNotFound
+	}
+	s.audit.LogOperation("", userID, "BENEFICIARY_REMOVED", fmt.Sprintf("id=%d", id))
+	return nil
+}
+
+// checkNickname fails when another of the user's beneficiaries, other than
+// exceptID, already has nickname.
+func (s *BeneficiaryService) checkNickname(ctx context.Context, userID, nickname string, exceptID int64) error {
+	if nickname == "" {
+		return nil
+	}
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM beneficiaries WHERE user_id::text = $1 AND LOWER(nickname) = LOWER($2) AND id <> $3)
+	`, userID, nickname, exceptID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrNicknameTaken
+	}
+	return nil
+}
+
+// findBeneficiary loads a beneficiary owned by userID. Transfers use it to
+// resolve a beneficiaryId.
+func findBeneficiary(ctx context.Context, db *sql.DB, userID string, id int64) (*Beneficiary, error) {
+	b, err := scanBeneficiary(db.QueryRowContext(ctx, `
+		SELECT `+beneficiaryColumns+`
+		FROM beneficiaries
+		WHERE id = $1 AND user_id::text = $2
+	`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrBeneficiaryNotFound
+	}
+	return b, err
+}
+
+type beneficiaryScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBeneficiary(row beneficiaryScanner) (*Beneficiary, error) {
+	var b Beneficiary
+	if err := row.Scan(&b.ID, &b.AccountID, &b.AccountName, &b.BankCode, &b.BankName, &b.Nickname, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// normalizeNickname trims and collapses whitespace so nicknames compare as
+// they are spoken or typed.
+func normalizeNickname(nickname string) (string, error) {
+	nickname = strings.Join(strings.Fields(nickname), " ")
+	if len([]rune(nickname)) > maxBeneficiaryNickname {
+		return "", ErrInvalidNickname
+	}
+	return nickname, nil
+}
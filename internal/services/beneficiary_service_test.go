@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBeneficiaryService(t *testing.T) (*BeneficiaryService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewBeneficiaryService(db, &TransactionService{db: db}, NewBankService()), mock
+}
+
+func TestBeneficiaryService_Create(t *testing.T) {
+	created := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+
+	t.Run("saves the verified name and bank", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectQuery("SELECT account_name, status FROM accounts").
+			WithArgs("2000000002").
+			WillReturnRows(sqlmock.NewRows([]string{"account_name", "status"}).AddRow("Grace Okafor", "ACTIVE"))
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs("7", "Mama", int64(0)).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery("INSERT INTO beneficiaries").
+			WithArgs("7", "2000000002", "999999", "RuralPay", "Grace Okafor", "Mama").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, created, created))
+
+		b, err := s.Create(context.Background(), "7", "2000000002", "999999", "  Mama ")
+		assert.NoError(t, err)
+		assert.Equal(t, &Beneficiary{
+			ID: 1, AccountID: "2000000002", AccountName: "Grace Okafor", BankCode: "999999",
+			BankName: "RuralPay", Nickname: "Mama", CreatedAt: created, UpdatedAt: created,
+		}, b)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already saved", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectQuery("SELECT account_name, status FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"account_name", "status"}).AddRow("Grace Okafor", "ACTIVE"))
+		mock.ExpectQuery("INSERT INTO beneficiaries").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}))
+
+		_, err := s.Create(context.Background(), "7", "2000000002", "999999", "")
+		assert.ErrorIs(t, err, ErrBeneficiaryExists)
+	})
+
+	t.Run("inactive account", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectQuery("SELECT account_name, status FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"account_name", "status"}).AddRow("Grace Okafor", "SUSPENDED"))
+
+		_, err := s.Create(context.Background(), "7", "2000000002", "999999", "")
+		assert.ErrorIs(t, err, ErrAccountNotActive)
+	})
+
+	t.Run("nickname taken", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectQuery("SELECT account_name, status FROM accounts").
+			WillReturnRows(sqlmock.NewRows([]string{"account_name", "status"}).AddRow("Grace Okafor", "ACTIVE"))
+		mock.ExpectQuery("SELECT EXISTS").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		_, err := s.Create(context.Background(), "7", "2000000002", "999999", "mama")
+		assert.ErrorIs(t, err, ErrNicknameTaken)
+	})
+
+	t.Run("account held with us not found", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectQuery("SELECT account_name, status FROM accounts").
+			WithArgs("2000000002").
+			WillReturnRows(sqlmock.NewRows([]string{"account_name", "status"}))
+
+		_, err := s.Create(context.Background(), "7", "2000000002", "999999", "")
+		assert.ErrorIs(t, err, ErrAccountNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown bank", func(t *testing.T) {
+		s, _ := newTestBeneficiaryService(t)
+		_, err := s.Create(context.Background(), "7", "2000000002", "999", "")
+		assert.ErrorIs(t, err, ErrUnknownBank)
+	})
+}
+
+func TestBeneficiaryService_RenameAndDelete(t *testing.T) {
+	created := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+	columns := []string{"id", "account_id", "account_name", "bank_code", "bank_name", "nickname", "created_at", "updated_at"}
+
+	t.Run("rename", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs("7", "Iya Ibeji", int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectQuery("UPDATE beneficiaries SET nickname").
+			WithArgs("Iya Ibeji", int64(3), "7").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "2000000002", "Grace Okafor", "058", "Guaranty Trust Bank", "Iya Ibeji", created, created))
+
+		b, err := s.Rename(context.Background(), "7", 3, "Iya  Ibeji")
+		assert.NoError(t, err)
+		assert.Equal(t, "Iya Ibeji", b.Nickname)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rename another user's beneficiary", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectQuery("UPDATE beneficiaries SET nickname").
+			WithArgs("", int64(3), "8").
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := s.Rename(context.Background(), "8", 3, "")
+		assert.ErrorIs(t, err, ErrBeneficiaryNotFound)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s, mock := newTestBeneficiaryService(t)
+		mock.ExpectExec("DELETE FROM beneficiaries").
+			WithArgs(int64(3), "7").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM beneficiaries").
+			WithArgs(int64(3), "7").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.NoError(t, s.Delete(context.Background(), "7", 3))
+		assert.ErrorIs(t, s.Delete(context.Background(), "7", 3), ErrBeneficiaryNotFound)
+	})
+}
@@ -1,51 +1,81 @@
 package services
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math/big"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/models"
+)
+
+const (
+	activationCodeTTL      = 24 * time.Hour
+	maxActivationAttempts  = 5
+	cardValidityPeriod     = 3 * 365 * 24 * time.Hour
+	cardAuthKeyDerivation  = "card_signing"
+	accountStatusActive    = "ACTIVE"
+	accountStatusInactive  = "INACTIVE"
+	accountStatusSuspended = "SUSPENDED"
+)
+
+var (
+	errCardNotFound = errors.New("card not found")
+	errCardNotOwned = errors.New("card does not belong to user")
 )
 
 type CardProvisioningService struct {
 	db        *sql.DB
 	hsm       hsm.HSMInterface
+	audit     *hsm.AuditLogger
 	validator *ValidationHelper
 }
 
 // ProvisionRequest represents card provisioning request
 type ProvisionRequest struct {
-	UserID         int     `json:"userId" validate:"required,gt=0"`
-	CardType       string  `json:"cardType" validate:"required,oneof=DEBIT CREDIT PREPAID"`
-	InitialBalance float64 `json:"initialBalance" validate:"gte=0"`
+	UserID   int    `json:"userId" validate:"required,gt=0"`
+	CardType string `json:"cardType" validate:"required,oneof=DEBIT CREDIT PREPAID"`
 }
 
 // ActivationRequest represents card activation request
 type ActivationRequest struct {
 	CardID         string `json:"cardId" validate:"required"`
-	ActivationCode string `json:"activationCode" validate:"required,len=6"`
+	ActivationCode string `json:"activationCode" validate:"required,len=6,numeric"`
 }
 
-func NewCardProvisioningService(db *sql.DB, hsm hsm.HSMInterface) *CardProvisioningService {
+func NewCardProvisioningService(db *sql.DB, hsmService hsm.HSMInterface) *CardProvisioningService {
 	return &CardProvisioningService{
 		db:        db,
-		hsm:       hsm,
+		hsm:       hsmService,
+		audit:     hsm.NewAuditLogger(),
 		validator: NewValidationHelper(),
 	}
 }
 
 // ProvisionCard creates a new NFC card
 // @Summary Provision a new card
-// @Description Create and provision a new NFC payment card
+// @Description Create and provision a new NFC payment card with its linked account and a one-time activation code. The card and account start with a zero balance and are funded through the ledger
 // @Tags cards
 // @Accept json
 // @Produce json
-// @Param card body object{userId=int,cardType=string,initialBalance=float64} true "Card provisioning data"
-// @Success 200 {object} object{cardId=string,status=string}
+// @Param card body object{userId=int,cardType=string} true "Card provisioning data"
+// @Success 201 {object} object{cardId=string,serialNumber=string,accountId=string,status=string,activationCode=string,activationExpiresAt=string}
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
 // @Router /cards/provision [post]
 func (cps *CardProvisioningService) ProvisionCard(w http.ResponseWriter, r *http.Request) {
 	maxBytes := 1_048_576 // 1 MB
@@ -70,19 +100,109 @@ func (cps *CardProvisioningService) ProvisionCard(w http.ResponseWriter, r *http
 		return
 	}
 
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if userID != strconv.Itoa(req.UserID) {
+		log.Printf("[CARD_PROVISION] User %s attempted to provision card for user %d", userID, req.UserID)
+		SendErrorResponse(w, "Unauthorized: Cannot provision card for another user", http.StatusForbidden, nil)
+		return
+	}
+
+	cardID := generateCardID()
+	serialNumber := generateSerialNumber()
+
+	cak, err := cps.deriveCardAuthKey(cardID, serialNumber)
+	if err != nil {
+		log.Printf("[CARD_PROVISION] Failed to derive card auth key: %v", err)
+		cps.audit.LogError(cardID, userID, err)
+		SendErrorResponse(w, "Failed to provision card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	activationCode := generateActivationCode()
+	activationExpiresAt := time.Now().Add(activationCodeTTL)
+
+	tx, err := cps.db.Begin()
+	if err != nil {
+		log.Printf("[CARD_PROVISION] Failed to begin transaction: %v", err)
+		SendErrorResponse(w, "Failed to provision card", http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var firstName, lastName string
+	err = tx.QueryRow(`SELECT first_name, last_name FROM users WHERE id = $1`, req.UserID).Scan(&firstName, &lastName)
+	if err != nil {
+		log.Printf("[CARD_PROVISION] User %d not found: %v", req.UserID, err)
+		SendErrorResponse(w, "User not found", http.StatusNotFound, nil)
+		return
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO cards
+		(card_id, user_id, serial_number, balance, currency, status, card_type, cak,
+		 activation_code_hash, activation_expires_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, cardID, req.UserID, serialNumber, int64(0), "NGN", models.CardStatusInactive, req.CardType,
+		hex.EncodeToString(cak), hashActivationCode(activationCode), activationExpiresAt, time.Now().Add(cardValidityPeriod))
+	if err != nil {
+		log.Printf("[CARD_PROVISION] Failed to insert card: %v", err)
+		cps.audit.LogError(cardID, userID, err)
+		SendErrorResponse(w, "Failed to provision card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	accountID := generateAccountID()
+	accountName := fmt.Sprintf("%s %s", firstName, lastName)
+	_, err = tx.Exec(`
+		INSERT INTO accounts (account_name, account_id, card_id, user_id, balance, version, status, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+	`, accountName, accountID, cardID, req.UserID, int64(0), 1, accountStatusInactive)
+	if err != nil {
+		log.Printf("[CARD_PROVISION] Failed to create account for card %s: %v", maskCardID(cardID), err)
+		cps.audit.LogError(cardID, userID, err)
+		SendErrorResponse(w, "Failed to provision card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[CARD_PROVISION] Failed to commit transaction: %v", err)
+		cps.audit.LogError(cardID, userID, err)
+		SendErrorResponse(w, "Failed to provision card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	cps.audit.LogOperation(cardID, accountID, "CARD_PROVISIONED",
+		fmt.Sprintf("user=%d type=%s status=%s", req.UserID, req.CardType, models.CardStatusInactive))
+	log.Printf("[CARD_PROVISION] Card %s provisioned for user %d", maskCardID(cardID), req.UserID)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "provisioned"})
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"cardId":              cardID,
+		"serialNumber":        serialNumber,
+		"accountId":           accountID,
+		"status":              models.CardStatusInactive,
+		"activationCode":      activationCode,
+		"activationExpiresAt": activationExpiresAt,
+	})
 }
 
 // ActivateCard activates a provisioned card
 // @Summary Activate card
-// @Description Activate a provisioned NFC card
+// @Description Activate a provisioned NFC card using its one-time activation code
 // @Tags cards
 // @Accept json
 // @Produce json
 // @Param activation body object{cardId=string,activationCode=string} true "Card activation data"
 // @Success 200 {object} object{cardId=string,status=string}
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Router /cards/activate [post]
 func (cps *CardProvisioningService) ActivateCard(w http.ResponseWriter, r *http.Request) {
 	maxBytes := 1_048_576 // 1 MB
@@ -107,23 +227,132 @@ func (cps *CardProvisioningService) ActivateCard(w http.ResponseWriter, r *http.
 		return
 	}
 
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	tx, err := cps.db.Begin()
+	if err != nil {
+		log.Printf("[CARD_ACTIVATE] Failed to begin transaction: %v", err)
+		SendErrorResponse(w, "Failed to activate card", http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var ownerID, attempts int
+	var status string
+	var codeHash sql.NullString
+	var expiresAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT user_id, status, activation_code_hash, activation_expires_at, activation_attempts
+		FROM cards WHERE card_id = $1
+		FOR UPDATE
+	`, req.CardID).Scan(&ownerID, &status, &codeHash, &expiresAt, &attempts)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			SendErrorResponse(w, "Card not found", http.StatusNotFound, nil)
+			return
+		}
+		log.Printf("[CARD_ACTIVATE] Failed to load card %s: %v", maskCardID(req.CardID), err)
+		SendErrorResponse(w, "Failed to activate card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if strconv.Itoa(ownerID) != userID {
+		SendErrorResponse(w, "Unauthorized: Card does not belong to user", http.StatusForbidden, nil)
+		return
+	}
+
+	if status != models.CardStatusInactive || !codeHash.Valid {
+		SendErrorResponse(w, "Card is not awaiting activation", http.StatusConflict, nil)
+		return
+	}
+
+	if attempts >= maxActivationAttempts {
+		cps.audit.LogError(req.CardID, userID, errors.New("activation attempts exceeded"))
+		SendErrorResponse(w, "Too many activation attempts", http.StatusTooManyRequests, nil)
+		return
+	}
+
+	if !expiresAt.Valid || time.Now().After(expiresAt.Time) {
+		cps.audit.LogError(req.CardID, userID, errors.New("activation code expired"))
+		SendErrorResponse(w, "Activation code expired", http.StatusBadRequest, nil)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashActivationCode(req.ActivationCode)), []byte(codeHash.String)) != 1 {
+		if _, err := tx.Exec(`UPDATE cards SET activation_attempts = activation_attempts + 1 WHERE card_id = $1`, req.CardID); err == nil {
+			tx.Commit()
+		}
+		cps.audit.LogError(req.CardID, userID, errors.New("invalid activation code"))
+		SendErrorResponse(w, "Invalid activation code", http.StatusBadRequest, nil)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE cards
+		SET status = $1, activation_code_hash = NULL, activation_expires_at = NULL, activation_attempts = 0
+		WHERE card_id = $2
+	`, models.CardStatusActive, req.CardID)
+	if err != nil {
+		log.Printf("[CARD_ACTIVATE] Failed to activate card %s: %v", maskCardID(req.CardID), err)
+		SendErrorResponse(w, "Failed to activate card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE accounts SET status = $1, updated_at = NOW() WHERE card_id = $2`, accountStatusActive, req.CardID); err != nil {
+		log.Printf("[CARD_ACTIVATE] Failed to activate account for card %s: %v", maskCardID(req.CardID), err)
+		SendErrorResponse(w, "Failed to activate card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[CARD_ACTIVATE] Failed to commit transaction: %v", err)
+		SendErrorResponse(w, "Failed to activate card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	cps.audit.LogOperation(req.CardID, userID, "CARD_ACTIVATED",
+		fmt.Sprintf("%s -> %s", models.CardStatusInactive, models.CardStatusActive))
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "activated"})
+	json.NewEncoder(w).Encode(map[string]string{"cardId": req.CardID, "status": models.CardStatusActive})
 }
 
 // GetCard retrieves card information
 // @Summary Get card details
-// @Description Retrieve information about a specific card
+// @Description Retrieve information about a specific card owned by the authenticated user
 // @Tags cards
 // @Produce json
 // @Param cardId path string true "Card ID"
-// @Success 200 {object} object{cardId=string,status=string,balance=float64}
+// @Success 200 {object} models.Card
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Router /cards/{cardId} [get]
 func (cps *CardProvisioningService) GetCard(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
 	cardID := chi.URLParam(r, "cardId")
+
+	card, err := cps.fetchCard(cardID)
+	if err != nil {
+		cps.sendCardError(w, err)
+		return
+	}
+
+	if strconv.Itoa(card.UserID) != userID {
+		cps.sendCardError(w, errCardNotOwned)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"cardId": cardID, "status": "active"})
+	json.NewEncoder(w).Encode(card)
 }
 
 // SuspendCard suspends a card
@@ -133,12 +362,12 @@ func (cps *CardProvisioningService) GetCard(w http.ResponseWriter, r *http.Reque
 // @Produce json
 // @Param cardId path string true "Card ID"
 // @Success 200 {object} object{cardId=string,status=string}
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /cards/{cardId}/suspend [put]
 func (cps *CardProvisioningService) SuspendCard(w http.ResponseWriter, r *http.Request) {
-	cardID := chi.URLParam(r, "cardId")
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"cardId": cardID, "status": "suspended"})
+	cps.transitionCard(w, r, models.CardStatusActive, models.CardStatusBlocked, accountStatusSuspended, "CARD_SUSPENDED")
 }
 
 // ReinstateCard reactivates a suspended card
@@ -148,10 +377,208 @@ func (cps *CardProvisioningService) SuspendCard(w http.ResponseWriter, r *http.R
 // @Produce json
 // @Param cardId path string true "Card ID"
 // @Success 200 {object} object{cardId=string,status=string}
+// @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Router /cards/{cardId}/reinstate [put]
 func (cps *CardProvisioningService) ReinstateCard(w http.ResponseWriter, r *http.Request) {
+	cps.transitionCard(w, r, models.CardStatusBlocked, models.CardStatusActive, accountStatusActive, "CARD_REINSTATED")
+}
+
+// transitionCard moves an owned card from one status to another and keeps
+// the linked account's status in step so transactions are gated as well.
+func (cps *CardProvisioningService) transitionCard(w http.ResponseWriter, r *http.Request, from, to, accountStatus, event string) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
 	cardID := chi.URLParam(r, "cardId")
+
+	tx, err := cps.db.Begin()
+	if err != nil {
+		log.Printf("[CARD_STATUS] Failed to begin transaction: %v", err)
+		SendErrorResponse(w, "Failed to update card", http.StatusInternalServerError, nil)
+		return
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	var status string
+	err = tx.QueryRow(`SELECT user_id, status FROM cards WHERE card_id = $1 FOR UPDATE`, cardID).Scan(&ownerID, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = errCardNotFound
+		}
+		cps.sendCardError(w, err)
+		return
+	}
+
+	if strconv.Itoa(ownerID) != userID {
+		cps.sendCardError(w, errCardNotOwned)
+		return
+	}
+
+	if status != from {
+		SendErrorResponse(w, fmt.Sprintf("Card cannot move from %s to %s", status, to), http.StatusConflict, nil)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE cards SET status = $1 WHERE card_id = $2`, to, cardID); err != nil {
+		log.Printf("[CARD_STATUS] Failed to update card %s: %v", maskCardID(cardID), err)
+		SendErrorResponse(w, "Failed to update card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE accounts SET status = $1, updated_at = NOW() WHERE card_id = $2`, accountStatus, cardID); err != nil {
+		log.Printf("[CARD_STATUS] Failed to update account for card %s: %v", maskCardID(cardID), err)
+		SendErrorResponse(w, "Failed to update card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("[CARD_STATUS] Failed to commit transaction: %v", err)
+		SendErrorResponse(w, "Failed to update card", http.StatusInternalServerError, nil)
+		return
+	}
+
+	cps.audit.LogOperation(cardID, userID, event, fmt.Sprintf("%s -> %s", from, to))
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"cardId": cardID, "status": "active"})
-}
\ No newline at end of file
+	json.NewEncoder(w).Encode(map[string]string{"cardId": cardID, "status": to})
+}
+
+// BlockUserCards blocks every active card owned by userID and suspends the
+// linked accounts, returning the IDs of the cards blocked. It backs
+// lost-card reports made outside the card endpoints, such as by voice.
+func (cps *CardProvisioningService) BlockUserCards(ctx context.Context, userID, source string) ([]string, error) {
+	tx, err := cps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE accounts SET status = $1, updated_at = NOW()
+		WHERE card_id IN (SELECT card_id FROM cards WHERE user_id::text = $2 AND status = $3)
+	`, accountStatusSuspended, userID, models.CardStatusActive); err != nil {
+		return nil, fmt.Errorf("failed to suspend accounts: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE cards SET status = $1 WHERE user_id::text = $2 AND status = $3 RETURNING card_id
+	`, models.CardStatusBlocked, userID, models.CardStatusActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to block cards: %w", err)
+	}
+	var cardIDs []string
+	for rows.Next() {
+		var cardID string
+		if err := rows.Scan(&cardID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cardIDs = append(cardIDs, cardID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, cardID := range cardIDs {
+		cps.audit.LogOperation(cardID, userID, "CARD_SUSPENDED",
+			fmt.Sprintf("%s -> %s (%s)", models.CardStatusActive, models.CardStatusBlocked, source))
+	}
+	return cardIDs, nil
+}
+
+func (cps *CardProvisioningService) fetchCard(cardID string) (*models.Card, error) {
+	var card models.Card
+	var lastSyncAt, lastTransactionAt, expiresAt sql.NullTime
+	err := cps.db.QueryRow(`
+		SELECT c.id, c.card_id, c.user_id, c.serial_number, COALESCE(a.balance, 0), c.currency, c.status,
+		       c.card_type, c.last_sync_at, c.last_transaction_at, c.tx_counter, c.max_balance,
+		       c.daily_spent, c.created_at, c.updated_at, c.expires_at
+		FROM cards c
+		LEFT JOIN accounts a ON a.card_id = c.card_id
+		WHERE c.card_id = $1
+	`, cardID).Scan(&card.ID, &card.CardID, &card.UserID, &card.SerialNumber, &card.Balance, &card.Currency,
+		&card.Status, &card.CardType, &lastSyncAt, &lastTransactionAt, &card.TxCounter, &card.MaxBalance,
+		&card.DailySpent, &card.CreatedAt, &card.UpdatedAt, &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errCardNotFound
+		}
+		return nil, err
+	}
+
+	// Account balances are held in minor units
+	card.Balance = card.Balance / 100
+	if lastSyncAt.Valid {
+		card.LastSyncAt = &lastSyncAt.Time
+	}
+	if lastTransactionAt.Valid {
+		card.LastTransactionAt = &lastTransactionAt.Time
+	}
+	if expiresAt.Valid {
+		card.ExpiresAt = &expiresAt.Time
+	}
+
+	return &card, nil
+}
+
+func (cps *CardProvisioningService) sendCardError(w http.ResponseWriter, err error) {
+	switch err {
+	case errCardNotFound:
+		SendErrorResponse(w, "Card not found", http.StatusNotFound, nil)
+	case errCardNotOwned:
+		SendErrorResponse(w, "Unauthorized: Card does not belong to user", http.StatusForbidden, nil)
+	default:
+		log.Printf("[CARD] Card lookup failed: %v", err)
+		SendErrorResponse(w, "Failed to fetch card", http.StatusInternalServerError, nil)
+	}
+}
+
+// deriveCardAuthKey derives the per-card HMAC key from an HSM-held signing key
+// so the CAK never leaves the HSM boundary as raw input. It uses the newest
+// rotation of the key; the CAK is stored with the card, so cards provisioned
+// before a rotation keep theirs.
+func (cps *CardProvisioningService) deriveCardAuthKey(cardID, serialNumber string) ([]byte, error) {
+	keyID, ok := hsm.NewestActiveKey(cps.hsm.ListKeys(cardAuthKeyDerivation))
+	if !ok {
+		return nil, fmt.Errorf("no active key for %s", cardAuthKeyDerivation)
+	}
+	sig, err := cps.hsm.SignData(keyID, []byte("CAK:"+cardID+":"+serialNumber))
+	if err != nil {
+		return nil, err
+	}
+	cak := sha256.Sum256(sig)
+	return cak[:], nil
+}
+
+func generateCardID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("CARD%X", b)
+}
+
+func generateSerialNumber() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%X", b)
+}
+
+func generateActivationCode() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+func hashActivationCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
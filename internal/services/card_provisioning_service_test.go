@@ -2,52 +2,139 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-chi/chi/v5"
+	"github.com/ruralpay/backend/internal/hsm"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
-func TestCardProvisioningService_ProvisionCard(t *testing.T) {
-	db, _, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	mockHSM := &MockHSM{}
-	service := NewCardProvisioningService(db, mockHSM)
+func withUserID(r *http.Request, userID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "userID", userID))
+}
 
+func TestCardProvisioningService_ProvisionCard(t *testing.T) {
 	t.Run("successful provisioning", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mockHSM := &MockHSM{}
+		mockHSM.On("ListKeys", cardAuthKeyDerivation).Return([]hsm.KeyInfo{
+			{ID: cardAuthKeyDerivation},
+			{ID: cardAuthKeyDerivation + "_1760000000", IsActive: true},
+		})
+		mockHSM.On("SignData", cardAuthKeyDerivation+"_1760000000", mockAnyBytes()).Return([]byte("derived-signature"), nil)
+		service := NewCardProvisioningService(db, mockHSM)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT first_name, last_name FROM users").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"first_name", "last_name"}).AddRow("Ada", "Obi"))
+		mock.ExpectExec("INSERT INTO cards").
+			WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), int64(0), "NGN", "inactive", "DEBIT",
+				sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO accounts").
+			WithArgs("Ada Obi", sqlmock.AnyArg(), sqlmock.AnyArg(), 1, int64(0), 1, accountStatusInactive).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
 		req := ProvisionRequest{
-			UserID:         1,
-			CardType:       "DEBIT",
-			InitialBalance: 100.0,
+			UserID:   1,
+			CardType: "DEBIT",
 		}
 
 		body, _ := json.Marshal(req)
-		r := httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer(body))
+		r := withUserID(httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer(body)), "1")
 		w := httptest.NewRecorder()
 
 		service.ProvisionCard(w, r)
 
-		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusCreated, w.Code)
 		var response map[string]string
 		json.Unmarshal(w.Body.Bytes(), &response)
-		assert.Equal(t, "provisioned", response["status"])
+		assert.Equal(t, "inactive", response["status"])
+		assert.Len(t, response["activationCode"], 6)
+		assert.NotEmpty(t, response["cardId"])
+		assert.NotEmpty(t, response["accountId"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+		mockHSM.AssertExpectations(t)
+	})
+
+	t.Run("another user's card", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
+		body, _ := json.Marshal(ProvisionRequest{UserID: 2, CardType: "DEBIT"})
+		r := withUserID(httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+
+		service.ProvisionCard(w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("initial balance is not accepted", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
+		body := []byte(`{"userId":1,"cardType":"DEBIT","initialBalance":1000000}`)
+		r := withUserID(httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+
+		service.ProvisionCard(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("hsm failure", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mockHSM := &MockHSM{}
+		mockHSM.On("ListKeys", cardAuthKeyDerivation).Return([]hsm.KeyInfo{{ID: cardAuthKeyDerivation, IsActive: true}})
+		mockHSM.On("SignData", cardAuthKeyDerivation, mockAnyBytes()).Return(nil, errors.New("hsm unavailable"))
+		service := NewCardProvisioningService(db, mockHSM)
+
+		body, _ := json.Marshal(ProvisionRequest{UserID: 1, CardType: "DEBIT"})
+		r := withUserID(httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+
+		service.ProvisionCard(w, r)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
 	})
 
 	t.Run("invalid card type", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
 		req := ProvisionRequest{
-			UserID:         1,
-			CardType:       "INVALID",
-			InitialBalance: 100.0,
+			UserID:   1,
+			CardType: "INVALID",
 		}
 
 		body, _ := json.Marshal(req)
-		r := httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer(body))
+		r := withUserID(httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer(body)), "1")
 		w := httptest.NewRecorder()
 
 		service.ProvisionCard(w, r)
@@ -56,6 +143,12 @@ func TestCardProvisioningService_ProvisionCard(t *testing.T) {
 	})
 
 	t.Run("invalid request body", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
 		r := httptest.NewRequest("POST", "/cards/provision", bytes.NewBuffer([]byte("invalid")))
 		w := httptest.NewRecorder()
 
@@ -66,21 +159,31 @@ func TestCardProvisioningService_ProvisionCard(t *testing.T) {
 }
 
 func TestCardProvisioningService_ActivateCard(t *testing.T) {
-	db, _, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	mockHSM := &MockHSM{}
-	service := NewCardProvisioningService(db, mockHSM)
+	activationColumns := []string{"user_id", "status", "activation_code_hash", "activation_expires_at", "activation_attempts"}
 
 	t.Run("successful activation", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT user_id, status, activation_code_hash").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows(activationColumns).
+				AddRow(1, "inactive", hashActivationCode("123456"), time.Now().Add(time.Hour), 0))
+		mock.ExpectExec("UPDATE cards").WithArgs("active", "card123").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE accounts").WithArgs("ACTIVE", "card123").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
 		req := ActivationRequest{
 			CardID:         "card123",
 			ActivationCode: "123456",
 		}
 
 		body, _ := json.Marshal(req)
-		r := httptest.NewRequest("POST", "/cards/activate", bytes.NewBuffer(body))
+		r := withUserID(httptest.NewRequest("POST", "/cards/activate", bytes.NewBuffer(body)), "1")
 		w := httptest.NewRecorder()
 
 		service.ActivateCard(w, r)
@@ -88,17 +191,72 @@ func TestCardProvisioningService_ActivateCard(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 		var response map[string]string
 		json.Unmarshal(w.Body.Bytes(), &response)
-		assert.Equal(t, "activated", response["status"])
+		assert.Equal(t, "active", response["status"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("wrong activation code", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT user_id, status, activation_code_hash").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows(activationColumns).
+				AddRow(1, "inactive", hashActivationCode("123456"), time.Now().Add(time.Hour), 0))
+		mock.ExpectExec("UPDATE cards SET activation_attempts").WithArgs("card123").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		body, _ := json.Marshal(ActivationRequest{CardID: "card123", ActivationCode: "654321"})
+		r := withUserID(httptest.NewRequest("POST", "/cards/activate", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+
+		service.ActivateCard(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("card owned by another user", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT user_id, status, activation_code_hash").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows(activationColumns).
+				AddRow(2, "inactive", hashActivationCode("123456"), time.Now().Add(time.Hour), 0))
+		mock.ExpectRollback()
+
+		body, _ := json.Marshal(ActivationRequest{CardID: "card123", ActivationCode: "123456"})
+		r := withUserID(httptest.NewRequest("POST", "/cards/activate", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+
+		service.ActivateCard(w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
 	})
 
 	t.Run("invalid activation code length", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
 		req := ActivationRequest{
 			CardID:         "card123",
 			ActivationCode: "123",
 		}
 
 		body, _ := json.Marshal(req)
-		r := httptest.NewRequest("POST", "/cards/activate", bytes.NewBuffer(body))
+		r := withUserID(httptest.NewRequest("POST", "/cards/activate", bytes.NewBuffer(body)), "1")
 		w := httptest.NewRecorder()
 
 		service.ActivateCard(w, r)
@@ -108,63 +266,116 @@ func TestCardProvisioningService_ActivateCard(t *testing.T) {
 }
 
 func TestCardProvisioningService_GetCard(t *testing.T) {
-	db, _, err := sqlmock.New()
+	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	mockHSM := &MockHSM{}
 	service := NewCardProvisioningService(db, mockHSM)
 
+	now := time.Now()
+	mock.ExpectQuery("SELECT c.id, c.card_id").
+		WithArgs("card123").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "card_id", "user_id", "serial_number", "balance", "currency", "status", "card_type",
+			"last_sync_at", "last_transaction_at", "tx_counter", "max_balance", "daily_spent",
+			"created_at", "updated_at", "expires_at",
+		}).AddRow(1, "card123", 1, "SN123", 10000, "NGN", "active", "DEBIT",
+			nil, nil, 3, 10000.0, 0.0, now, now, nil))
+
 	r := chi.NewRouter()
 	r.Get("/cards/{cardId}", service.GetCard)
 
-	req := httptest.NewRequest("GET", "/cards/card123", nil)
+	req := withUserID(httptest.NewRequest("GET", "/cards/card123", nil), "1")
 	w := httptest.NewRecorder()
 
 	r.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	var response map[string]string
+	var response map[string]any
 	json.Unmarshal(w.Body.Bytes(), &response)
-	assert.Equal(t, "card123", response["cardId"])
+	assert.Equal(t, "card123", response["card_id"])
 	assert.Equal(t, "active", response["status"])
+	assert.Equal(t, 100.0, response["balance"])
 }
 
 func TestCardProvisioningService_SuspendCard(t *testing.T) {
-	db, _, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+	t.Run("active card", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
 
-	mockHSM := &MockHSM{}
-	service := NewCardProvisioningService(db, mockHSM)
+		service := NewCardProvisioningService(db, &MockHSM{})
 
-	r := chi.NewRouter()
-	r.Put("/cards/{cardId}/suspend", service.SuspendCard)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT user_id, status FROM cards").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+		mock.ExpectExec("UPDATE cards SET status").WithArgs("blocked", "card123").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE accounts SET status").WithArgs("SUSPENDED", "card123").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
 
-	req := httptest.NewRequest("PUT", "/cards/card123/suspend", nil)
-	w := httptest.NewRecorder()
+		r := chi.NewRouter()
+		r.Put("/cards/{cardId}/suspend", service.SuspendCard)
 
-	r.ServeHTTP(w, req)
+		req := withUserID(httptest.NewRequest("PUT", "/cards/card123/suspend", nil), "1")
+		w := httptest.NewRecorder()
 
-	assert.Equal(t, http.StatusOK, w.Code)
-	var response map[string]string
-	json.Unmarshal(w.Body.Bytes(), &response)
-	assert.Equal(t, "card123", response["cardId"])
-	assert.Equal(t, "suspended", response["status"])
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]string
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, "card123", response["cardId"])
+		assert.Equal(t, "blocked", response["status"])
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already blocked", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewCardProvisioningService(db, &MockHSM{})
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT user_id, status FROM cards").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "blocked"))
+		mock.ExpectRollback()
+
+		r := chi.NewRouter()
+		r.Put("/cards/{cardId}/suspend", service.SuspendCard)
+
+		req := withUserID(httptest.NewRequest("PUT", "/cards/card123/suspend", nil), "1")
+		w := httptest.NewRecorder()
+
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
 }
 
 func TestCardProvisioningService_ReinstateCard(t *testing.T) {
-	db, _, err := sqlmock.New()
+	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	mockHSM := &MockHSM{}
 	service := NewCardProvisioningService(db, mockHSM)
 
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM cards").
+		WithArgs("card123").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "blocked"))
+	mock.ExpectExec("UPDATE cards SET status").WithArgs("active", "card123").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts SET status").WithArgs("ACTIVE", "card123").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
 	r := chi.NewRouter()
 	r.Put("/cards/{cardId}/reinstate", service.ReinstateCard)
 
-	req := httptest.NewRequest("PUT", "/cards/card123/reinstate", nil)
+	req := withUserID(httptest.NewRequest("PUT", "/cards/card123/reinstate", nil), "1")
 	w := httptest.NewRecorder()
 
 	r.ServeHTTP(w, req)
@@ -174,4 +385,9 @@ func TestCardProvisioningService_ReinstateCard(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &response)
 	assert.Equal(t, "card123", response["cardId"])
 	assert.Equal(t, "active", response["status"])
-}
\ No newline at end of file
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func mockAnyBytes() any {
+	return mock.AnythingOfType("[]uint8")
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ruralpay/backend/internal/models"
+)
+
+// Conflict resolutions returned to the card on sync
+const (
+	ResolutionKeepLocal = "keep_local"
+	ResolutionUseServer = "use_server"
+	ResolutionManual    = "manual"
+)
+
+// SyncCard reconciles transactions captured by a card while offline
+// @Summary Sync offline card
+// @Description Verify and post offline card transactions, returning server-side balance, limits and conflicts
+// @Tags cards
+// @Accept json
+// @Produce json
+// @Param cardId path string true "Card ID"
+// @Param sync body models.CardSyncRequest true "Card sync data"
+// @Success 200 {object} models.CardSyncResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /cards/{cardId}/sync [post]
+func (ts *TransactionService) SyncCard(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	cardID := chi.URLParam(r, "cardId")
+
+	maxBytes := 1_048_576 // 1 MB
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var req models.CardSyncRequest
+	if err := dec.Decode(&req); err != nil {
+		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		SendErrorResponse(w, "Request body must only contain a single JSON object", http.StatusBadRequest, nil)
+		return
+	}
+
+	if req.CardID == "" || req.CardID != cardID {
+		SendErrorResponse(w, "Card ID mismatch", http.StatusBadRequest, nil)
+		return
+	}
+
+	if len(req.Transactions) > 100 {
+		SendErrorResponse(w, "Sync batch size exceeds limit (100)", http.StatusBadRequest, nil)
+		return
+	}
+
+	// Verify card belongs to authenticated user
+	if err := ts.verifyCardOwnership(cardID, userID); err != nil {
+		SendErrorResponse(w, "Unauthorized: Card does not belong to user", http.StatusForbidden, nil)
+		return
+	}
+
+	// Verify the card signed its own snapshot
+	if err := ts.verifySyncSignature(&req); err != nil {
+		log.Printf("[CARD_SYNC] Snapshot signature verification failed for card %s: %v", maskCardID(cardID), err)
+		SendErrorResponse(w, "Signature verification failed", http.StatusUnauthorized, nil)
+		return
+	}
+
+	log.Printf("[CARD_SYNC] Syncing %d offline transactions for card %s", len(req.Transactions), maskCardID(cardID))
+
+	// Replay in counter order so the incrementing-counter check holds
+	offline := make([]models.Transaction, len(req.Transactions))
+	copy(offline, req.Transactions)
+	sort.SliceStable(offline, func(i, j int) bool {
+		return offline[i].Counter < offline[j].Counter
+	})
+
+	var updates []models.TransactionUpdate
+	var conflicts []models.TransactionConflict
+	var posted []Transaction
+	var maxCounter uint32
+
+	for _, local := range offline {
+		update, conflict, tx := ts.syncOfflineTransaction(cardID, local)
+		if update != nil {
+			updates = append(updates, *update)
+		}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+		}
+		if tx != nil {
+			posted = append(posted, *tx)
+			if tx.Counter > maxCounter {
+				maxCounter = tx.Counter
+			}
+		}
+	}
+
+	// Queue posted transactions for settlement (after commit)
+	for i := range posted {
+		if err := ts.queueForSettlement(&posted[i]); err != nil {
+			log.Printf("[CARD_SYNC] Failed to queue transaction %s for settlement: %v", posted[i].TxID, err)
+		}
+	}
+
+	// Surface server-side changes the card has not seen yet
+	serverUpdates, err := ts.fetchPendingUpdates(cardID, req.LastSyncAt)
+	if err != nil {
+		log.Printf("[CARD_SYNC] Failed to fetch pending updates for card %s: %v", maskCardID(cardID), err)
+	}
+	updates = append(updates, serverUpdates...)
+
+	syncedAt := time.Now()
+	if _, err := ts.db.Exec(`
+		UPDATE cards
+		SET last_sync_at = $1, tx_counter = GREATEST(tx_counter, $2)
+		WHERE card_id = $3
+	`, syncedAt, maxCounter, cardID); err != nil {
+		log.Printf("[CARD_SYNC] Failed to update sync state for card %s: %v", maskCardID(cardID), err)
+	}
+
+	resp, err := ts.buildSyncResponse(cardID, syncedAt)
+	if err != nil {
+		log.Printf("[CARD_SYNC] Failed to load card state for %s: %v", maskCardID(cardID), err)
+		SendErrorResponse(w, "Failed to load card state", http.StatusInternalServerError, nil)
+		return
+	}
+	resp.PendingUpdates = updates
+	resp.Conflicts = conflicts
+
+	ts.audit.LogOperation("CARD_SYNC", cardID, "CARD_SYNC",
+		fmt.Sprintf("posted=%d conflicts=%d", len(posted), len(conflicts)))
+
+	log.Printf("[CARD_SYNC] Sync complete for card %s: posted=%d, conflicts=%d", maskCardID(cardID), len(posted), len(conflicts))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// syncOfflineTransaction verifies and posts a single offline transaction.
+// It returns a status update for the card, a conflict when the card and
+// server disagree, and the posted transaction when money moved.
+func (ts *TransactionService) syncOfflineTransaction(cardID string, local models.Transaction) (*models.TransactionUpdate, *models.TransactionConflict, *Transaction) {
+	tx := fromOfflineTransaction(cardID, local)
+
+	// Already on the server: either a retry of a previous sync or a clash
+	if server, err := ts.fetchTransaction(tx.TxID); err == nil {
+		if server.CardID == tx.CardID && server.MerchantID == tx.MerchantID && server.Amount == tx.Amount {
+			return &models.TransactionUpdate{TransactionID: tx.TxID, Status: server.Status}, nil, nil
+		}
+		resolution := ResolutionUseServer
+		if strings.HasPrefix(server.Status, "FAILED") {
+			// Nothing was posted for the server copy, so the card's record stands
+			resolution = ResolutionKeepLocal
+		}
+		return nil, &models.TransactionConflict{
+			LocalTransaction:  local,
+			ServerTransaction: toModelTransaction(server),
+			Resolution:        resolution,
+		}, nil
+	}
+
+	if err := ts.validateOfflineTransaction(&tx); err != nil {
+		log.Printf("[CARD_SYNC] Offline transaction %s rejected: %v", tx.TxID, err)
+		ts.audit.LogError(tx.TxID, cardID, err)
+		return &models.TransactionUpdate{TransactionID: tx.TxID, Status: "FAILED"}, nil, nil
+	}
+
+	if err := ts.verifySignature(&tx); err != nil {
+		log.Printf("[CARD_SYNC] Offline transaction %s signature invalid: %v", tx.TxID, err)
+		ts.audit.LogError(tx.TxID, cardID, err)
+		return &models.TransactionUpdate{TransactionID: tx.TxID, Status: "FAILED"}, nil, nil
+	}
+
+	if err := ts.checkDoubleSpending(&tx); err != nil {
+		log.Printf("[CARD_SYNC] Offline transaction %s counter check failed: %v", tx.TxID, err)
+		conflict := &models.TransactionConflict{
+			LocalTransaction: local,
+			Resolution:       ResolutionManual,
+		}
+		if server, err := ts.fetchTransactionByCounter(cardID, tx.Counter); err == nil {
+			conflict.ServerTransaction = toModelTransaction(server)
+			conflict.Resolution = ResolutionUseServer
+		}
+		return nil, conflict, nil
+	}
+
+	if err := ts.postOfflineTransaction(&tx); err != nil {
+		ts.audit.LogError(tx.TxID, cardID, err)
+		if strings.Contains(err.Error(), "insufficient balance") {
+			// The card spent money the server does not have; needs a human
+			return nil, &models.TransactionConflict{
+				LocalTransaction: local,
+				Resolution:       ResolutionManual,
+			}, nil
+		}
+		return &models.TransactionUpdate{TransactionID: tx.TxID, Status: "FAILED"}, nil, nil
+	}
+
+	return &models.TransactionUpdate{TransactionID: tx.TxID, Status: tx.Status}, nil, &tx
+}
+
+// validateOfflineTransaction applies the checks from validateTransaction that
+// still make sense offline; the timestamp window and nonce are skipped since
+// the card may have been disconnected for hours.
+func (ts *TransactionService) validateOfflineTransaction(tx *Transaction) error {
+	if tx.TxID == "" {
+		return errors.New("transaction ID is required")
+	}
+
+	if tx.MerchantID == "" {
+		return errors.New("merchant ID is required")
+	}
+
+	if tx.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+
+	if tx.Currency == "" {
+		return errors.New("currency is required")
+	}
+
+	if tx.Counter == 0 {
+		return errors.New("counter is required")
+	}
+
+	if tx.Signature == "" {
+		return errors.New("signature is required")
+	}
+
+	if tx.Timestamp > time.Now().Unix()+30 {
+		return errors.New("transaction timestamp is in the future")
+	}
+
+	return ts.validateAccountInternal(tx.CardID)
+}
+
+func (ts *TransactionService) postOfflineTransaction(tx *Transaction) error {
+	dbTx, err := ts.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	// The card already approved this payment offline, so it only counts
+	// towards the spend limits
+	if err := ts.limits.Record(dbTx, tx.CardID, tx.Amount); err != nil {
+		return err
+	}
+
+	if err := ts.processLedgerTransferTx(dbTx, tx); err != nil {
+		return err
+	}
+
+	if err := ts.storeTransactionTx(dbTx, tx); err != nil {
+		return err
+	}
+
+	if _, err := dbTx.Exec(`
+		UPDATE transactions SET sync_status = 'synced' WHERE transaction_id = $1
+	`, tx.TxID); err != nil {
+		return err
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return err
+	}
+
+	ts.setIdempotency(tx.TxID, tx.Status)
+	return nil
+}
+
+// verifySyncSignature checks the HMAC the card computed over its own snapshot
+func (ts *TransactionService) verifySyncSignature(req *models.CardSyncRequest) error {
+	cak, err := ts.getCardAuthKey(req.CardID)
+	if err != nil {
+		return fmt.Errorf("failed to get card keys: %v", err)
+	}
+
+	h := hmac.New(sha256.New, cak)
+	h.Write(serializeSyncSnapshot(req))
+	expectedSig := hex.EncodeToString(h.Sum(nil))
+
+	if !hmac.Equal([]byte(expectedSig), []byte(req.Signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+func serializeSyncSnapshot(req *models.CardSyncRequest) []byte {
+	data := []byte{}
+	data = append(data, []byte(req.CardID)...)
+	data = append(data, int64ToBytes(toMinorUnits(req.Balance))...)
+	data = append(data, uint32ToBytes(uint32(req.TxCounter))...)
+	data = append(data, int64ToBytes(req.LastSyncAt.Unix())...)
+	return data
+}
+
+func (ts *TransactionService) fetchTransactionByCounter(cardID string, counter uint32) (*Transaction, error) {
+	var txID string
+	err := ts.db.QueryRow(`
+		SELECT transaction_id FROM transactions
+		WHERE card_id = $1 AND counter = $2
+		LIMIT 1
+	`, cardID, counter).Scan(&txID)
+	if err != nil {
+		return nil, err
+	}
+	return ts.fetchTransaction(txID)
+}
+
+func (ts *TransactionService) fetchPendingUpdates(cardID string, since time.Time) ([]models.TransactionUpdate, error) {
+	rows, err := ts.db.Query(`
+		SELECT transaction_id, status, settled_at
+		FROM transactions
+		WHERE from_card_id = $1 AND updated_at > $2
+		ORDER BY updated_at ASC
+		LIMIT 100
+	`, cardID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var updates []models.TransactionUpdate
+	for rows.Next() {
+		var update models.TransactionUpdate
+		var settledAt sql.NullTime
+		if err := rows.Scan(&update.TransactionID, &update.Status, &settledAt); err != nil {
+			return nil, err
+		}
+		if settledAt.Valid {
+			update.SettledAt = &settledAt.Time
+		}
+		updates = append(updates, update)
+	}
+
+	return updates, rows.Err()
+}
+
+func (ts *TransactionService) buildSyncResponse(cardID string, syncedAt time.Time) (*models.CardSyncResponse, error) {
+	var status, currency string
+	var dailySpent int64
+	today, _ := ts.limits.periods()
+	err := ts.db.QueryRow(`
+		SELECT c.status, c.currency, COALESCE(sc.amount, 0)
+		FROM cards c
+		LEFT JOIN spend_counters sc ON sc.subject_type = 'card' AND sc.subject_id = c.card_id
+		     AND sc.period = 'day' AND sc.period_start = $2
+		WHERE c.card_id = $1
+	`, cardID, today).Scan(&status, &currency, &dailySpent)
+	if err != nil {
+		return nil, err
+	}
+
+	dailyLimit := ts.cardDailyLimit
+	if limit, err := ts.limits.DailyLimit(cardID); err == nil && limit > 0 {
+		dailyLimit = float64(limit) / 100
+	}
+
+	var balance int64
+	err = ts.db.QueryRow(`
+		SELECT balance FROM accounts WHERE card_id = $1
+	`, cardID).Scan(&balance)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CardSyncResponse{
+		CardID:     cardID,
+		Balance:    float64(balance) / 100,
+		Currency:   currency,
+		LastSyncAt: syncedAt,
+		DailyLimit: dailyLimit,
+		DailySpent: float64(dailySpent) / 100,
+		IsActive:   status == models.CardStatusActive,
+	}, nil
+}
+
+// fromOfflineTransaction maps a card-captured transaction onto the wire
+// format the card signs; amounts arrive in major units.
+func fromOfflineTransaction(cardID string, local models.Transaction) Transaction {
+	txType := local.Type
+	if txType != "CREDIT" {
+		txType = "DEBIT"
+	}
+	return Transaction{
+		Version:    1,
+		TxID:       local.TransactionID,
+		Timestamp:  local.Timestamp,
+		CardID:     cardID,
+		MerchantID: local.ToCardID,
+		Amount:     toMinorUnits(local.Amount),
+		Currency:   local.Currency,
+		Counter:    local.Counter,
+		TxType:     txType,
+		Signature:  local.Signature,
+	}
+}
+
+func toModelTransaction(tx *Transaction) models.Transaction {
+	return models.Transaction{
+		TransactionID: tx.TxID,
+		FromCardID:    tx.CardID,
+		ToCardID:      tx.MerchantID,
+		Amount:        float64(tx.Amount) / 100,
+		Currency:      tx.Currency,
+		Status:        tx.Status,
+		Type:          tx.TxType,
+		Signature:     tx.Signature,
+		Counter:       tx.Counter,
+		Timestamp:     tx.Timestamp,
+		CreatedAt:     tx.CreatedAt,
+	}
+}
+
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
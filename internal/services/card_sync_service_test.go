@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionService_SyncCard(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	redisClient, _ := redismock.NewClientMock()
+	mockHSM := &MockHSM{}
+	service := NewTransactionService(db, redisClient, mockHSM)
+
+	router := chi.NewRouter()
+	router.Post("/cards/{cardId}/sync", service.SyncCard)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/cards/card123/sync", bytes.NewBuffer([]byte("{}")))
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("card ID mismatch", func(t *testing.T) {
+		body, _ := json.Marshal(models.CardSyncRequest{CardID: "other", Signature: "sig"})
+		req := httptest.NewRequest("POST", "/cards/card123/sync", bytes.NewBuffer(body))
+		req = req.WithContext(context.WithValue(req.Context(), "userID", "1"))
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("card does not belong to user", func(t *testing.T) {
+		mock.ExpectQuery("SELECT user_id FROM cards WHERE card_id = \\$1").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+
+		body, _ := json.Marshal(models.CardSyncRequest{CardID: "card123", Signature: "sig"})
+		req := httptest.NewRequest("POST", "/cards/card123/sync", bytes.NewBuffer(body))
+		req = req.WithContext(context.WithValue(req.Context(), "userID", "1"))
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid snapshot signature", func(t *testing.T) {
+		cak := []byte("0123456789abcdef")
+
+		mock.ExpectQuery("SELECT user_id FROM cards WHERE card_id = \\$1").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+		mock.ExpectQuery("SELECT cak FROM cards WHERE card_id = \\$1").
+			WithArgs("card123").
+			WillReturnRows(sqlmock.NewRows([]string{"cak"}).AddRow(hex.EncodeToString(cak)))
+
+		body, _ := json.Marshal(models.CardSyncRequest{CardID: "card123", Balance: 10, TxCounter: 1, Signature: "bad"})
+		req := httptest.NewRequest("POST", "/cards/card123/sync", bytes.NewBuffer(body))
+		req = req.WithContext(context.WithValue(req.Context(), "userID", "1"))
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestTransactionService_verifySyncSignature(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	redisClient, _ := redismock.NewClientMock()
+	mockHSM := &MockHSM{}
+	service := NewTransactionService(db, redisClient, mockHSM)
+
+	cak := []byte("0123456789abcdef")
+	req := &models.CardSyncRequest{
+		CardID:     "card123",
+		Balance:    154.20,
+		TxCounter:  7,
+		LastSyncAt: time.Unix(1700000000, 0),
+	}
+	h := hmac.New(sha256.New, cak)
+	h.Write(serializeSyncSnapshot(req))
+	req.Signature = hex.EncodeToString(h.Sum(nil))
+
+	mock.ExpectQuery("SELECT cak FROM cards WHERE card_id = \\$1").
+		WithArgs("card123").
+		WillReturnRows(sqlmock.NewRows([]string{"cak"}).AddRow(hex.EncodeToString(cak)))
+
+	assert.NoError(t, service.verifySyncSignature(req))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFromOfflineTransaction(t *testing.T) {
+	local := models.Transaction{
+		TransactionID: "tx123",
+		ToCardID:      "merchant123",
+		Amount:        12.34,
+		Currency:      "NGN",
+		Counter:       9,
+		Timestamp:     1700000000,
+		Signature:     "sig",
+	}
+
+	tx := fromOfflineTransaction("card123", local)
+
+	assert.Equal(t, "card123", tx.CardID)
+	assert.Equal(t, "merchant123", tx.MerchantID)
+	assert.Equal(t, int64(1234), tx.Amount)
+	assert.Equal(t, uint32(9), tx.Counter)
+	assert.Equal(t, "DEBIT", tx.TxType)
+	assert.Equal(t, uint8(1), tx.Version)
+}
+
+func TestTransactionService_syncOfflineTransactionConflict(t *testing.T) {
+	local := models.Transaction{
+		TransactionID: "tx123",
+		ToCardID:      "merchant123",
+		Amount:        12.34,
+		Currency:      "NGN",
+		Counter:       9,
+		Timestamp:     1700000000,
+		Signature:     "sig",
+	}
+
+	tests := []struct {
+		status     string
+		resolution string
+	}{
+		{"FAILED_INSUFFICIENT_BALANCE", ResolutionKeepLocal},
+		// Dead-lettered payments were posted, so the card must not post its copy
+		{StatusSettlementDeadLetter, ResolutionUseServer},
+		{"COMPLETED", ResolutionUseServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			redisClient, _ := redismock.NewClientMock()
+			service := NewTransactionService(db, redisClient, &MockHSM{})
+
+			mock.ExpectQuery("SELECT transaction_id, from_card_id, to_card_id").
+				WithArgs("tx123").
+				WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "from_card_id", "to_card_id", "amount", "currency", "timestamp", "signature", "type", "status", "created_at"}).
+					AddRow("tx123", "card123", "merchant123", "5000", "NGN", 1700000000, "", "DEBIT", tt.status, time.Now()))
+
+			update, conflict, posted := service.syncOfflineTransaction("card123", local)
+
+			assert.Nil(t, update)
+			assert.Nil(t, posted)
+			if assert.NotNil(t, conflict) {
+				assert.Equal(t, tt.resolution, conflict.Resolution)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTransactionService_postOfflineTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	redisClient, redisMock := redismock.NewClientMock()
+	service := NewTransactionService(db, redisClient, &MockHSM{})
+	service.limits = fixedLimitsService(db)
+
+	tx := fromOfflineTransaction("card123", models.Transaction{
+		TransactionID: "tx123",
+		ToCardID:      "merchant123",
+		Amount:        12.34,
+		Currency:      "NGN",
+		Counter:       9,
+		Timestamp:     1700000000,
+		Signature:     "sig",
+	})
+
+	mock.ExpectBegin()
+
+	// The card approved the payment offline, so the spend is only recorded
+	mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+		WithArgs("card123").
+		WillReturnRows(limitSubjectRows().AddRow("card123", 7, 1, 1000, 1000, 1000, 1000, 1000))
+	expectSpend(mock, "card", "card123", "day", "2026-03-14", 1234, 1234)
+	mock.ExpectExec("UPDATE cards SET daily_spent").
+		WithArgs(int64(1234), "card123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSpend(mock, "card", "card123", "month", "2026-03-01", 1234, 1234)
+	expectSpend(mock, "user", "7", "day", "2026-03-14", 1234, 1234)
+	expectSpend(mock, "user", "7", "month", "2026-03-01", 1234, 1234)
+
+	// Card pays merchant through the ledger
+	for _, acct := range []struct {
+		id, lockID string
+		balance    int64
+		version    int
+	}{{"acct-card", "card123", 10000, 1}, {"acct-merchant", "merchant123", 500, 3}} {
+		mock.ExpectQuery("SELECT id, balance, version, updated_at").
+			WithArgs(acct.lockID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).
+				AddRow(acct.id, acct.balance, acct.version, time.Now()))
+	}
+	mock.ExpectExec("INSERT INTO ledger_entries").
+		WithArgs("tx123", "acct-card", int64(-1234), "DEBIT", int64(8766), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO ledger_entries").
+		WithArgs("tx123", "acct-merchant", int64(1234), "CREDIT", int64(1734), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").
+		WithArgs(int64(8766), sqlmock.AnyArg(), "acct-card", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").
+		WithArgs(int64(1734), sqlmock.AnyArg(), "acct-merchant", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectQuery("SELECT user_id FROM accounts WHERE card_id = \\$1").
+		WithArgs("card123").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs("tx123", "card123", "merchant123", "card123", int64(9), int64(1234), "NGN",
+			"DEBIT", "sig", "COMPLETED", int64(7), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE transactions SET sync_status = 'synced'").
+		WithArgs("tx123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	redisMock.ExpectSetEX("idempotency:tx123", "COMPLETED", 24*time.Hour).SetVal("OK")
+
+	assert.NoError(t, service.postOfflineTransaction(&tx))
+	assert.Equal(t, "COMPLETED", tx.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
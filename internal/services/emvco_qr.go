@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EMVCo Merchant-Presented Mode tags used by NQR payloads
+const (
+	emvTagPayloadFormat  = "00"
+	emvTagInitiation     = "01"
+	emvTagMerchantInfo   = "26"
+	emvTagCategoryCode   = "52"
+	emvTagCurrency       = "53"
+	emvTagAmount         = "54"
+	emvTagCountry        = "58"
+	emvTagMerchantName   = "59"
+	emvTagMerchantCity   = "60"
+	emvTagAdditionalData = "62"
+	emvTagCRC            = "63"
+
+	// Sub-tags of the merchant account information template
+	emvMerchantGUID        = "00"
+	emvMerchantInstitution = "01"
+	emvMerchantAccount     = "02"
+
+	// Sub-tag of the additional data template
+	emvAdditionalReference = "05"
+
+	// Unreserved templates 80-99 carry the HSM signature, which is too long
+	// for one data object. The first template names the signing key and the
+	// following ones hold the signature in chunks, each behind our GUID.
+	emvSignatureFirstTag  = 80
+	emvSignatureLastTag   = 99
+	emvSignatureGUID      = "00"
+	emvSignatureKey       = "01"
+	emvSignatureChunk     = "02"
+	emvSignatureChunkSize = 70
+
+	// Point of initiation values
+	emvInitiationStatic  = "11"
+	emvInitiationDynamic = "12"
+
+	emvCurrencyNGN = "566"
+	emvCountryNG   = "NG"
+)
+
+var (
+	ErrQRMalformed    = errors.New("malformed QR payload")
+	ErrQRChecksum     = errors.New("QR checksum mismatch")
+	ErrQRUnsigned     = errors.New("QR payload is not signed by this issuer")
+	ErrQRBadSignature = errors.New("QR signature verification failed")
+)
+
+// emvField is one top-level TLV data object. Offset is the position of the
+// tag within the payload.
+type emvField struct {
+	Tag    string
+	Value  string
+	Offset int
+}
+
+// emvTLV encodes one data object as tag, two-digit length and value
+func emvTLV(tag, value string) string {
+	return fmt.Sprintf("%s%02d%s", tag, len(value), value)
+}
+
+// parseEMVFields splits a payload into its top-level data objects
+func parseEMVFields(payload string) ([]emvField, error) {
+	var fields []emvField
+	for i := 0; i < len(payload); {
+		if i+4 > len(payload) {
+			return nil, fmt.Errorf("%w: truncated header at %d", ErrQRMalformed, i)
+		}
+		length, err := strconv.Atoi(payload[i+2 : i+4])
+		if err != nil || length < 0 || i+4+length > len(payload) {
+			return nil, fmt.Errorf("%w: bad length for tag %s", ErrQRMalformed, payload[i:i+2])
+		}
+		fields = append(fields, emvField{Tag: payload[i : i+2], Value: payload[i+4 : i+4+length], Offset: i})
+		i += 4 + length
+	}
+	return fields, nil
+}
+
+// emvSubFields decodes a template value into a tag to value map
+func emvSubFields(value string) (map[string]string, error) {
+	fields, err := parseEMVFields(value)
+	if err != nil {
+		return nil, err
+	}
+	sub := make(map[string]string, len(fields))
+	for _, f := range fields {
+		sub[f.Tag] = f.Value
+	}
+	return sub, nil
+}
+
+// emvCRC16 is CRC-16/CCITT-FALSE (polynomial 0x1021, initial 0xFFFF) as
+// required by EMVCo for tag 63.
+func emvCRC16(data string) string {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%04X", crc)
+}
+
+// emvText trims a value to the EMVCo field limit, keeping it printable
+func emvText(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7E {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+	if len(value) > max {
+		value = value[:max]
+	}
+	return value
+}
+
+// formatNairaAmount renders kobo as the decimal amount EMVCo expects
+func formatNairaAmount(kobo int64) string {
+	return fmt.Sprintf("%d.%02d", kobo/100, kobo%100)
+}
+
+// parseNairaAmount converts a tag 54 amount back to kobo
+func parseNairaAmount(value string) (int64, error) {
+	whole, frac, _ := strings.Cut(value, ".")
+	if whole == "" || len(frac) > 2 {
+		return 0, fmt.Errorf("%w: bad amount %q", ErrQRMalformed, value)
+	}
+	naira, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || naira < 0 {
+		return 0, fmt.Errorf("%w: bad amount %q", ErrQRMalformed, value)
+	}
+	kobo := int64(0)
+	if frac != "" {
+		for len(frac) < 2 {
+			frac += "0"
+		}
+		if kobo, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return 0, fmt.Errorf("%w: bad amount %q", ErrQRMalformed, value)
+		}
+	}
+	return naira*100 + kobo, nil
+}
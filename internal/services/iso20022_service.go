@@ -1,10 +1,13 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,14 +18,44 @@ import (
 
 type ISO20022Service struct {
 	validator *ValidationHelper
+	transport SettlementTransport
+}
+
+var (
+	settlementTransportOnce sync.Once
+	settlementTransport     SettlementTransport
+)
+
+// defaultSettlementTransport builds the configured transport once so every
+// ISO20022Service shares the same connection pool.
+func defaultSettlementTransport() SettlementTransport {
+	settlementTransportOnce.Do(func() {
+		transport, err := NewSettlementTransportFromEnv()
+		if err != nil {
+			log.Printf("[SETTLEMENT] Failed to configure settlement transport: %v", err)
+			transport = &unavailableSettlementTransport{err: err}
+		}
+		settlementTransport = transport
+	})
+	return settlementTransport
 }
 
 func NewISO20022Service() *ISO20022Service {
+	return NewISO20022ServiceWithTransport(defaultSettlementTransport())
+}
+
+func NewISO20022ServiceWithTransport(transport SettlementTransport) *ISO20022Service {
 	return &ISO20022Service{
 		validator: NewValidationHelper(),
+		transport: transport,
 	}
 }
 
+// Transport returns the settlement transport used by this service.
+func (iso *ISO20022Service) Transport() SettlementTransport {
+	return iso.transport
+}
+
 // ConvertToISO20022 converts transaction to ISO20022 format
 // @Summary Convert to ISO20022
 // @Description Convert transaction data to ISO20022 XML format
@@ -100,15 +133,27 @@ func (iso *ISO20022Service) ProcessSettlement(w http.ResponseWriter, r *http.Req
 	}
 
 	// Send to settlement
-	err = iso.SendToSettlement(pacs002)
+	ack, err := iso.SendToSettlement(pacs002)
 	if err != nil {
-		SendErrorResponse(w, err.Error(), http.StatusInternalServerError, nil)
+		SendErrorResponse(w, err.Error(), http.StatusBadGateway, nil)
+		return
+	}
+
+	if !ack.Accepted() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":      "rejected",
+			"messageType": "pacs.002.001.08",
+			"reasonCode":  ack.ReasonCode,
+			"reason":      ack.Reason,
+		})
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]any{
 		"status":      "settled",
 		"messageType": "pacs.002.001.08",
+		"reference":   ack.Reference,
 	})
 }
 
@@ -116,16 +161,14 @@ func (iso *ISO20022Service) ConvertTransaction(tx *models.Transaction) (*pacs_v0
 	return iso.CreatePacs008(tx)
 }
 
-func (iso *ISO20022Service) SendToSettlement(doc any) error {
-	// Convert to XML and send to settlement system
-	xmlData, err := xml.MarshalIndent(doc, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal XML: %w", err)
-	}
+// SendToSettlement delivers a document through the configured transport and
+// returns the settlement system's acknowledgement.
+func (iso *ISO20022Service) SendToSettlement(doc any) (*SettlementAck, error) {
+	return iso.SendToSettlementContext(context.Background(), doc)
+}
 
-	// TODO: Implement actual settlement system integration
-	fmt.Printf("Sending to settlement: %s\n", string(xmlData))
-	return nil
+func (iso *ISO20022Service) SendToSettlementContext(ctx context.Context, doc any) (*SettlementAck, error) {
+	return iso.transport.Send(ctx, doc)
 }
 
 // CreatePacs008 creates a pacs.008 FIToFICustomerCreditTransfer message
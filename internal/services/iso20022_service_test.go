@@ -202,7 +202,8 @@ func TestISO20022Service_ConvertTransaction(t *testing.T) {
 }
 
 func TestISO20022Service_SendToSettlement(t *testing.T) {
-	service := NewISO20022Service()
+	transport := NewInMemorySettlementTransport()
+	service := NewISO20022ServiceWithTransport(transport)
 
 	t.Run("send to settlement", func(t *testing.T) {
 		tx := &models.Transaction{
@@ -215,16 +216,30 @@ func TestISO20022Service_SendToSettlement(t *testing.T) {
 		doc, err := service.CreatePacs008(tx)
 		assert.NoError(t, err)
 
-		// This should not error as it's just a mock implementation
-		err = service.SendToSettlement(doc)
+		ack, err := service.SendToSettlement(doc)
+		assert.NoError(t, err)
+		assert.True(t, ack.Accepted())
+		assert.Len(t, transport.Sent(), 1)
+	})
+
+	t.Run("rejected by settlement", func(t *testing.T) {
+		transport.Respond(&SettlementAck{Status: SettlementRejected, ReasonCode: "AC04", Reason: "Closed account"}, nil)
+		defer transport.Respond(&SettlementAck{Status: SettlementAccepted}, nil)
+
+		doc, err := service.CreatePacs008(&models.Transaction{TransactionID: "tx124", Amount: 10, Currency: "NGN"})
+		assert.NoError(t, err)
+
+		ack, err := service.SendToSettlement(doc)
 		assert.NoError(t, err)
+		assert.False(t, ack.Accepted())
+		assert.Equal(t, "AC04", ack.ReasonCode)
 	})
 
 	t.Run("send invalid document", func(t *testing.T) {
 		// Test with a struct that can't be marshaled to XML
 		invalidDoc := make(chan int)
 
-		err := service.SendToSettlement(invalidDoc)
+		_, err := service.SendToSettlement(invalidDoc)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "failed to marshal XML")
 	})
@@ -0,0 +1,501 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const jobLeaderKey = "jobs:leader"
+
+// Job run statuses recorded in job_runs
+const (
+	JobRunRunning = "RUNNING"
+	JobRunSuccess = "SUCCESS"
+	JobRunFailed  = "FAILED"
+)
+
+// acquireLeaderScript takes the leader lease if it is free or extends it if
+// this instance already holds it.
+var acquireLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseLeaderScript gives up the lease only if this instance holds it.
+var releaseLeaderScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// JobFunc is the body of a scheduled job
+type JobFunc func(ctx context.Context) error
+
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule jobSchedule
+	run      JobFunc
+	next     time.Time
+	running  bool
+}
+
+// JobRun is one recorded execution of a job
+type JobRun struct {
+	Status     string     `json:"status"`
+	Instance   string     `json:"instance"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// JobStatus describes a registered job and its most recent run
+type JobStatus struct {
+	Name    string    `json:"name"`
+	Spec    string    `json:"spec"`
+	NextRun time.Time `json:"nextRun"`
+	Running bool      `json:"running"`
+	LastRun *JobRun   `json:"lastRun,omitempty"`
+}
+
+// JobScheduler runs registered maintenance jobs on cron-like schedules
+// evaluated in WAT. Replicas elect a leader through a Redis lease and only
+// the leader runs jobs; every run is recorded in job_runs.
+type JobScheduler struct {
+	db         *sql.DB
+	redis      *redis.Client
+	instanceID string
+	interval   time.Duration
+	leaseTTL   time.Duration
+	now        func() time.Time
+
+	mu     sync.Mutex
+	jobs   []*scheduledJob
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewJobScheduler(db *sql.DB, redis *redis.Client) *JobScheduler {
+	interval := 15 * time.Second
+	if envInterval := os.Getenv("JOB_SCHEDULER_INTERVAL"); envInterval != "" {
+		if val, err := time.ParseDuration(envInterval); err == nil && val > 0 {
+			interval = val
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return &JobScheduler{
+		db:         db,
+		redis:      redis,
+		instanceID: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(b)),
+		interval:   interval,
+		leaseTTL:   3 * interval,
+		now:        time.Now,
+	}
+}
+
+// Register adds a job. spec is a five-field cron expression (minute hour
+// day-of-month month day-of-week), @hourly, @daily or @every <duration>.
+func (js *JobScheduler) Register(name, spec string, run JobFunc) error {
+	schedule, err := parseJobSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for _, job := range js.jobs {
+		if job.name == name {
+			return fmt.Errorf("job %s is already registered", name)
+		}
+	}
+	js.jobs = append(js.jobs, &scheduledJob{
+		name:     name,
+		spec:     spec,
+		schedule: schedule,
+		run:      run,
+		next:     schedule.Next(js.now().In(watLocation)),
+	})
+	return nil
+}
+
+// Start launches the scheduling loop
+func (js *JobScheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	js.cancel = cancel
+
+	js.wg.Add(1)
+	go js.loop(ctx)
+
+	log.Printf("[JOBS] Scheduler started as %s with %d jobs", js.instanceID, len(js.jobs))
+}
+
+// Stop ends the loop, waits for running jobs and releases the leader lease.
+func (js *JobScheduler) Stop() {
+	if js.cancel == nil {
+		return
+	}
+	js.cancel()
+	js.wg.Wait()
+
+	if js.redis != nil {
+		releaseLeaderScript.Run(context.Background(), js.redis, []string{jobLeaderKey}, js.instanceID)
+	}
+	log.Println("[JOBS] Scheduler stopped")
+}
+
+func (js *JobScheduler) loop(ctx context.Context) {
+	defer js.wg.Done()
+
+	ticker := time.NewTicker(js.interval)
+	defer ticker.Stop()
+
+	for {
+		js.runDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue starts every job whose next run time has passed, provided this
+// instance holds the leader lease. Jobs still running from an earlier tick
+// are skipped.
+func (js *JobScheduler) runDue(ctx context.Context) {
+	leader := js.acquireLeadership(ctx)
+	now := js.now().In(watLocation)
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	for _, job := range js.jobs {
+		if now.Before(job.next) {
+			continue
+		}
+		job.next = job.schedule.Next(now)
+
+		if !leader || job.running {
+			continue
+		}
+		job.running = true
+
+		js.wg.Add(1)
+		go func(job *scheduledJob) {
+			defer js.wg.Done()
+			js.runJob(ctx, job)
+
+			js.mu.Lock()
+			job.running = false
+			js.mu.Unlock()
+		}(job)
+	}
+}
+
+func (js *JobScheduler) acquireLeadership(ctx context.Context) bool {
+	if js.redis == nil {
+		// Without Redis there is nothing to coordinate with
+		return true
+	}
+
+	held, err := acquireLeaderScript.Run(ctx, js.redis, []string{jobLeaderKey},
+		js.instanceID, js.leaseTTL.Milliseconds()).Int()
+	if err != nil {
+		log.Printf("[JOBS] Leader election failed: %v", err)
+		return false
+	}
+	return held == 1
+}
+
+func (js *JobScheduler) runJob(ctx context.Context, job *scheduledJob) {
+	var runID int64
+	err := js.db.QueryRowContext(ctx, `
+		INSERT INTO job_runs (job_name, instance_id, status, started_at)
+		VALUES ($1, $2, $3, NOW())
+		RETURNING id
+	`, job.name, js.instanceID, JobRunRunning).Scan(&runID)
+	if err != nil {
+		log.Printf("[JOBS] Failed to record start of %s: %v", job.name, err)
+		return
+	}
+
+	log.Printf("[JOBS] Running %s", job.name)
+	started := time.Now()
+
+	status, message := JobRunSuccess, ""
+	if err := js.safeRun(ctx, job); err != nil {
+		status, message = JobRunFailed, err.Error()
+		log.Printf("[JOBS] %s failed after %s: %v", job.name, time.Since(started), err)
+	} else {
+		log.Printf("[JOBS] %s finished in %s", job.name, time.Since(started))
+	}
+
+	// Record the outcome even if the scheduler is shutting down
+	if _, err := js.db.ExecContext(context.Background(), `
+		UPDATE job_runs SET status = $1, error = NULLIF($2, ''), finished_at = NOW() WHERE id = $3
+	`, status, message, runID); err != nil {
+		log.Printf("[JOBS] Failed to record result of %s: %v", job.name, err)
+	}
+}
+
+func (js *JobScheduler) safeRun(ctx context.Context, job *scheduledJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.run(ctx)
+}
+
+// Status returns the registered jobs with their latest recorded run
+func (js *JobScheduler) Status(ctx context.Context) ([]JobStatus, error) {
+	js.mu.Lock()
+	statuses := make([]JobStatus, len(js.jobs))
+	for i, job := range js.jobs {
+		statuses[i] = JobStatus{Name: job.name, Spec: job.spec, NextRun: job.next, Running: job.running}
+	}
+	js.mu.Unlock()
+
+	rows, err := js.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (job_name) job_name, status, instance_id, started_at, finished_at, COALESCE(error, '')
+		FROM job_runs
+		ORDER BY job_name, started_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastRuns := make(map[string]*JobRun)
+	for rows.Next() {
+		var name string
+		var run JobRun
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&name, &run.Status, &run.Instance, &run.StartedAt, &finishedAt, &run.Error); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+		lastRuns[name] = &run
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range statuses {
+		statuses[i].LastRun = lastRuns[statuses[i].Name]
+	}
+	return statuses, nil
+}
+
+// JobStatus reports the scheduled jobs and their last run
+// @Summary Scheduled job status
+// @Description List the registered maintenance jobs with their schedule, next run and last recorded run
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{instance=string,jobs=[]services.JobStatus}
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/jobs [get]
+func (js *JobScheduler) JobStatus(w http.ResponseWriter, r *http.Request) {
+	statuses, err := js.Status(r.Context())
+	if err != nil {
+		log.Printf("[JOBS] Failed to load job status: %v", err)
+		SendErrorResponse(w, "Failed to load job status", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"instance": js.instanceID,
+		"jobs":     statuses,
+	})
+}
+
+// ExpireOrphanedKeys scans keys matching pattern and gives any key without
+// a TTL the supplied one. Nonce and idempotency keys are always written
+// with an expiry, so this only catches keys left behind by a failed write or
+// a manual PERSIST. It returns the number of keys updated.
+func ExpireOrphanedKeys(ctx context.Context, client *redis.Client, pattern string, ttl time.Duration) (int, error) {
+	expired := 0
+	iter := client.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		remaining, err := client.TTL(ctx, key).Result()
+		if err != nil {
+			return expired, err
+		}
+		// -1 means the key exists without an expiry
+		if remaining != -1 {
+			continue
+		}
+		if err := client.Expire(ctx, key, ttl).Err(); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	if err := iter.Err(); err != nil {
+		return expired, err
+	}
+
+	if expired > 0 {
+		log.Printf("[JOBS] Set expiry on %d orphaned %s keys", expired, pattern)
+	}
+	return expired, nil
+}
+
+// jobSchedule yields the next run time strictly after t
+type jobSchedule interface {
+	Next(t time.Time) time.Time
+}
+
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSchedule holds the allowed values of each cron field as bit sets
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var errInvalidJobSpec = errors.New("invalid schedule spec")
+
+func parseJobSchedule(spec string) (jobSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "@hourly":
+		spec = "0 * * * *"
+	case spec == "@daily", spec == "@midnight":
+		spec = "0 0 * * *"
+	case strings.HasPrefix(spec, "@every "):
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || interval < time.Second {
+			return nil, fmt.Errorf("%w: %q", errInvalidJobSpec, spec)
+		}
+		return everySchedule{interval: interval}, nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q needs 5 fields", errInvalidJobSpec, spec)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", errInvalidJobSpec, spec, err)
+		}
+		sets[i] = set
+	}
+
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField accepts *, n, a-b, */s, a-b/s and comma separated lists
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rangePart, step = part[:i], s
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			a, b, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	// Every valid schedule matches within five years (29 February)
+	limit := next.AddDate(5, 0, 0)
+
+	for next.Before(limit) {
+		switch {
+		case s.month&(1<<uint(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !s.dayMatches(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case s.hour&(1<<uint(next.Hour())) == 0:
+			next = next.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(next.Minute())) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return limit
+}
+
+// dayMatches applies cron's rule that a restricted day-of-month and
+// day-of-week match if either does.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJobSchedule(t *testing.T) {
+	base := time.Date(2026, 3, 14, 10, 7, 30, 0, watLocation) // Saturday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 3, 14, 10, 15, 0, 0, watLocation)},
+		{"@hourly", time.Date(2026, 3, 14, 11, 0, 0, 0, watLocation)},
+		{"@daily", time.Date(2026, 3, 15, 0, 0, 0, 0, watLocation)},
+		{"30 2 * * *", time.Date(2026, 3, 15, 2, 30, 0, 0, watLocation)},
+		{"0 9 * * 1-5", time.Date(2026, 3, 16, 9, 0, 0, 0, watLocation)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, watLocation)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, watLocation)},
+		{"0,30 10 * * *", time.Date(2026, 3, 14, 10, 30, 0, 0, watLocation)},
+		{"@every 90s", base.Add(90 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := parseJobSchedule(tt.spec)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, schedule.Next(base))
+		})
+	}
+
+	t.Run("day of month or day of week", func(t *testing.T) {
+		// Restricted dom and dow match on either: the 20th or the next Monday
+		schedule, err := parseJobSchedule("0 0 20 * 1")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Date(2026, 3, 16, 0, 0, 0, 0, watLocation), schedule.Next(base))
+	})
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "@every 1ms", "@weekly"} {
+		t.Run("invalid "+spec, func(t *testing.T) {
+			_, err := parseJobSchedule(spec)
+			assert.ErrorIs(t, err, errInvalidJobSpec)
+		})
+	}
+}
+
+func TestJobScheduler_Register(t *testing.T) {
+	js := NewJobScheduler(nil, nil)
+	noop := func(ctx context.Context) error { return nil }
+
+	assert.NoError(t, js.Register("cleanup", "@hourly", noop))
+	assert.Error(t, js.Register("cleanup", "@daily", noop))
+	assert.Error(t, js.Register("broken", "* *", noop))
+}
+
+func TestJobScheduler_RunDue(t *testing.T) {
+	t.Run("records a successful run", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		js := NewJobScheduler(db, nil)
+		now := time.Date(2026, 3, 14, 10, 0, 0, 0, watLocation)
+		js.now = func() time.Time { return now }
+
+		ran := 0
+		assert.NoError(t, js.Register("cleanup", "@every 1m", func(ctx context.Context) error {
+			ran++
+			return nil
+		}))
+
+		mock.ExpectQuery("INSERT INTO job_runs").
+			WithArgs("cleanup", js.instanceID, JobRunRunning).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec("UPDATE job_runs SET status").
+			WithArgs(JobRunSuccess, "", int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Not yet due
+		js.runDue(context.Background())
+		js.wg.Wait()
+		assert.Equal(t, 0, ran)
+
+		now = now.Add(time.Minute)
+		js.runDue(context.Background())
+		js.wg.Wait()
+
+		assert.Equal(t, 1, ran)
+		assert.Equal(t, now.Add(time.Minute), js.jobs[0].next)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("records failures and panics", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		js := NewJobScheduler(db, nil)
+		now := time.Date(2026, 3, 14, 10, 0, 0, 0, watLocation)
+		js.now = func() time.Time { return now }
+
+		assert.NoError(t, js.Register("failing", "@every 1m", func(ctx context.Context) error {
+			return errors.New("boom")
+		}))
+		assert.NoError(t, js.Register("panicking", "@every 1m", func(ctx context.Context) error {
+			panic("bad state")
+		}))
+
+		mock.MatchExpectationsInOrder(false)
+		mock.ExpectQuery("INSERT INTO job_runs").
+			WithArgs("failing", js.instanceID, JobRunRunning).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("INSERT INTO job_runs").
+			WithArgs("panicking", js.instanceID, JobRunRunning).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectExec("UPDATE job_runs SET status").
+			WithArgs(JobRunFailed, "boom", int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE job_runs SET status").
+			WithArgs(JobRunFailed, "panic: bad state", int64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		now = now.Add(time.Minute)
+		js.runDue(context.Background())
+		js.wg.Wait()
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestJobScheduler_JobStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	js := NewJobScheduler(db, nil)
+	assert.NoError(t, js.Register("cleanup", "@hourly", func(ctx context.Context) error { return nil }))
+	assert.NoError(t, js.Register("rotate", "@daily", func(ctx context.Context) error { return nil }))
+
+	started := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT DISTINCT ON \\(job_name\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"job_name", "status", "instance_id", "started_at", "finished_at", "error"}).
+			AddRow("cleanup", JobRunFailed, "host-1", started, started.Add(time.Second), "db down"))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+	rr := httptest.NewRecorder()
+	js.JobStatus(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"name":"cleanup"`)
+	assert.Contains(t, rr.Body.String(), `"error":"db down"`)
+	assert.Contains(t, rr.Body.String(), `"name":"rotate"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ruralpay/backend/internal/hsm"
+)
+
+const DefaultJWTSigningKeyID = "jwt_signing"
+
+var (
+	ErrNoSigningKey      = errors.New("no active JWT signing key")
+	ErrUnknownSigningKey = errors.New("token signed with an unknown or retired key")
+)
+
+// JWTKeyring signs access tokens with RS256 using a key held in the HSM and
+// publishes the public keys as a JWKS, so other services can verify tokens
+// without a shared secret. Tokens name their key in the kid header. When
+// RotateKeys replaces the key, the retired one is still accepted for one
+// access token lifetime so tokens issued before the rotation stay valid.
+// A token naming a kid this replica has not seen makes it re-read the key
+// store, so keys rotated by another replica are picked up.
+type JWTKeyring struct {
+	hsm   hsm.HSMInterface
+	keyID string
+
+	mu         sync.RWMutex
+	public     map[string]*rsa.PublicKey
+	reloadedAt time.Time
+}
+
+// jwtKeyReloadGap limits how often a token with an unknown kid makes the
+// keyring re-read the HSM key store
+const jwtKeyReloadGap = 10 * time.Second
+
+// JWK is an RSA public key in JSON Web Key form
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWTKeyring signs with the HSM key keyID, or the newest key rotated from
+// it, generating the key on first use
+func NewJWTKeyring(h hsm.HSMInterface, keyID string) (*JWTKeyring, error) {
+	if len(h.ListKeys(keyID)) == 0 {
+		if _, err := h.GenerateKeyPair(keyID); err != nil {
+			return nil, fmt.Errorf("failed to generate JWT signing key: %w", err)
+		}
+	}
+	return &JWTKeyring{hsm: h, keyID: keyID, public: make(map[string]*rsa.PublicKey)}, nil
+}
+
+// Sign returns claims as a compact RS256 JWT signed in the HSM
+func (k *JWTKeyring) Sign(claims jwt.Claims) (string, error) {
+	kid, err := k.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	// SignData is RSASSA-PKCS1-v1_5 over SHA-256, which is RS256
+	signature, err := k.hsm.SignData(kid, []byte(signingString))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Keyfunc resolves the kid header of a token to its public key, for use
+// with jwt.Parse
+func (k *JWTKeyring) Keyfunc(token *jwt.Token) (any, error) {
+	if token.Method != jwt.SigningMethodRS256 {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	if k.verifiable(kid) {
+		return k.publicKey(kid)
+	}
+
+	// The key may have been rotated by another replica sharing the store
+	if k.reloadKeys() && k.verifiable(kid) {
+		return k.publicKey(kid)
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+func (k *JWTKeyring) verifiable(kid string) bool {
+	for _, key := range k.verificationKeys() {
+		if key.ID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadKeys re-reads the HSM key store unless it was re-read within
+// jwtKeyReloadGap, and reports whether it did
+func (k *JWTKeyring) reloadKeys() bool {
+	k.mu.Lock()
+	if time.Since(k.reloadedAt) < jwtKeyReloadGap {
+		k.mu.Unlock()
+		return false
+	}
+	k.reloadedAt = time.Now()
+	k.mu.Unlock()
+
+	if err := k.hsm.ReloadKeys(); err != nil {
+		log.Printf("[AUTH] Failed to reload signing keys: %v", err)
+		return false
+	}
+	return true
+}
+
+// JWKS returns the public keys tokens may currently be signed with
+func (k *JWTKeyring) JWKS() (*JWKSet, error) {
+	set := &JWKSet{Keys: []JWK{}}
+	for _, key := range k.verificationKeys() {
+		pub, err := k.publicKey(key.ID)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: key.ID,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	return set, nil
+}
+
+// ServeJWKS publishes the token verification keys
+// @Summary JSON Web Key Set
+// @Description Public keys that verify access tokens, matched on the kid header. Retired keys are listed until tokens signed with them have expired
+// @Tags auth
+// @Produce json
+// @Success 200 {object} JWKSet
+// @Failure 500 {object} ErrorResponse
+// @Router /.well-known/jwks.json [get]
+func (k *JWTKeyring) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	set, err := k.JWKS()
+	if err != nil {
+		log.Printf("[AUTH] Failed to build JWKS: %v", err)
+		SendErrorResponse(w, "Failed to load signing keys", http.StatusInternalServerError, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(set)
+}
+
+// signingKey returns the newest active key
+func (k *JWTKeyring) signingKey() (string, error) {
+	if kid, ok := hsm.NewestActiveKey(k.hsm.ListKeys(k.keyID)); ok {
+		return kid, nil
+	}
+	return "", ErrNoSigningKey
+}
+
+// verificationKeys returns the active keys and those retired less than an
+// access token lifetime ago
+func (k *JWTKeyring) verificationKeys() []hsm.KeyInfo {
+	cutoff := time.Now().Add(-accessTokenTTL())
+	var keys []hsm.KeyInfo
+	for _, key := range k.hsm.ListKeys(k.keyID) {
+		if key.IsActive || key.RetiredAt.After(cutoff) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (k *JWTKeyring) publicKey(kid string) (*rsa.PublicKey, error) {
+	k.mu.RLock()
+	pub, ok := k.public[kid]
+	k.mu.RUnlock()
+	if ok {
+		return pub, nil
+	}
+
+	publicKeyPEM, err := k.hsm.GetPublicKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key for %s", kid)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok = parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an RSA key", kid)
+	}
+
+	k.mu.Lock()
+	k.public[kid] = pub
+	k.mu.Unlock()
+	return pub, nil
+}
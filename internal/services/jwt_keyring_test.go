@@ -0,0 +1,166 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testHSMOnce sync.Once
+	testHSM     *hsm.HSMServer
+	testHSMErr  error
+)
+
+// newTestHSM returns an in-memory HSM shared by the tests, as generating
+// its RSA keys is slow
+func newTestHSM(t *testing.T) *hsm.HSMServer {
+	t.Helper()
+	testHSMOnce.Do(func() {
+		testHSM, testHSMErr = hsm.InitHSM(hsm.Config{MasterKey: "test-master-key", Salt: []byte("test-salt")})
+	})
+	if testHSMErr != nil {
+		t.Fatalf("failed to initialize HSM: %v", testHSMErr)
+	}
+	return testHSM
+}
+
+func testJWTKeyring(t *testing.T) *JWTKeyring {
+	t.Helper()
+	keys, err := NewJWTKeyring(newTestHSM(t), DefaultJWTSigningKeyID)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	return keys
+}
+
+func parseTestToken(keys *JWTKeyring, token string) (*jwt.Token, error) {
+	return jwt.Parse(token, keys.Keyfunc, jwt.WithValidMethods([]string{"RS256"}))
+}
+
+func TestJWTKeyring_SignAndVerify(t *testing.T) {
+	keys := testJWTKeyring(t)
+
+	token, err := keys.Sign(jwt.MapClaims{"user_id": 7, "exp": time.Now().Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+
+	parsed, err := parseTestToken(keys, token)
+	assert.NoError(t, err)
+	assert.Equal(t, "RS256", parsed.Header["alg"])
+	assert.Equal(t, DefaultJWTSigningKeyID, parsed.Header["kid"])
+
+	t.Run("tampered token", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		forged, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"user_id": 8}).SigningString()
+		_, err := parseTestToken(keys, forged+"."+parts[2])
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown kid", func(t *testing.T) {
+		unsigned := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"user_id": 7})
+		unsigned.Header["kid"] = "card_signing"
+		signingString, _ := unsigned.SigningString()
+		sig, err := newTestHSM(t).SignData("card_signing", []byte(signingString))
+		assert.NoError(t, err)
+
+		_, err = parseTestToken(keys, signingString+"."+base64.RawURLEncoding.EncodeToString(sig))
+		assert.ErrorIs(t, err, ErrUnknownSigningKey)
+	})
+
+	t.Run("symmetric token", func(t *testing.T) {
+		hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 7}).SignedString([]byte("secret"))
+		_, err := parseTestToken(keys, hs)
+		assert.Error(t, err)
+	})
+}
+
+func TestJWTKeyring_Rotation(t *testing.T) {
+	h := newTestHSM(t)
+	first, err := h.GenerateKeyPair("jwt_rotation_test")
+	assert.NoError(t, err)
+	keys, err := NewJWTKeyring(h, "jwt_rotation_test")
+	assert.NoError(t, err)
+
+	claims := jwt.MapClaims{"user_id": 7, "exp": time.Now().Add(time.Minute).Unix()}
+	before, err := keys.Sign(claims)
+	assert.NoError(t, err)
+
+	// Expire the key so RotateKeys replaces it
+	first.ExpiresAt = time.Now().Add(-time.Hour)
+	assert.NoError(t, h.RotateKeys())
+
+	after, err := keys.Sign(claims)
+	assert.NoError(t, err)
+	parsed, err := parseTestToken(keys, after)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "jwt_rotation_test", parsed.Header["kid"])
+
+	// Tokens signed before the rotation still verify
+	_, err = parseTestToken(keys, before)
+	assert.NoError(t, err)
+
+	set, err := keys.JWKS()
+	assert.NoError(t, err)
+	assert.Len(t, set.Keys, 2)
+
+	// Once tokens from the retired key have expired it is dropped
+	first.RetiredAt = time.Now().Add(-2 * accessTokenTTL())
+	_, err = parseTestToken(keys, before)
+	assert.ErrorIs(t, err, ErrUnknownSigningKey)
+
+	set, err = keys.JWKS()
+	assert.NoError(t, err)
+	assert.Len(t, set.Keys, 1)
+	assert.Equal(t, parsed.Header["kid"], set.Keys[0].Kid)
+}
+
+func TestJWTKeyring_ServeJWKS(t *testing.T) {
+	keys := testJWTKeyring(t)
+
+	w := httptest.NewRecorder()
+	keys.ServeJWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var set JWKSet
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	assert.NotEmpty(t, set.Keys)
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, "RS256", set.Keys[0].Alg)
+	assert.Equal(t, "AQAB", set.Keys[0].E)
+}
+
+func TestJWTKeyring_FollowsKeysRotatedByAnotherReplica(t *testing.T) {
+	config := hsm.Config{MasterKey: "test-master-key", Salt: []byte("test-salt"), KeyStorePath: t.TempDir()}
+	leaderHSM, err := hsm.InitHSM(config)
+	assert.NoError(t, err)
+	leader, err := NewJWTKeyring(leaderHSM, DefaultJWTSigningKeyID)
+	assert.NoError(t, err)
+
+	followerHSM, err := hsm.InitHSM(config)
+	assert.NoError(t, err)
+	follower, err := NewJWTKeyring(followerHSM, DefaultJWTSigningKeyID)
+	assert.NoError(t, err)
+
+	// The leader rotates the key into the shared store
+	rotated := fmt.Sprintf("%s_%d", DefaultJWTSigningKeyID, time.Now().Unix())
+	_, err = leaderHSM.GenerateKeyPair(rotated)
+	assert.NoError(t, err)
+	assert.Empty(t, followerHSM.ListKeys(rotated))
+
+	token, err := leader.Sign(jwt.MapClaims{"user_id": 7, "exp": time.Now().Add(time.Minute).Unix()})
+	assert.NoError(t, err)
+
+	parsed, err := parseTestToken(follower, token)
+	assert.NoError(t, err)
+	assert.Equal(t, rotated, parsed.Header["kid"])
+}
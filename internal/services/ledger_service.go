@@ -2,16 +2,19 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/ruralpay/backend/internal/models"
 )
 
 type DoubleLedgerService struct {
-	db              *sql.DB
-	systemFeeAccount string
+	db                *sql.DB
+	systemFeeAccount  string
+	settlementAccount string
 }
 
 func NewDoubleLedgerService(db *sql.DB) *DoubleLedgerService {
@@ -19,9 +22,14 @@ func NewDoubleLedgerService(db *sql.DB) *DoubleLedgerService {
 	if envAccount := os.Getenv("SYSTEM_FEE_ACCOUNT"); envAccount != "" {
 		systemFeeAccount = envAccount
 	}
+	settlementAccount := "0000000003"
+	if envAccount := os.Getenv("SETTLEMENT_SUSPENSE_ACCOUNT"); envAccount != "" {
+		settlementAccount = envAccount
+	}
 	return &DoubleLedgerService{
-		db:              db,
-		systemFeeAccount: systemFeeAccount,
+		db:                db,
+		systemFeeAccount:  systemFeeAccount,
+		settlementAccount: settlementAccount,
 	}
 }
 
@@ -93,6 +101,298 @@ func (s *DoubleLedgerService) TransferTx(tx *sql.Tx, fromAccountID, toAccountID,
 	return nil
 }
 
+// JournalLeg is one side of a journal posting. Amount is a positive value in
+// minor units; EntryType decides whether it leaves (DEBIT) or enters (CREDIT)
+// the account.
+type JournalLeg struct {
+	AccountID string
+	EntryType string
+	Amount    int64
+	Currency  string
+}
+
+var ErrUnbalancedJournal = errors.New("journal debits and credits do not balance")
+
+// PostJournal posts a balanced multi-leg journal in its own transaction.
+func (s *DoubleLedgerService) PostJournal(transactionID string, legs []JournalLeg) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.appendPaymentState(tx, transactionID, "PENDING"); err != nil {
+		return err
+	}
+
+	if err := s.PostJournalTx(tx, transactionID, legs); err != nil {
+		return err
+	}
+
+	if err := s.appendPaymentState(tx, transactionID, "SUCCESS"); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PostJournalTx posts all legs atomically within tx. Debits must equal
+// credits for every currency in the journal.
+func (s *DoubleLedgerService) PostJournalTx(tx *sql.Tx, transactionID string, legs []JournalLeg) error {
+	if len(legs) < 2 {
+		return fmt.Errorf("journal requires at least two legs")
+	}
+
+	totals := make(map[string]int64)
+	accountIDs := make([]string, 0, len(legs))
+	seen := make(map[string]bool)
+	for _, leg := range legs {
+		if leg.Amount <= 0 {
+			return fmt.Errorf("journal leg for account %s must have a positive amount", leg.AccountID)
+		}
+		if leg.Currency == "" {
+			return fmt.Errorf("journal leg for account %s has no currency", leg.AccountID)
+		}
+		switch leg.EntryType {
+		case "DEBIT":
+			totals[leg.Currency] -= leg.Amount
+		case "CREDIT":
+			totals[leg.Currency] += leg.Amount
+		default:
+			return fmt.Errorf("invalid entry type %q", leg.EntryType)
+		}
+		if !seen[leg.AccountID] {
+			seen[leg.AccountID] = true
+			accountIDs = append(accountIDs, leg.AccountID)
+		}
+	}
+	for currency, net := range totals {
+		if net != 0 {
+			return fmt.Errorf("%w for %s", ErrUnbalancedJournal, currency)
+		}
+	}
+
+	// Lock accounts in consistent order to prevent deadlocks
+	sort.Strings(accountIDs)
+	accounts := make(map[string]*models.Account, len(accountIDs))
+	balances := make(map[string]int64, len(accountIDs))
+	for _, id := range accountIDs {
+		account, err := s.lockAccount(tx, id)
+		if err != nil {
+			return err
+		}
+		accounts[id] = account
+		balances[id] = account.Balance
+	}
+
+	for _, leg := range legs {
+		amount := leg.Amount
+		if leg.EntryType == "DEBIT" {
+			amount = -amount
+		}
+		balances[leg.AccountID] += amount
+		if err := s.createLedgerEntry(tx, transactionID, accounts[leg.AccountID].ID, amount, leg.EntryType, balances[leg.AccountID]); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range accountIDs {
+		account := accounts[id]
+		if balances[id] < 0 {
+			return fmt.Errorf("insufficient balance")
+		}
+		if err := s.updateAccountBalance(tx, account.ID, balances[id], account.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostOutboundTransferTx debits the customer for an outbound transfer,
+// crediting the settlement suspense (nostro) account with the principal and
+// the system fee account with the fee. The principal is held in suspense
+// until the counterparty settles, or returned by ReverseTx on rejection.
+func (s *DoubleLedgerService) PostOutboundTransferTx(tx *sql.Tx, fromAccountID, transactionID, currency string, amount, fee int64) error {
+	legs := []JournalLeg{
+		{AccountID: fromAccountID, EntryType: "DEBIT", Amount: amount + fee, Currency: currency},
+		{AccountID: s.settlementAccount, EntryType: "CREDIT", Amount: amount, Currency: currency},
+	}
+	if fee > 0 {
+		legs = append(legs, JournalLeg{AccountID: s.systemFeeAccount, EntryType: "CREDIT", Amount: fee, Currency: currency})
+	}
+	return s.PostJournalTx(tx, transactionID, legs)
+}
+
+var (
+	ErrNothingToReverse = errors.New("no ledger entries to reverse")
+	ErrAlreadyReversed  = errors.New("transaction already reversed")
+)
+
+// ReverseTx posts the mirror image of every ledger entry written for
+// originalTxID under reversalTxID, restoring each account's balance.
+func (s *DoubleLedgerService) ReverseTx(tx *sql.Tx, originalTxID, reversalTxID string) error {
+	var reversed int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM ledger_entries WHERE transaction_id = $1`, reversalTxID).Scan(&reversed); err != nil {
+		return err
+	}
+	if reversed > 0 {
+		return ErrAlreadyReversed
+	}
+
+	rows, err := tx.Query(`
+		SELECT account_id, amount
+		FROM ledger_entries
+		WHERE transaction_id = $1
+		ORDER BY id`, originalTxID)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		accountID string
+		amount    int64
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.accountID, &e.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return ErrNothingToReverse
+	}
+
+	// Lock accounts in consistent order to prevent deadlocks
+	accountIDs := make([]string, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !seen[e.accountID] {
+			seen[e.accountID] = true
+			accountIDs = append(accountIDs, e.accountID)
+		}
+	}
+	sort.Strings(accountIDs)
+
+	accounts := make(map[string]*models.Account, len(accountIDs))
+	for _, id := range accountIDs {
+		account, err := s.lockAccount(tx, id)
+		if err != nil {
+			return err
+		}
+		accounts[id] = account
+	}
+
+	balances := make(map[string]int64, len(accounts))
+	for id, account := range accounts {
+		balances[id] = account.Balance
+	}
+
+	for _, e := range entries {
+		amount := -e.amount
+		entryType := "CREDIT"
+		if amount < 0 {
+			entryType = "DEBIT"
+		}
+		balances[e.accountID] += amount
+		if balances[e.accountID] < 0 {
+			return fmt.Errorf("insufficient balance in account %s to reverse %s", e.accountID, originalTxID)
+		}
+		if err := s.createLedgerEntry(tx, reversalTxID, e.accountID, amount, entryType, balances[e.accountID]); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range accountIDs {
+		if err := s.updateAccountBalance(tx, id, balances[id], accounts[id].Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostedTransfer describes the ledger postings of a single payment: one
+// payer debit, one principal credit and an optional fee credit. Account IDs
+// are ledger (accounts.id) identifiers.
+type PostedTransfer struct {
+	Payer      string
+	Payee      string
+	FeeAccount string
+	Amount     int64
+	Fee        int64
+	External   bool
+}
+
+var ErrUnsupportedPosting = errors.New("ledger postings do not describe a single payment")
+
+// PostedTransferTx reads back the ledger entries of transactionID. External
+// is set when the principal was credited to the settlement suspense account.
+func (s *DoubleLedgerService) PostedTransferTx(tx *sql.Tx, transactionID string) (*PostedTransfer, error) {
+	rows, err := tx.Query(`
+		SELECT le.account_id, le.amount,
+			COALESCE(a.account_id = $2 OR a.card_id = $2 OR a.id = $2, false),
+			COALESCE(a.account_id = $3 OR a.card_id = $3 OR a.id = $3, false)
+		FROM ledger_entries le
+		JOIN accounts a ON a.id = le.account_id
+		WHERE le.transaction_id = $1
+		ORDER BY le.id`, transactionID, s.systemFeeAccount, s.settlementAccount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posted := &PostedTransfer{}
+	var entries int
+	for rows.Next() {
+		var (
+			accountID         string
+			amount            int64
+			isFee, isSuspense bool
+		)
+		if err := rows.Scan(&accountID, &amount, &isFee, &isSuspense); err != nil {
+			return nil, err
+		}
+		entries++
+
+		switch {
+		case amount < 0:
+			if posted.Payer != "" {
+				return nil, ErrUnsupportedPosting
+			}
+			posted.Payer = accountID
+		case isFee:
+			posted.FeeAccount = accountID
+			posted.Fee += amount
+		default:
+			if posted.Payee != "" && posted.Payee != accountID {
+				return nil, ErrUnsupportedPosting
+			}
+			posted.Payee = accountID
+			posted.Amount += amount
+			posted.External = isSuspense
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if entries == 0 {
+		return nil, ErrNothingToReverse
+	}
+	if posted.Payer == "" || posted.Payee == "" {
+		return nil, ErrUnsupportedPosting
+	}
+
+	return posted, nil
+}
+
 func (s *DoubleLedgerService) appendPaymentState(tx *sql.Tx, transactionID, state string) error {
 	_, err := tx.Exec(`
 		INSERT INTO payment_states (transaction_id, state, created_at)
@@ -109,7 +409,7 @@ func (s *DoubleLedgerService) lockAccount(tx *sql.Tx, accountID string) (*models
 		WHERE card_id = $1 OR account_id = $1 OR id = $1
 		LIMIT 1
 		FOR UPDATE`, accountID).Scan(&account.ID, &account.Balance, &account.Version, &account.UpdatedAt)
-	
+
 	return &account, err
 }
 
@@ -127,19 +427,19 @@ func (s *DoubleLedgerService) updateAccountBalance(tx *sql.Tx, accountID string,
 		SET balance = $1, version = version + 1, updated_at = $2 
 		WHERE id = $3 AND version = $4`,
 		newBalance, time.Now(), accountID, version)
-	
+
 	if err != nil {
 		return err
 	}
-	
+
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	
+
 	if rowsAffected == 0 {
 		return fmt.Errorf("optimistic lock failed for account %s", accountID)
 	}
-	
+
 	return nil
-}
\ No newline at end of file
+}
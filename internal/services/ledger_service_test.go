@@ -1,6 +1,7 @@
 package services
 
 import (
+	"database/sql"
 	"testing"
 	"time"
 
@@ -22,15 +23,18 @@ func TestDoubleLedgerService_Transfer(t *testing.T) {
 		amount := int64(1000)
 
 		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO payment_states").
+			WithArgs(transactionID, "PENDING", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		// Lock from account
-		mock.ExpectQuery("SELECT id, balance, version, updated_at FROM accounts WHERE id = \\$1 FOR UPDATE").
+		mock.ExpectQuery("SELECT id, balance, version, updated_at\\s+FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1 OR id = \\$1\\s+LIMIT 1\\s+FOR UPDATE").
 			WithArgs(fromAccountID).
 			WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).
 				AddRow(fromAccountID, 5000, 1, time.Now()))
 
 		// Lock to account
-		mock.ExpectQuery("SELECT id, balance, version, updated_at FROM accounts WHERE id = \\$1 FOR UPDATE").
+		mock.ExpectQuery("SELECT id, balance, version, updated_at\\s+FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1 OR id = \\$1\\s+LIMIT 1\\s+FOR UPDATE").
 			WithArgs(toAccountID).
 			WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).
 				AddRow(toAccountID, 2000, 1, time.Now()))
@@ -55,6 +59,9 @@ func TestDoubleLedgerService_Transfer(t *testing.T) {
 			WithArgs(3000, sqlmock.AnyArg(), toAccountID, 1).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
+		mock.ExpectExec("INSERT INTO payment_states").
+			WithArgs(transactionID, "SUCCESS", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectCommit()
 
 		err := service.Transfer(fromAccountID, toAccountID, transactionID, amount)
@@ -69,19 +76,25 @@ func TestDoubleLedgerService_Transfer(t *testing.T) {
 		amount := int64(6000) // More than available balance
 
 		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO payment_states").
+			WithArgs(transactionID, "PENDING", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		// Lock from account with insufficient balance
-		mock.ExpectQuery("SELECT id, balance, version, updated_at FROM accounts WHERE id = \\$1 FOR UPDATE").
+		mock.ExpectQuery("SELECT id, balance, version, updated_at\\s+FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1 OR id = \\$1\\s+LIMIT 1\\s+FOR UPDATE").
 			WithArgs(fromAccountID).
 			WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).
 				AddRow(fromAccountID, 5000, 1, time.Now()))
 
 		// Lock to account
-		mock.ExpectQuery("SELECT id, balance, version, updated_at FROM accounts WHERE id = \\$1 FOR UPDATE").
+		mock.ExpectQuery("SELECT id, balance, version, updated_at\\s+FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1 OR id = \\$1\\s+LIMIT 1\\s+FOR UPDATE").
 			WithArgs(toAccountID).
 			WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).
 				AddRow(toAccountID, 2000, 1, time.Now()))
 
+		mock.ExpectExec("INSERT INTO payment_states").
+			WithArgs(transactionID, "FAILED", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
 		mock.ExpectRollback()
 
 		err := service.Transfer(fromAccountID, toAccountID, transactionID, amount)
@@ -108,7 +121,7 @@ func TestDoubleLedgerService_lockAccount(t *testing.T) {
 		tx, _ := db.Begin()
 		accountID := "account1"
 
-		mock.ExpectQuery("SELECT id, balance, version, updated_at FROM accounts WHERE id = \\$1 FOR UPDATE").
+		mock.ExpectQuery("SELECT id, balance, version, updated_at\\s+FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1 OR id = \\$1\\s+LIMIT 1\\s+FOR UPDATE").
 			WithArgs(accountID).
 			WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).
 				AddRow(accountID, 5000, 1, time.Now()))
@@ -158,4 +171,127 @@ func TestDoubleLedgerService_updateAccountBalance(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "optimistic lock failed")
 	})
-}
\ No newline at end of file
+}
+func ledgerLockRows(id string, balance int64, version int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).AddRow(id, balance, version, time.Now())
+}
+
+func TestDoubleLedgerService_PostJournal(t *testing.T) {
+	t.Run("merchant payment with fee, VAT and commission", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewDoubleLedgerService(db)
+		legs := []JournalLeg{
+			{AccountID: "customer", EntryType: "DEBIT", Amount: 10000, Currency: "NGN"},
+			{AccountID: "merchant", EntryType: "CREDIT", Amount: 9800, Currency: "NGN"},
+			{AccountID: "fees", EntryType: "CREDIT", Amount: 135, Currency: "NGN"},
+			{AccountID: "vat", EntryType: "CREDIT", Amount: 15, Currency: "NGN"},
+			{AccountID: "agent", EntryType: "CREDIT", Amount: 50, Currency: "NGN"},
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("tx1", "PENDING", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		// Locks are taken in sorted order regardless of leg order
+		for _, id := range []string{"agent", "customer", "fees", "merchant", "vat"} {
+			balance := int64(0)
+			if id == "customer" {
+				balance = 20000
+			}
+			mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs(id).WillReturnRows(ledgerLockRows(id, balance, 1))
+		}
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "customer", int64(-10000), "DEBIT", int64(10000), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "merchant", int64(9800), "CREDIT", int64(9800), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "fees", int64(135), "CREDIT", int64(135), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "vat", int64(15), "CREDIT", int64(15), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "agent", int64(50), "CREDIT", int64(50), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		for _, update := range []struct {
+			id      string
+			balance int64
+		}{{"agent", 50}, {"customer", 10000}, {"fees", 135}, {"merchant", 9800}, {"vat", 15}} {
+			mock.ExpectExec("UPDATE accounts").WithArgs(update.balance, sqlmock.AnyArg(), update.id, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("tx1", "SUCCESS", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, service.PostJournal("tx1", legs))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unbalanced journal", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewDoubleLedgerService(db)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO payment_states").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectRollback()
+
+		err = service.PostJournal("tx2", []JournalLeg{
+			{AccountID: "customer", EntryType: "DEBIT", Amount: 1000, Currency: "NGN"},
+			{AccountID: "merchant", EntryType: "CREDIT", Amount: 900, Currency: "NGN"},
+		})
+		assert.ErrorIs(t, err, ErrUnbalancedJournal)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("balanced total but mixed currencies", func(t *testing.T) {
+		tx := &sql.Tx{}
+		service := NewDoubleLedgerService(nil)
+
+		err := service.PostJournalTx(tx, "tx3", []JournalLeg{
+			{AccountID: "a", EntryType: "DEBIT", Amount: 1000, Currency: "NGN"},
+			{AccountID: "b", EntryType: "CREDIT", Amount: 1000, Currency: "USD"},
+		})
+		assert.ErrorIs(t, err, ErrUnbalancedJournal)
+	})
+
+	t.Run("insufficient balance", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		service := NewDoubleLedgerService(db)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs("customer").WillReturnRows(ledgerLockRows("customer", 500, 1))
+		mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs("merchant").WillReturnRows(ledgerLockRows("merchant", 0, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		tx, err := db.Begin()
+		assert.NoError(t, err)
+		err = service.PostJournalTx(tx, "tx4", []JournalLeg{
+			{AccountID: "customer", EntryType: "DEBIT", Amount: 1000, Currency: "NGN"},
+			{AccountID: "merchant", EntryType: "CREDIT", Amount: 1000, Currency: "NGN"},
+		})
+		assert.EqualError(t, err, "insufficient balance")
+	})
+}
+
+func TestDoubleLedgerService_PostOutboundTransferTx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := NewDoubleLedgerService(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs("0000000001").WillReturnRows(ledgerLockRows("fees", 0, 1))
+	mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs("0000000003").WillReturnRows(ledgerLockRows("suspense", 0, 1))
+	mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs("1234567890").WillReturnRows(ledgerLockRows("customer", 5000, 1))
+	mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "customer", int64(-1055), "DEBIT", int64(3945), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "suspense", int64(1000), "CREDIT", int64(1000), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1", "fees", int64(55), "CREDIT", int64(55), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WithArgs(int64(55), sqlmock.AnyArg(), "fees", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WithArgs(int64(1000), sqlmock.AnyArg(), "suspense", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WithArgs(int64(3945), sqlmock.AnyArg(), "customer", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+
+	err = service.PostOutboundTransferTx(tx, "1234567890", "tx1", "NGN", 1000, 55)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Error codes returned when the limits engine blocks a payment
+const (
+	LimitCodePerTransaction = "LIMIT_PER_TRANSACTION_EXCEEDED"
+	LimitCodeCardDaily      = "LIMIT_CARD_DAILY_EXCEEDED"
+	LimitCodeCardMonthly    = "LIMIT_CARD_MONTHLY_EXCEEDED"
+	LimitCodeUserDaily      = "LIMIT_USER_DAILY_EXCEEDED"
+	LimitCodeUserMonthly    = "LIMIT_USER_MONTHLY_EXCEEDED"
+	LimitCodeMaxBalance     = "LIMIT_MAX_BALANCE_EXCEEDED"
+)
+
+// watLocation is West Africa Time (UTC+1, no daylight saving). Spend
+// counters roll over at midnight WAT.
+var watLocation = time.FixedZone("WAT", 60*60)
+
+// LimitError reports a payment blocked by a limit. Amounts are in minor
+// units; Attempted is the total the payment would have reached.
+type LimitError struct {
+	Code      string
+	Limit     int64
+	Attempted int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %d exceeds limit of %d", e.Code, e.Attempted, e.Limit)
+}
+
+// SendLimitErrorResponse reports a payment blocked by the limits engine
+func SendLimitErrorResponse(w http.ResponseWriter, limitErr *LimitError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: "Transaction limit exceeded",
+		Code:  limitErr.Code,
+	})
+}
+
+// LimitProfile holds the limits for a card type and KYC tier in minor
+// units. Zero means unlimited.
+type LimitProfile struct {
+	PerTransaction int64
+	CardDaily      int64
+	CardMonthly    int64
+	UserDaily      int64
+	UserMonthly    int64
+}
+
+// defaultLimitProfiles apply when limit_profiles or kyc_tier_limits has no
+// row for the payer, keyed by KYC tier.
+var defaultLimitProfiles = map[int]LimitProfile{
+	1: {PerTransaction: 5_000_000, CardDaily: 5_000_000, CardMonthly: 30_000_000, UserDaily: 5_000_000, UserMonthly: 30_000_000},
+	2: {PerTransaction: 10_000_000, CardDaily: 20_000_000, CardMonthly: 100_000_000, UserDaily: 20_000_000, UserMonthly: 100_000_000},
+	3: {PerTransaction: 100_000_000, CardDaily: 500_000_000, CardMonthly: 0, UserDaily: 500_000_000, UserMonthly: 0},
+}
+
+// spendSubject identifies whose counters a payment moves
+type spendSubject struct {
+	CardID  string
+	UserID  int
+	KYCTier int
+	Profile LimitProfile
+}
+
+// LimitsService enforces per-transaction caps and per-card and per-user
+// daily and monthly velocity limits. Counters live in spend_counters keyed
+// by the WAT period they cover, so a new day or month starts from zero
+// without a reset job.
+type LimitsService struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+func NewLimitsService(db *sql.DB) *LimitsService {
+	return &LimitsService{
+		db:  db,
+		now: time.Now,
+	}
+}
+
+// Reserve checks amount against the payer's limits and adds it to the spend
+// counters within tx. A *LimitError is returned when a limit would be
+// exceeded; the caller must then roll tx back so the counters are restored.
+func (ls *LimitsService) Reserve(tx *sql.Tx, payerID string, amount int64) error {
+	return ls.apply(tx, payerID, amount, true)
+}
+
+// Record adds amount to the payer's counters without enforcing limits. It
+// is used for offline payments that the card has already completed.
+func (ls *LimitsService) Record(tx *sql.Tx, payerID string, amount int64) error {
+	return ls.apply(tx, payerID, amount, false)
+}
+
+func (ls *LimitsService) apply(tx *sql.Tx, payerID string, amount int64, enforce bool) error {
+	subject, err := ls.loadSubject(tx, payerID)
+	if err != nil {
+		return err
+	}
+
+	if enforce && subject.Profile.PerTransaction > 0 && amount > subject.Profile.PerTransaction {
+		return &LimitError{Code: LimitCodePerTransaction, Limit: subject.Profile.PerTransaction, Attempted: amount}
+	}
+
+	day, month := ls.periods()
+	counters := []struct {
+		subjectType, subjectID, period, start string
+		limit                                 int64
+		code                                  string
+	}{
+		{"card", subject.CardID, "day", day, subject.Profile.CardDaily, LimitCodeCardDaily},
+		{"card", subject.CardID, "month", month, subject.Profile.CardMonthly, LimitCodeCardMonthly},
+		{"user", fmt.Sprint(subject.UserID), "day", day, subject.Profile.UserDaily, LimitCodeUserDaily},
+		{"user", fmt.Sprint(subject.UserID), "month", month, subject.Profile.UserMonthly, LimitCodeUserMonthly},
+	}
+
+	for _, c := range counters {
+		if c.subjectType == "card" && subject.CardID == "" || c.subjectType == "user" && subject.UserID == 0 {
+			continue
+		}
+
+		total, err := ls.addSpend(tx, c.subjectType, c.subjectID, c.period, c.start, amount)
+		if err != nil {
+			return err
+		}
+		if enforce && c.limit > 0 && total > c.limit {
+			return &LimitError{Code: c.code, Limit: c.limit, Attempted: total}
+		}
+
+		// Mirror today's card spend on the card for offline sync
+		if c.subjectType == "card" && c.period == "day" {
+			if _, err := tx.Exec(`
+				UPDATE cards SET daily_spent = $1::numeric / 100, updated_at = NOW() WHERE card_id = $2
+			`, total, subject.CardID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckMaxBalance verifies that the payee card's balance stays within its
+// max_balance. It must run after the ledger credit within the same tx.
+func (ls *LimitsService) CheckMaxBalance(tx *sql.Tx, payeeID string) error {
+	var maxBalance float64
+	var balance int64
+	err := tx.QueryRow(`
+		SELECT c.max_balance, a.balance
+		FROM cards c
+		JOIN accounts a ON a.card_id = c.card_id
+		WHERE c.card_id = $1 OR a.account_id = $1
+		LIMIT 1
+	`, payeeID).Scan(&maxBalance, &balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Merchant and system accounts have no card and no balance cap
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	limit := int64(maxBalance * 100) // Convert to cents
+	if limit > 0 && balance > limit {
+		return &LimitError{Code: LimitCodeMaxBalance, Limit: limit, Attempted: balance}
+	}
+	return nil
+}
+
+// ResetDailySpend sets cards.daily_spent on every card to its spend in the
+// current WAT day, zero for cards with none yet, and drops counters older
+// than the previous month. Cards that already spent today keep that spend
+// however late after midnight the job runs. The counters themselves need
+// no reset as each day has its own row.
+func (ls *LimitsService) ResetDailySpend(ctx context.Context) error {
+	day, month := ls.periods()
+	monthStart, _ := time.ParseInLocation("2006-01-02", month, watLocation)
+
+	if _, err := ls.db.ExecContext(ctx, `
+		UPDATE cards c SET daily_spent = t.spent, updated_at = NOW()
+		FROM (
+			SELECT c2.card_id, COALESCE(s.amount, 0)::numeric / 100 AS spent
+			FROM cards c2
+			LEFT JOIN spend_counters s ON s.subject_type = 'card' AND s.subject_id = c2.card_id
+			     AND s.period = 'day' AND s.period_start = $1
+		) t
+		WHERE t.card_id = c.card_id AND c.daily_spent IS DISTINCT FROM t.spent
+	`, day); err != nil {
+		return fmt.Errorf("failed to reset daily spend: %w", err)
+	}
+
+	if _, err := ls.db.ExecContext(ctx, `
+		DELETE FROM spend_counters WHERE period_start < $1
+	`, monthStart.AddDate(0, -1, 0).Format("2006-01-02")); err != nil {
+		return fmt.Errorf("failed to prune spend counters: %w", err)
+	}
+	return nil
+}
+
+// DailyLimit returns the card daily limit in minor units for cardID, or
+// zero when the card is unlimited.
+func (ls *LimitsService) DailyLimit(cardID string) (int64, error) {
+	tx, err := ls.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	subject, err := ls.loadSubject(tx, cardID)
+	if err != nil {
+		return 0, err
+	}
+	return subject.Profile.CardDaily, nil
+}
+
+func (ls *LimitsService) loadSubject(tx *sql.Tx, payerID string) (*spendSubject, error) {
+	subject := &spendSubject{}
+	var card [3]int64
+	var user [2]int64
+	err := tx.QueryRow(`
+		SELECT COALESCE(c.card_id, ''), COALESCE(a.user_id, c.user_id, 0), COALESCE(u.kyc_tier, 1),
+		       COALESCE(lp.per_transaction_limit, -1), COALESCE(lp.daily_limit, -1), COALESCE(lp.monthly_limit, -1),
+		       COALESCE(kl.daily_limit, -1), COALESCE(kl.monthly_limit, -1)
+		FROM accounts a
+		LEFT JOIN cards c ON c.card_id = a.card_id
+		LEFT JOIN users u ON u.id = COALESCE(a.user_id, c.user_id)
+		LEFT JOIN limit_profiles lp ON lp.kyc_tier = COALESCE(u.kyc_tier, 1)
+		     AND lp.card_type = CASE WHEN UPPER(c.card_type) IN ('CREDIT', 'PREPAID') THEN UPPER(c.card_type) ELSE 'DEBIT' END
+		LEFT JOIN kyc_tier_limits kl ON kl.kyc_tier = COALESCE(u.kyc_tier, 1)
+		WHERE a.account_id = $1 OR a.card_id = $1
+		LIMIT 1
+	`, payerID).Scan(&subject.CardID, &subject.UserID, &subject.KYCTier,
+		&card[0], &card[1], &card[2], &user[0], &user[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to load limits for %s: %w", maskAccountID(payerID), err)
+	}
+
+	defaults, ok := defaultLimitProfiles[subject.KYCTier]
+	if !ok {
+		defaults = defaultLimitProfiles[1]
+	}
+	subject.Profile = defaults
+	if card[0] >= 0 {
+		subject.Profile.PerTransaction = card[0]
+		subject.Profile.CardDaily = card[1]
+		subject.Profile.CardMonthly = card[2]
+	}
+	if user[0] >= 0 {
+		subject.Profile.UserDaily = user[0]
+		subject.Profile.UserMonthly = user[1]
+	}
+
+	return subject, nil
+}
+
+func (ls *LimitsService) addSpend(tx *sql.Tx, subjectType, subjectID, period, start string, amount int64) (int64, error) {
+	var total int64
+	err := tx.QueryRow(`
+		INSERT INTO spend_counters (subject_type, subject_id, period, period_start, amount, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (subject_type, subject_id, period, period_start)
+		DO UPDATE SET amount = spend_counters.amount + EXCLUDED.amount, updated_at = NOW()
+		RETURNING amount
+	`, subjectType, subjectID, period, start, amount).Scan(&total)
+	return total, err
+}
+
+// periods returns the first day of the current WAT day and month.
+func (ls *LimitsService) periods() (string, string) {
+	now := ls.now().In(watLocation)
+	day := now.Format("2006-01-02")
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, watLocation).Format("2006-01-02")
+	return day, month
+}
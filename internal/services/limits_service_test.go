@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func limitSubjectRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"card_id", "user_id", "kyc_tier", "per_tx", "card_daily", "card_monthly", "user_daily", "user_monthly"})
+}
+
+func fixedLimitsService(db *sql.DB) *LimitsService {
+	ls := NewLimitsService(db)
+	ls.now = func() time.Time { return time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC) }
+	return ls
+}
+
+func expectSpend(mock sqlmock.Sqlmock, subjectType, subjectID, period, start string, amount, total int64) {
+	mock.ExpectQuery("INSERT INTO spend_counters").
+		WithArgs(subjectType, subjectID, period, start, amount).
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(total))
+}
+
+func TestLimitsService_Reserve(t *testing.T) {
+	t.Run("within limits", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+			WithArgs("card123").
+			WillReturnRows(limitSubjectRows().AddRow("card123", 1, 1, 100000, 500000, 3000000, 800000, 5000000))
+		expectSpend(mock, "card", "card123", "day", "2026-03-14", 10000, 40000)
+		mock.ExpectExec("UPDATE cards SET daily_spent").
+			WithArgs(int64(40000), "card123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectSpend(mock, "card", "card123", "month", "2026-03-01", 10000, 90000)
+		expectSpend(mock, "user", "1", "day", "2026-03-14", 10000, 40000)
+		expectSpend(mock, "user", "1", "month", "2026-03-01", 10000, 90000)
+
+		tx, _ := db.Begin()
+		err = fixedLimitsService(db).Reserve(tx, "card123", 10000)
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("per transaction cap", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+			WithArgs("card123").
+			WillReturnRows(limitSubjectRows().AddRow("card123", 1, 1, 100000, 500000, 3000000, 800000, 5000000))
+
+		tx, _ := db.Begin()
+		err = fixedLimitsService(db).Reserve(tx, "card123", 100001)
+
+		var limitErr *LimitError
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, LimitCodePerTransaction, limitErr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("card daily limit", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+			WithArgs("card123").
+			WillReturnRows(limitSubjectRows().AddRow("card123", 1, 1, 100000, 500000, 3000000, 800000, 5000000))
+		expectSpend(mock, "card", "card123", "day", "2026-03-14", 60000, 510000)
+
+		tx, _ := db.Begin()
+		err = fixedLimitsService(db).Reserve(tx, "card123", 60000)
+
+		var limitErr *LimitError
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, LimitCodeCardDaily, limitErr.Code)
+		assert.Equal(t, int64(510000), limitErr.Attempted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("defaults by KYC tier when no profile", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+			WithArgs("0123456789").
+			WillReturnRows(limitSubjectRows().AddRow("", 7, 1, -1, -1, -1, -1, -1))
+		expectSpend(mock, "user", "7", "day", "2026-03-14", 20000, 4990000)
+		expectSpend(mock, "user", "7", "month", "2026-03-01", 20000, 30000001)
+
+		tx, _ := db.Begin()
+		err = fixedLimitsService(db).Reserve(tx, "0123456789", 20000)
+
+		var limitErr *LimitError
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, LimitCodeUserMonthly, limitErr.Code)
+		assert.Equal(t, defaultLimitProfiles[1].UserMonthly, limitErr.Limit)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestLimitsService_Record(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+		WithArgs("card123").
+		WillReturnRows(limitSubjectRows().AddRow("card123", 0, 1, 100000, 500000, 3000000, 800000, 5000000))
+	expectSpend(mock, "card", "card123", "day", "2026-03-14", 900000, 900000)
+	mock.ExpectExec("UPDATE cards SET daily_spent").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSpend(mock, "card", "card123", "month", "2026-03-01", 900000, 900000)
+
+	tx, _ := db.Begin()
+	assert.NoError(t, fixedLimitsService(db).Record(tx, "card123", 900000))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLimitsService_CheckMaxBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT c.max_balance, a.balance").
+		WithArgs("card456").
+		WillReturnRows(sqlmock.NewRows([]string{"max_balance", "balance"}).AddRow(10000.00, 1000001))
+	mock.ExpectQuery("SELECT c.max_balance, a.balance").
+		WithArgs("merchant").
+		WillReturnRows(sqlmock.NewRows([]string{"max_balance", "balance"}))
+
+	tx, _ := db.Begin()
+	ls := NewLimitsService(db)
+
+	var limitErr *LimitError
+	assert.True(t, errors.As(ls.CheckMaxBalance(tx, "card456"), &limitErr))
+	assert.Equal(t, LimitCodeMaxBalance, limitErr.Code)
+	assert.Equal(t, int64(1000000), limitErr.Limit)
+
+	assert.NoError(t, ls.CheckMaxBalance(tx, "merchant"))
+}
+
+func TestLimitsService_PeriodsRollOverAtMidnightWAT(t *testing.T) {
+	ls := NewLimitsService(nil)
+
+	ls.now = func() time.Time { return time.Date(2026, 1, 31, 22, 59, 0, 0, time.UTC) }
+	day, month := ls.periods()
+	assert.Equal(t, "2026-01-31", day)
+	assert.Equal(t, "2026-01-01", month)
+
+	ls.now = func() time.Time { return time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC) }
+	day, month = ls.periods()
+	assert.Equal(t, "2026-02-01", day)
+	assert.Equal(t, "2026-02-01", month)
+}
+
+func TestLimitsService_ResetDailySpend(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ls := NewLimitsService(db)
+	// 00:05 WAT, after cards may already have spent today
+	ls.now = func() time.Time { return time.Date(2026, 3, 13, 23, 5, 0, 0, time.UTC) }
+
+	mock.ExpectExec("UPDATE cards c SET daily_spent = t.spent").
+		WithArgs("2026-03-14").
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectExec("DELETE FROM spend_counters").
+		WithArgs("2026-02-01").
+		WillReturnResult(sqlmock.NewResult(0, 40))
+
+	assert.NoError(t, ls.ResetDailySpend(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -22,6 +22,14 @@ func (m *MockHSM) GetPublicKey(keyID string) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockHSM) ListKeys(prefix string) []hsm.KeyInfo {
+	args := m.Called(prefix)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).([]hsm.KeyInfo)
+}
+
 func (m *MockHSM) DeleteKey(keyID string) error {
 	args := m.Called(keyID)
 	return args.Error(0)
@@ -32,6 +40,11 @@ func (m *MockHSM) RotateKeys() error {
 	return args.Error(0)
 }
 
+func (m *MockHSM) ReloadKeys() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 func (m *MockHSM) EncryptData(keyID string, plaintext []byte) ([]byte, error) {
 	args := m.Called(keyID, plaintext)
 	if args.Get(0) == nil {
@@ -119,4 +132,4 @@ func (m *MockAuditLogger) LogTransfer(txID, fromAccount, toAccount string, amoun
 
 func (m *MockAuditLogger) LogError(txID, cardID string, err error) {
 	m.Called(txID, cardID, err)
-}
\ No newline at end of file
+}
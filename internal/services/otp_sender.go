@@ -0,0 +1,311 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruralpay/backend/internal/config"
+)
+
+// Message channels and the providers that serve them
+const (
+	OTPChannelSMS   = "sms"
+	OTPChannelEmail = "email"
+
+	OTPProviderHTTP = "http"
+	OTPProviderSMTP = "smtp"
+	OTPProviderFile = "file"
+)
+
+// OTPMessage is a rendered message for one recipient. Subject is only used
+// by email.
+type OTPMessage struct {
+	ID        string
+	Channel   string
+	Recipient string
+	Subject   string
+	Body      string
+}
+
+// OTPSender delivers OTPs and alerts. Send returns the provider's reference
+// for the message, which its delivery reports quote.
+type OTPSender interface {
+	Send(ctx context.Context, msg OTPMessage) (string, error)
+}
+
+// NewOTPSender routes each message to the SMS or email provider named in
+// cfg. A provider that is unknown, unset or missing its settings is an
+// error; the file sink is only used when a channel is set to it.
+func NewOTPSender(cfg *config.OTPConfig) (OTPSender, error) {
+	sms, err := newSMSSender(cfg)
+	if err != nil {
+		return nil, err
+	}
+	email, err := newEmailSender(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &channelSender{senders: map[string]OTPSender{
+		OTPChannelSMS:   sms,
+		OTPChannelEmail: email,
+	}}, nil
+}
+
+func newSMSSender(cfg *config.OTPConfig) (OTPSender, error) {
+	switch cfg.SMSProvider {
+	case OTPProviderHTTP:
+		if cfg.SMSURL == "" || cfg.SMSAPIKey == "" {
+			return nil, errors.New("OTP_SMS_URL and OTP_SMS_API_KEY are required for the http SMS provider")
+		}
+		return NewHTTPSMSSender(cfg.SMSURL, cfg.SMSAPIKey, cfg.SMSSenderID, cfg.ProviderTimeout), nil
+	case OTPProviderFile:
+		return NewFileOTPSender(cfg.FileSink), nil
+	case "":
+		return nil, errors.New("OTP_SMS_PROVIDER is required")
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", cfg.SMSProvider)
+	}
+}
+
+func newEmailSender(cfg *config.OTPConfig) (OTPSender, error) {
+	switch cfg.EmailProvider {
+	case OTPProviderSMTP:
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("OTP_SMTP_HOST is required for the smtp email provider")
+		}
+		return NewSMTPEmailSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.EmailFrom, cfg.ProviderTimeout), nil
+	case OTPProviderFile:
+		return NewFileOTPSender(cfg.FileSink), nil
+	case "":
+		return nil, errors.New("OTP_EMAIL_PROVIDER is required")
+	default:
+		return nil, fmt.Errorf("unknown email provider %q", cfg.EmailProvider)
+	}
+}
+
+// unavailableSender refuses every message. It stands in for a misconfigured
+// provider so codes are never diverted to the file sink.
+type unavailableSender struct {
+	err error
+}
+
+func (s unavailableSender) Send(ctx context.Context, msg OTPMessage) (string, error) {
+	return "", fmt.Errorf("%w: %v", ErrOTPUnavailable, s.err)
+}
+
+type channelSender struct {
+	senders map[string]OTPSender
+}
+
+func (s *channelSender) Send(ctx context.Context, msg OTPMessage) (string, error) {
+	sender, ok := s.senders[msg.Channel]
+	if !ok {
+		return "", fmt.Errorf("unsupported message channel: %s", msg.Channel)
+	}
+	return sender.Send(ctx, msg)
+}
+
+// HTTPSMSSender sends SMS through a Termii-compatible JSON API. OTPs go
+// over the DND route so they reach numbers that opted out of promotions.
+type HTTPSMSSender struct {
+	url      string
+	apiKey   string
+	senderID string
+	client   *http.Client
+}
+
+func NewHTTPSMSSender(url, apiKey, senderID string, timeout time.Duration) *HTTPSMSSender {
+	return &HTTPSMSSender{
+		url:      url,
+		apiKey:   apiKey,
+		senderID: senderID,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSMSSender) Send(ctx context.Context, msg OTPMessage) (string, error) {
+	payload, err := json.Marshal(map[string]string{
+		"to":      strings.TrimPrefix(msg.Recipient, "+"),
+		"from":    s.senderID,
+		"sms":     msg.Body,
+		"type":    "plain",
+		"channel": "dnd",
+		"api_key": s.apiKey,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("SMS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 65536))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("SMS provider returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var result struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("invalid SMS provider response: %w", err)
+	}
+	return result.MessageID, nil
+}
+
+// SMTPEmailSender sends plain-text email, upgrading to TLS when the server
+// offers STARTTLS. The Message-ID header carries the message's ID, which
+// is returned as its reference.
+type SMTPEmailSender struct {
+	host    string
+	addr    string
+	auth    smtp.Auth
+	from    string
+	timeout time.Duration
+}
+
+func NewSMTPEmailSender(host string, port int, username, password, from string, timeout time.Duration) *SMTPEmailSender {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPEmailSender{
+		host:    host,
+		addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		auth:    auth,
+		from:    from,
+		timeout: timeout,
+	}
+}
+
+func (s *SMTPEmailSender) Send(ctx context.Context, msg OTPMessage) (string, error) {
+	if strings.ContainsAny(msg.Recipient+msg.Subject, "\r\n") {
+		return "", errors.New("email recipient and subject must be a single line")
+	}
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return "", fmt.Errorf("SMTP connection failed: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return "", fmt.Errorf("SMTP handshake failed: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return "", fmt.Errorf("SMTP STARTTLS failed: %w", err)
+		}
+	}
+	if s.auth != nil {
+		if err := client.Auth(s.auth); err != nil {
+			return "", fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	messageID := fmt.Sprintf("<%s@%s>", msg.ID, s.host)
+	if err := client.Mail(s.from); err != nil {
+		return "", fmt.Errorf("SMTP sender rejected: %w", err)
+	}
+	if err := client.Rcpt(msg.Recipient); err != nil {
+		return "", fmt.Errorf("SMTP recipient rejected: %w", err)
+	}
+	wc, err := client.Data()
+	if err != nil {
+		return "", err
+	}
+	if _, err := wc.Write(s.compose(msg, messageID)); err != nil {
+		wc.Close()
+		return "", err
+	}
+	if err := wc.Close(); err != nil {
+		return "", fmt.Errorf("SMTP delivery failed: %w", err)
+	}
+	client.Quit()
+	return messageID, nil
+}
+
+func (s *SMTPEmailSender) compose(msg OTPMessage, messageID string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.Recipient)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Message-ID: %s\r\n", messageID)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// FileOTPSender appends each message as a JSON line to a file, or prints it
+// to the console when no path is set. It stands in for the SMS and email
+// providers in development; the message ID is its reference.
+type FileOTPSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileOTPSender(path string) *FileOTPSender {
+	return &FileOTPSender{path: path}
+}
+
+func (s *FileOTPSender) Send(ctx context.Context, msg OTPMessage) (string, error) {
+	line, err := json.Marshal(map[string]any{
+		"time":      time.Now().UTC(),
+		"id":        msg.ID,
+		"channel":   msg.Channel,
+		"recipient": msg.Recipient,
+		"subject":   msg.Subject,
+		"body":      msg.Body,
+	})
+	if err != nil {
+		return "", err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		_, err = os.Stdout.Write(line)
+		return msg.ID, err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open message sink: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		return "", fmt.Errorf("failed to write message sink: %w", err)
+	}
+	return msg.ID, nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSMSSender_Send(t *testing.T) {
+	t.Run("accepted", func(t *testing.T) {
+		var got map[string]string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"message_id":"3017544054459493911","message":"Successfully Sent","balance":9}`))
+		}))
+		defer server.Close()
+
+		sender := NewHTTPSMSSender(server.URL, "key", "RuralPay", time.Second)
+		ref, err := sender.Send(context.Background(), OTPMessage{Channel: OTPChannelSMS, Recipient: "+2348012345678", Body: "hello"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "3017544054459493911", ref)
+		assert.Equal(t, "2348012345678", got["to"])
+		assert.Equal(t, "RuralPay", got["from"])
+		assert.Equal(t, "dnd", got["channel"])
+		assert.Equal(t, "key", got["api_key"])
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"message":"Insufficient balance"}`, http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		_, err := NewHTTPSMSSender(server.URL, "key", "RuralPay", time.Second).
+			Send(context.Background(), OTPMessage{Recipient: "0801", Body: "hello"})
+		assert.ErrorContains(t, err, "SMS provider returned 400")
+	})
+}
+
+func TestFileOTPSender_Send(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	sender := NewFileOTPSender(path)
+
+	ref, err := sender.Send(context.Background(), OTPMessage{ID: "msg-1", Channel: OTPChannelEmail, Recipient: "ada@example.com", Subject: "Code", Body: "12345678"})
+	assert.NoError(t, err)
+	assert.Equal(t, "msg-1", ref)
+	_, err = sender.Send(context.Background(), OTPMessage{ID: "msg-2", Channel: OTPChannelSMS, Recipient: "0801", Body: "87654321"})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var first map[string]any
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+		assert.Equal(t, "ada@example.com", first["recipient"])
+		assert.Equal(t, "12345678", first["body"])
+	}
+}
+
+func TestNewOTPSender(t *testing.T) {
+	t.Run("file sink when configured", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "messages.jsonl")
+		sender, err := NewOTPSender(&config.OTPConfig{SMSProvider: OTPProviderFile, EmailProvider: OTPProviderFile, FileSink: path})
+		assert.NoError(t, err)
+
+		_, err = sender.Send(context.Background(), OTPMessage{ID: "msg-1", Channel: OTPChannelSMS, Recipient: "0801", Body: "hi"})
+		assert.NoError(t, err)
+		_, err = sender.Send(context.Background(), OTPMessage{ID: "msg-2", Channel: OTPChannelEmail, Recipient: "ada@example.com", Body: "hi"})
+		assert.NoError(t, err)
+		_, err = sender.Send(context.Background(), OTPMessage{ID: "msg-3", Channel: "push", Recipient: "device", Body: "hi"})
+		assert.Error(t, err)
+
+		data, _ := os.ReadFile(path)
+		assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 2)
+	})
+
+	// Misconfigured providers fail instead of falling back to the file sink
+	tests := []struct {
+		name string
+		cfg  config.OTPConfig
+		want string
+	}{
+		{"http SMS without settings", config.OTPConfig{SMSProvider: OTPProviderHTTP, EmailProvider: OTPProviderFile}, "OTP_SMS_URL"},
+		{"smtp email without host", config.OTPConfig{SMSProvider: OTPProviderFile, EmailProvider: OTPProviderSMTP}, "OTP_SMTP_HOST"},
+		{"unknown SMS provider", config.OTPConfig{SMSProvider: "twilio", EmailProvider: OTPProviderFile}, "unknown SMS provider"},
+		{"unknown email provider", config.OTPConfig{SMSProvider: OTPProviderFile, EmailProvider: "ses"}, "unknown email provider"},
+		{"unset providers", config.OTPConfig{}, "OTP_SMS_PROVIDER is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, err := NewOTPSender(&tt.cfg)
+			assert.Nil(t, sender)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestSMTPEmailSender_Compose(t *testing.T) {
+	sender := NewSMTPEmailSender("smtp.example.com", 587, "", "", "no-reply@ruralpay.ng", time.Second)
+	msg := string(sender.compose(OTPMessage{Recipient: "ada@example.com", Subject: "Code", Body: "line one\nline two"}, "<msg-1@smtp.example.com>"))
+
+	assert.Contains(t, msg, "To: ada@example.com\r\n")
+	assert.Contains(t, msg, "Subject: Code\r\n")
+	assert.Contains(t, msg, "Message-ID: <msg-1@smtp.example.com>\r\n")
+	assert.Contains(t, msg, "\r\n\r\nline one\r\nline two\r\n")
+
+	_, err := sender.Send(context.Background(), OTPMessage{Recipient: "ada@example.com\r\nBcc: eve@example.com", Subject: "Code"})
+	assert.Error(t, err)
+}
+
+func TestNewOTPService_MisconfiguredProviderRefusesSends(t *testing.T) {
+	t.Setenv("OTP_SMS_PROVIDER", "twilio")
+	t.Setenv("OTP_EMAIL_PROVIDER", OTPProviderFile)
+
+	_, err := NewOTPService(nil, nil).sender.Send(context.Background(), OTPMessage{ID: "msg-1", Channel: OTPChannelSMS, Recipient: "0801", Body: "hi"})
+	assert.ErrorIs(t, err, ErrOTPUnavailable)
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/ruralpay/backend/internal/config"
+)
+
+// Redis keys for OTPs and send throttling. Codes are keyed by purpose and
+// subject, so a new code replaces the last one for the same purpose;
+// throttling is keyed by channel and recipient.
+const (
+	otpCodeKey     = "otp:%s:%s"
+	otpAttemptsKey = "otp:attempts:%s:%s"
+	otpResendKey   = "otp:resend:%s:%s"
+	otpSendsKey    = "otp:sends:%s:%s"
+)
+
+// Message purposes, each with its own template
+const (
+	OTPPurposeBVN          = "bvn_verification"
+	OTPPurposeLoginUnlock  = "login_unlock"
+	OTPPurposePINReset     = "pin_reset"
+	MessagePurposeTxnAlert = "transaction_alert"
+)
+
+// Delivery statuses. Messages start SENT once the provider accepts them
+// and move on as delivery reports arrive.
+const (
+	DeliveryStatusSent      = "SENT"
+	DeliveryStatusDelivered = "DELIVERED"
+	DeliveryStatusFailed    = "FAILED"
+)
+
+var (
+	ErrOTPInvalid          = errors.New("invalid or expired OTP")
+	ErrOTPAttemptsExceeded = fmt.Errorf("%w: too many wrong codes", ErrOTPInvalid)
+	ErrOTPUnavailable      = errors.New("OTP delivery is unavailable")
+	ErrOTPDeliveryFailed   = errors.New("failed to deliver message")
+	ErrDeliveryNotFound    = errors.New("message delivery not found")
+)
+
+// OTPThrottleError is returned when a recipient has been sent too many
+// messages; RetryAfter is when the next one is allowed
+type OTPThrottleError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OTPThrottleError) Error() string {
+	return fmt.Sprintf("too many OTP requests, retry after %s", e.RetryAfter)
+}
+
+// SendOTPThrottleResponse writes a 429 with Retry-After if err is an
+// OTPThrottleError, and reports whether it did
+func SendOTPThrottleResponse(w http.ResponseWriter, err error) bool {
+	var throttleErr *OTPThrottleError
+	if !errors.As(err, &throttleErr) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttleErr.RetryAfter.Seconds()))))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: "Too many code requests. Try again later", Code: "OTP_THROTTLED"})
+	return true
+}
+
+type messageTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newMessageTemplate(name, subject, body string) messageTemplate {
+	funcs := template.FuncMap{"naira": formatNaira, "mask": maskAccountID}
+	return messageTemplate{
+		subject: template.Must(template.New(name + ".subject").Parse(subject)),
+		body:    template.Must(template.New(name).Funcs(funcs).Parse(body)),
+	}
+}
+
+// messageTemplates are keyed by purpose. OTP templates get the code and
+// its lifetime in minutes; the alert template gets a TransactionAlert.
+var messageTemplates = map[string]messageTemplate{
+	OTPPurposeBVN: newMessageTemplate(OTPPurposeBVN,
+		"Your RuralPay verification code",
+		"Your RuralPay verification code is {{.Code}}. It expires in {{.Minutes}} minutes. Do not share it with anyone."),
+	OTPPurposeLoginUnlock: newMessageTemplate(OTPPurposeLoginUnlock,
+		"Unlock your RuralPay account",
+		"Your RuralPay account unlock code is {{.Code}}. It expires in {{.Minutes}} minutes. If you did not request it, change your password."),
+	OTPPurposePINReset: newMessageTemplate(OTPPurposePINReset,
+		"Reset your RuralPay transaction PIN",
+		"Your RuralPay PIN reset code is {{.Code}}. It expires in {{.Minutes}} minutes. If you did not request it, contact support immediately."),
+	MessagePurposeTxnAlert: newMessageTemplate(MessagePurposeTxnAlert,
+		"RuralPay {{.Type}} Alert",
+		"RuralPay {{.Type}} Alert\nAcct: {{mask .Account}}\nAmt: {{naira .Amount}}\n{{if .Narration}}Desc: {{.Narration}}\n{{end}}Ref: {{.Reference}}"),
+}
+
+// OTPRequest names what a code is for and where to send it. Subject is
+// what the code proves, such as a BVN or user ID; UserID is recorded with
+// the delivery when the recipient is a known user.
+type OTPRequest struct {
+	Purpose   string
+	Subject   string
+	Channel   string
+	Recipient string
+	UserID    string
+}
+
+// TransactionAlert is the content of a debit or credit alert. Amount is in
+// kobo.
+type TransactionAlert struct {
+	Type      string
+	Account   string
+	Amount    int64
+	Narration string
+	Reference string
+}
+
+// MessageDelivery is the tracked state of one message. The recipient is
+// stored masked.
+type MessageDelivery struct {
+	ID          string     `json:"id"`
+	Purpose     string     `json:"purpose"`
+	Channel     string     `json:"channel"`
+	Recipient   string     `json:"recipient"`
+	ProviderRef string     `json:"providerRef,omitempty"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+}
+
+// OTPService issues and verifies one-time codes and sends transaction
+// alerts through an OTPSender. Codes are stored hashed; every message is
+// recorded in message_deliveries and updated from provider delivery
+// reports.
+type OTPService struct {
+	db     *sql.DB
+	redis  *redis.Client
+	sender OTPSender
+	config *config.OTPConfig
+}
+
+// NewOTPService delivers through the providers in the OTP config. The
+// server checks that config at startup; if it is invalid here every send
+// fails.
+func NewOTPService(db *sql.DB, redis *redis.Client) *OTPService {
+	cfg := config.LoadOTPConfig()
+	sender, err := NewOTPSender(cfg)
+	if err != nil {
+		log.Printf("Warning: OTP delivery disabled: %v", err)
+		sender = unavailableSender{err: err}
+	}
+	return &OTPService{
+		db:     db,
+		redis:  redis,
+		sender: sender,
+		config: cfg,
+	}
+}
+
+// CallbackToken is the shared token delivery reports must carry
+func (s *OTPService) CallbackToken() string {
+	return s.config.CallbackToken
+}
+
+// Issue sends a new code for req.Purpose and req.Subject, replacing any
+// earlier one. It returns an OTPThrottleError if the recipient was sent a
+// message too recently or too often.
+func (s *OTPService) Issue(ctx context.Context, req OTPRequest) (*MessageDelivery, error) {
+	if s.redis == nil {
+		return nil, ErrOTPUnavailable
+	}
+	if err := s.throttle(ctx, req.Channel, req.Recipient); err != nil {
+		return nil, err
+	}
+
+	code := generateOTP()
+	codeKey := fmt.Sprintf(otpCodeKey, req.Purpose, req.Subject)
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, codeKey, hashOTP(code), s.config.TTL)
+	pipe.Del(ctx, fmt.Sprintf(otpAttemptsKey, req.Purpose, req.Subject))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to store OTP: %w", err)
+	}
+
+	data := struct {
+		Code    string
+		Minutes int
+	}{code, int(s.config.TTL.Minutes())}
+	msg, err := s.render(req.Purpose, req.Channel, req.Recipient, data)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := s.deliver(ctx, req.UserID, req.Purpose, msg)
+	if err != nil {
+		// An undelivered code is useless; let the next request send a fresh one
+		s.redis.Del(ctx, codeKey, fmt.Sprintf(otpResendKey, req.Channel, strings.ToLower(req.Recipient)))
+		return delivery, err
+	}
+	return delivery, nil
+}
+
+// Verify checks code against the current OTP for purpose and subject and
+// consumes it. MaxAttempts wrong codes discard the OTP and return
+// ErrOTPAttemptsExceeded.
+func (s *OTPService) Verify(ctx context.Context, purpose, subject, code string) error {
+	if s.redis == nil {
+		return ErrOTPUnavailable
+	}
+
+	codeKey := fmt.Sprintf(otpCodeKey, purpose, subject)
+	attemptsKey := fmt.Sprintf(otpAttemptsKey, purpose, subject)
+
+	stored, err := s.redis.Get(ctx, codeKey).Result()
+	if err == redis.Nil {
+		return ErrOTPInvalid
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load OTP: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(hashOTP(code))) != 1 {
+		attempts, err := countWithin(ctx, s.redis, attemptsKey, s.config.TTL)
+		if err != nil || attempts >= s.config.MaxAttempts {
+			s.redis.Del(ctx, codeKey, attemptsKey)
+			return ErrOTPAttemptsExceeded
+		}
+		return ErrOTPInvalid
+	}
+	s.redis.Del(ctx, codeKey, attemptsKey)
+	return nil
+}
+
+// SendTransactionAlert texts alert to the user who owns account. Accounts
+// without a user, such as merchant and system accounts, are skipped.
+func (s *OTPService) SendTransactionAlert(ctx context.Context, account string, alert TransactionAlert) error {
+	var userID, phone string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.id::text, COALESCE(u.phone_number, '')
+		FROM accounts a
+		LEFT JOIN cards c ON c.card_id = a.card_id
+		JOIN users u ON u.id = COALESCE(a.user_id, c.user_id)
+		WHERE a.account_id = $1 OR a.card_id = $1
+		LIMIT 1
+	`, account).Scan(&userID, &phone)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && phone == "") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load alert recipient: %w", err)
+	}
+
+	alert.Account = account
+	msg, err := s.render(MessagePurposeTxnAlert, OTPChannelSMS, phone, alert)
+	if err != nil {
+		return err
+	}
+	_, err = s.deliver(ctx, userID, MessagePurposeTxnAlert, msg)
+	return err
+}
+
+// UpdateDeliveryStatus applies a provider delivery report. A message that
+// has been delivered stays delivered if a late failure report arrives.
+func (s *OTPService) UpdateDeliveryStatus(ctx context.Context, providerRef, status, reason string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE message_deliveries
+		SET status = CASE WHEN status = 'DELIVERED' THEN status ELSE $1::text END,
+		    error = CASE WHEN $1::text = 'FAILED' THEN NULLIF($2, '') ELSE error END,
+		    delivered_at = CASE WHEN $1::text = 'DELIVERED' THEN COALESCE(delivered_at, NOW()) ELSE delivered_at END,
+		    updated_at = NOW()
+		WHERE provider_ref = $3
+	`, status, truncate(reason, 255), providerRef)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+// Delivery returns the tracked state of the message with id
+func (s *OTPService) Delivery(ctx context.Context, id string) (*MessageDelivery, error) {
+	var d MessageDelivery
+	var providerRef, deliveryErr sql.NullString
+	var deliveredAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, purpose, channel, recipient, provider_ref, status, error, created_at, updated_at, delivered_at
+		FROM message_deliveries WHERE id::text = $1
+	`, id).Scan(&d.ID, &d.Purpose, &d.Channel, &d.Recipient, &providerRef, &d.Status, &deliveryErr,
+		&d.CreatedAt, &d.UpdatedAt, &deliveredAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load delivery: %w", err)
+	}
+	d.ProviderRef = providerRef.String
+	d.Error = deliveryErr.String
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return &d, nil
+}
+
+// throttle allows one message per ResendInterval and MaxSends per
+// SendWindow to each recipient
+func (s *OTPService) throttle(ctx context.Context, channel, recipient string) error {
+	recipient = strings.ToLower(recipient)
+	resendKey := fmt.Sprintf(otpResendKey, channel, recipient)
+	ok, err := s.redis.SetNX(ctx, resendKey, 1, s.config.ResendInterval).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check OTP throttle: %w", err)
+	}
+	if !ok {
+		return &OTPThrottleError{RetryAfter: s.retryAfter(ctx, resendKey, s.config.ResendInterval)}
+	}
+
+	sendsKey := fmt.Sprintf(otpSendsKey, channel, recipient)
+	sends, err := countWithin(ctx, s.redis, sendsKey, s.config.SendWindow)
+	if err != nil {
+		return fmt.Errorf("failed to check OTP throttle: %w", err)
+	}
+	if sends > s.config.MaxSends {
+		return &OTPThrottleError{RetryAfter: s.retryAfter(ctx, sendsKey, s.config.SendWindow)}
+	}
+	return nil
+}
+
+func (s *OTPService) retryAfter(ctx context.Context, key string, fallback time.Duration) time.Duration {
+	if ttl, err := s.redis.TTL(ctx, key).Result(); err == nil && ttl > 0 {
+		return ttl
+	}
+	return fallback
+}
+
+func (s *OTPService) render(purpose, channel, recipient string, data any) (OTPMessage, error) {
+	tmpl, ok := messageTemplates[purpose]
+	if !ok {
+		return OTPMessage{}, fmt.Errorf("no message template for %s", purpose)
+	}
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return OTPMessage{}, fmt.Errorf("failed to render %s subject: %w", purpose, err)
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return OTPMessage{}, fmt.Errorf("failed to render %s message: %w", purpose, err)
+	}
+	return OTPMessage{
+		ID:        uuid.New().String(),
+		Channel:   channel,
+		Recipient: recipient,
+		Subject:   subject.String(),
+		Body:      body.String(),
+	}, nil
+}
+
+// deliver sends msg and records the outcome. Failing to record is logged
+// rather than returned, since the message has already gone out.
+func (s *OTPService) deliver(ctx context.Context, userID, purpose string, msg OTPMessage) (*MessageDelivery, error) {
+	delivery := &MessageDelivery{
+		ID:        msg.ID,
+		Purpose:   purpose,
+		Channel:   msg.Channel,
+		Recipient: maskRecipient(msg.Recipient),
+		Status:    DeliveryStatusSent,
+	}
+
+	ref, sendErr := s.sender.Send(ctx, msg)
+	if sendErr != nil {
+		delivery.Status = DeliveryStatusFailed
+		delivery.Error = truncate(sendErr.Error(), 255)
+	}
+	delivery.ProviderRef = ref
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO message_deliveries (id, user_id, purpose, channel, recipient, provider_ref, status, error)
+		VALUES ($1, NULLIF($2, '')::int, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''))
+	`, delivery.ID, userID, purpose, delivery.Channel, delivery.Recipient, delivery.ProviderRef, delivery.Status, delivery.Error)
+	if err != nil {
+		log.Printf("[OTP] Failed to record delivery %s: %v", delivery.ID, err)
+	}
+
+	if sendErr != nil {
+		log.Printf("[OTP] %s %s to %s failed: %v", purpose, msg.Channel, delivery.Recipient, sendErr)
+		return delivery, fmt.Errorf("%w: %v", ErrOTPDeliveryFailed, sendErr)
+	}
+	return delivery, nil
+}
+
+// ParseDeliveryStatus maps a provider's delivery report status onto a
+// tracked status. Interim statuses such as "Message Sent" are not
+// reported.
+func ParseDeliveryStatus(status string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "delivered", "delivrd":
+		return DeliveryStatusDelivered, true
+	case "failed", "rejected", "expired", "undelivered", "undeliv", "dnd active":
+		return DeliveryStatusFailed, true
+	}
+	return "", false
+}
+
+func hashOTP(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// maskRecipient keeps the last four digits of a phone number, or the first
+// letter and domain of an email address
+func maskRecipient(recipient string) string {
+	if local, domain, ok := strings.Cut(recipient, "@"); ok && local != "" {
+		return local[:1] + "***@" + domain
+	}
+	return maskAccountID(recipient)
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSender keeps the messages it is given instead of sending them
+type recordingSender struct {
+	sent []OTPMessage
+	err  error
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg OTPMessage) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	s.sent = append(s.sent, msg)
+	return "ref-" + msg.ID, nil
+}
+
+func newTestOTPService(db *sql.DB, redisClient *redis.Client) (*OTPService, *recordingSender) {
+	sender := &recordingSender{}
+	return &OTPService{
+		db:     db,
+		redis:  redisClient,
+		sender: sender,
+		config: &config.OTPConfig{
+			TTL:            10 * time.Minute,
+			MaxAttempts:    3,
+			ResendInterval: time.Minute,
+			MaxSends:       5,
+			SendWindow:     time.Hour,
+		},
+	}, sender
+}
+
+func expectOTPIssue(mock sqlmock.Sqlmock, redisMock redismock.ClientMock, purpose, subject, recipient string) {
+	redisMock.ExpectSetNX("otp:resend:sms:"+recipient, 1, time.Minute).SetVal(true)
+	redisMock.ExpectIncr("otp:sends:sms:" + recipient).SetVal(1)
+	redisMock.ExpectExpire("otp:sends:sms:"+recipient, time.Hour).SetVal(true)
+	redisMock.ExpectTxPipeline()
+	redisMock.Regexp().ExpectSet("otp:"+purpose+":"+subject, "[0-9a-f]{64}", 10*time.Minute).SetVal("OK")
+	redisMock.ExpectDel("otp:attempts:" + purpose + ":" + subject).SetVal(0)
+	redisMock.ExpectTxPipelineExec()
+	mock.ExpectExec("INSERT INTO message_deliveries").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), purpose, OTPChannelSMS, sqlmock.AnyArg(), sqlmock.AnyArg(), DeliveryStatusSent, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestOTPService_Issue(t *testing.T) {
+	t.Run("sends a templated code", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		s, sender := newTestOTPService(db, redisClient)
+		expectOTPIssue(mock, redisMock, OTPPurposeBVN, "12345678901", "08012345678")
+
+		delivery, err := s.Issue(context.Background(), OTPRequest{
+			Purpose:   OTPPurposeBVN,
+			Subject:   "12345678901",
+			Channel:   OTPChannelSMS,
+			Recipient: "08012345678",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, DeliveryStatusSent, delivery.Status)
+		assert.Equal(t, "****5678", delivery.Recipient)
+		assert.Equal(t, "ref-"+delivery.ID, delivery.ProviderRef)
+		if assert.Len(t, sender.sent, 1) {
+			assert.Equal(t, "08012345678", sender.sent[0].Recipient)
+			assert.Regexp(t, "^Your RuralPay verification code is [0-9]{8}\\. It expires in 10 minutes\\.", sender.sent[0].Body)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("resend within the interval is throttled", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		s, sender := newTestOTPService(nil, redisClient)
+		redisMock.ExpectSetNX("otp:resend:sms:0801", 1, time.Minute).SetVal(false)
+		redisMock.ExpectTTL("otp:resend:sms:0801").SetVal(40 * time.Second)
+
+		_, err := s.Issue(context.Background(), OTPRequest{Purpose: OTPPurposeLoginUnlock, Subject: "0801", Channel: OTPChannelSMS, Recipient: "0801"})
+
+		var throttleErr *OTPThrottleError
+		assert.True(t, errors.As(err, &throttleErr))
+		assert.Equal(t, 40*time.Second, throttleErr.RetryAfter)
+		assert.Empty(t, sender.sent)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("too many sends in the window are throttled", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		s, _ := newTestOTPService(nil, redisClient)
+		redisMock.ExpectSetNX("otp:resend:sms:0801", 1, time.Minute).SetVal(true)
+		redisMock.ExpectIncr("otp:sends:sms:0801").SetVal(6)
+		redisMock.ExpectTTL("otp:sends:sms:0801").SetVal(20 * time.Minute)
+
+		_, err := s.Issue(context.Background(), OTPRequest{Purpose: OTPPurposeLoginUnlock, Subject: "0801", Channel: OTPChannelSMS, Recipient: "0801"})
+
+		var throttleErr *OTPThrottleError
+		assert.True(t, errors.As(err, &throttleErr))
+		assert.Equal(t, 20*time.Minute, throttleErr.RetryAfter)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("failed delivery is recorded and the code discarded", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		s, sender := newTestOTPService(db, redisClient)
+		sender.err = errors.New("provider down")
+		redisMock.ExpectSetNX("otp:resend:sms:0801", 1, time.Minute).SetVal(true)
+		redisMock.ExpectIncr("otp:sends:sms:0801").SetVal(1)
+		redisMock.ExpectExpire("otp:sends:sms:0801", time.Hour).SetVal(true)
+		redisMock.ExpectTxPipeline()
+		redisMock.Regexp().ExpectSet("otp:pin_reset:7", "[0-9a-f]{64}", 10*time.Minute).SetVal("OK")
+		redisMock.ExpectDel("otp:attempts:pin_reset:7").SetVal(0)
+		redisMock.ExpectTxPipelineExec()
+		mock.ExpectExec("INSERT INTO message_deliveries").
+			WithArgs(sqlmock.AnyArg(), "7", OTPPurposePINReset, OTPChannelSMS, "****", "", DeliveryStatusFailed, "provider down").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		redisMock.ExpectDel("otp:pin_reset:7", "otp:resend:sms:0801").SetVal(2)
+
+		_, err := s.Issue(context.Background(), OTPRequest{Purpose: OTPPurposePINReset, Subject: "7", Channel: OTPChannelSMS, Recipient: "0801", UserID: "7"})
+
+		assert.ErrorIs(t, err, ErrOTPDeliveryFailed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("unavailable without redis", func(t *testing.T) {
+		s, _ := newTestOTPService(nil, nil)
+		_, err := s.Issue(context.Background(), OTPRequest{Purpose: OTPPurposeBVN})
+		assert.ErrorIs(t, err, ErrOTPUnavailable)
+	})
+}
+
+func TestOTPService_Verify(t *testing.T) {
+	t.Run("correct code is consumed", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		s, _ := newTestOTPService(nil, redisClient)
+		redisMock.ExpectGet("otp:bvn_verification:12345678901").SetVal(hashOTP("12345678"))
+		redisMock.ExpectDel("otp:bvn_verification:12345678901", "otp:attempts:bvn_verification:12345678901").SetVal(1)
+
+		assert.NoError(t, s.Verify(context.Background(), OTPPurposeBVN, "12345678901", "12345678"))
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("wrong code counts an attempt", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		s, _ := newTestOTPService(nil, redisClient)
+		redisMock.ExpectGet("otp:bvn_verification:12345678901").SetVal(hashOTP("12345678"))
+		redisMock.ExpectIncr("otp:attempts:bvn_verification:12345678901").SetVal(1)
+		redisMock.ExpectExpire("otp:attempts:bvn_verification:12345678901", 10*time.Minute).SetVal(true)
+
+		err := s.Verify(context.Background(), OTPPurposeBVN, "12345678901", "87654321")
+		assert.ErrorIs(t, err, ErrOTPInvalid)
+		assert.NotErrorIs(t, err, ErrOTPAttemptsExceeded)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("last wrong code discards the OTP", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		s, _ := newTestOTPService(nil, redisClient)
+		redisMock.ExpectGet("otp:bvn_verification:12345678901").SetVal(hashOTP("12345678"))
+		redisMock.ExpectIncr("otp:attempts:bvn_verification:12345678901").SetVal(3)
+		redisMock.ExpectDel("otp:bvn_verification:12345678901", "otp:attempts:bvn_verification:12345678901").SetVal(2)
+
+		err := s.Verify(context.Background(), OTPPurposeBVN, "12345678901", "87654321")
+		assert.ErrorIs(t, err, ErrOTPAttemptsExceeded)
+		assert.ErrorIs(t, err, ErrOTPInvalid)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		s, _ := newTestOTPService(nil, redisClient)
+		redisMock.ExpectGet("otp:bvn_verification:12345678901").RedisNil()
+
+		assert.ErrorIs(t, s.Verify(context.Background(), OTPPurposeBVN, "12345678901", "12345678"), ErrOTPInvalid)
+	})
+}
+
+func TestOTPService_SendTransactionAlert(t *testing.T) {
+	t.Run("texts the account owner", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		s, sender := newTestOTPService(db, nil)
+		mock.ExpectQuery("SELECT u.id::text, COALESCE\\(u.phone_number, ''\\)").
+			WithArgs("0123456789").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "phone_number"}).AddRow("7", "08012345678"))
+		mock.ExpectExec("INSERT INTO message_deliveries").
+			WithArgs(sqlmock.AnyArg(), "7", MessagePurposeTxnAlert, OTPChannelSMS, "****5678", sqlmock.AnyArg(), DeliveryStatusSent, "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := s.SendTransactionAlert(context.Background(), "0123456789", TransactionAlert{
+			Type: "Debit", Amount: 150050, Narration: "School fees", Reference: "TX123",
+		})
+
+		assert.NoError(t, err)
+		if assert.Len(t, sender.sent, 1) {
+			assert.Equal(t, "RuralPay Debit Alert\nAcct: ****6789\nAmt: NGN 1500.50\nDesc: School fees\nRef: TX123", sender.sent[0].Body)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("merchant accounts are skipped", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		s, sender := newTestOTPService(db, nil)
+		mock.ExpectQuery("SELECT u.id::text").WithArgs("MERCHANT1").WillReturnRows(sqlmock.NewRows(nil))
+
+		assert.NoError(t, s.SendTransactionAlert(context.Background(), "MERCHANT1", TransactionAlert{Type: "Credit", Amount: 100}))
+		assert.Empty(t, sender.sent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOTPService_UpdateDeliveryStatus(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+	s, _ := newTestOTPService(db, nil)
+
+	mock.ExpectExec("UPDATE message_deliveries").
+		WithArgs(DeliveryStatusDelivered, "Delivered", "ref-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.UpdateDeliveryStatus(context.Background(), "ref-1", DeliveryStatusDelivered, "Delivered"))
+
+	mock.ExpectExec("UPDATE message_deliveries").
+		WithArgs(DeliveryStatusFailed, "Rejected", "unknown").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.UpdateDeliveryStatus(context.Background(), "unknown", DeliveryStatusFailed, "Rejected"), ErrDeliveryNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseDeliveryStatus(t *testing.T) {
+	for input, want := range map[string]string{
+		"Delivered":  DeliveryStatusDelivered,
+		"DELIVRD":    DeliveryStatusDelivered,
+		"Rejected":   DeliveryStatusFailed,
+		"DND Active": DeliveryStatusFailed,
+		"Expired":    DeliveryStatusFailed,
+	} {
+		got, ok := ParseDeliveryStatus(input)
+		assert.True(t, ok, input)
+		assert.Equal(t, want, got, input)
+	}
+
+	_, ok := ParseDeliveryStatus("Message Sent")
+	assert.False(t, ok)
+}
+
+func TestSendOTPThrottleResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	assert.True(t, SendOTPThrottleResponse(w, &OTPThrottleError{RetryAfter: 1500 * time.Millisecond}))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	assert.False(t, SendOTPThrottleResponse(httptest.NewRecorder(), ErrOTPInvalid))
+}
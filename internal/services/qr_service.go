@@ -6,85 +6,703 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image/png"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/models"
 	"github.com/skip2/go-qrcode"
 )
 
+// QR code types. Static codes carry no amount and can be paid any number of
+// times; dynamic codes carry the amount and are single use.
+const (
+	QRStatic  = "STATIC"
+	QRDynamic = "DYNAMIC"
+)
+
+// QR code states recorded in qr_codes. Dynamic codes start PENDING and
+// become PAID or EXPIRED; static codes stay ACTIVE.
+const (
+	QRStatusActive  = "ACTIVE"
+	QRStatusPending = "PENDING"
+	QRStatusPaid    = "PAID"
+	QRStatusExpired = "EXPIRED"
+)
+
+// ChannelQR tags transactions paid by scanning a QR code
+const ChannelQR = "QR"
+
+var (
+	ErrQRNotFound     = errors.New("QR code not found")
+	ErrQRExpired      = errors.New("QR code expired")
+	ErrQRAlreadyPaid  = errors.New("QR code already paid")
+	ErrQRSelfPayment  = errors.New("QR code cannot be paid by its owner")
+	ErrQRAmountNeeded = errors.New("amount is required for a static QR code")
+	ErrQRAmountFixed  = errors.New("amount does not match the QR code")
+)
+
 type QRService struct {
-	db    *sql.DB
-	redis *redis.Client
+	db     *sql.DB
+	redis  *redis.Client
+	hsm    hsm.HSMInterface
+	config *config.QRConfig
+	ledger *DoubleLedgerService
+	limits *LimitsService
+	audit  *hsm.AuditLogger
+	stepUp *StepUpService
+}
+
+// GeneratedQR is a new merchant QR code. QRImage is a base64 PNG of
+// QRCode; Reference identifies the code when polling its status.
+type GeneratedQR struct {
+	QRCode    string     `json:"qrCode"`
+	QRImage   string     `json:"qrImage"`
+	Reference string     `json:"reference"`
+	Type      string     `json:"type"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// QRReceipt is returned to the payer once a QR payment is posted. Amounts
+// are in minor units.
+type QRReceipt struct {
+	TransactionID string    `json:"transactionId"`
+	Reference     string    `json:"reference"`
+	Type          string    `json:"type"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	MerchantName  string    `json:"merchantName"`
+	PayeeAccount  string    `json:"payeeAccount"`
+	PayerAccount  string    `json:"payerAccount"`
+	Channel       string    `json:"channel"`
+	Status        string    `json:"status"`
+	PaidAt        time.Time `json:"paidAt"`
+}
+
+// QRCodeStatus is the payment state of a code as seen by its owner
+type QRCodeStatus struct {
+	Reference string      `json:"reference"`
+	Type      string      `json:"type"`
+	Amount    int64       `json:"amount"`
+	Status    string      `json:"status"`
+	ExpiresAt *time.Time  `json:"expiresAt,omitempty"`
+	Payments  []QRPayment `json:"payments"`
+}
+
+// QRPayment is one completed payment made against a code
+type QRPayment struct {
+	TransactionID string    `json:"transactionId"`
+	Amount        int64     `json:"amount"`
+	PayerAccount  string    `json:"payerAccount"`
+	PaidAt        time.Time `json:"paidAt"`
+}
+
+// qrCodeRecord is a row of qr_codes
+type qrCodeRecord struct {
+	UserID    string
+	Type      string
+	Amount    int64
+	Status    string
+	ExpiresAt sql.NullTime
+}
+
+// qrMerchant is the account a QR code pays into
+type qrMerchant struct {
+	UserID        string
+	Name          string
+	AccountNumber string
+	BankCode      string
 }
 
-func NewQRService(db *sql.DB, redis *redis.Client) *QRService {
+func NewQRService(db *sql.DB, redis *redis.Client, hsmInstance hsm.HSMInterface) *QRService {
+	cfg := config.LoadQRConfig()
+	if len(cfg.SignatureGUID) > 20 {
+		log.Printf("[QRService] QR_SIGNATURE_GUID %q is too long for the signature templates, truncating", cfg.SignatureGUID)
+		cfg.SignatureGUID = cfg.SignatureGUID[:20]
+	}
+
 	return &QRService{
-		db:    db,
-		redis: redis,
+		db:     db,
+		redis:  redis,
+		hsm:    hsmInstance,
+		config: cfg,
+		ledger: NewDoubleLedgerService(db),
+		limits: NewLimitsService(db),
+		audit:  hsm.NewAuditLogger(),
+		stepUp: NewStepUpService(db, redis, hsmInstance),
 	}
 }
 
-func (s *QRService) GenerateQRCode(ctx context.Context, userID string, amount int64) (string, string, error) {
-	qrData := map[string]any{
-		"userId":    userID,
-		"amount":    amount,
-		"timestamp": time.Now().Unix(),
-		"nonce":     s.generateNonce(),
+// GenerateQRCode builds a signed EMVCo merchant-presented payload for the
+// user's account and records it in qr_codes. An amount of zero yields a
+// static code; otherwise the code is dynamic and expires after the
+// configured timeout.
+func (s *QRService) GenerateQRCode(ctx context.Context, userID string, amount int64) (*GeneratedQR, error) {
+	if amount < 0 {
+		return nil, fmt.Errorf("amount must not be negative")
+	}
+
+	merchant, err := s.lookupMerchant(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	jsonData, err := json.Marshal(qrData)
+	reference := s.generateReference()
+	payload, err := s.buildPayload(merchant, amount, reference)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	qrCode := base64.URLEncoding.EncodeToString(jsonData)
+	qrType, status := QRStatic, QRStatusActive
+	var expiresAt *time.Time
+	if amount > 0 {
+		qrType, status = QRDynamic, QRStatusPending
+		expiry := time.Now().Add(s.config.DynamicTimeout)
+		expiresAt = &expiry
+	}
 
-	key := fmt.Sprintf("qr:%s", qrCode)
-	if err := s.redis.Set(ctx, key, jsonData, 5*time.Minute).Err(); err != nil {
-		return "", "", err
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO qr_codes (reference, user_id, qr_type, amount, status, created_at, expires_at)
+		VALUES ($1, $2::integer, $3, $4, $5, NOW(), $6)
+	`, reference, merchant.UserID, qrType, amount, status, expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to record QR code: %w", err)
 	}
 
-	qr, err := qrcode.New(qrCode, qrcode.Medium)
+	qr, err := qrcode.New(payload, qrcode.Medium)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	var buf bytes.Buffer
 	if err := png.Encode(&buf, qr.Image(256)); err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	qrImage := base64.StdEncoding.EncodeToString(buf.Bytes())
-
-	return qrCode, qrImage, nil
+	return &GeneratedQR{
+		QRCode:    payload,
+		QRImage:   base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Reference: reference,
+		Type:      qrType,
+		ExpiresAt: expiresAt,
+	}, nil
 }
 
+// ProcessQRCode checks the CRC and HSM signature of a scanned payload and
+// returns its merchant, amount and payment state so the scanner can confirm
+// the payment. Nothing is consumed until PayQRCode.
 func (s *QRService) ProcessQRCode(ctx context.Context, qrData string) (map[string]any, error) {
-	key := fmt.Sprintf("qr:%s", qrData)
+	decoded, err := s.decodePayload(qrData)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.loadCode(ctx, s.db.QueryRowContext, decoded.Reference, false)
+	if err != nil {
+		return nil, err
+	}
+	if !record.matches(decoded) {
+		return nil, ErrQRBadSignature
+	}
+	status := record.effectiveStatus(time.Now())
+	if status == QRStatusExpired {
+		return nil, ErrQRExpired
+	}
 
-	data, err := s.redis.Get(ctx, key).Bytes()
-	if err == redis.Nil {
-		return nil, fmt.Errorf("invalid or expired QR code")
+	return map[string]any{
+		"type":          record.Type,
+		"userId":        record.UserID,
+		"amount":        decoded.Amount,
+		"merchantName":  decoded.MerchantName,
+		"accountNumber": decoded.AccountNumber,
+		"bankCode":      decoded.BankCode,
+		"reference":     decoded.Reference,
+		"currency":      "NGN",
+		"status":        status,
+	}, nil
+}
+
+// PayQRCode pays a scanned code from the payer's account into the code
+// owner's account through the ledger and records a transactions row with
+// channel QR. amount is required for static codes and, if given, must match
+// a dynamic code. A dynamic code is marked PAID in the same database
+// transaction, so it can only be paid once. Amounts above the step-up
+// threshold need the payer's proof.
+func (s *QRService) PayQRCode(ctx context.Context, payerID, qrData string, amount int64, narration string, proof models.StepUpProof) (*QRReceipt, error) {
+	decoded, err := s.decodePayload(qrData)
+	if err != nil {
+		return nil, err
 	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	var result map[string]any
-	if err := json.Unmarshal(data, &result); err != nil {
+	record, err := s.loadCode(ctx, tx.QueryRowContext, decoded.Reference, true)
+	if err != nil {
 		return nil, err
 	}
+	if !record.matches(decoded) {
+		return nil, ErrQRBadSignature
+	}
 
-	s.redis.Del(ctx, key)
+	now := time.Now()
+	switch record.effectiveStatus(now) {
+	case QRStatusPaid:
+		return nil, ErrQRAlreadyPaid
+	case QRStatusExpired:
+		return nil, ErrQRExpired
+	}
 
-	return result, nil
+	if record.Type == QRDynamic {
+		if amount != 0 && amount != record.Amount {
+			return nil, ErrQRAmountFixed
+		}
+		amount = record.Amount
+	} else if amount <= 0 {
+		return nil, ErrQRAmountNeeded
+	}
+
+	if payerID == record.UserID {
+		return nil, ErrQRSelfPayment
+	}
+	if err := s.stepUp.Authorize(ctx, payerID, amount, proof); err != nil {
+		return nil, err
+	}
+
+	var payerAccount, payeeAccount string
+	if err := tx.QueryRowContext(ctx, `SELECT account_id FROM users WHERE id::text = $1`, payerID).Scan(&payerAccount); err != nil {
+		return nil, fmt.Errorf("failed to resolve payer account: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT account_id FROM users WHERE id::text = $1`, record.UserID).Scan(&payeeAccount); err != nil {
+		return nil, fmt.Errorf("failed to resolve merchant account: %w", err)
+	}
+
+	transactionID := s.generateTransactionID()
+	if narration == "" {
+		narration = fmt.Sprintf("QR payment to %s", decoded.MerchantName)
+	}
+
+	log.Printf("[QRService] PayQRCode - txID: %s, ref: %s, payer: %s, payee: %s, amount: %d",
+		transactionID, decoded.Reference, maskAccountID(payerAccount), maskAccountID(payeeAccount), amount)
+
+	if err := s.postPayment(ctx, tx, payerID, payerAccount, payeeAccount, transactionID, decoded.Reference, amount, narration); err != nil {
+		return nil, err
+	}
+
+	if record.Type == QRDynamic {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE qr_codes SET status = $1, transaction_id = $2, paid_at = $3 WHERE reference = $4
+		`, QRStatusPaid, transactionID, now, decoded.Reference); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.audit.LogTransfer(transactionID, payerAccount, payeeAccount, amount, "COMPLETED")
+	s.publishPaid(ctx, decoded.Reference, transactionID)
+
+	status := QRStatusPaid
+	if record.Type == QRStatic {
+		status = QRStatusActive
+	}
+	return &QRReceipt{
+		TransactionID: transactionID,
+		Reference:     decoded.Reference,
+		Type:          record.Type,
+		Amount:        amount,
+		Currency:      "NGN",
+		MerchantName:  decoded.MerchantName,
+		PayeeAccount:  maskAccountID(payeeAccount),
+		PayerAccount:  maskAccountID(payerAccount),
+		Channel:       ChannelQR,
+		Status:        status,
+		PaidAt:        now,
+	}, nil
 }
 
-func (s *QRService) generateNonce() string {
+// postPayment applies the payer's limits, posts the payment to the ledger
+// and records it in transactions, all within tx.
+func (s *QRService) postPayment(ctx context.Context, tx *sql.Tx, payerID, payerAccount, payeeAccount, transactionID, reference string, amount int64, narration string) error {
+	if err := s.ledger.appendPaymentState(tx, transactionID, "PENDING"); err != nil {
+		return err
+	}
+
+	if err := s.limits.Reserve(tx, payerAccount, amount); err != nil {
+		return err
+	}
+
+	if err := s.ledger.TransferTx(tx, payerAccount, payeeAccount, transactionID, amount); err != nil {
+		log.Printf("[QRService] postPayment - Ledger error for %s: %v", transactionID, err)
+		return err
+	}
+
+	if err := s.limits.CheckMaxBalance(tx, payeeAccount); err != nil {
+		return err
+	}
+
+	if err := s.ledger.appendPaymentState(tx, transactionID, "SUCCESS"); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO transactions
+		(transaction_id, reference_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, channel, user_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, 0, $5, 'NGN', $6, 'payment', 'COMPLETED', $7, NULLIF($8, '')::integer, NOW())
+	`, transactionID, reference, payerAccount, payeeAccount, amount, narration, ChannelQR, payerID)
+	return err
+}
+
+// CodeStatus returns the payment state of one of userID's codes. With a
+// positive wait, a pending dynamic code is watched until it is paid or wait
+// elapses, so the merchant's counter screen can long-poll.
+func (s *QRService) CodeStatus(ctx context.Context, userID, reference string, wait time.Duration) (*QRCodeStatus, error) {
+	status, err := s.codeStatus(ctx, userID, reference)
+	if err != nil || wait <= 0 || status.Status != QRStatusPending || s.redis == nil {
+		return status, err
+	}
+
+	sub := s.redis.Subscribe(ctx, qrEventsChannel(reference))
+	defer sub.Close()
+
+	// The code may have been paid between the first read and subscribing
+	if status, err = s.codeStatus(ctx, userID, reference); err != nil || status.Status != QRStatusPending {
+		return status, err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	if _, err := sub.ReceiveMessage(waitCtx); err != nil && waitCtx.Err() == nil {
+		log.Printf("[QRService] CodeStatus - subscription for %s failed: %v", reference, err)
+	}
+
+	return s.codeStatus(ctx, userID, reference)
+}
+
+func (s *QRService) codeStatus(ctx context.Context, userID, reference string) (*QRCodeStatus, error) {
+	var record qrCodeRecord
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id::text, qr_type, amount, status, expires_at
+		FROM qr_codes
+		WHERE reference = $1 AND user_id::text = $2
+	`, reference, userID).Scan(&record.UserID, &record.Type, &record.Amount, &record.Status, &record.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrQRNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := &QRCodeStatus{
+		Reference: reference,
+		Type:      record.Type,
+		Amount:    record.Amount,
+		Status:    record.effectiveStatus(time.Now()),
+		Payments:  []QRPayment{},
+	}
+	if record.ExpiresAt.Valid {
+		status.ExpiresAt = &record.ExpiresAt.Time
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT transaction_id, amount, from_card_id, created_at
+		FROM transactions
+		WHERE reference_id = $1 AND channel = $2 AND status = 'COMPLETED'
+		ORDER BY created_at DESC
+		LIMIT 20
+	`, reference, ChannelQR)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var payment QRPayment
+		var payerAccount sql.NullString
+		if err := rows.Scan(&payment.TransactionID, &payment.Amount, &payerAccount, &payment.PaidAt); err != nil {
+			return nil, err
+		}
+		payment.PayerAccount = maskAccountID(payerAccount.String)
+		status.Payments = append(status.Payments, payment)
+	}
+	return status, rows.Err()
+}
+
+// ExpireCodes marks dynamic codes past their expiry as EXPIRED
+func (s *QRService) ExpireCodes(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE qr_codes SET status = $1 WHERE status = $2 AND expires_at < NOW()
+	`, QRStatusExpired, QRStatusPending)
+	return err
+}
+
+// publishPaid notifies CodeStatus watchers that a code was paid
+func (s *QRService) publishPaid(ctx context.Context, reference, transactionID string) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Publish(ctx, qrEventsChannel(reference), transactionID).Err(); err != nil {
+		log.Printf("[QRService] Failed to publish payment of %s: %v", reference, err)
+	}
+}
+
+// loadCode reads the qr_codes row for reference, locking it when forUpdate
+// is set. query is the QueryRowContext of the database or of a transaction.
+func (s *QRService) loadCode(ctx context.Context, query func(context.Context, string, ...any) *sql.Row, reference string, forUpdate bool) (*qrCodeRecord, error) {
+	stmt := `SELECT user_id::text, qr_type, amount, status, expires_at FROM qr_codes WHERE reference = $1`
+	if forUpdate {
+		stmt += " FOR UPDATE"
+	}
+
+	var record qrCodeRecord
+	err := query(ctx, stmt, reference).Scan(&record.UserID, &record.Type, &record.Amount, &record.Status, &record.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrQRNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// matches reports whether a decoded payload agrees with the recorded code
+func (r *qrCodeRecord) matches(decoded *decodedQR) bool {
+	if decoded.Initiation == emvInitiationDynamic {
+		return r.Type == QRDynamic && r.Amount == decoded.Amount
+	}
+	return r.Type == QRStatic
+}
+
+// effectiveStatus reports a pending code past its expiry as EXPIRED even
+// before the expiry job has updated it.
+func (r *qrCodeRecord) effectiveStatus(now time.Time) string {
+	if r.Status == QRStatusPending && r.ExpiresAt.Valid && now.After(r.ExpiresAt.Time) {
+		return QRStatusExpired
+	}
+	return r.Status
+}
+
+// decodedQR holds the signed fields of a verified payload
+type decodedQR struct {
+	Initiation    string
+	Amount        int64
+	MerchantName  string
+	AccountNumber string
+	BankCode      string
+	Reference     string
+}
+
+// decodePayload verifies a payload and extracts the fields the payment flow
+// needs. Callers check the result against qr_codes with matches.
+func (s *QRService) decodePayload(qrData string) (*decodedQR, error) {
+	fields, err := s.verifyPayload(qrData)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(fields))
+	for _, f := range fields {
+		values[f.Tag] = f.Value
+	}
+
+	merchantInfo, err := emvSubFields(values[emvTagMerchantInfo])
+	if err != nil {
+		return nil, err
+	}
+	additional, err := emvSubFields(values[emvTagAdditionalData])
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := &decodedQR{
+		Initiation:    values[emvTagInitiation],
+		MerchantName:  values[emvTagMerchantName],
+		AccountNumber: merchantInfo[emvMerchantAccount],
+		BankCode:      merchantInfo[emvMerchantInstitution],
+		Reference:     additional[emvAdditionalReference],
+	}
+	if decoded.Reference == "" {
+		return nil, fmt.Errorf("%w: missing reference", ErrQRMalformed)
+	}
+
+	switch decoded.Initiation {
+	case emvInitiationStatic:
+	case emvInitiationDynamic:
+		if decoded.Amount, err = parseNairaAmount(values[emvTagAmount]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown point of initiation %q", ErrQRMalformed, decoded.Initiation)
+	}
+	return decoded, nil
+}
+
+func (s *QRService) lookupMerchant(ctx context.Context, userID string) (*qrMerchant, error) {
+	merchant := &qrMerchant{UserID: userID}
+	var firstName, lastName string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), u.account_id, COALESCE(a.bank_code, '')
+		FROM users u
+		LEFT JOIN accounts a ON a.account_id = u.account_id
+		WHERE u.id::text = $1
+	`, userID).Scan(&firstName, &lastName, &merchant.AccountNumber, &merchant.BankCode)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	merchant.Name = strings.TrimSpace(firstName + " " + lastName)
+	if merchant.BankCode == "" {
+		merchant.BankCode = s.config.InstitutionCode
+	}
+	return merchant, nil
+}
+
+// buildPayload assembles the EMVCo data objects, appends the signature over
+// everything before it and finishes with the CRC.
+func (s *QRService) buildPayload(merchant *qrMerchant, amount int64, reference string) (string, error) {
+	initiation := emvInitiationStatic
+	if amount > 0 {
+		initiation = emvInitiationDynamic
+	}
+
+	var b strings.Builder
+	b.WriteString(emvTLV(emvTagPayloadFormat, "01"))
+	b.WriteString(emvTLV(emvTagInitiation, initiation))
+	b.WriteString(emvTLV(emvTagMerchantInfo,
+		emvTLV(emvMerchantGUID, s.config.MerchantGUID)+
+			emvTLV(emvMerchantInstitution, merchant.BankCode)+
+			emvTLV(emvMerchantAccount, merchant.AccountNumber)))
+	b.WriteString(emvTLV(emvTagCategoryCode, s.config.MerchantCategory))
+	b.WriteString(emvTLV(emvTagCurrency, emvCurrencyNGN))
+	if amount > 0 {
+		b.WriteString(emvTLV(emvTagAmount, formatNairaAmount(amount)))
+	}
+	b.WriteString(emvTLV(emvTagCountry, emvCountryNG))
+	b.WriteString(emvTLV(emvTagMerchantName, emvText(merchant.Name, 25)))
+	b.WriteString(emvTLV(emvTagMerchantCity, emvText(s.config.MerchantCity, 15)))
+	b.WriteString(emvTLV(emvTagAdditionalData, emvTLV(emvAdditionalReference, reference)))
+
+	// Sign with the newest rotation of the configured key; verifyPayload
+	// accepts any of them by the key ID carried in the payload
+	keyID, ok := hsm.NewestActiveKey(s.hsm.ListKeys(s.config.SigningKeyID))
+	if !ok {
+		return "", fmt.Errorf("no active QR signing key %s", s.config.SigningKeyID)
+	}
+	signature, err := s.hsm.SignData(keyID, []byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign QR payload: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(signature)
+
+	tag := emvSignatureFirstTag
+	b.WriteString(emvTLV(strconv.Itoa(tag),
+		emvTLV(emvSignatureGUID, s.config.SignatureGUID)+emvTLV(emvSignatureKey, keyID)))
+	for len(encoded) > 0 {
+		tag++
+		if tag > emvSignatureLastTag {
+			return "", fmt.Errorf("QR signature does not fit in templates %d-%d", emvSignatureFirstTag, emvSignatureLastTag)
+		}
+		chunk := encoded[:min(len(encoded), emvSignatureChunkSize)]
+		encoded = encoded[len(chunk):]
+		b.WriteString(emvTLV(strconv.Itoa(tag),
+			emvTLV(emvSignatureGUID, s.config.SignatureGUID)+emvTLV(emvSignatureChunk, chunk)))
+	}
+
+	b.WriteString(emvTagCRC + "04")
+	b.WriteString(emvCRC16(b.String()))
+	return b.String(), nil
+}
+
+// verifyPayload parses a payload and checks its CRC and signature. The
+// signature covers every data object before the first signature template.
+func (s *QRService) verifyPayload(payload string) ([]emvField, error) {
+	fields, err := parseEMVFields(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	last := len(fields) - 1
+	if last < 0 || fields[last].Tag != emvTagCRC || len(fields[last].Value) != 4 {
+		return nil, fmt.Errorf("%w: missing CRC", ErrQRMalformed)
+	}
+	if !strings.EqualFold(emvCRC16(payload[:fields[last].Offset+4]), fields[last].Value) {
+		return nil, ErrQRChecksum
+	}
+
+	signedEnd := -1
+	var keyID string
+	var signature strings.Builder
+	for _, f := range fields[:last] {
+		tag, err := strconv.Atoi(f.Tag)
+		if err != nil || tag < emvSignatureFirstTag || tag > emvSignatureLastTag {
+			continue
+		}
+		sub, err := emvSubFields(f.Value)
+		if err != nil || sub[emvSignatureGUID] != s.config.SignatureGUID {
+			continue
+		}
+		if signedEnd < 0 {
+			signedEnd = f.Offset
+		}
+		if key, ok := sub[emvSignatureKey]; ok {
+			keyID = key
+		}
+		signature.WriteString(sub[emvSignatureChunk])
+	}
+	if signedEnd < 0 || keyID == "" || signature.Len() == 0 {
+		return nil, ErrQRUnsigned
+	}
+	// Accept the configured key and its rotated successors only
+	if keyID != s.config.SigningKeyID && !strings.HasPrefix(keyID, s.config.SigningKeyID+"_") {
+		return nil, ErrQRBadSignature
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature.String())
+	if err != nil {
+		return nil, ErrQRBadSignature
+	}
+	valid, err := s.hsm.VerifySignature(keyID, []byte(payload[:signedEnd]), sig)
+	if err != nil || !valid {
+		return nil, ErrQRBadSignature
+	}
+
+	// Only the signed data objects are trusted from here on
+	var signed []emvField
+	for _, f := range fields {
+		if f.Offset < signedEnd {
+			signed = append(signed, f)
+		}
+	}
+	return signed, nil
+}
+
+func (s *QRService) generateReference() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *QRService) generateTransactionID() string {
 	b := make([]byte, 16)
 	rand.Read(b)
-	return base64.URLEncoding.EncodeToString(b)
+	return fmt.Sprintf("QR-%X-%d", b, time.Now().Unix())
+}
+
+func qrEventsChannel(reference string) string {
+	return fmt.Sprintf("qr:events:%s", reference)
 }
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// signingHSM signs with a fixed signature and verifies it only against the
+// data that was actually signed.
+func signingHSM() *MockHSM {
+	return rotatedSigningHSM("transaction_signing")
+}
+
+// rotatedSigningHSM is signingHSM with activeKey as the only active key
+// rotated from transaction_signing
+func rotatedSigningHSM(activeKey string) *MockHSM {
+	mockHSM := &MockHSM{}
+	signature := bytes.Repeat([]byte{0xA5}, 256)
+	var signed []byte
+
+	keys := []hsm.KeyInfo{{ID: "transaction_signing", IsActive: activeKey == "transaction_signing"}}
+	if activeKey != "transaction_signing" {
+		keys = append(keys, hsm.KeyInfo{ID: activeKey, IsActive: true})
+	}
+	mockHSM.On("ListKeys", "transaction_signing").Return(keys)
+	mockHSM.On("SignData", activeKey, mock.Anything).
+		Run(func(args mock.Arguments) { signed = append([]byte(nil), args.Get(1).([]byte)...) }).
+		Return(signature, nil)
+	mockHSM.On("VerifySignature", activeKey,
+		mock.MatchedBy(func(data []byte) bool { return bytes.Equal(data, signed) }), signature).
+		Return(true, nil)
+	mockHSM.On("VerifySignature", mock.Anything, mock.Anything, mock.Anything).
+		Return(false, nil)
+	return mockHSM
+}
+
+func testMerchant() *qrMerchant {
+	return &qrMerchant{UserID: "7", Name: "Ada Obi", AccountNumber: "0123456789", BankCode: "999999"}
+}
+
+// recomputeCRC replaces the CRC of a payload edited after signing
+func recomputeCRC(payload string) string {
+	body := payload[:len(payload)-4]
+	return body + emvCRC16(body)
+}
+
+func TestEMVCRC16(t *testing.T) {
+	// CRC-16/CCITT-FALSE check value
+	assert.Equal(t, "29B1", emvCRC16("123456789"))
+}
+
+func TestNairaAmount(t *testing.T) {
+	assert.Equal(t, "1500.05", formatNairaAmount(150005))
+	assert.Equal(t, "0.50", formatNairaAmount(50))
+
+	for value, want := range map[string]int64{"1500.05": 150005, "1500": 150000, "1500.5": 150050} {
+		got, err := parseNairaAmount(value)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	for _, value := range []string{"", ".50", "1.234", "-1.00", "abc"} {
+		_, err := parseNairaAmount(value)
+		assert.ErrorIs(t, err, ErrQRMalformed)
+	}
+}
+
+func TestQRService_BuildPayload(t *testing.T) {
+	s := NewQRService(nil, nil, signingHSM())
+
+	t.Run("dynamic", func(t *testing.T) {
+		payload, err := s.buildPayload(testMerchant(), 150000, "ref123")
+		assert.NoError(t, err)
+
+		fields, err := s.verifyPayload(payload)
+		assert.NoError(t, err)
+
+		values := map[string]string{}
+		for _, f := range fields {
+			values[f.Tag] = f.Value
+		}
+		assert.Equal(t, "01", values[emvTagPayloadFormat])
+		assert.Equal(t, emvInitiationDynamic, values[emvTagInitiation])
+		assert.Equal(t, "1500.00", values[emvTagAmount])
+		assert.Equal(t, emvCurrencyNGN, values[emvTagCurrency])
+		assert.Equal(t, "Ada Obi", values[emvTagMerchantName])
+
+		info, err := emvSubFields(values[emvTagMerchantInfo])
+		assert.NoError(t, err)
+		assert.Equal(t, "NG.COM.NIBSS-PLC.QRCODE", info[emvMerchantGUID])
+		assert.Equal(t, "0123456789", info[emvMerchantAccount])
+
+		// Every data object fits the two-digit length field
+		all, err := parseEMVFields(payload)
+		assert.NoError(t, err)
+		for _, f := range all {
+			assert.LessOrEqual(t, len(f.Value), 99, "tag %s", f.Tag)
+		}
+		assert.Equal(t, emvTagCRC, all[len(all)-1].Tag)
+	})
+
+	t.Run("static has no amount", func(t *testing.T) {
+		payload, err := s.buildPayload(testMerchant(), 0, "ref123")
+		assert.NoError(t, err)
+		assert.Contains(t, payload, "010211")
+		assert.NotContains(t, payload, "5407")
+	})
+
+	t.Run("signs with the rotated key", func(t *testing.T) {
+		rotated := NewQRService(nil, nil, rotatedSigningHSM("transaction_signing_1760000000"))
+		payload, err := rotated.buildPayload(testMerchant(), 150000, "ref123")
+		assert.NoError(t, err)
+		assert.Contains(t, payload, emvTLV(emvSignatureKey, "transaction_signing_1760000000"))
+
+		_, err = rotated.verifyPayload(payload)
+		assert.NoError(t, err)
+	})
+}
+
+func TestQRService_GenerateQRCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := NewQRService(db, nil, signingHSM())
+
+	mock.ExpectQuery("SELECT COALESCE\\(u.first_name, ''\\)").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"first_name", "last_name", "account_id", "bank_code"}).
+			AddRow("Ada", "Obi", "0123456789", ""))
+	mock.ExpectExec("INSERT INTO qr_codes").
+		WithArgs(sqlmock.AnyArg(), "7", QRDynamic, int64(150000), QRStatusPending, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	qr, err := s.GenerateQRCode(context.Background(), "7", 150000)
+	assert.NoError(t, err)
+	assert.Equal(t, QRDynamic, qr.Type)
+	assert.Len(t, qr.Reference, 24)
+	assert.NotNil(t, qr.ExpiresAt)
+	assert.Contains(t, qr.QRCode, qr.Reference)
+	assert.NotEmpty(t, qr.QRImage)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func qrCodeRows(userID, qrType string, amount int64, status string, expiresAt any) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user_id", "qr_type", "amount", "status", "expires_at"}).
+		AddRow(userID, qrType, amount, status, expiresAt)
+}
+
+func TestQRService_ProcessQRCode(t *testing.T) {
+	t.Run("dynamic code is not consumed", func(t *testing.T) {
+		db, dbMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		s := NewQRService(db, nil, signingHSM())
+
+		payload, err := s.buildPayload(testMerchant(), 150000, "ref123")
+		assert.NoError(t, err)
+
+		dbMock.ExpectQuery("SELECT user_id::text, qr_type, amount, status, expires_at FROM qr_codes").
+			WithArgs("ref123").
+			WillReturnRows(qrCodeRows("7", QRDynamic, 150000, QRStatusPending, time.Now().Add(time.Minute)))
+
+		result, err := s.ProcessQRCode(context.Background(), payload)
+		assert.NoError(t, err)
+		assert.Equal(t, QRDynamic, result["type"])
+		assert.Equal(t, "7", result["userId"])
+		assert.Equal(t, int64(150000), result["amount"])
+		assert.Equal(t, "ref123", result["reference"])
+		assert.Equal(t, QRStatusPending, result["status"])
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("expired dynamic code", func(t *testing.T) {
+		db, dbMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		s := NewQRService(db, nil, signingHSM())
+
+		payload, err := s.buildPayload(testMerchant(), 150000, "ref123")
+		assert.NoError(t, err)
+
+		dbMock.ExpectQuery("FROM qr_codes").
+			WillReturnRows(qrCodeRows("7", QRDynamic, 150000, QRStatusPending, time.Now().Add(-time.Minute)))
+
+		_, err = s.ProcessQRCode(context.Background(), payload)
+		assert.ErrorIs(t, err, ErrQRExpired)
+	})
+
+	t.Run("static code", func(t *testing.T) {
+		db, dbMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		s := NewQRService(db, nil, signingHSM())
+
+		payload, err := s.buildPayload(testMerchant(), 0, "ref123")
+		assert.NoError(t, err)
+
+		dbMock.ExpectQuery("FROM qr_codes").
+			WithArgs("ref123").
+			WillReturnRows(qrCodeRows("7", QRStatic, 0, QRStatusActive, nil))
+
+		result, err := s.ProcessQRCode(context.Background(), payload)
+		assert.NoError(t, err)
+		assert.Equal(t, QRStatic, result["type"])
+		assert.Equal(t, "7", result["userId"])
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("tampered amount", func(t *testing.T) {
+		s := NewQRService(nil, nil, signingHSM())
+		payload, err := s.buildPayload(testMerchant(), 150000, "ref123")
+		assert.NoError(t, err)
+
+		tampered := recomputeCRC(strings.Replace(payload, "54071500.00", "54071.00000", 1))
+		_, err = s.ProcessQRCode(context.Background(), tampered)
+		assert.ErrorIs(t, err, ErrQRBadSignature)
+	})
+
+	t.Run("bad checksum", func(t *testing.T) {
+		s := NewQRService(nil, nil, signingHSM())
+		payload, err := s.buildPayload(testMerchant(), 150000, "ref123")
+		assert.NoError(t, err)
+
+		_, err = s.ProcessQRCode(context.Background(), strings.Replace(payload, "Ada Obi", "Ada Oba", 1))
+		assert.ErrorIs(t, err, ErrQRChecksum)
+	})
+
+	t.Run("unsigned payload", func(t *testing.T) {
+		s := NewQRService(nil, nil, signingHSM())
+		unsigned := emvTLV(emvTagPayloadFormat, "01") + emvTLV(emvTagInitiation, emvInitiationStatic) + emvTagCRC + "04"
+		unsigned += emvCRC16(unsigned)
+
+		_, err := s.ProcessQRCode(context.Background(), unsigned)
+		assert.ErrorIs(t, err, ErrQRUnsigned)
+	})
+}
+
+func expectQRTransfer(mock sqlmock.Sqlmock, amount int64) {
+	mock.ExpectQuery("SELECT account_id FROM users").WithArgs("9").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow("9000000009"))
+	mock.ExpectQuery("SELECT account_id FROM users").WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow("0123456789"))
+	mock.ExpectExec("INSERT INTO payment_states").WithArgs(sqlmock.AnyArg(), "PENDING", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+		WithArgs("9000000009").
+		WillReturnRows(limitSubjectRows().AddRow("", 0, 1, -1, -1, -1, -1, -1))
+	// Accounts are locked in ID order
+	mock.ExpectQuery("FROM accounts").WithArgs("0123456789").WillReturnRows(ledgerLockRows("acct7", 0, 1))
+	mock.ExpectQuery("FROM accounts").WithArgs("9000000009").WillReturnRows(ledgerLockRows("acct9", 500000, 1))
+	mock.ExpectExec("INSERT INTO ledger_entries").WithArgs(sqlmock.AnyArg(), "acct9", -amount, "DEBIT", 500000-amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO ledger_entries").WithArgs(sqlmock.AnyArg(), "acct7", amount, "CREDIT", amount, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT c.max_balance, a.balance").WithArgs("0123456789").WillReturnRows(sqlmock.NewRows([]string{"max_balance", "balance"}))
+	mock.ExpectExec("INSERT INTO payment_states").WithArgs(sqlmock.AnyArg(), "SUCCESS", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs(sqlmock.AnyArg(), "ref123", "9000000009", "0123456789", amount, sqlmock.AnyArg(), ChannelQR, "9").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestQRService_PayQRCode(t *testing.T) {
+	t.Run("dynamic code is paid once", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		s := NewQRService(db, redisClient, signingHSM())
+
+		payload, err := s.buildPayload(testMerchant(), 150000, "ref123")
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM qr_codes WHERE reference = \\$1 FOR UPDATE").
+			WithArgs("ref123").
+			WillReturnRows(qrCodeRows("7", QRDynamic, 150000, QRStatusPending, time.Now().Add(time.Minute)))
+		expectQRTransfer(mock, 150000)
+		mock.ExpectExec("UPDATE qr_codes SET status").
+			WithArgs(QRStatusPaid, sqlmock.AnyArg(), sqlmock.AnyArg(), "ref123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		redisMock.Regexp().ExpectPublish("qr:events:ref123", "QR-.*").SetVal(1)
+
+		receipt, err := s.PayQRCode(context.Background(), "9", payload, 0, "", models.StepUpProof{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(150000), receipt.Amount)
+		assert.Equal(t, QRStatusPaid, receipt.Status)
+		assert.Equal(t, ChannelQR, receipt.Channel)
+		assert.Equal(t, "****6789", receipt.PayeeAccount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("static code takes the payer's amount", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		s := NewQRService(db, nil, signingHSM())
+
+		payload, err := s.buildPayload(testMerchant(), 0, "ref123")
+		assert.NoError(t, err)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM qr_codes").
+			WillReturnRows(qrCodeRows("7", QRStatic, 0, QRStatusActive, nil))
+		expectQRTransfer(mock, 25000)
+		mock.ExpectCommit()
+
+		receipt, err := s.PayQRCode(context.Background(), "9", payload, 25000, "lunch", models.StepUpProof{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(25000), receipt.Amount)
+		assert.Equal(t, QRStatusActive, receipt.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	rejected := []struct {
+		name    string
+		amount  int64
+		payer   string
+		initial int64
+		record  *sqlmock.Rows
+		want    error
+	}{
+		{"already paid", 0, "9", 150000, qrCodeRows("7", QRDynamic, 150000, QRStatusPaid, time.Now().Add(time.Minute)), ErrQRAlreadyPaid},
+		{"expired", 0, "9", 150000, qrCodeRows("7", QRDynamic, 150000, QRStatusPending, time.Now().Add(-time.Minute)), ErrQRExpired},
+		{"amount mismatch", 100, "9", 150000, qrCodeRows("7", QRDynamic, 150000, QRStatusPending, time.Now().Add(time.Minute)), ErrQRAmountFixed},
+		{"static without amount", 0, "9", 0, qrCodeRows("7", QRStatic, 0, QRStatusActive, nil), ErrQRAmountNeeded},
+		{"owner pays own code", 0, "7", 150000, qrCodeRows("7", QRDynamic, 150000, QRStatusPending, time.Now().Add(time.Minute)), ErrQRSelfPayment},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			s := NewQRService(db, nil, signingHSM())
+
+			payload, err := s.buildPayload(testMerchant(), tt.initial, "ref123")
+			assert.NoError(t, err)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("FROM qr_codes").WillReturnRows(tt.record)
+			mock.ExpectRollback()
+
+			_, err = s.PayQRCode(context.Background(), tt.payer, payload, tt.amount, "", models.StepUpProof{})
+			assert.ErrorIs(t, err, tt.want)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestQRService_CodeStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	s := NewQRService(db, nil, signingHSM())
+
+	paidAt := time.Now()
+	mock.ExpectQuery("FROM qr_codes").
+		WithArgs("ref123", "7").
+		WillReturnRows(qrCodeRows("7", QRDynamic, 150000, QRStatusPaid, paidAt.Add(time.Minute)))
+	mock.ExpectQuery("FROM transactions").
+		WithArgs("ref123", ChannelQR).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "amount", "from_card_id", "created_at"}).
+			AddRow("QR-1", 150000, "9000000009", paidAt))
+
+	status, err := s.CodeStatus(context.Background(), "7", "ref123", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, QRStatusPaid, status.Status)
+	assert.Len(t, status.Payments, 1)
+	assert.Equal(t, "****0009", status.Payments[0].PayerAccount)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
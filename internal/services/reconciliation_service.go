@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ruralpay/backend/internal/hsm"
+)
+
+// Discrepancy kinds reported by the trial balance
+const (
+	DiscrepancyReplayMismatch     = "replay_mismatch"
+	DiscrepancyRunningBalance     = "running_balance_mismatch"
+	DiscrepancyUnbalancedTransfer = "unbalanced_transaction"
+	DiscrepancyEntrySignMismatch  = "entry_sign_mismatch"
+	DiscrepancyUnbalancedLedger   = "unbalanced_ledger"
+)
+
+// LedgerDiscrepancy is one finding of the trial balance. Expected is the
+// figure derived from ledger_entries and Actual the figure it disagrees with.
+type LedgerDiscrepancy struct {
+	Kind          string `json:"kind"`
+	AccountID     string `json:"accountId,omitempty"`
+	TransactionID string `json:"transactionId,omitempty"`
+	Expected      int64  `json:"expected"`
+	Actual        int64  `json:"actual"`
+	Detail        string `json:"detail"`
+}
+
+// TrialBalanceReport summarises a reconciliation run. All amounts are in
+// minor units.
+type TrialBalanceReport struct {
+	GeneratedAt         time.Time           `json:"generatedAt"`
+	AccountsChecked     int                 `json:"accountsChecked"`
+	TransactionsChecked int                 `json:"transactionsChecked"`
+	TotalDebits         int64               `json:"totalDebits"`
+	TotalCredits        int64               `json:"totalCredits"`
+	Balanced            bool                `json:"balanced"`
+	Discrepancies       []LedgerDiscrepancy `json:"discrepancies"`
+}
+
+// ReconciliationService checks ledger_entries against accounts.balance
+// before the end-of-day close.
+type ReconciliationService struct {
+	db    *sql.DB
+	audit *hsm.AuditLogger
+}
+
+func NewReconciliationService(db *sql.DB) *ReconciliationService {
+	return &ReconciliationService{
+		db:    db,
+		audit: hsm.NewAuditLogger(),
+	}
+}
+
+// Run builds a trial balance from a single consistent snapshot of the
+// ledger. For each account it replays ledger_entries and compares the result
+// with accounts.balance and the last entry's running balance, then checks
+// that debits equal credits for every transaction_id.
+func (s *ReconciliationService) Run(ctx context.Context) (*TrialBalanceReport, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &TrialBalanceReport{
+		GeneratedAt:   time.Now().UTC(),
+		Discrepancies: []LedgerDiscrepancy{},
+	}
+
+	if err := s.checkAccounts(ctx, tx, report); err != nil {
+		return nil, fmt.Errorf("failed to check accounts: %w", err)
+	}
+	if err := s.checkTransactions(ctx, tx, report); err != nil {
+		return nil, fmt.Errorf("failed to check transactions: %w", err)
+	}
+
+	if report.TotalDebits != report.TotalCredits {
+		report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{
+			Kind:     DiscrepancyUnbalancedLedger,
+			Expected: report.TotalDebits,
+			Actual:   report.TotalCredits,
+			Detail:   "total debits do not equal total credits",
+		})
+	}
+	report.Balanced = len(report.Discrepancies) == 0
+
+	return report, tx.Commit()
+}
+
+func (s *ReconciliationService) checkAccounts(ctx context.Context, tx *sql.Tx, report *TrialBalanceReport) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT a.id, a.balance, COALESCE(e.replayed, 0), COALESCE(e.entries, 0), last.balance
+		FROM accounts a
+		LEFT JOIN (
+			SELECT account_id, SUM(amount) AS replayed, COUNT(*) AS entries
+			FROM ledger_entries
+			GROUP BY account_id
+		) e ON e.account_id = a.id
+		LEFT JOIN LATERAL (
+			SELECT balance FROM ledger_entries
+			WHERE account_id = a.id
+			ORDER BY id DESC
+			LIMIT 1
+		) last ON true
+		ORDER BY a.id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			accountID        string
+			balance          int64
+			replayed         int64
+			entries          int64
+			lastEntryBalance sql.NullInt64
+		)
+		if err := rows.Scan(&accountID, &balance, &replayed, &entries, &lastEntryBalance); err != nil {
+			return err
+		}
+		report.AccountsChecked++
+
+		if replayed != balance {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{
+				Kind:      DiscrepancyReplayMismatch,
+				AccountID: accountID,
+				Expected:  replayed,
+				Actual:    balance,
+				Detail:    fmt.Sprintf("replay of %d entries does not match accounts.balance", entries),
+			})
+		}
+		if lastEntryBalance.Valid && lastEntryBalance.Int64 != balance {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{
+				Kind:      DiscrepancyRunningBalance,
+				AccountID: accountID,
+				Expected:  lastEntryBalance.Int64,
+				Actual:    balance,
+				Detail:    "last entry running balance does not match accounts.balance",
+			})
+		}
+	}
+	return rows.Err()
+}
+
+func (s *ReconciliationService) checkTransactions(ctx context.Context, tx *sql.Tx, report *TrialBalanceReport) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT transaction_id,
+			COALESCE(SUM(-amount) FILTER (WHERE entry_type = 'DEBIT'), 0),
+			COALESCE(SUM(amount) FILTER (WHERE entry_type = 'CREDIT'), 0),
+			COUNT(*) FILTER (WHERE (entry_type = 'DEBIT' AND amount > 0) OR (entry_type = 'CREDIT' AND amount < 0))
+		FROM ledger_entries
+		GROUP BY transaction_id
+		ORDER BY transaction_id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			transactionID   string
+			debits, credits int64
+			badSigns        int64
+		)
+		if err := rows.Scan(&transactionID, &debits, &credits, &badSigns); err != nil {
+			return err
+		}
+		report.TransactionsChecked++
+		report.TotalDebits += debits
+		report.TotalCredits += credits
+
+		if debits != credits {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{
+				Kind:          DiscrepancyUnbalancedTransfer,
+				TransactionID: transactionID,
+				Expected:      debits,
+				Actual:        credits,
+				Detail:        "debits do not equal credits",
+			})
+		}
+		if badSigns > 0 {
+			report.Discrepancies = append(report.Discrepancies, LedgerDiscrepancy{
+				Kind:          DiscrepancyEntrySignMismatch,
+				TransactionID: transactionID,
+				Detail:        fmt.Sprintf("%d entries have an amount sign that contradicts entry_type", badSigns),
+			})
+		}
+	}
+	return rows.Err()
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *TrialBalanceReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per discrepancy. A balanced ledger produces only
+// the header row.
+func (r *TrialBalanceReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"kind", "account_id", "transaction_id", "expected", "actual", "detail"}); err != nil {
+		return err
+	}
+	for _, d := range r.Discrepancies {
+		if err := cw.Write([]string{
+			d.Kind,
+			d.AccountID,
+			d.TransactionID,
+			strconv.FormatInt(d.Expected, 10),
+			strconv.FormatInt(d.Actual, 10),
+			d.Detail,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// TrialBalance runs the ledger integrity check
+// @Summary Ledger trial balance
+// @Description Replay ledger_entries against accounts.balance and check that debits equal credits per transaction. Admin only.
+// @Tags admin
+// @Produce json
+// @Produce text/csv
+// @Security BearerAuth
+// @Param format query string false "Output format: json (default) or csv"
+// @Success 200 {object} TrialBalanceReport
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/ledger/trial-balance [get]
+func (s *ReconciliationService) TrialBalance(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("userID").(string)
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		SendErrorResponse(w, "format must be json or csv", http.StatusBadRequest, nil)
+		return
+	}
+
+	report, err := s.Run(r.Context())
+	if err != nil {
+		log.Printf("[RECONCILIATION] Trial balance failed: %v", err)
+		s.audit.LogError("", userID, err)
+		SendErrorResponse(w, "Failed to run trial balance", http.StatusInternalServerError, nil)
+		return
+	}
+
+	s.audit.LogOperation("", userID, "LEDGER_TRIAL_BALANCE",
+		fmt.Sprintf("balanced=%t discrepancies=%d", report.Balanced, len(report.Discrepancies)))
+	log.Printf("[RECONCILIATION] Trial balance: %d accounts, %d transactions, %d discrepancies",
+		report.AccountsChecked, report.TransactionsChecked, len(report.Discrepancies))
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"trial-balance-%s.csv\"",
+			report.GeneratedAt.Format("20060102T150405Z")))
+		report.WriteCSV(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	report.WriteJSON(w)
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func expectTrialBalance(mock sqlmock.Sqlmock, accounts, transactions *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT a.id, a.balance").WillReturnRows(accounts)
+	mock.ExpectQuery("SELECT transaction_id").WillReturnRows(transactions)
+	mock.ExpectCommit()
+}
+
+func trialBalanceAccountRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "balance", "replayed", "entries", "last_balance"})
+}
+
+func trialBalanceTransactionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"transaction_id", "debits", "credits", "bad_signs"})
+}
+
+func TestReconciliationService_Run(t *testing.T) {
+	t.Run("balanced ledger", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		expectTrialBalance(mock,
+			trialBalanceAccountRows().
+				AddRow("acct1", 3945, 3945, 2, 3945).
+				AddRow("fees", 55, 55, 1, 55).
+				AddRow("empty", 0, 0, 0, nil),
+			trialBalanceTransactionRows().
+				AddRow("tx1", 1055, 1055, 0))
+
+		report, err := NewReconciliationService(db).Run(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced)
+		assert.Equal(t, 3, report.AccountsChecked)
+		assert.Equal(t, 1, report.TransactionsChecked)
+		assert.Equal(t, int64(1055), report.TotalDebits)
+		assert.Empty(t, report.Discrepancies)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("reports discrepancies", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		expectTrialBalance(mock,
+			trialBalanceAccountRows().
+				AddRow("acct1", 5000, 3945, 2, 3945).
+				AddRow("opening", 10000, 0, 0, nil),
+			trialBalanceTransactionRows().
+				AddRow("tx1", 1055, 1000, 0).
+				AddRow("tx2", 0, 0, 2))
+
+		report, err := NewReconciliationService(db).Run(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, report.Balanced)
+
+		kinds := make(map[string]LedgerDiscrepancy)
+		for _, d := range report.Discrepancies {
+			kinds[d.Kind+":"+d.AccountID+d.TransactionID] = d
+		}
+		assert.Equal(t, int64(3945), kinds["replay_mismatch:acct1"].Expected)
+		assert.Equal(t, int64(5000), kinds["replay_mismatch:acct1"].Actual)
+		assert.Contains(t, kinds, "running_balance_mismatch:acct1")
+		assert.Contains(t, kinds, "replay_mismatch:opening")
+		assert.NotContains(t, kinds, "running_balance_mismatch:opening")
+		assert.Contains(t, kinds, "unbalanced_transaction:tx1")
+		assert.Contains(t, kinds, "entry_sign_mismatch:tx2")
+		assert.Contains(t, kinds, "unbalanced_ledger:")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReconciliationService_TrialBalance(t *testing.T) {
+	t.Run("csv", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		expectTrialBalance(mock,
+			trialBalanceAccountRows().AddRow("acct1", 5000, 3945, 2, 3945),
+			trialBalanceTransactionRows())
+
+		req := withUserID(httptest.NewRequest("GET", "/admin/ledger/trial-balance?format=csv", nil), "1")
+		w := httptest.NewRecorder()
+		NewReconciliationService(db).TrialBalance(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+
+		records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, 3)
+		assert.Equal(t, []string{"kind", "account_id", "transaction_id", "expected", "actual", "detail"}, records[0])
+		assert.Equal(t, []string{"replay_mismatch", "acct1", "", "3945", "5000"}, records[1][:5])
+	})
+
+	t.Run("json", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		expectTrialBalance(mock, trialBalanceAccountRows(), trialBalanceTransactionRows())
+
+		req := withUserID(httptest.NewRequest("GET", "/admin/ledger/trial-balance", nil), "1")
+		w := httptest.NewRecorder()
+		NewReconciliationService(db).TrialBalance(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var report TrialBalanceReport
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.True(t, report.Balanced)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		req := withUserID(httptest.NewRequest("GET", "/admin/ledger/trial-balance?format=xml", nil), "1")
+		w := httptest.NewRecorder()
+		NewReconciliationService(db).TrialBalance(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
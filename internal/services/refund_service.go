@@ -0,0 +1,394 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Fee policies for partial refunds, selected by REFUND_FEE_POLICY. A full
+// reversal always returns the fee because it undoes the payment.
+const (
+	RefundFeeRetain  = "retain"
+	RefundFeeProrate = "prorate"
+)
+
+var (
+	errRefundNotOwned   = errors.New("transaction was not paid to the user's account")
+	errNotRefundable    = errors.New("transaction cannot be refunded")
+	errRefundTooLarge   = errors.New("refund exceeds the refundable amount")
+	errRefundIDConflict = errors.New("refund reference belongs to another transaction")
+)
+
+type ReversalRequest struct {
+	Reason string `json:"reason" validate:"max=200"`
+}
+
+// RefundRequest refunds part of a payment. Amount is in minor units.
+type RefundRequest struct {
+	Amount    int64  `json:"amount" validate:"required,gt=0"`
+	Reason    string `json:"reason" validate:"max=200"`
+	Reference string `json:"reference" validate:"omitempty,max=64"`
+}
+
+// RefundResult describes the linked transaction created by a refund or
+// reversal. Amounts are in minor units.
+type RefundResult struct {
+	TransactionID         string `json:"transactionId"`
+	OriginalTransactionID string `json:"originalTransactionId"`
+	Amount                int64  `json:"amount"`
+	FeeRefunded           int64  `json:"feeRefunded"`
+	Currency              string `json:"currency"`
+	RefundedTotal         int64  `json:"refundedTotal"`
+	OriginalStatus        string `json:"originalStatus"`
+	Status                string `json:"status"`
+}
+
+// paymentReturn is a refund or reversal of originalID posted as returnID
+type paymentReturn struct {
+	originalID string
+	returnID   string
+	amount     int64
+	reversal   bool
+	reason     string
+}
+
+// ReverseTransaction fully reverses a completed payment
+// @Summary Reverse a transaction
+// @Description Fully reverse a completed payment received by the authenticated merchant. Creates a linked refund transaction that posts opposite ledger entries, including the fee, and marks the original REVERSED.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param txId path string true "Transaction ID"
+// @Param reversal body ReversalRequest false "Reversal reason"
+// @Success 201 {object} object{success=bool,refund=RefundResult}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Router /transactions/{txId}/reverse [post]
+func (ts *TransactionService) ReverseTransaction(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req ReversalRequest
+	if !decodeOptionalBody(w, r, &req) {
+		return
+	}
+	if err := ts.validator.ValidateStruct(&req); err != nil {
+		SendErrorResponse(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	txID := chi.URLParam(r, "txId")
+	ts.handlePaymentReturn(w, userID, paymentReturn{
+		originalID: txID,
+		returnID:   txID + "-REV",
+		reversal:   true,
+		reason:     req.Reason,
+	})
+}
+
+// RefundTransaction refunds part of a completed payment
+// @Summary Refund a transaction
+// @Description Refund part of a payment received by the authenticated merchant. Refunds across multiple calls cannot exceed the original amount. The fee is returned according to REFUND_FEE_POLICY.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param txId path string true "Transaction ID"
+// @Param refund body RefundRequest true "Refund details"
+// @Success 201 {object} object{success=bool,refund=RefundResult}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Router /transactions/{txId}/refund [post]
+func (ts *TransactionService) RefundTransaction(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req RefundRequest
+	maxBytes := 1_048_576 // 1 MB
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		SendErrorResponse(w, "Request body must only contain a single JSON object", http.StatusBadRequest, nil)
+		return
+	}
+
+	if err := ts.validator.ValidateStruct(&req); err != nil {
+		SendErrorResponse(w, "Validation failed", http.StatusBadRequest, err)
+		return
+	}
+
+	refundID := req.Reference
+	if refundID == "" {
+		refundID = fmt.Sprintf("RFD-%d", time.Now().UnixNano())
+	}
+
+	ts.handlePaymentReturn(w, userID, paymentReturn{
+		originalID: chi.URLParam(r, "txId"),
+		returnID:   refundID,
+		amount:     req.Amount,
+		reason:     req.Reason,
+	})
+}
+
+func (ts *TransactionService) handlePaymentReturn(w http.ResponseWriter, userID string, ret paymentReturn) {
+	logTag := "[REFUND]"
+	if ret.reversal {
+		logTag = "[REVERSAL]"
+	}
+
+	// A repeated request returns the refund it already created
+	var existingOriginal, existingStatus string
+	err := ts.db.QueryRow(`
+		SELECT COALESCE(original_transaction_id, ''), status FROM transactions WHERE transaction_id = $1
+	`, ret.returnID).Scan(&existingOriginal, &existingStatus)
+	if err == nil {
+		if existingOriginal != ret.originalID {
+			SendErrorResponse(w, errRefundIDConflict.Error(), http.StatusConflict, nil)
+			return
+		}
+		log.Printf("%s Duplicate request for %s, status: %s", logTag, ret.returnID, existingStatus)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"success":       existingStatus == "COMPLETED",
+			"transactionId": ret.returnID,
+			"status":        existingStatus,
+			"message":       "Refund already processed",
+		})
+		return
+	}
+
+	result, err := ts.returnPayment(userID, ret)
+	if err != nil {
+		log.Printf("%s Failed for %s: %v", logTag, ret.originalID, err)
+		ts.audit.LogError(ret.returnID, "", err)
+
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			SendErrorResponse(w, "Transaction not found", http.StatusNotFound, nil)
+		case errors.Is(err, errRefundNotOwned):
+			SendErrorResponse(w, "Unauthorized: Transaction was not paid to your account", http.StatusForbidden, nil)
+		case errors.Is(err, errNotRefundable):
+			SendErrorResponse(w, err.Error(), http.StatusConflict, nil)
+		case errors.Is(err, errRefundTooLarge):
+			SendErrorResponse(w, err.Error(), http.StatusUnprocessableEntity, nil)
+		case strings.Contains(err.Error(), "insufficient balance"):
+			SendErrorResponse(w, "Insufficient balance to refund", http.StatusUnprocessableEntity, nil)
+		default:
+			SendErrorResponse(w, "Failed to process refund", http.StatusInternalServerError, nil)
+		}
+		return
+	}
+
+	ts.setIdempotency(result.TransactionID, result.Status)
+	log.Printf("%s %s returned %d (fee %d) of %s, original now %s",
+		logTag, result.TransactionID, result.Amount, result.FeeRefunded, result.OriginalTransactionID, result.OriginalStatus)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"success": true,
+		"refund":  result,
+	})
+}
+
+// returnPayment posts a refund or reversal of ret.originalID as a linked
+// transaction. The principal is debited from the payee and the payer is
+// credited with it plus the returned share of the fee.
+func (ts *TransactionService) returnPayment(userID string, ret paymentReturn) (*RefundResult, error) {
+	tx, err := ts.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var fromCard, toCard, currency, status string
+	err = tx.QueryRow(`
+		SELECT COALESCE(from_card_id, ''), COALESCE(to_card_id, ''), currency, status
+		FROM transactions
+		WHERE transaction_id = $1 AND type <> 'refund'
+		FOR UPDATE
+	`, ret.originalID).Scan(&fromCard, &toCard, &currency, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ts.verifyAccountOwnership(toCard, userID); err != nil {
+		return nil, fmt.Errorf("%w: %v", errRefundNotOwned, err)
+	}
+
+	switch {
+	case ret.reversal && status != "COMPLETED":
+		return nil, fmt.Errorf("%w: status is %s", errNotRefundable, status)
+	case !ret.reversal && status != "COMPLETED" && status != "PARTIALLY_REFUNDED":
+		return nil, fmt.Errorf("%w: status is %s", errNotRefundable, status)
+	}
+
+	posted, err := ts.ledger.PostedTransferTx(tx, ret.originalID)
+	if errors.Is(err, ErrNothingToReverse) || errors.Is(err, ErrUnsupportedPosting) {
+		return nil, fmt.Errorf("%w: %v", errNotRefundable, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if posted.External {
+		// Funds have left for another bank and must be recalled through the scheme
+		return nil, fmt.Errorf("%w: external transfers cannot be refunded", errNotRefundable)
+	}
+
+	var refunded int64
+	if err := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0)::bigint FROM transactions
+		WHERE original_transaction_id = $1 AND type = 'refund' AND status = 'COMPLETED'
+	`, ret.originalID).Scan(&refunded); err != nil {
+		return nil, err
+	}
+
+	amount := ret.amount
+	if ret.reversal {
+		amount = posted.Amount
+	}
+	if amount > posted.Amount-refunded {
+		return nil, fmt.Errorf("%w: %d already refunded of %d", errRefundTooLarge, refunded, posted.Amount)
+	}
+
+	feeRefunded := ts.refundedFee(posted, refunded, amount, ret.reversal)
+
+	legs := []JournalLeg{
+		{AccountID: posted.Payee, EntryType: "DEBIT", Amount: amount, Currency: currency},
+		{AccountID: posted.Payer, EntryType: "CREDIT", Amount: amount + feeRefunded, Currency: currency},
+	}
+	if feeRefunded > 0 {
+		legs = append(legs, JournalLeg{AccountID: posted.FeeAccount, EntryType: "DEBIT", Amount: feeRefunded, Currency: currency})
+	}
+
+	if err := ts.ledger.appendPaymentState(tx, ret.returnID, "PENDING"); err != nil {
+		return nil, err
+	}
+	if err := ts.ledger.PostJournalTx(tx, ret.returnID, legs); err != nil {
+		return nil, err
+	}
+	if err := ts.ledger.appendPaymentState(tx, ret.returnID, "SUCCESS"); err != nil {
+		return nil, err
+	}
+
+	metadata, _ := json.Marshal(map[string]any{
+		"reason":       ret.reason,
+		"reversal":     ret.reversal,
+		"fee_refunded": feeRefunded,
+	})
+	if _, err := tx.Exec(`
+		INSERT INTO transactions
+		(transaction_id, reference_id, original_transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, user_id, metadata, created_at)
+		VALUES ($1, $2, $2, $3, $4, $5, 0, $6, $7, $8, 'refund', 'COMPLETED', NULLIF($9, '')::integer, $10, NOW())
+	`, ret.returnID, ret.originalID, toCard, fromCard, amount, amount+feeRefunded, currency, ret.reason, userID, metadata); err != nil {
+		return nil, err
+	}
+
+	refunded += amount
+	originalStatus := "PARTIALLY_REFUNDED"
+	switch {
+	case ret.reversal:
+		originalStatus = "REVERSED"
+	case refunded == posted.Amount:
+		originalStatus = "REFUNDED"
+	}
+	if _, err := tx.Exec(`
+		UPDATE transactions SET status = $1, updated_at = NOW() WHERE transaction_id = $2
+	`, originalStatus, ret.originalID); err != nil {
+		return nil, err
+	}
+	if err := ts.ledger.appendPaymentState(tx, ret.originalID, originalStatus); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ts.audit.LogTransfer(ret.returnID, toCard, fromCard, amount, originalStatus)
+	ts.audit.LogOperation(ret.originalID, toCard, originalStatus,
+		fmt.Sprintf("refund=%s amount=%d fee=%d reason=%s", ret.returnID, amount, feeRefunded, ret.reason))
+
+	return &RefundResult{
+		TransactionID:         ret.returnID,
+		OriginalTransactionID: ret.originalID,
+		Amount:                amount,
+		FeeRefunded:           feeRefunded,
+		Currency:              currency,
+		RefundedTotal:         refunded,
+		OriginalStatus:        originalStatus,
+		Status:                "COMPLETED",
+	}, nil
+}
+
+// refundedFee returns the share of the original fee given back with a refund
+// of amount after refunded has already been returned. Prorated shares are
+// computed on the running total so rounding never returns more than the fee.
+func (ts *TransactionService) refundedFee(posted *PostedTransfer, refunded, amount int64, reversal bool) int64 {
+	if posted.Fee == 0 || posted.FeeAccount == "" {
+		return 0
+	}
+	if reversal {
+		return posted.Fee
+	}
+	if ts.refundFeePolicy != RefundFeeProrate {
+		return 0
+	}
+	share := func(total int64) int64 {
+		return posted.Fee * total / posted.Amount
+	}
+	return share(refunded+amount) - share(refunded)
+}
+
+// decodeOptionalBody decodes a JSON body into v, accepting an empty body.
+func decodeOptionalBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	maxBytes := 1_048_576 // 1 MB
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return true
+		}
+		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return false
+	}
+
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		SendErrorResponse(w, "Request body must only contain a single JSON object", http.StatusBadRequest, nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func refundRouter(service *TransactionService) *chi.Mux {
+	r := chi.NewRouter()
+	r.Post("/transactions/{txId}/reverse", service.ReverseTransaction)
+	r.Post("/transactions/{txId}/refund", service.RefundTransaction)
+	return r
+}
+
+func expectRefundablePayment(mock sqlmock.Sqlmock, status string, refunded int64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COALESCE\\(from_card_id, ''\\), COALESCE\\(to_card_id, ''\\), currency, status").
+		WithArgs("tx1").
+		WillReturnRows(sqlmock.NewRows([]string{"from_card_id", "to_card_id", "currency", "status"}).
+			AddRow("card123", "merchant456", "NGN", status))
+	mock.ExpectQuery("SELECT user_id").
+		WithArgs("merchant456").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery("SELECT le.account_id, le.amount").
+		WithArgs("tx1", "0000000001", "0000000003").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "amount", "is_fee", "is_suspense"}).
+			AddRow("payer", -10100, false, false).
+			AddRow("merchant", 10000, false, false).
+			AddRow("fees", 100, true, false))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\)::bigint FROM transactions").
+		WithArgs("tx1").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(refunded))
+}
+
+func TestTransactionService_RefundTransaction(t *testing.T) {
+	t.Run("partial refund with prorated fee", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+		service.refundFeePolicy = RefundFeeProrate
+
+		mock.ExpectQuery("SELECT COALESCE\\(original_transaction_id, ''\\), status FROM transactions").
+			WithArgs("RFD-1").
+			WillReturnRows(sqlmock.NewRows([]string{"original_transaction_id", "status"}))
+		expectRefundablePayment(mock, "COMPLETED", 2500)
+
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("RFD-1", "PENDING", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		for _, id := range []string{"fees", "merchant", "payer"} {
+			mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs(id).WillReturnRows(ledgerLockRows(id, 20000, 1))
+		}
+		// 4000 refunded after 2500: fee share is 100*6500/10000 - 100*2500/10000 = 40
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("RFD-1", "merchant", int64(-4000), "DEBIT", int64(16000), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("RFD-1", "payer", int64(4040), "CREDIT", int64(24040), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("RFD-1", "fees", int64(-40), "DEBIT", int64(19960), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE accounts").WithArgs(int64(19960), sqlmock.AnyArg(), "fees", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE accounts").WithArgs(int64(16000), sqlmock.AnyArg(), "merchant", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE accounts").WithArgs(int64(24040), sqlmock.AnyArg(), "payer", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("RFD-1", "SUCCESS", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO transactions").
+			WithArgs("RFD-1", "tx1", "merchant456", "card123", int64(4000), int64(4040), "NGN", "damaged goods", "1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("PARTIALLY_REFUNDED", "tx1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("tx1", "PARTIALLY_REFUNDED", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		redisMock.ExpectSetEX("idempotency:RFD-1", "COMPLETED", 24*time.Hour).SetVal("OK")
+
+		body := []byte(`{"amount":4000,"reason":"damaged goods","reference":"RFD-1"}`)
+		req := withUserID(httptest.NewRequest("POST", "/transactions/tx1/refund", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+		refundRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"refundedTotal":6500`)
+		assert.Contains(t, w.Body.String(), `"feeRefunded":40`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("cannot refund more than the original", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+
+		mock.ExpectQuery("SELECT COALESCE\\(original_transaction_id, ''\\), status FROM transactions").
+			WithArgs("RFD-2").
+			WillReturnRows(sqlmock.NewRows([]string{"original_transaction_id", "status"}))
+		expectRefundablePayment(mock, "PARTIALLY_REFUNDED", 7000)
+		mock.ExpectRollback()
+
+		body := []byte(`{"amount":3001,"reference":"RFD-2"}`)
+		req := withUserID(httptest.NewRequest("POST", "/transactions/tx1/refund", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+		refundRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("not the payee", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+
+		mock.ExpectQuery("SELECT COALESCE\\(original_transaction_id, ''\\), status FROM transactions").
+			WithArgs("RFD-3").
+			WillReturnRows(sqlmock.NewRows([]string{"original_transaction_id", "status"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT COALESCE\\(from_card_id, ''\\), COALESCE\\(to_card_id, ''\\), currency, status").
+			WithArgs("tx1").
+			WillReturnRows(sqlmock.NewRows([]string{"from_card_id", "to_card_id", "currency", "status"}).
+				AddRow("card123", "merchant456", "NGN", "COMPLETED"))
+		mock.ExpectQuery("SELECT user_id").
+			WithArgs("merchant456").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+		mock.ExpectRollback()
+
+		body := []byte(`{"amount":100,"reference":"RFD-3"}`)
+		req := withUserID(httptest.NewRequest("POST", "/transactions/tx1/refund", bytes.NewBuffer(body)), "1")
+		w := httptest.NewRecorder()
+		refundRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestTransactionService_ReverseTransaction(t *testing.T) {
+	t.Run("full reversal returns the fee", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+
+		mock.ExpectQuery("SELECT COALESCE\\(original_transaction_id, ''\\), status FROM transactions").
+			WithArgs("tx1-REV").
+			WillReturnRows(sqlmock.NewRows([]string{"original_transaction_id", "status"}))
+		expectRefundablePayment(mock, "COMPLETED", 0)
+
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("tx1-REV", "PENDING", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		for _, id := range []string{"fees", "merchant", "payer"} {
+			mock.ExpectQuery("SELECT id, balance, version, updated_at").WithArgs(id).WillReturnRows(ledgerLockRows(id, 20000, 1))
+		}
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1-REV", "merchant", int64(-10000), "DEBIT", int64(10000), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1-REV", "payer", int64(10100), "CREDIT", int64(30100), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("tx1-REV", "fees", int64(-100), "DEBIT", int64(19900), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		for range 3 {
+			mock.ExpectExec("UPDATE accounts").WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("tx1-REV", "SUCCESS", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("REVERSED", "tx1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO payment_states").WithArgs("tx1", "REVERSED", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		redisMock.ExpectSetEX("idempotency:tx1-REV", "COMPLETED", 24*time.Hour).SetVal("OK")
+
+		req := withUserID(httptest.NewRequest("POST", "/transactions/tx1/reverse", nil), "1")
+		w := httptest.NewRecorder()
+		refundRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"originalStatus":"REVERSED"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already reversed", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+
+		mock.ExpectQuery("SELECT COALESCE\\(original_transaction_id, ''\\), status FROM transactions").
+			WithArgs("tx1-REV").
+			WillReturnRows(sqlmock.NewRows([]string{"original_transaction_id", "status"}).AddRow("tx1", "COMPLETED"))
+
+		req := withUserID(httptest.NewRequest("POST", "/transactions/tx1/reverse", nil), "1")
+		w := httptest.NewRecorder()
+		refundRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Refund already processed")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("external transfer", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+
+		mock.ExpectQuery("SELECT COALESCE\\(original_transaction_id, ''\\), status FROM transactions").
+			WithArgs("tx1-REV").
+			WillReturnRows(sqlmock.NewRows([]string{"original_transaction_id", "status"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT COALESCE\\(from_card_id, ''\\), COALESCE\\(to_card_id, ''\\), currency, status").
+			WithArgs("tx1").
+			WillReturnRows(sqlmock.NewRows([]string{"from_card_id", "to_card_id", "currency", "status"}).
+				AddRow("card123", "merchant456", "NGN", "COMPLETED"))
+		mock.ExpectQuery("SELECT user_id").
+			WithArgs("merchant456").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+		mock.ExpectQuery("SELECT le.account_id, le.amount").
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "amount", "is_fee", "is_suspense"}).
+				AddRow("payer", -1050, false, false).
+				AddRow("suspense", 1000, false, true).
+				AddRow("fees", 50, true, false))
+		mock.ExpectRollback()
+
+		req := withUserID(httptest.NewRequest("POST", "/transactions/tx1/reverse", nil), "1")
+		w := httptest.NewRecorder()
+		refundRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestTransactionService_RefundedFee(t *testing.T) {
+	posted := &PostedTransfer{Amount: 999, Fee: 10, FeeAccount: "fees"}
+	service := &TransactionService{refundFeePolicy: RefundFeeProrate}
+
+	// Shares computed on the running total always add up to the fee
+	assert.Equal(t, int64(10), service.refundedFee(posted, 0, 999, false))
+	assert.Equal(t, int64(10), service.refundedFee(posted, 0, 333, false)+service.refundedFee(posted, 333, 333, false)+service.refundedFee(posted, 666, 333, false))
+
+	service.refundFeePolicy = RefundFeeRetain
+	assert.Equal(t, int64(0), service.refundedFee(posted, 0, 500, false))
+	assert.Equal(t, int64(10), service.refundedFee(posted, 0, 999, true))
+}
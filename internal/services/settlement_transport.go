@@ -0,0 +1,289 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SettlementAckStatus is the outcome reported by the settlement system.
+type SettlementAckStatus string
+
+const (
+	SettlementAccepted SettlementAckStatus = "ACCEPTED"
+	SettlementRejected SettlementAckStatus = "REJECTED"
+)
+
+// SettlementAck is the acknowledgement returned for a delivered document.
+// A rejection is a final answer from the counterparty; transport failures
+// are returned as errors instead and may be retried.
+type SettlementAck struct {
+	Status     SettlementAckStatus `json:"status"`
+	ReasonCode string              `json:"reasonCode,omitempty"`
+	Reason     string              `json:"reason,omitempty"`
+	Reference  string              `json:"reference,omitempty"`
+}
+
+func (a *SettlementAck) Accepted() bool {
+	return a != nil && a.Status == SettlementAccepted
+}
+
+// SettlementTransport delivers ISO 20022 documents to the settlement system.
+type SettlementTransport interface {
+	Send(ctx context.Context, doc any) (*SettlementAck, error)
+}
+
+// NewSettlementTransportFromEnv builds the transport selected by
+// SETTLEMENT_TRANSPORT: "http", "file", "memory" or "log" (default).
+func NewSettlementTransportFromEnv() (SettlementTransport, error) {
+	switch os.Getenv("SETTLEMENT_TRANSPORT") {
+	case "", "log":
+		return NewLogSettlementTransport(), nil
+	case "memory":
+		return NewInMemorySettlementTransport(), nil
+	case "file":
+		dir := os.Getenv("SETTLEMENT_DROP_DIR")
+		if dir == "" {
+			return nil, errors.New("SETTLEMENT_DROP_DIR is required for file transport")
+		}
+		return NewFileSettlementTransport(dir)
+	case "http":
+		timeout := 30 * time.Second
+		if envTimeout := os.Getenv("SETTLEMENT_HTTP_TIMEOUT"); envTimeout != "" {
+			if val, err := time.ParseDuration(envTimeout); err == nil && val > 0 {
+				timeout = val
+			}
+		}
+		return NewHTTPSettlementTransport(HTTPSettlementConfig{
+			URL:      os.Getenv("SETTLEMENT_HTTP_URL"),
+			CertFile: os.Getenv("SETTLEMENT_TLS_CERT"),
+			KeyFile:  os.Getenv("SETTLEMENT_TLS_KEY"),
+			CAFile:   os.Getenv("SETTLEMENT_TLS_CA"),
+			Timeout:  timeout,
+		})
+	default:
+		return nil, fmt.Errorf("unknown settlement transport %q", os.Getenv("SETTLEMENT_TRANSPORT"))
+	}
+}
+
+func marshalSettlementXML(doc any) ([]byte, error) {
+	xmlData, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal XML: %w", err)
+	}
+	return append([]byte(xml.Header), xmlData...), nil
+}
+
+// LogSettlementTransport writes settlement documents to the application log
+// and accepts them. It stands in for a real scheme connection in development.
+type LogSettlementTransport struct{}
+
+func NewLogSettlementTransport() *LogSettlementTransport {
+	return &LogSettlementTransport{}
+}
+
+func (t *LogSettlementTransport) Send(ctx context.Context, doc any) (*SettlementAck, error) {
+	xmlData, err := marshalSettlementXML(doc)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[SETTLEMENT] Sending to settlement: %s", xmlData)
+	return &SettlementAck{Status: SettlementAccepted}, nil
+}
+
+// HTTPSettlementConfig configures the mutual TLS settlement endpoint.
+type HTTPSettlementConfig struct {
+	URL      string
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	Timeout  time.Duration
+}
+
+// HTTPSettlementTransport POSTs documents to the settlement endpoint over
+// mutual TLS. 2xx responses are acceptances, 4xx responses are rejections
+// and anything else is treated as a retryable transport failure.
+type HTTPSettlementTransport struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSettlementTransport(cfg HTTPSettlementConfig) (*HTTPSettlementTransport, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("settlement URL is required for http transport")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("CA bundle contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &HTTPSettlementTransport{
+		url: cfg.URL,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func (t *HTTPSettlementTransport) Send(ctx context.Context, doc any) (*SettlementAck, error) {
+	xmlData, err := marshalSettlementXML(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(xmlData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("settlement request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1_048_576))
+
+	var ack SettlementAck
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &ack); err != nil && resp.StatusCode < 300 {
+			return nil, fmt.Errorf("invalid settlement acknowledgement: %w", err)
+		}
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if ack.Status == "" {
+			ack.Status = SettlementAccepted
+		}
+		return &ack, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		ack.Status = SettlementRejected
+		if ack.ReasonCode == "" {
+			ack.ReasonCode = fmt.Sprintf("HTTP_%d", resp.StatusCode)
+		}
+		return &ack, nil
+	default:
+		return nil, fmt.Errorf("settlement endpoint returned %d", resp.StatusCode)
+	}
+}
+
+// FileSettlementTransport drops each document into a directory collected
+// by an SFTP/batch job. Files are written under a temporary name and renamed
+// so the collector never sees a partial document.
+type FileSettlementTransport struct {
+	dir string
+}
+
+func NewFileSettlementTransport(dir string) (*FileSettlementTransport, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create drop directory: %w", err)
+	}
+	return &FileSettlementTransport{dir: dir}, nil
+}
+
+func (t *FileSettlementTransport) Send(ctx context.Context, doc any) (*SettlementAck, error) {
+	xmlData, err := marshalSettlementXML(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s_%s.xml", time.Now().UTC().Format("20060102T150405"), uuid.New().String())
+	tmpPath := filepath.Join(t.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmpPath, xmlData, 0o640); err != nil {
+		return nil, fmt.Errorf("failed to write settlement file: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(t.dir, name)); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to publish settlement file: %w", err)
+	}
+
+	return &SettlementAck{Status: SettlementAccepted, Reference: name}, nil
+}
+
+// InMemorySettlementTransport records documents and replies with a
+// configurable acknowledgement. It is intended for tests and local runs.
+type InMemorySettlementTransport struct {
+	mu   sync.Mutex
+	docs []any
+	ack  *SettlementAck
+	err  error
+}
+
+func NewInMemorySettlementTransport() *InMemorySettlementTransport {
+	return &InMemorySettlementTransport{ack: &SettlementAck{Status: SettlementAccepted}}
+}
+
+// Respond sets the acknowledgement and error returned by subsequent sends.
+func (t *InMemorySettlementTransport) Respond(ack *SettlementAck, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ack = ack
+	t.err = err
+}
+
+// Sent returns the documents delivered so far.
+func (t *InMemorySettlementTransport) Sent() []any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]any(nil), t.docs...)
+}
+
+func (t *InMemorySettlementTransport) Send(ctx context.Context, doc any) (*SettlementAck, error) {
+	if _, err := marshalSettlementXML(doc); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return nil, t.err
+	}
+	t.docs = append(t.docs, doc)
+	ack := *t.ack
+	return &ack, nil
+}
+
+// unavailableSettlementTransport fails every send; it is used when the
+// configured transport cannot be built so payments are never acknowledged
+// by accident.
+type unavailableSettlementTransport struct {
+	err error
+}
+
+func (t *unavailableSettlementTransport) Send(ctx context.Context, doc any) (*SettlementAck, error) {
+	return nil, fmt.Errorf("settlement transport unavailable: %w", t.err)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ruralpay/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func samplePacs008(t *testing.T) any {
+	doc, err := NewISO20022ServiceWithTransport(NewInMemorySettlementTransport()).CreatePacs008(&models.Transaction{
+		TransactionID: "tx123",
+		ReferenceID:   "ref123",
+		Amount:        100.50,
+		Currency:      "NGN",
+	})
+	assert.NoError(t, err)
+	return doc
+}
+
+func TestHTTPSettlementTransport_Send(t *testing.T) {
+	t.Run("accepted", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, "application/xml", r.Header.Get("Content-Type"))
+			assert.Contains(t, string(body), "tx123")
+			w.Write([]byte(`{"status":"ACCEPTED","reference":"SW-1"}`))
+		}))
+		defer server.Close()
+
+		transport, err := NewHTTPSettlementTransport(HTTPSettlementConfig{URL: server.URL})
+		assert.NoError(t, err)
+
+		ack, err := transport.Send(context.Background(), samplePacs008(t))
+		assert.NoError(t, err)
+		assert.True(t, ack.Accepted())
+		assert.Equal(t, "SW-1", ack.Reference)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"reasonCode":"AC04","reason":"Closed account"}`))
+		}))
+		defer server.Close()
+
+		transport, err := NewHTTPSettlementTransport(HTTPSettlementConfig{URL: server.URL})
+		assert.NoError(t, err)
+
+		ack, err := transport.Send(context.Background(), samplePacs008(t))
+		assert.NoError(t, err)
+		assert.False(t, ack.Accepted())
+		assert.Equal(t, SettlementRejected, ack.Status)
+		assert.Equal(t, "AC04", ack.ReasonCode)
+	})
+
+	t.Run("server error is retryable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		transport, err := NewHTTPSettlementTransport(HTTPSettlementConfig{URL: server.URL})
+		assert.NoError(t, err)
+
+		ack, err := transport.Send(context.Background(), samplePacs008(t))
+		assert.Error(t, err)
+		assert.Nil(t, ack)
+	})
+
+	t.Run("missing URL", func(t *testing.T) {
+		_, err := NewHTTPSettlementTransport(HTTPSettlementConfig{})
+		assert.Error(t, err)
+	})
+}
+
+func TestFileSettlementTransport_Send(t *testing.T) {
+	dir := t.TempDir()
+	transport, err := NewFileSettlementTransport(dir)
+	assert.NoError(t, err)
+
+	ack, err := transport.Send(context.Background(), samplePacs008(t))
+	assert.NoError(t, err)
+	assert.True(t, ack.Accepted())
+
+	data, err := os.ReadFile(filepath.Join(dir, ack.Reference))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "<?xml"))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
+
+func TestNewSettlementTransportFromEnv(t *testing.T) {
+	t.Setenv("SETTLEMENT_TRANSPORT", "memory")
+	transport, err := NewSettlementTransportFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &InMemorySettlementTransport{}, transport)
+
+	t.Setenv("SETTLEMENT_TRANSPORT", "file")
+	t.Setenv("SETTLEMENT_DROP_DIR", "")
+	_, err = NewSettlementTransportFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("SETTLEMENT_TRANSPORT", "carrier-pigeon")
+	_, err = NewSettlementTransportFromEnv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,452 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/models"
+)
+
+const (
+	settlementQueueKey      = "settlement_queue"
+	settlementProcessingKey = "settlement_processing"
+	settlementRetryKey      = "settlement_retry"
+	settlementDeadLetterKey = "settlement_dead_letter"
+	settlementWorkersKey    = "settlement_workers"
+	settlementLeasePrefix   = "settlement_lease:"
+
+	// settlementLeaseTTL is how long a worker that stops renewing its lease
+	// keeps its in-flight jobs before another worker requeues them
+	settlementLeaseTTL = 30 * time.Second
+)
+
+// StatusSettlementDeadLetter marks a transaction whose funds have moved but
+// which could not be settled. It deliberately does not start with FAILED,
+// which card sync reads as nothing having been posted.
+const StatusSettlementDeadLetter = "SETTLEMENT_DEAD_LETTER"
+
+// settlementJob is the payload held on the settlement queues. It embeds the
+// queued Transaction so entries pushed by queueForSettlement decode directly.
+type settlementJob struct {
+	Transaction
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// settlementRejectedError reports a document the settlement system refused.
+type settlementRejectedError struct {
+	ack *SettlementAck
+}
+
+func (e *settlementRejectedError) Error() string {
+	return fmt.Sprintf("settlement rejected: %s %s", e.ack.ReasonCode, e.ack.Reason)
+}
+
+// SettlementWorker drains settlement_queue, converts each transaction to a
+// pacs.008 document and hands it to the settlement transport. Failed
+// deliveries are retried with exponential backoff and dead-lettered once
+// the retry budget is spent.
+//
+// Each worker claims jobs into its own processing list and holds a lease
+// while it runs. Only the jobs of a worker whose lease has lapsed are put
+// back on the queue, so replicas never re-send each other's jobs.
+type SettlementWorker struct {
+	db            *sql.DB
+	redis         *redis.Client
+	iso           *ISO20022Service
+	transport     SettlementTransport
+	audit         *hsm.AuditLogger
+	instanceID    string
+	processingKey string
+	maxRetries    int
+	baseBackoff   time.Duration
+	maxBackoff    time.Duration
+	pollInterval  time.Duration
+	leaseTTL      time.Duration
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+func NewSettlementWorker(db *sql.DB, redis *redis.Client, iso *ISO20022Service, transport SettlementTransport) *SettlementWorker {
+	maxRetries := 5
+	if envMaxRetries := os.Getenv("SETTLEMENT_MAX_RETRIES"); envMaxRetries != "" {
+		if val, err := strconv.Atoi(envMaxRetries); err == nil && val >= 0 {
+			maxRetries = val
+		}
+	}
+	baseBackoff := 2 * time.Second
+	if envBackoff := os.Getenv("SETTLEMENT_RETRY_BASE_DELAY"); envBackoff != "" {
+		if val, err := time.ParseDuration(envBackoff); err == nil && val > 0 {
+			baseBackoff = val
+		}
+	}
+	instanceID := settlementInstanceID()
+	return &SettlementWorker{
+		db:            db,
+		redis:         redis,
+		iso:           iso,
+		transport:     transport,
+		audit:         hsm.NewAuditLogger(),
+		instanceID:    instanceID,
+		processingKey: settlementProcessingKey + ":" + instanceID,
+		maxRetries:    maxRetries,
+		baseBackoff:   baseBackoff,
+		maxBackoff:    5 * time.Minute,
+		pollInterval:  2 * time.Second,
+		leaseTTL:      settlementLeaseTTL,
+	}
+}
+
+// Start takes the worker's lease, recovers jobs left in flight by workers
+// that have gone away and launches the queue consumer, retry scheduler and
+// lease heartbeat.
+func (sw *SettlementWorker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sw.cancel = cancel
+
+	sw.renewLease(ctx)
+	sw.recoverInFlight(ctx)
+
+	sw.wg.Add(3)
+	go sw.consume(ctx)
+	go sw.promoteRetries(ctx)
+	go sw.heartbeat(ctx)
+
+	log.Printf("[SETTLEMENT] Worker %s started", sw.instanceID)
+}
+
+// Stop signals the worker to finish, waits for the in-flight job and
+// releases the lease.
+func (sw *SettlementWorker) Stop() {
+	if sw.cancel == nil {
+		return
+	}
+	sw.cancel()
+	sw.wg.Wait()
+	sw.redis.Del(context.Background(), settlementLeasePrefix+sw.instanceID)
+	log.Println("[SETTLEMENT] Worker stopped")
+}
+
+func (sw *SettlementWorker) renewLease(ctx context.Context) {
+	pipe := sw.redis.TxPipeline()
+	pipe.Set(ctx, settlementLeasePrefix+sw.instanceID, time.Now().Unix(), sw.leaseTTL)
+	pipe.SAdd(ctx, settlementWorkersKey, sw.instanceID)
+	if _, err := pipe.Exec(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("[SETTLEMENT] Failed to renew worker lease: %v", err)
+	}
+}
+
+// heartbeat renews the lease well within its TTL and picks up the jobs of
+// workers that stopped renewing theirs.
+func (sw *SettlementWorker) heartbeat(ctx context.Context) {
+	defer sw.wg.Done()
+
+	ticker := time.NewTicker(sw.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sw.renewLease(ctx)
+			sw.recoverInFlight(ctx)
+		}
+	}
+}
+
+// recoverInFlight requeues the processing lists of registered workers whose
+// lease has lapsed. LMOVE hands each job to exactly one recovering worker.
+func (sw *SettlementWorker) recoverInFlight(ctx context.Context) {
+	workers, err := sw.redis.SMembers(ctx, settlementWorkersKey).Result()
+	if err != nil {
+		log.Printf("[SETTLEMENT] Failed to list settlement workers: %v", err)
+		return
+	}
+
+	for _, id := range workers {
+		if id == sw.instanceID {
+			continue
+		}
+		alive, err := sw.redis.Exists(ctx, settlementLeasePrefix+id).Result()
+		if err != nil {
+			log.Printf("[SETTLEMENT] Failed to check lease of worker %s: %v", id, err)
+			return
+		}
+		if alive > 0 {
+			continue
+		}
+
+		recovered := 0
+		for {
+			_, err := sw.redis.LMove(ctx, settlementProcessingKey+":"+id, settlementQueueKey, "RIGHT", "LEFT").Result()
+			if err == redis.Nil {
+				break
+			}
+			if err != nil {
+				log.Printf("[SETTLEMENT] Failed to recover in-flight jobs of worker %s: %v", id, err)
+				return
+			}
+			recovered++
+		}
+		sw.redis.SRem(ctx, settlementWorkersKey, id)
+		if recovered > 0 {
+			log.Printf("[SETTLEMENT] Requeued %d in-flight jobs from worker %s", recovered, id)
+		}
+	}
+}
+
+func (sw *SettlementWorker) consume(ctx context.Context) {
+	defer sw.wg.Done()
+
+	for ctx.Err() == nil {
+		payload, err := sw.redis.BLMove(ctx, settlementQueueKey, sw.processingKey, "LEFT", "RIGHT", sw.pollInterval).Result()
+		if err != nil {
+			if err != redis.Nil && ctx.Err() == nil {
+				log.Printf("[SETTLEMENT] Failed to read settlement queue: %v", err)
+				sleepContext(ctx, sw.pollInterval)
+			}
+			continue
+		}
+
+		// The job is already claimed; finish it even if shutdown has begun
+		sw.processJob(context.Background(), payload)
+	}
+}
+
+func (sw *SettlementWorker) processJob(ctx context.Context, payload string) {
+	defer sw.redis.LRem(ctx, sw.processingKey, 1, payload)
+
+	var job settlementJob
+	if err := json.Unmarshal([]byte(payload), &job); err != nil || job.TxID == "" {
+		log.Printf("[SETTLEMENT] Dropping malformed job to dead letter: %v", err)
+		sw.redis.RPush(ctx, settlementDeadLetterKey, payload)
+		return
+	}
+
+	err := sw.settle(ctx, &job.Transaction)
+	if err == nil {
+		return
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+	sw.audit.LogError(job.TxID, job.CardID, err)
+
+	// A rejection is final, so only transport failures are retried
+	var rejected *settlementRejectedError
+	if errors.As(err, &rejected) || job.Attempts > sw.maxRetries {
+		sw.deadLetter(ctx, &job)
+		return
+	}
+
+	delay := sw.backoff(job.Attempts)
+	log.Printf("[SETTLEMENT] Transaction %s failed (attempt %d), retrying in %s: %v", job.TxID, job.Attempts, delay, err)
+
+	data, _ := json.Marshal(job)
+	if err := sw.redis.ZAdd(ctx, settlementRetryKey, &redis.Z{
+		Score:  float64(time.Now().Add(delay).Unix()),
+		Member: string(data),
+	}).Err(); err != nil {
+		log.Printf("[SETTLEMENT] Failed to schedule retry for %s: %v", job.TxID, err)
+		sw.redis.RPush(ctx, settlementDeadLetterKey, string(data))
+	}
+}
+
+func (sw *SettlementWorker) settle(ctx context.Context, tx *Transaction) error {
+	var settledAt sql.NullTime
+	err := sw.db.QueryRowContext(ctx, `SELECT settled_at FROM transactions WHERE transaction_id = $1`, tx.TxID).Scan(&settledAt)
+	if err != nil {
+		return fmt.Errorf("failed to load transaction: %w", err)
+	}
+	if settledAt.Valid {
+		log.Printf("[SETTLEMENT] Transaction %s already settled, skipping", tx.TxID)
+		return nil
+	}
+
+	doc, err := sw.iso.CreatePacs008(settlementModel(tx))
+	if err != nil {
+		return fmt.Errorf("failed to build pacs.008: %w", err)
+	}
+
+	ack, err := sw.transport.Send(ctx, doc)
+	if err != nil {
+		return fmt.Errorf("settlement delivery failed: %w", err)
+	}
+	if !ack.Accepted() {
+		return &settlementRejectedError{ack: ack}
+	}
+
+	_, err = sw.db.ExecContext(ctx, `
+		UPDATE transactions SET status = $1, settled_at = NOW(), updated_at = NOW()
+		WHERE transaction_id = $2
+	`, "COMPLETED", tx.TxID)
+	if err != nil {
+		return fmt.Errorf("failed to mark transaction settled: %w", err)
+	}
+
+	sw.audit.LogTransfer(tx.TxID, tx.CardID, tx.MerchantID, tx.Amount, "SETTLED")
+	log.Printf("[SETTLEMENT] Transaction %s settled", tx.TxID)
+	return nil
+}
+
+func (sw *SettlementWorker) deadLetter(ctx context.Context, job *settlementJob) {
+	data, _ := json.Marshal(job)
+	if err := sw.redis.RPush(ctx, settlementDeadLetterKey, string(data)).Err(); err != nil {
+		log.Printf("[SETTLEMENT] Failed to dead-letter %s: %v", job.TxID, err)
+	}
+
+	if _, err := sw.db.ExecContext(ctx, `
+		UPDATE transactions SET status = $1, updated_at = NOW() WHERE transaction_id = $2
+	`, StatusSettlementDeadLetter, job.TxID); err != nil {
+		log.Printf("[SETTLEMENT] Failed to mark %s as dead-lettered: %v", job.TxID, err)
+	}
+
+	sw.audit.LogOperation(job.TxID, job.CardID, "SETTLEMENT_DEAD_LETTER",
+		fmt.Sprintf("attempts=%d error=%s", job.Attempts, job.LastError))
+	log.Printf("[SETTLEMENT] Transaction %s moved to dead letter after %d attempts", job.TxID, job.Attempts)
+}
+
+// RedriveDeadLetters puts dead-lettered jobs that failed on delivery back on
+// the queue with a fresh retry budget. Jobs the settlement system rejected
+// stay dead-lettered for manual review.
+func (sw *SettlementWorker) RedriveDeadLetters(ctx context.Context) (int, error) {
+	pending, err := sw.redis.LLen(ctx, settlementDeadLetterKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	redriven := 0
+	for i := int64(0); i < pending; i++ {
+		payload, err := sw.redis.LPop(ctx, settlementDeadLetterKey).Result()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return redriven, err
+		}
+
+		var job settlementJob
+		if err := json.Unmarshal([]byte(payload), &job); err != nil {
+			log.Printf("[SETTLEMENT] Dropping undecodable dead letter: %v", err)
+			continue
+		}
+
+		target := settlementDeadLetterKey
+		if strings.HasPrefix(job.LastError, "settlement delivery failed") {
+			target = settlementQueueKey
+			job.Attempts, job.LastError = 0, ""
+		}
+
+		data, _ := json.Marshal(job)
+		if err := sw.redis.RPush(ctx, target, string(data)).Err(); err != nil {
+			return redriven, fmt.Errorf("failed to requeue %s: %w", job.TxID, err)
+		}
+		if target == settlementQueueKey {
+			redriven++
+			sw.audit.LogOperation(job.TxID, job.CardID, "SETTLEMENT_REDRIVE", "requeued from dead letter")
+		}
+	}
+
+	if redriven > 0 {
+		log.Printf("[SETTLEMENT] Redrove %d dead-lettered transactions", redriven)
+	}
+	return redriven, nil
+}
+
+// promoteRetries moves retry jobs whose backoff has elapsed back onto the
+// main queue.
+func (sw *SettlementWorker) promoteRetries(ctx context.Context) {
+	defer sw.wg.Done()
+
+	ticker := time.NewTicker(sw.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sw.promoteDue(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("[SETTLEMENT] Failed to promote retries: %v", err)
+			}
+		}
+	}
+}
+
+func (sw *SettlementWorker) promoteDue(ctx context.Context) error {
+	due, err := sw.redis.ZRangeByScore(ctx, settlementRetryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, payload := range due {
+		// Only the instance that removes the member re-queues it
+		removed, err := sw.redis.ZRem(ctx, settlementRetryKey, payload).Result()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			continue
+		}
+		if err := sw.redis.RPush(ctx, settlementQueueKey, payload).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sw *SettlementWorker) backoff(attempt int) time.Duration {
+	delay := sw.baseBackoff << (attempt - 1)
+	if delay <= 0 || delay > sw.maxBackoff {
+		return sw.maxBackoff
+	}
+	return delay
+}
+
+func settlementModel(tx *Transaction) *models.Transaction {
+	return &models.Transaction{
+		TransactionID: tx.TxID,
+		ReferenceID:   tx.TxID,
+		FromCardID:    tx.CardID,
+		ToCardID:      tx.MerchantID,
+		Amount:        float64(tx.Amount) / 100, // Convert from cents
+		Currency:      tx.Currency,
+		Status:        tx.Status,
+	}
+}
+
+// settlementInstanceID names this worker's lease and processing list. The
+// random suffix keeps restarts on the same host from reusing a lease.
+func settlementInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubSettlementTransport struct {
+	ack  *SettlementAck
+	err  error
+	sent int
+}
+
+func (t *stubSettlementTransport) Send(ctx context.Context, doc any) (*SettlementAck, error) {
+	t.sent++
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.ack != nil {
+		return t.ack, nil
+	}
+	return &SettlementAck{Status: SettlementAccepted}, nil
+}
+
+func queuedTransaction() (Transaction, string) {
+	tx := Transaction{
+		Version:    1,
+		TxID:       "tx-settle-1",
+		Timestamp:  time.Now().Unix(),
+		CardID:     "card123",
+		MerchantID: "merchant456",
+		Amount:     10000,
+		Currency:   "NGN",
+		Counter:    1,
+		TxType:     "DEBIT",
+		Status:     "COMPLETED",
+	}
+	data, _ := json.Marshal(tx)
+	return tx, string(data)
+}
+
+func TestSettlementWorker_ProcessJob(t *testing.T) {
+	t.Run("delivers and marks settled", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		transport := &stubSettlementTransport{}
+		worker := NewSettlementWorker(db, redisClient, NewISO20022Service(), transport)
+		_, payload := queuedTransaction()
+
+		mock.ExpectQuery("SELECT settled_at FROM transactions").
+			WithArgs("tx-settle-1").
+			WillReturnRows(sqlmock.NewRows([]string{"settled_at"}).AddRow(nil))
+		mock.ExpectExec("UPDATE transactions SET status").
+			WithArgs("COMPLETED", "tx-settle-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		redisMock.ExpectLRem(worker.processingKey, 1, payload).SetVal(1)
+
+		worker.processJob(context.Background(), payload)
+
+		assert.Equal(t, 1, transport.sent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("already settled is skipped", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		transport := &stubSettlementTransport{}
+		worker := NewSettlementWorker(db, redisClient, NewISO20022Service(), transport)
+		_, payload := queuedTransaction()
+
+		mock.ExpectQuery("SELECT settled_at FROM transactions").
+			WithArgs("tx-settle-1").
+			WillReturnRows(sqlmock.NewRows([]string{"settled_at"}).AddRow(time.Now()))
+		redisMock.ExpectLRem(worker.processingKey, 1, payload).SetVal(1)
+
+		worker.processJob(context.Background(), payload)
+
+		assert.Equal(t, 0, transport.sent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("dead letters after retries are exhausted", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		transport := &stubSettlementTransport{err: errors.New("connection refused")}
+		worker := NewSettlementWorker(db, redisClient, NewISO20022Service(), transport)
+		worker.maxRetries = 0
+		tx, payload := queuedTransaction()
+
+		dead, _ := json.Marshal(settlementJob{
+			Transaction: tx,
+			Attempts:    1,
+			LastError:   "settlement delivery failed: connection refused",
+		})
+
+		mock.ExpectQuery("SELECT settled_at FROM transactions").
+			WithArgs("tx-settle-1").
+			WillReturnRows(sqlmock.NewRows([]string{"settled_at"}).AddRow(nil))
+		redisMock.ExpectRPush(settlementDeadLetterKey, string(dead)).SetVal(1)
+		mock.ExpectExec("UPDATE transactions SET status").
+			WithArgs(StatusSettlementDeadLetter, "tx-settle-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		redisMock.ExpectLRem(worker.processingKey, 1, payload).SetVal(1)
+
+		worker.processJob(context.Background(), payload)
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("rejection is not retried", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		transport := &stubSettlementTransport{ack: &SettlementAck{Status: SettlementRejected, ReasonCode: "AC01", Reason: "Incorrect account"}}
+		worker := NewSettlementWorker(db, redisClient, NewISO20022Service(), transport)
+		tx, payload := queuedTransaction()
+
+		dead, _ := json.Marshal(settlementJob{
+			Transaction: tx,
+			Attempts:    1,
+			LastError:   "settlement rejected: AC01 Incorrect account",
+		})
+
+		mock.ExpectQuery("SELECT settled_at FROM transactions").
+			WithArgs("tx-settle-1").
+			WillReturnRows(sqlmock.NewRows([]string{"settled_at"}).AddRow(nil))
+		redisMock.ExpectRPush(settlementDeadLetterKey, string(dead)).SetVal(1)
+		mock.ExpectExec("UPDATE transactions SET status").
+			WithArgs(StatusSettlementDeadLetter, "tx-settle-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		redisMock.ExpectLRem(worker.processingKey, 1, payload).SetVal(1)
+
+		worker.processJob(context.Background(), payload)
+
+		assert.Equal(t, 1, transport.sent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("malformed payload is dead lettered", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		worker := NewSettlementWorker(db, redisClient, NewISO20022Service(), &stubSettlementTransport{})
+
+		redisMock.ExpectRPush(settlementDeadLetterKey, "not-json").SetVal(1)
+		redisMock.ExpectLRem(worker.processingKey, 1, "not-json").SetVal(1)
+
+		worker.processJob(context.Background(), "not-json")
+
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
+
+func TestSettlementWorker_RecoverInFlight(t *testing.T) {
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	redisClient, redisMock := redismock.NewClientMock()
+
+	worker := NewSettlementWorker(db, redisClient, NewISO20022Service(), &stubSettlementTransport{})
+	_, payload := queuedTransaction()
+
+	redisMock.ExpectSMembers(settlementWorkersKey).SetVal([]string{worker.instanceID, "live-1", "dead-1"})
+	redisMock.ExpectExists(settlementLeasePrefix + "live-1").SetVal(1)
+	redisMock.ExpectExists(settlementLeasePrefix + "dead-1").SetVal(0)
+	redisMock.ExpectLMove(settlementProcessingKey+":dead-1", settlementQueueKey, "RIGHT", "LEFT").SetVal(payload)
+	redisMock.ExpectLMove(settlementProcessingKey+":dead-1", settlementQueueKey, "RIGHT", "LEFT").RedisNil()
+	redisMock.ExpectSRem(settlementWorkersKey, "dead-1").SetVal(1)
+
+	worker.recoverInFlight(context.Background())
+
+	// Neither this worker's nor the live worker's list is touched
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestSettlementWorker_Backoff(t *testing.T) {
+	worker := &SettlementWorker{baseBackoff: time.Second, maxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, worker.backoff(1))
+	assert.Equal(t, 2*time.Second, worker.backoff(2))
+	assert.Equal(t, 8*time.Second, worker.backoff(4))
+	assert.Equal(t, 10*time.Second, worker.backoff(5))
+	assert.Equal(t, 10*time.Second, worker.backoff(70))
+}
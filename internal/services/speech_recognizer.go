@@ -0,0 +1,349 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	speech "cloud.google.com/go/speech/apiv1"
+	"cloud.google.com/go/speech/apiv1/speechpb"
+	"github.com/ruralpay/backend/internal/config"
+)
+
+// Speech engines that can be named in VOICE_SPEECH_BACKEND and routes
+const (
+	SpeechEngineGoogle  = "google"
+	SpeechEngineLocal   = "local"
+	SpeechEngineFixture = "fixture"
+)
+
+var ErrSpeechNoFixture = errors.New("no fixture transcript for audio")
+
+// SpeechAudio is decoded audio in the format the client recorded it
+type SpeechAudio struct {
+	Content      []byte
+	Encoding     string
+	SampleRate   int
+	LanguageCode string
+}
+
+// SpeechRecognizer turns recorded speech into text. Confidence is between 0
+// and 1, or 0 when the engine does not report one.
+type SpeechRecognizer interface {
+	Recognize(ctx context.Context, audio SpeechAudio) (transcript string, confidence float32, err error)
+	Close() error
+}
+
+// NewSpeechRecognizer builds the engines named in cfg and routes each
+// request to one by language. A Google engine that cannot be created, for
+// want of credentials, is replaced by the fixture engine.
+func NewSpeechRecognizer(cfg *config.VoiceConfig) (SpeechRecognizer, error) {
+	router := &speechRouter{engines: make(map[string]SpeechRecognizer), routes: cfg.SpeechRoutes, fallback: cfg.SpeechBackend}
+
+	names := []string{cfg.SpeechBackend}
+	for _, engine := range cfg.SpeechRoutes {
+		names = append(names, engine)
+	}
+	for _, name := range names {
+		if _, ok := router.engines[name]; ok {
+			continue
+		}
+		engine, err := newSpeechEngine(name, cfg)
+		if err != nil {
+			router.Close()
+			return nil, err
+		}
+		router.engines[name] = engine
+	}
+	return router, nil
+}
+
+func newSpeechEngine(name string, cfg *config.VoiceConfig) (SpeechRecognizer, error) {
+	switch name {
+	case SpeechEngineGoogle:
+		client, err := speech.NewClient(context.Background())
+		if err != nil {
+			log.Printf("Warning: Failed to initialize speech client, using fixture transcripts: %v", err)
+			return NewFixtureRecognizer(cfg.FixtureFile, cfg.FixtureDefault)
+		}
+		return &googleRecognizer{client: client}, nil
+	case SpeechEngineLocal:
+		return NewLocalRecognizer(cfg.LocalEngineURL, cfg.LocalEngineTimeout), nil
+	case SpeechEngineFixture:
+		return NewFixtureRecognizer(cfg.FixtureFile, cfg.FixtureDefault)
+	}
+	return nil, fmt.Errorf("unknown speech engine: %s", name)
+}
+
+// speechRouter sends each request to the engine routed for its language,
+// trying the full code (en-NG) before the base language (en).
+type speechRouter struct {
+	engines  map[string]SpeechRecognizer
+	routes   map[string]string
+	fallback string
+}
+
+func (r *speechRouter) Recognize(ctx context.Context, audio SpeechAudio) (string, float32, error) {
+	return r.engines[r.engineFor(audio.LanguageCode)].Recognize(ctx, audio)
+}
+
+func (r *speechRouter) engineFor(languageCode string) string {
+	lang := strings.ToLower(languageCode)
+	if engine, ok := r.routes[lang]; ok {
+		return engine
+	}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		if engine, ok := r.routes[base]; ok {
+			return engine
+		}
+	}
+	return r.fallback
+}
+
+func (r *speechRouter) Close() error {
+	var errs []error
+	for _, engine := range r.engines {
+		if err := engine.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// googleRecognizer uses Google Cloud Speech-to-Text
+type googleRecognizer struct {
+	client *speech.Client
+}
+
+func (g *googleRecognizer) Recognize(ctx context.Context, audio SpeechAudio) (string, float32, error) {
+	encoding, err := parseEncoding(audio.Encoding)
+	if err != nil {
+		return "", 0, err
+	}
+
+	speechReq := &speechpb.RecognizeRequest{
+		Config: &speechpb.RecognitionConfig{
+			Encoding:                   encoding,
+			SampleRateHertz:            int32(audio.SampleRate),
+			LanguageCode:               audio.LanguageCode,
+			EnableAutomaticPunctuation: true,
+			Model:                      "latest_long",
+			UseEnhanced:                true,
+		},
+		Audio: &speechpb.RecognitionAudio{
+			AudioSource: &speechpb.RecognitionAudio_Content{
+				Content: audio.Content,
+			},
+		},
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := g.client.Recognize(timeoutCtx, speechReq)
+	if err != nil {
+		return "", 0, fmt.Errorf("recognition failed: %w", err)
+	}
+
+	if len(resp.Results) == 0 {
+		return "", 0, errors.New("no transcription results")
+	}
+
+	var transcript strings.Builder
+	var totalConfidence float32
+	var count int
+
+	for _, result := range resp.Results {
+		if len(result.Alternatives) > 0 {
+			alternative := result.Alternatives[0]
+			transcript.WriteString(alternative.Transcript)
+			transcript.WriteString(" ")
+			totalConfidence += alternative.Confidence
+			count++
+		}
+	}
+
+	if count == 0 {
+		return "", 0, errors.New("no alternatives in results")
+	}
+
+	avgConfidence := totalConfidence / float32(count)
+	finalTranscript := strings.TrimSpace(transcript.String())
+	return finalTranscript, avgConfidence, nil
+}
+
+func (g *googleRecognizer) Close() error {
+	return g.client.Close()
+}
+
+func parseEncoding(encoding string) (speechpb.RecognitionConfig_AudioEncoding, error) {
+	switch strings.ToUpper(encoding) {
+	case "LINEAR16":
+		return speechpb.RecognitionConfig_LINEAR16, nil
+	case "FLAC":
+		return speechpb.RecognitionConfig_FLAC, nil
+	case "MULAW":
+		return speechpb.RecognitionConfig_MULAW, nil
+	case "AMR":
+		return speechpb.RecognitionConfig_AMR, nil
+	case "AMR_WB":
+		return speechpb.RecognitionConfig_AMR_WB, nil
+	case "OGG_OPUS":
+		return speechpb.RecognitionConfig_OGG_OPUS, nil
+	case "SPEEX_WITH_HEADER_BYTE":
+		return speechpb.RecognitionConfig_SPEEX_WITH_HEADER_BYTE, nil
+	case "WEBM_OPUS":
+		return speechpb.RecognitionConfig_WEBM_OPUS, nil
+	default:
+		return speechpb.RecognitionConfig_ENCODING_UNSPECIFIED, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// LocalRecognizer posts audio to a self-hosted engine speaking the
+// whisper.cpp server protocol: a multipart "file" upload answered with
+// {"text": "..."}. Vosk can be run behind the same interface.
+type LocalRecognizer struct {
+	url    string
+	client *http.Client
+}
+
+func NewLocalRecognizer(url string, timeout time.Duration) *LocalRecognizer {
+	return &LocalRecognizer{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (l *LocalRecognizer) Recognize(ctx context.Context, audio SpeechAudio) (string, float32, error) {
+	content := audio.Content
+	if strings.EqualFold(audio.Encoding, "LINEAR16") && !bytes.HasPrefix(content, []byte("RIFF")) {
+		content = wavFile(content, audio.SampleRate)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := part.Write(content); err != nil {
+		return "", 0, err
+	}
+	// The engines take ISO 639-1 codes, so en-NG is sent as en
+	lang, _, _ := strings.Cut(strings.ToLower(audio.LanguageCode), "-")
+	form.WriteField("language", lang)
+	form.WriteField("response_format", "json")
+	if err := form.Close(); err != nil {
+		return "", 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url, &body)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("recognition failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", 0, fmt.Errorf("recognition failed: engine returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, fmt.Errorf("recognition failed: %w", err)
+	}
+	transcript := strings.TrimSpace(result.Text)
+	if transcript == "" {
+		return "", 0, errors.New("no transcription results")
+	}
+	return transcript, 0, nil
+}
+
+func (l *LocalRecognizer) Close() error {
+	return nil
+}
+
+// wavFile wraps 16-bit mono PCM in a WAV header
+func wavFile(pcm []byte, sampleRate int) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(pcm)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&b, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate*2))
+	binary.Write(&b, binary.LittleEndian, uint16(2))
+	binary.Write(&b, binary.LittleEndian, uint16(16))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(pcm)))
+	b.Write(pcm)
+	return b.Bytes()
+}
+
+// FixtureRecognizer returns canned transcripts keyed by the hex SHA-256 of
+// the audio, so voice flows can run without a speech engine. Audio with no
+// fixture gets the default transcript, or ErrSpeechNoFixture if none is set.
+type FixtureRecognizer struct {
+	fixtures map[string]string
+	fallback string
+}
+
+// NewFixtureRecognizer loads fixtures from a JSON object of audio hash to
+// transcript. An empty path starts with no fixtures.
+func NewFixtureRecognizer(path, fallback string) (*FixtureRecognizer, error) {
+	f := &FixtureRecognizer{fixtures: make(map[string]string), fallback: fallback}
+	if path == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speech fixtures: %w", err)
+	}
+	if err := json.Unmarshal(data, &f.fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse speech fixtures: %w", err)
+	}
+	return f, nil
+}
+
+// Add registers transcript for audio
+func (f *FixtureRecognizer) Add(audio []byte, transcript string) {
+	f.fixtures[audioFingerprint(audio)] = transcript
+}
+
+func (f *FixtureRecognizer) Recognize(ctx context.Context, audio SpeechAudio) (string, float32, error) {
+	if transcript, ok := f.fixtures[audioFingerprint(audio.Content)]; ok {
+		return transcript, 1, nil
+	}
+	if f.fallback != "" {
+		return f.fallback, 1, nil
+	}
+	return "", 0, ErrSpeechNoFixture
+}
+
+func (f *FixtureRecognizer) Close() error {
+	return nil
+}
+
+func audioFingerprint(audio []byte) string {
+	sum := sha256.Sum256(audio)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeechRouter(t *testing.T) {
+	google, _ := NewFixtureRecognizer("", "from google")
+	local, _ := NewFixtureRecognizer("", "from local")
+	router := &speechRouter{
+		engines:  map[string]SpeechRecognizer{SpeechEngineGoogle: google, SpeechEngineLocal: local},
+		routes:   map[string]string{"yo": SpeechEngineLocal, "ha-ng": SpeechEngineLocal},
+		fallback: SpeechEngineGoogle,
+	}
+
+	for lang, want := range map[string]string{
+		"en-NG": "from google",
+		"yo":    "from local",
+		"yo-NG": "from local",
+		"ha-NG": "from local",
+		"ha":    "from google",
+		"":      "from google",
+	} {
+		transcript, _, err := router.Recognize(context.Background(), SpeechAudio{Content: []byte("x"), LanguageCode: lang})
+		assert.NoError(t, err)
+		assert.Equal(t, want, transcript, lang)
+	}
+}
+
+func TestNewSpeechRecognizer(t *testing.T) {
+	cfg := &config.VoiceConfig{SpeechBackend: SpeechEngineFixture, SpeechRoutes: map[string]string{"yo": SpeechEngineLocal}}
+	recognizer, err := NewSpeechRecognizer(cfg)
+	assert.NoError(t, err)
+	router := recognizer.(*speechRouter)
+	assert.IsType(t, &FixtureRecognizer{}, router.engines[SpeechEngineFixture])
+	assert.IsType(t, &LocalRecognizer{}, router.engines[SpeechEngineLocal])
+
+	cfg.SpeechRoutes["ig"] = "cloud"
+	_, err = NewSpeechRecognizer(cfg)
+	assert.ErrorContains(t, err, "unknown speech engine")
+}
+
+func TestFixtureRecognizer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	fixtures, _ := json.Marshal(map[string]string{audioFingerprint([]byte("balance.wav")): "Check my balance"})
+	assert.NoError(t, os.WriteFile(path, fixtures, 0o600))
+
+	f, err := NewFixtureRecognizer(path, "")
+	assert.NoError(t, err)
+	f.Add([]byte("block.wav"), "Block my card")
+
+	transcript, _, err := f.Recognize(context.Background(), SpeechAudio{Content: []byte("balance.wav")})
+	assert.NoError(t, err)
+	assert.Equal(t, "Check my balance", transcript)
+
+	transcript, _, err = f.Recognize(context.Background(), SpeechAudio{Content: []byte("block.wav")})
+	assert.NoError(t, err)
+	assert.Equal(t, "Block my card", transcript)
+
+	_, _, err = f.Recognize(context.Background(), SpeechAudio{Content: []byte("unknown.wav")})
+	assert.ErrorIs(t, err, ErrSpeechNoFixture)
+}
+
+func TestLocalRecognizer(t *testing.T) {
+	var gotLang string
+	var gotAudio []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		assert.NoError(t, err)
+		gotAudio = make([]byte, 64)
+		n, _ := file.Read(gotAudio)
+		gotAudio = gotAudio[:n]
+		gotLang = r.FormValue("language")
+		w.Write([]byte(`{"text":" Wetin remain for my account \n"}`))
+	}))
+	defer server.Close()
+
+	l := NewLocalRecognizer(server.URL, 5*time.Second)
+	pcm := []byte{1, 2, 3, 4}
+	transcript, _, err := l.Recognize(context.Background(), SpeechAudio{Content: pcm, Encoding: "LINEAR16", SampleRate: 16000, LanguageCode: "yo-NG"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Wetin remain for my account", transcript)
+	assert.Equal(t, "yo", gotLang)
+	// Raw PCM is sent as a WAV file
+	assert.True(t, bytes.HasPrefix(gotAudio, []byte("RIFF")))
+	assert.True(t, bytes.HasSuffix(gotAudio, pcm))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	_, _, err = NewLocalRecognizer(failing.URL, 5*time.Second).Recognize(context.Background(), SpeechAudio{Content: pcm})
+	assert.ErrorContains(t, err, "model not loaded")
+}
+
+func TestVoiceBankingService_VoiceCommandWithFixtureAudio(t *testing.T) {
+	s, mock, _, _ := newTestVoiceService(t)
+	fixtures, _ := NewFixtureRecognizer("", "")
+	fixtures.Add([]byte("statement.wav"), "Show my last transactions")
+	s.recognizer = fixtures
+	s.accounts = &TransactionService{db: s.db}
+
+	expectVoiceProfile(mock, true, "")
+	mock.ExpectQuery("SELECT transaction_id, from_card_id").
+		WithArgs("7", voiceTransactionsCount).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "from_card_id", "to_card_id", "amount", "currency", "counter", "timestamp", "signature", "type", "status", "created_at"}).
+			AddRow("TX1", "0123456789", "9000000009", "150000", "NGN", 0, 0, "", "DEBIT", "COMPLETED", time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)))
+
+	body := `{"audio":"` + base64.StdEncoding.EncodeToString([]byte("statement.wav")) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/voice/command", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), "userID", "7"))
+	rr := httptest.NewRecorder()
+	s.VoiceCommand(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp VoiceCommandResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, VoiceIntentTransactions, resp.Command.Intent)
+	assert.Equal(t, "Your last transaction: NGN 1500.00 to ****0009 on 14/03.", resp.Reply)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/moov-io/iso20022/pkg/pacs_v08"
+)
+
+// Outcomes reported per transaction by ProcessStatusReport
+const (
+	StatusReportApplied          = "applied"
+	StatusReportIgnored          = "ignored"
+	StatusReportNotFound         = "not_found"
+	StatusReportReversalRequired = "manual_reversal_required"
+	StatusReportError            = "error"
+)
+
+// StatusReportResult describes how one TxInfAndSts entry was handled
+type StatusReportResult struct {
+	TransactionID string `json:"transactionId"`
+	ReportStatus  string `json:"reportStatus"`
+	Status        string `json:"status,omitempty"`
+	Outcome       string `json:"outcome"`
+	ReasonCode    string `json:"reasonCode,omitempty"`
+}
+
+// pacs002Document is the ISO 20022 envelope around a pacs.002 report
+type pacs002Document struct {
+	XMLName xml.Name                              `xml:"Document"`
+	Report  pacs_v08.FIToFIPaymentStatusReportV08 `xml:"FIToFIPmtStsRpt"`
+}
+
+// ProcessStatusReport ingests an inbound pacs.002 status report
+// @Summary Ingest pacs.002 status report
+// @Description Apply an inbound pacs.002 payment status report to pending external transfers. ACSC/ACCP complete the transfer; RJCT fails it and reverses the debit and fee.
+// @Tags iso20022
+// @Accept xml
+// @Produce json
+// @Param X-Settlement-Signature header string true "Hex HMAC-SHA256 of the request body"
+// @Success 200 {object} object{messageId=string,results=[]StatusReportResult}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /iso20022/status-report [post]
+func (ts *TransactionService) ProcessStatusReport(w http.ResponseWriter, r *http.Request) {
+	if ts.inboundSecret == "" {
+		log.Printf("[STATUS_REPORT] SETTLEMENT_INBOUND_SECRET is not configured")
+		SendErrorResponse(w, "Status report ingestion is not configured", http.StatusServiceUnavailable, nil)
+		return
+	}
+
+	maxBytes := 1_048_576 // 1 MB
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest, nil)
+		return
+	}
+
+	if !ts.verifyStatusReportSignature(body, r.Header.Get("X-Settlement-Signature")) {
+		log.Printf("[STATUS_REPORT] Rejected report with invalid signature from %s", r.RemoteAddr)
+		SendErrorResponse(w, "Invalid signature", http.StatusUnauthorized, nil)
+		return
+	}
+
+	report, err := parsePacs002(body)
+	if err != nil {
+		log.Printf("[STATUS_REPORT] Failed to parse pacs.002: %v", err)
+		SendErrorResponse(w, "Invalid pacs.002 document", http.StatusBadRequest, nil)
+		return
+	}
+
+	msgID := string(report.GrpHdr.MsgId)
+	log.Printf("[STATUS_REPORT] Processing pacs.002 %s with %d entries", msgID, len(report.TxInfAndSts))
+
+	results := make([]StatusReportResult, 0, len(report.TxInfAndSts))
+	for _, info := range report.TxInfAndSts {
+		results = append(results, ts.applyPaymentStatus(info))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"messageId": msgID,
+		"results":   results,
+	})
+}
+
+func (ts *TransactionService) applyPaymentStatus(info pacs_v08.PaymentTransaction80) StatusReportResult {
+	result := StatusReportResult{Outcome: StatusReportIgnored}
+	if info.OrgnlTxId != nil {
+		result.TransactionID = string(*info.OrgnlTxId)
+	}
+	if info.TxSts != nil {
+		result.ReportStatus = string(*info.TxSts)
+	}
+	result.ReasonCode = statusReasonCode(info)
+
+	if result.TransactionID == "" || result.ReportStatus == "" {
+		return result
+	}
+
+	var err error
+	switch result.ReportStatus {
+	case "ACSC", "ACCP":
+		result.Status, result.Outcome, err = ts.completeExternalTransfer(result.TransactionID, result.ReportStatus == "ACSC")
+	case "RJCT":
+		result.Status, result.Outcome, err = ts.rejectExternalTransfer(result.TransactionID, result.ReasonCode, "FAILED")
+	default:
+		return result
+	}
+
+	if err != nil {
+		log.Printf("[STATUS_REPORT] Failed to apply %s to %s: %v", result.ReportStatus, result.TransactionID, err)
+		ts.audit.LogError(result.TransactionID, "", err)
+		result.Outcome = StatusReportError
+	}
+	return result
+}
+
+func (ts *TransactionService) completeExternalTransfer(txID string, settled bool) (string, string, error) {
+	tx, err := ts.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	status, fromAccount, err := lockOutboundTransfer(tx, txID)
+	if err == sql.ErrNoRows {
+		return "", StatusReportNotFound, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	// A late ACSC may follow an earlier ACCP
+	if status != "PENDING" && status != "PROCESSING" && !(settled && status == "COMPLETED") {
+		return status, StatusReportIgnored, nil
+	}
+
+	query := `UPDATE transactions SET status = $1, updated_at = NOW() WHERE transaction_id = $2`
+	if settled {
+		query = `UPDATE transactions SET status = $1, settled_at = COALESCE(settled_at, NOW()), updated_at = NOW() WHERE transaction_id = $2`
+	}
+	if _, err := tx.Exec(query, "COMPLETED", txID); err != nil {
+		return "", "", err
+	}
+
+	if status != "COMPLETED" {
+		if err := ts.ledger.appendPaymentState(tx, txID, "SUCCESS"); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	ts.setIdempotency(txID, "COMPLETED")
+	ts.audit.LogOperation(txID, fromAccount, "EXTERNAL_TRANSFER_COMPLETED", fmt.Sprintf("settled=%t", settled))
+	log.Printf("[STATUS_REPORT] Transaction %s completed", txID)
+	return "COMPLETED", StatusReportApplied, nil
+}
+
+// rejectExternalTransfer fails an outbound transfer still pending at
+// settlement with failedStatus and reverses its ledger postings, returning
+// the customer's debit and fee. Transfers in any other state are ignored.
+func (ts *TransactionService) rejectExternalTransfer(txID, reasonCode, failedStatus string) (string, string, error) {
+	tx, err := ts.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	status, fromAccount, err := lockOutboundTransfer(tx, txID)
+	if err == sql.ErrNoRows {
+		return "", StatusReportNotFound, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if status != "PENDING" && status != "PROCESSING" {
+		return status, StatusReportIgnored, nil
+	}
+
+	outcome := StatusReportApplied
+	reversalID := txID + "-REV"
+	err = ts.ledger.ReverseTx(tx, txID, reversalID)
+	switch {
+	case errors.Is(err, ErrNothingToReverse):
+		// Nothing was posted to the ledger for this transfer
+		outcome = StatusReportReversalRequired
+	case err != nil:
+		return "", "", fmt.Errorf("failed to reverse transfer: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE transactions SET status = $1, updated_at = NOW() WHERE transaction_id = $2
+	`, failedStatus, txID); err != nil {
+		return "", "", err
+	}
+
+	if outcome == StatusReportApplied {
+		if err := ts.ledger.appendPaymentState(tx, txID, "REVERSED"); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	ts.setIdempotency(txID, failedStatus)
+	if outcome == StatusReportReversalRequired {
+		ts.audit.LogOperation(txID, fromAccount, "REVERSAL_REQUIRED", fmt.Sprintf("rejected with %s but no ledger entries found", reasonCode))
+		log.Printf("[STATUS_REPORT] Transaction %s rejected (%s); manual reversal required", txID, reasonCode)
+	} else {
+		ts.audit.LogOperation(txID, fromAccount, "EXTERNAL_TRANSFER_REVERSED", fmt.Sprintf("reason=%s reversal=%s", reasonCode, reversalID))
+		log.Printf("[STATUS_REPORT] Transaction %s rejected (%s) and reversed", txID, reasonCode)
+	}
+	return failedStatus, outcome, nil
+}
+
+// lockOutboundTransfer locks an outbound external transfer and returns its
+// status and payer. Any other transaction is reported as sql.ErrNoRows, so
+// a status report can never complete or reverse an internal payment.
+func lockOutboundTransfer(tx *sql.Tx, txID string) (string, string, error) {
+	var status, fromAccount string
+	err := tx.QueryRow(`
+		SELECT status, from_card_id FROM transactions
+		WHERE transaction_id = $1 AND channel = $2
+		FOR UPDATE
+	`, txID, ChannelExternalTransfer).Scan(&status, &fromAccount)
+	return status, fromAccount, err
+}
+
+func (ts *TransactionService) verifyStatusReportSignature(body []byte, signature string) bool {
+	expected, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(ts.inboundSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// parsePacs002 accepts either a full <Document> envelope or a bare
+// <FIToFIPmtStsRpt> element.
+func parsePacs002(body []byte) (*pacs_v08.FIToFIPaymentStatusReportV08, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "Document":
+			var doc pacs002Document
+			if err := dec.DecodeElement(&doc, &start); err != nil {
+				return nil, err
+			}
+			return &doc.Report, nil
+		case "FIToFIPmtStsRpt":
+			var report pacs_v08.FIToFIPaymentStatusReportV08
+			if err := dec.DecodeElement(&report, &start); err != nil {
+				return nil, err
+			}
+			return &report, nil
+		default:
+			return nil, fmt.Errorf("unexpected root element %s", start.Name.Local)
+		}
+	}
+}
+
+func statusReasonCode(info pacs_v08.PaymentTransaction80) string {
+	for _, reason := range info.StsRsnInf {
+		if reason.Rsn == nil {
+			continue
+		}
+		if reason.Rsn.Cd != nil {
+			return string(*reason.Rsn.Cd)
+		}
+		if reason.Rsn.Prtry != nil {
+			return string(*reason.Rsn.Prtry)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const testInboundSecret = "inbound-secret"
+
+func signedStatusReport(t *testing.T, txID, status string) (*http.Request, []byte) {
+	iso := NewISO20022ServiceWithTransport(NewInMemorySettlementTransport())
+	doc, err := iso.CreatePacs002(&models.Transaction{TransactionID: txID, ReferenceID: "ref-" + txID}, status)
+	assert.NoError(t, err)
+
+	inner, err := iso.ConvertToXML(doc)
+	assert.NoError(t, err)
+	body := []byte(`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pacs.002.001.08">` +
+		inner[len(`<?xml version="1.0" encoding="UTF-8"?>`)+1:] + `</Document>`)
+
+	mac := hmac.New(sha256.New, []byte(testInboundSecret))
+	mac.Write(body)
+
+	req := httptest.NewRequest("POST", "/iso20022/status-report", bytes.NewBuffer(body))
+	req.Header.Set("X-Settlement-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return req, body
+}
+
+func statusReportResults(t *testing.T, w *httptest.ResponseRecorder) []StatusReportResult {
+	var response struct {
+		Results []StatusReportResult `json:"results"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Results
+}
+
+func TestTransactionService_ProcessStatusReport(t *testing.T) {
+	t.Run("not configured", func(t *testing.T) {
+		db, _, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+
+		req, _ := signedStatusReport(t, "tx1", "ACSC")
+		w := httptest.NewRecorder()
+		service.ProcessStatusReport(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		db, _, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+		service.inboundSecret = testInboundSecret
+
+		req, _ := signedStatusReport(t, "tx1", "ACSC")
+		req.Header.Set("X-Settlement-Signature", "deadbeef")
+		w := httptest.NewRecorder()
+		service.ProcessStatusReport(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("settled", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+		service.inboundSecret = testInboundSecret
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status, from_card_id FROM transactions").
+			WithArgs("tx1", ChannelExternalTransfer).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "from_card_id"}).AddRow("PENDING", "0123456789"))
+		mock.ExpectExec("UPDATE transactions SET status = \\$1, settled_at").
+			WithArgs("COMPLETED", "tx1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO payment_states").
+			WithArgs("tx1", "SUCCESS", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		redisMock.ExpectSetEX("idempotency:tx1", "COMPLETED", 24*time.Hour).SetVal("OK")
+
+		req, _ := signedStatusReport(t, "tx1", "ACSC")
+		w := httptest.NewRecorder()
+		service.ProcessStatusReport(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statusReportResults(t, w)
+		assert.Len(t, results, 1)
+		assert.Equal(t, StatusReportApplied, results[0].Outcome)
+		assert.Equal(t, "COMPLETED", results[0].Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rejected and reversed with a client reference as ID", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+		service.inboundSecret = testInboundSecret
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status, from_card_id FROM transactions").
+			WithArgs("INV-0042", ChannelExternalTransfer).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "from_card_id"}).AddRow("PENDING", "acct1"))
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM ledger_entries").
+			WithArgs("INV-0042-REV").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery("SELECT account_id, amount FROM ledger_entries").
+			WithArgs("INV-0042").
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "amount"}).
+				AddRow("acct1", -1050).
+				AddRow("suspense", 1000).
+				AddRow("fees", 50))
+		for _, acct := range []struct {
+			id      string
+			balance int64
+		}{{"acct1", 0}, {"fees", 50}, {"suspense", 1000}} {
+			mock.ExpectQuery("SELECT id, balance, version, updated_at").
+				WithArgs(acct.id).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "version", "updated_at"}).
+					AddRow(acct.id, acct.balance, 1, time.Now()))
+		}
+		mock.ExpectExec("INSERT INTO ledger_entries").
+			WithArgs("INV-0042-REV", "acct1", int64(1050), "CREDIT", int64(1050), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").
+			WithArgs("INV-0042-REV", "suspense", int64(-1000), "DEBIT", int64(0), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO ledger_entries").
+			WithArgs("INV-0042-REV", "fees", int64(-50), "DEBIT", int64(0), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		for _, acct := range []struct {
+			id      string
+			balance int64
+		}{{"acct1", 1050}, {"fees", 0}, {"suspense", 0}} {
+			mock.ExpectExec("UPDATE accounts").
+				WithArgs(acct.balance, sqlmock.AnyArg(), acct.id, 1).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec("UPDATE transactions SET status").
+			WithArgs("FAILED", "INV-0042").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO payment_states").
+			WithArgs("INV-0042", "REVERSED", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		redisMock.ExpectSetEX("idempotency:INV-0042", "FAILED", 24*time.Hour).SetVal("OK")
+
+		req, _ := signedStatusReport(t, "INV-0042", "RJCT")
+		w := httptest.NewRecorder()
+		service.ProcessStatusReport(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statusReportResults(t, w)
+		assert.Len(t, results, 1)
+		assert.Equal(t, StatusReportApplied, results[0].Outcome)
+		assert.Equal(t, "FAILED", results[0].Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown transaction", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+		service.inboundSecret = testInboundSecret
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status, from_card_id FROM transactions").
+			WithArgs("missing", ChannelExternalTransfer).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "from_card_id"}))
+		mock.ExpectRollback()
+
+		req, _ := signedStatusReport(t, "missing", "ACCP")
+		w := httptest.NewRecorder()
+		service.ProcessStatusReport(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statusReportResults(t, w)
+		assert.Equal(t, StatusReportNotFound, results[0].Outcome)
+	})
+
+	t.Run("rejection of an internal payment is not applied", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+		service.inboundSecret = testInboundSecret
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM transactions\\s+WHERE transaction_id = \\$1 AND channel = \\$2").
+			WithArgs("TXN-123", ChannelExternalTransfer).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "from_card_id"}))
+		mock.ExpectRollback()
+
+		req, _ := signedStatusReport(t, "TXN-123", "RJCT")
+		w := httptest.NewRecorder()
+		service.ProcessStatusReport(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statusReportResults(t, w)
+		assert.Equal(t, StatusReportNotFound, results[0].Outcome)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("rejection of a completed transfer is ignored", func(t *testing.T) {
+		db, mock, _ := sqlmock.New()
+		defer db.Close()
+		redisClient, _ := redismock.NewClientMock()
+		service := NewTransactionService(db, redisClient, &MockHSM{})
+		service.inboundSecret = testInboundSecret
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status, from_card_id FROM transactions").
+			WithArgs("EXT-1", ChannelExternalTransfer).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "from_card_id"}).AddRow("COMPLETED", "acct1"))
+		mock.ExpectRollback()
+
+		req, _ := signedStatusReport(t, "EXT-1", "RJCT")
+		w := httptest.NewRecorder()
+		service.ProcessStatusReport(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		results := statusReportResults(t, w)
+		assert.Equal(t, StatusReportIgnored, results[0].Outcome)
+		assert.Equal(t, "COMPLETED", results[0].Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestParsePacs002(t *testing.T) {
+	_, body := signedStatusReport(t, "tx9", "RJCT")
+
+	report, err := parsePacs002(body)
+	assert.NoError(t, err)
+	assert.Len(t, report.TxInfAndSts, 1)
+	assert.Equal(t, "tx9", string(*report.TxInfAndSts[0].OrgnlTxId))
+
+	_, err = parsePacs002([]byte(`<Other/>`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/models"
+)
+
+// Redis keys for PIN attempt counting and biometric challenges
+const (
+	pinFailuresKey     = "pin:failures:%s"
+	pinLockKey         = "pin:lock:%s"
+	stepUpChallengeKey = "stepup:challenge:%s"
+)
+
+var (
+	ErrStepUpRequired      = errors.New("transaction PIN or biometric confirmation required")
+	ErrInvalidPIN          = errors.New("incorrect transaction PIN")
+	ErrPINLocked           = errors.New("transaction PIN locked after too many wrong attempts")
+	ErrPINNotSet           = errors.New("transaction PIN not set")
+	ErrPINAlreadySet       = errors.New("transaction PIN already set")
+	ErrPINUnavailable      = errors.New("transaction PIN check is unavailable")
+	ErrInvalidBiometric    = errors.New("biometric confirmation rejected")
+	ErrInvalidBiometricKey = errors.New("public key must be a PEM-encoded ECDSA P-256 key")
+	ErrBiometricNotFound   = errors.New("no biometric key registered for device")
+)
+
+// stepUpErrors gives the status and code each step-up failure is reported
+// with, so apps know whether to prompt for the PIN, offer a reset or wait
+var stepUpErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrStepUpRequired, http.StatusForbidden, "STEP_UP_REQUIRED"},
+	{ErrInvalidPIN, http.StatusForbidden, "PIN_INVALID"},
+	{ErrPINLocked, http.StatusLocked, "PIN_LOCKED"},
+	{ErrPINNotSet, http.StatusForbidden, "PIN_NOT_SET"},
+	{ErrPINUnavailable, http.StatusServiceUnavailable, "PIN_UNAVAILABLE"},
+	{ErrInvalidBiometric, http.StatusForbidden, "BIOMETRIC_INVALID"},
+}
+
+// SendStepUpErrorResponse writes err if it is a step-up failure, and
+// reports whether it did
+func SendStepUpErrorResponse(w http.ResponseWriter, err error) bool {
+	for _, e := range stepUpErrors {
+		if errors.Is(err, e.err) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(e.status)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: e.err.Error(), Code: e.code})
+			return true
+		}
+	}
+	return false
+}
+
+// StepUpService manages transaction PINs and biometric keys, and checks
+// that payments above the step-up threshold carry one or the other. PINs
+// are stored as HSM Argon2 hashes; too many wrong PINs lock the PIN for
+// every channel until the lockout passes or it is reset by OTP.
+type StepUpService struct {
+	db     *sql.DB
+	redis  *redis.Client
+	hsm    hsm.HSMInterface
+	config *config.StepUpConfig
+	audit  *hsm.AuditLogger
+	otp    *OTPService
+}
+
+func NewStepUpService(db *sql.DB, redis *redis.Client, hsmInstance hsm.HSMInterface) *StepUpService {
+	return &StepUpService{
+		db:     db,
+		redis:  redis,
+		hsm:    hsmInstance,
+		config: config.LoadStepUpConfig(),
+		audit:  hsm.NewAuditLogger(),
+		otp:    NewOTPService(db, redis),
+	}
+}
+
+// Required reports whether a payment of amount kobo needs step-up
+func (s *StepUpService) Required(amount int64) bool {
+	return amount > s.config.Threshold
+}
+
+// Authorize checks the proof given for a payment of amount kobo by userID.
+// A biometric assertion is preferred when both are given.
+func (s *StepUpService) Authorize(ctx context.Context, userID string, amount int64, proof models.StepUpProof) error {
+	if !s.Required(amount) {
+		return nil
+	}
+
+	var method string
+	var err error
+	switch {
+	case proof.Biometric != nil:
+		method, err = "BIOMETRIC", s.verifyBiometric(ctx, userID, *proof.Biometric)
+	case proof.PIN != "":
+		method, err = "PIN", s.VerifyPIN(ctx, userID, proof.PIN)
+	default:
+		return ErrStepUpRequired
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.LogOperation("", userID, "STEP_UP_AUTHORIZED", fmt.Sprintf("method=%s amount=%d", method, amount))
+	return nil
+}
+
+// VerifyPIN checks pin against the user's transaction PIN, counting wrong
+// PINs towards the lockout. PINs are not checked while the lockout cannot
+// be read, as wrong guesses would go uncounted.
+func (s *StepUpService) VerifyPIN(ctx context.Context, userID, pin string) error {
+	if s.redis == nil {
+		return ErrPINUnavailable
+	}
+	n, err := s.redis.Exists(ctx, fmt.Sprintf(pinLockKey, userID)).Result()
+	if err != nil {
+		log.Printf("[STEP_UP] Failed to check PIN lockout for user %s: %v", userID, err)
+		return ErrPINUnavailable
+	}
+	if n > 0 {
+		return ErrPINLocked
+	}
+
+	var pinHash string
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(transaction_pin_hash, '') FROM users WHERE id::text = $1`, userID).Scan(&pinHash)
+	if err != nil {
+		return fmt.Errorf("failed to load transaction PIN: %w", err)
+	}
+	if pinHash == "" {
+		return ErrPINNotSet
+	}
+
+	ok, err := s.hsm.VerifyPIN(pin, pinHash)
+	if err != nil {
+		return fmt.Errorf("failed to verify transaction PIN: %w", err)
+	}
+	if !ok {
+		return s.recordPINFailure(ctx, userID)
+	}
+
+	s.redis.Del(ctx, fmt.Sprintf(pinFailuresKey, userID))
+	return nil
+}
+
+// recordPINFailure counts a wrong PIN and locks the PIN at the limit. It
+// returns the error to report for the attempt.
+func (s *StepUpService) recordPINFailure(ctx context.Context, userID string) error {
+	failuresKey := fmt.Sprintf(pinFailuresKey, userID)
+	failures, err := countWithin(ctx, s.redis, failuresKey, s.config.PINLockout)
+	if err != nil {
+		log.Printf("[STEP_UP] Failed to count PIN failure for user %s: %v", userID, err)
+		return ErrInvalidPIN
+	}
+	if failures < s.config.PINMaxAttempts {
+		return ErrInvalidPIN
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf(pinLockKey, userID), failures, s.config.PINLockout)
+	pipe.Del(ctx, failuresKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("[STEP_UP] Failed to lock PIN for user %s: %v", userID, err)
+	}
+	s.audit.LogOperation("", userID, "TRANSACTION_PIN_LOCKED", fmt.Sprintf("failures=%d lockout=%s", failures, s.config.PINLockout))
+	return ErrPINLocked
+}
+
+// SetPIN sets the user's first transaction PIN
+func (s *StepUpService) SetPIN(ctx context.Context, userID, pin string) error {
+	pinHash, err := s.hsm.HashPIN(pin, nil)
+	if err != nil {
+		return fmt.Errorf("failed to hash transaction PIN: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE users SET transaction_pin_hash = $1, transaction_pin_updated_at = NOW(), updated_at = NOW()
+		WHERE id::text = $2 AND COALESCE(transaction_pin_hash, '') = ''
+	`, pinHash, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPINAlreadySet
+	}
+
+	s.audit.LogOperation("", userID, "TRANSACTION_PIN_SET", "")
+	return nil
+}
+
+// ChangePIN replaces the user's transaction PIN after checking the current one
+func (s *StepUpService) ChangePIN(ctx context.Context, userID, currentPIN, newPIN string) error {
+	if err := s.VerifyPIN(ctx, userID, currentPIN); err != nil {
+		return err
+	}
+	if err := s.replacePIN(ctx, userID, newPIN); err != nil {
+		return err
+	}
+	s.audit.LogOperation("", userID, "TRANSACTION_PIN_CHANGED", "")
+	return nil
+}
+
+// RequestPINReset issues an OTP that resets a forgotten transaction PIN
+func (s *StepUpService) RequestPINReset(ctx context.Context, userID string) error {
+	var phone string
+	if err := s.db.QueryRowContext(ctx, `SELECT phone_number FROM users WHERE id::text = $1`, userID).Scan(&phone); err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	_, err := s.otp.Issue(ctx, OTPRequest{
+		Purpose:   OTPPurposePINReset,
+		Subject:   userID,
+		Channel:   OTPChannelSMS,
+		Recipient: phone,
+		UserID:    userID,
+	})
+	if err != nil {
+		return err
+	}
+	s.audit.LogOperation("", userID, "TRANSACTION_PIN_RESET_REQUESTED", "")
+	return nil
+}
+
+// ResetPIN replaces the user's transaction PIN with the OTP from
+// RequestPINReset, lifting any PIN lockout. Too many wrong codes discard
+// the OTP.
+func (s *StepUpService) ResetPIN(ctx context.Context, userID, otp, newPIN string) error {
+	err := s.otp.Verify(ctx, OTPPurposePINReset, userID, otp)
+	if errors.Is(err, ErrOTPAttemptsExceeded) {
+		s.audit.LogOperation("", userID, "TRANSACTION_PIN_RESET_FAILED", "attempts exceeded")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.replacePIN(ctx, userID, newPIN); err != nil {
+		return err
+	}
+	if s.redis != nil {
+		s.redis.Del(ctx, fmt.Sprintf(pinFailuresKey, userID), fmt.Sprintf(pinLockKey, userID))
+	}
+	s.audit.LogOperation("", userID, "TRANSACTION_PIN_RESET", "method=OTP")
+	return nil
+}
+
+func (s *StepUpService) replacePIN(ctx context.Context, userID, pin string) error {
+	pinHash, err := s.hsm.HashPIN(pin, nil)
+	if err != nil {
+		return fmt.Errorf("failed to hash transaction PIN: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE users SET transaction_pin_hash = $1, transaction_pin_updated_at = NOW(), updated_at = NOW()
+		WHERE id::text = $2
+	`, pinHash, userID)
+	return err
+}
+
+// IssueChallenge returns a single-use challenge for the user's device to
+// sign after a biometric check
+func (s *StepUpService) IssueChallenge(ctx context.Context, userID string) (string, error) {
+	if s.redis == nil {
+		return "", errors.New("biometric confirmation is unavailable")
+	}
+	challenge, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := s.redis.Set(ctx, fmt.Sprintf(stepUpChallengeKey, challenge), userID, s.config.ChallengeTTL).Err(); err != nil {
+		return "", fmt.Errorf("failed to store challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// ChallengeTTL is how long an issued challenge may be signed
+func (s *StepUpService) ChallengeTTL() time.Duration {
+	return s.config.ChallengeTTL
+}
+
+// EnableBiometric registers the public key of a device's biometric-protected
+// key pair. The transaction PIN is required, so a stolen access token cannot
+// enrol a key of its own.
+func (s *StepUpService) EnableBiometric(ctx context.Context, userID, pin, deviceID, publicKeyPEM string) error {
+	if _, err := parseBiometricKey(publicKeyPEM); err != nil {
+		return err
+	}
+	if err := s.VerifyPIN(ctx, userID, pin); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO biometric_keys (user_id, device_id, public_key)
+		VALUES ($1::integer, $2, $3)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET public_key = EXCLUDED.public_key, created_at = NOW()
+	`, userID, deviceID, publicKeyPEM)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET biometric_enabled = true, updated_at = NOW() WHERE id::text = $1`, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.audit.LogOperation("", userID, "BIOMETRIC_ENABLED", fmt.Sprintf("device=%s", deviceID))
+	return nil
+}
+
+// DisableBiometric removes a device's biometric key. Biometric step-up is
+// turned off for the user once no device has a key.
+func (s *StepUpService) DisableBiometric(ctx context.Context, userID, deviceID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM biometric_keys WHERE user_id::text = $1 AND device_id = $2`, userID, deviceID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrBiometricNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET biometric_enabled = EXISTS (SELECT 1 FROM biometric_keys WHERE user_id = users.id), updated_at = NOW()
+		WHERE id::text = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.audit.LogOperation("", userID, "BIOMETRIC_DISABLED", fmt.Sprintf("device=%s", deviceID))
+	return nil
+}
+
+// verifyBiometric checks that the assertion signs a challenge issued to
+// userID with the key registered for its device. The challenge is consumed
+// whether or not the signature holds.
+func (s *StepUpService) verifyBiometric(ctx context.Context, userID string, assertion models.BiometricAssertion) error {
+	if s.redis == nil {
+		return ErrInvalidBiometric
+	}
+	owner, err := s.redis.GetDel(ctx, fmt.Sprintf(stepUpChallengeKey, assertion.Challenge)).Result()
+	if err == redis.Nil {
+		return ErrInvalidBiometric
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load challenge: %w", err)
+	}
+	if owner != userID {
+		return ErrInvalidBiometric
+	}
+
+	var publicKeyPEM string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT public_key FROM biometric_keys WHERE user_id::text = $1 AND device_id = $2
+	`, userID, assertion.DeviceID).Scan(&publicKeyPEM)
+	if err == sql.ErrNoRows {
+		return ErrInvalidBiometric
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load biometric key: %w", err)
+	}
+
+	publicKey, err := parseBiometricKey(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(assertion.Signature)
+	digest := sha256.Sum256([]byte(assertion.Challenge))
+	if err != nil || !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		s.audit.LogOperation("", userID, "BIOMETRIC_REJECTED", fmt.Sprintf("device=%s", assertion.DeviceID))
+		return ErrInvalidBiometric
+	}
+	return nil
+}
+
+func parseBiometricKey(publicKeyPEM string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, ErrInvalidBiometricKey
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidBiometricKey
+	}
+	publicKey, ok := parsed.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != elliptic.P256() {
+		return nil, ErrInvalidBiometricKey
+	}
+	return publicKey, nil
+}
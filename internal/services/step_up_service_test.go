@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStepUpService(t *testing.T) (*StepUpService, sqlmock.Sqlmock, redismock.ClientMock, *MockHSM) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	redisClient, redisMock := redismock.NewClientMock()
+	mockHSM := &MockHSM{}
+	otp, _ := newTestOTPService(db, redisClient)
+	return &StepUpService{
+		db:    db,
+		redis: redisClient,
+		hsm:   mockHSM,
+		config: &config.StepUpConfig{
+			Threshold:      500000,
+			ChallengeTTL:   2 * time.Minute,
+			PINMaxAttempts: 5,
+			PINLockout:     30 * time.Minute,
+		},
+		audit: hsm.NewAuditLogger(),
+		otp:   otp,
+	}, mock, redisMock, mockHSM
+}
+
+func expectPINHash(mock sqlmock.Sqlmock, redisMock redismock.ClientMock) {
+	redisMock.ExpectExists("pin:lock:7").SetVal(0)
+	mock.ExpectQuery("SELECT COALESCE\\(transaction_pin_hash, ''\\) FROM users").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_pin_hash"}).AddRow("hash"))
+}
+
+func TestStepUpService_AuthorizePIN(t *testing.T) {
+	t.Run("below the threshold", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+
+		assert.NoError(t, s.Authorize(context.Background(), "7", 500000, models.StepUpProof{}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("above the threshold without proof", func(t *testing.T) {
+		s, _, _, _ := newTestStepUpService(t)
+
+		err := s.Authorize(context.Background(), "7", 500001, models.StepUpProof{})
+		assert.ErrorIs(t, err, ErrStepUpRequired)
+	})
+
+	t.Run("correct PIN", func(t *testing.T) {
+		s, mock, redisMock, mockHSM := newTestStepUpService(t)
+		expectPINHash(mock, redisMock)
+		mockHSM.On("VerifyPIN", "1357", "hash").Return(true, nil)
+		redisMock.ExpectDel("pin:failures:7").SetVal(1)
+
+		assert.NoError(t, s.Authorize(context.Background(), "7", 900000, models.StepUpProof{PIN: "1357"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("wrong PIN", func(t *testing.T) {
+		s, mock, redisMock, mockHSM := newTestStepUpService(t)
+		expectPINHash(mock, redisMock)
+		mockHSM.On("VerifyPIN", "0000", "hash").Return(false, nil)
+		redisMock.ExpectIncr("pin:failures:7").SetVal(1)
+		redisMock.ExpectExpire("pin:failures:7", 30*time.Minute).SetVal(true)
+
+		err := s.Authorize(context.Background(), "7", 900000, models.StepUpProof{PIN: "0000"})
+		assert.ErrorIs(t, err, ErrInvalidPIN)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("last wrong PIN locks it", func(t *testing.T) {
+		s, mock, redisMock, mockHSM := newTestStepUpService(t)
+		expectPINHash(mock, redisMock)
+		mockHSM.On("VerifyPIN", "0000", "hash").Return(false, nil)
+		redisMock.ExpectIncr("pin:failures:7").SetVal(5)
+		redisMock.ExpectTxPipeline()
+		redisMock.ExpectSet("pin:lock:7", 5, 30*time.Minute).SetVal("OK")
+		redisMock.ExpectDel("pin:failures:7").SetVal(1)
+		redisMock.ExpectTxPipelineExec()
+
+		err := s.Authorize(context.Background(), "7", 900000, models.StepUpProof{PIN: "0000"})
+		assert.ErrorIs(t, err, ErrPINLocked)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("locked PIN is not checked", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectExists("pin:lock:7").SetVal(1)
+
+		err := s.Authorize(context.Background(), "7", 900000, models.StepUpProof{PIN: "1357"})
+		assert.ErrorIs(t, err, ErrPINLocked)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("lockout unreadable", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectExists("pin:lock:7").SetErr(errors.New("connection refused"))
+
+		err := s.Authorize(context.Background(), "7", 900000, models.StepUpProof{PIN: "1357"})
+		assert.ErrorIs(t, err, ErrPINUnavailable)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("without Redis", func(t *testing.T) {
+		s, mock, _, _ := newTestStepUpService(t)
+		s.redis = nil
+
+		err := s.Authorize(context.Background(), "7", 900000, models.StepUpProof{PIN: "0000"})
+		assert.ErrorIs(t, err, ErrPINUnavailable)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no PIN set", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectExists("pin:lock:7").SetVal(0)
+		mock.ExpectQuery("SELECT COALESCE\\(transaction_pin_hash, ''\\) FROM users").
+			WithArgs("7").
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_pin_hash"}).AddRow(""))
+
+		err := s.Authorize(context.Background(), "7", 900000, models.StepUpProof{PIN: "1357"})
+		assert.ErrorIs(t, err, ErrPINNotSet)
+	})
+}
+
+func TestStepUpService_AuthorizeBiometric(t *testing.T) {
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&deviceKey.PublicKey)
+	assert.NoError(t, err)
+	publicKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	sign := func(challenge string) string {
+		digest := sha256.Sum256([]byte(challenge))
+		sig, err := ecdsa.SignASN1(rand.Reader, deviceKey, digest[:])
+		assert.NoError(t, err)
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+	expectDeviceKey := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("SELECT public_key FROM biometric_keys").
+			WithArgs("7", "device-1").
+			WillReturnRows(sqlmock.NewRows([]string{"public_key"}).AddRow(publicKeyPEM))
+	}
+
+	t.Run("signed challenge", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectGetDel("stepup:challenge:abc").SetVal("7")
+		expectDeviceKey(mock)
+
+		proof := models.StepUpProof{Biometric: &models.BiometricAssertion{DeviceID: "device-1", Challenge: "abc", Signature: sign("abc")}}
+		assert.NoError(t, s.Authorize(context.Background(), "7", 900000, proof))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("signature over another challenge", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectGetDel("stepup:challenge:abc").SetVal("7")
+		expectDeviceKey(mock)
+
+		proof := models.StepUpProof{Biometric: &models.BiometricAssertion{DeviceID: "device-1", Challenge: "abc", Signature: sign("xyz")}}
+		assert.ErrorIs(t, s.Authorize(context.Background(), "7", 900000, proof), ErrInvalidBiometric)
+	})
+
+	t.Run("challenge issued to another user", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectGetDel("stepup:challenge:abc").SetVal("8")
+
+		proof := models.StepUpProof{Biometric: &models.BiometricAssertion{DeviceID: "device-1", Challenge: "abc", Signature: sign("abc")}}
+		assert.ErrorIs(t, s.Authorize(context.Background(), "7", 900000, proof), ErrInvalidBiometric)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("used or expired challenge", func(t *testing.T) {
+		s, _, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectGetDel("stepup:challenge:abc").RedisNil()
+
+		proof := models.StepUpProof{Biometric: &models.BiometricAssertion{DeviceID: "device-1", Challenge: "abc", Signature: sign("abc")}}
+		assert.ErrorIs(t, s.Authorize(context.Background(), "7", 900000, proof), ErrInvalidBiometric)
+	})
+
+	t.Run("key that is not ECDSA P-256", func(t *testing.T) {
+		_, err := parseBiometricKey("not a key")
+		assert.ErrorIs(t, err, ErrInvalidBiometricKey)
+
+		p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		der, _ := x509.MarshalPKIXPublicKey(&p384.PublicKey)
+		_, err = parseBiometricKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+		assert.ErrorIs(t, err, ErrInvalidBiometricKey)
+	})
+}
+
+func TestStepUpService_SetPIN(t *testing.T) {
+	t.Run("first PIN", func(t *testing.T) {
+		s, mock, _, mockHSM := newTestStepUpService(t)
+		mockHSM.On("HashPIN", "1357", []byte(nil)).Return("hash", nil)
+		mock.ExpectExec("UPDATE users SET transaction_pin_hash").
+			WithArgs("hash", "7").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, s.SetPIN(context.Background(), "7", "1357"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("PIN already set", func(t *testing.T) {
+		s, mock, _, mockHSM := newTestStepUpService(t)
+		mockHSM.On("HashPIN", "1357", []byte(nil)).Return("hash", nil)
+		mock.ExpectExec("UPDATE users SET transaction_pin_hash").
+			WithArgs("hash", "7").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.ErrorIs(t, s.SetPIN(context.Background(), "7", "1357"), ErrPINAlreadySet)
+	})
+}
+
+func TestStepUpService_ResetPIN(t *testing.T) {
+	t.Run("correct code resets and unlocks", func(t *testing.T) {
+		s, mock, redisMock, mockHSM := newTestStepUpService(t)
+		redisMock.ExpectGet("otp:pin_reset:7").SetVal(hashOTP("12345678"))
+		redisMock.ExpectDel("otp:pin_reset:7", "otp:attempts:pin_reset:7").SetVal(1)
+		mockHSM.On("HashPIN", "2468", []byte(nil)).Return("hash", nil)
+		mock.ExpectExec("UPDATE users SET transaction_pin_hash").
+			WithArgs("hash", "7").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		redisMock.ExpectDel("pin:failures:7", "pin:lock:7").SetVal(1)
+
+		assert.NoError(t, s.ResetPIN(context.Background(), "7", "12345678", "2468"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("last wrong code discards the OTP", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestStepUpService(t)
+		redisMock.ExpectGet("otp:pin_reset:7").SetVal(hashOTP("12345678"))
+		redisMock.ExpectIncr("otp:attempts:pin_reset:7").SetVal(3)
+		redisMock.ExpectDel("otp:pin_reset:7", "otp:attempts:pin_reset:7").SetVal(2)
+
+		assert.ErrorIs(t, s.ResetPIN(context.Background(), "7", "87654321", "2468"), ErrOTPAttemptsExceeded)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
+
+func TestSendStepUpErrorResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	assert.True(t, SendStepUpErrorResponse(w, ErrPINLocked))
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"PIN_LOCKED"`)
+
+	assert.False(t, SendStepUpErrorResponse(httptest.NewRecorder(), ErrQRNotFound))
+}
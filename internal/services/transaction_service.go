@@ -25,15 +25,21 @@ import (
 )
 
 type TransactionService struct {
-	db            *sql.DB
-	redis         *redis.Client
-	hsm           hsm.HSMInterface
-	ledger        *DoubleLedgerService
-	audit         *hsm.AuditLogger
-	validator     *ValidationHelper
-	bankService   *BankService
-	feePercentage float64
-	feeFixed      int64
+	db              *sql.DB
+	redis           *redis.Client
+	hsm             hsm.HSMInterface
+	ledger          *DoubleLedgerService
+	limits          *LimitsService
+	audit           *hsm.AuditLogger
+	validator       *ValidationHelper
+	bankService     *BankService
+	feePercentage   float64
+	feeFixed        int64
+	cardDailyLimit  float64
+	inboundSecret   string
+	refundFeePolicy string
+	stepUp          *StepUpService
+	otp             *OTPService
 }
 
 type Transaction struct {
@@ -52,6 +58,11 @@ type Transaction struct {
 	CreatedAt  time.Time `json:"createdAt"`
 }
 
+var (
+	ErrAccountNotFound  = errors.New("account not found")
+	ErrAccountNotActive = errors.New("account not active")
+)
+
 func NewTransactionService(db *sql.DB, redis *redis.Client, hsmInstance hsm.HSMInterface) *TransactionService {
 	feePercentage := 0.5
 	feeFixed := int64(50)
@@ -65,16 +76,32 @@ func NewTransactionService(db *sql.DB, redis *redis.Client, hsmInstance hsm.HSMI
 			feeFixed = val
 		}
 	}
+	cardDailyLimit := 50000.0
+	if envDailyLimit := os.Getenv("CARD_DAILY_LIMIT"); envDailyLimit != "" {
+		if val, err := strconv.ParseFloat(envDailyLimit, 64); err == nil {
+			cardDailyLimit = val
+		}
+	}
+	refundFeePolicy := RefundFeeRetain
+	if envPolicy := os.Getenv("REFUND_FEE_POLICY"); envPolicy == RefundFeeProrate {
+		refundFeePolicy = envPolicy
+	}
 	return &TransactionService{
-		db:            db,
-		redis:         redis,
-		hsm:           hsmInstance,
-		ledger:        NewDoubleLedgerService(db),
-		audit:         hsm.NewAuditLogger(),
-		validator:     NewValidationHelper(),
-		bankService:   NewBankService(),
-		feePercentage: feePercentage,
-		feeFixed:      feeFixed,
+		db:              db,
+		redis:           redis,
+		hsm:             hsmInstance,
+		ledger:          NewDoubleLedgerService(db),
+		limits:          NewLimitsService(db),
+		audit:           hsm.NewAuditLogger(),
+		validator:       NewValidationHelper(),
+		bankService:     NewBankService(),
+		feePercentage:   feePercentage,
+		feeFixed:        feeFixed,
+		cardDailyLimit:  cardDailyLimit,
+		inboundSecret:   os.Getenv("SETTLEMENT_INBOUND_SECRET"),
+		refundFeePolicy: refundFeePolicy,
+		stepUp:          NewStepUpService(db, redis, hsmInstance),
+		otp:             NewOTPService(db, redis),
 	}
 }
 
@@ -179,6 +206,19 @@ func (ts *TransactionService) CreateTransaction(w http.ResponseWriter, r *http.R
 	}
 	defer dbTx.Rollback()
 
+	// Enforce spend limits; counters roll back with the transaction
+	if err := ts.limits.Reserve(dbTx, tx.CardID, tx.Amount); err != nil {
+		ts.audit.LogError(tx.TxID, tx.CardID, err)
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			log.Printf("[TRANSACTION] Limit blocked %s: %v", tx.TxID, err)
+			SendLimitErrorResponse(w, limitErr)
+			return
+		}
+		http.Error(w, "Failed to process transaction", http.StatusInternalServerError)
+		return
+	}
+
 	// Process ledger transfer
 	if err := ts.processLedgerTransferTx(dbTx, &tx); err != nil {
 		ts.audit.LogError(tx.TxID, tx.CardID, err)
@@ -186,6 +226,18 @@ func (ts *TransactionService) CreateTransaction(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if err := ts.limits.CheckMaxBalance(dbTx, tx.MerchantID); err != nil {
+		ts.audit.LogError(tx.TxID, tx.CardID, err)
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			log.Printf("[TRANSACTION] Limit blocked %s: %v", tx.TxID, err)
+			SendLimitErrorResponse(w, limitErr)
+			return
+		}
+		http.Error(w, "Failed to process transaction", http.StatusInternalServerError)
+		return
+	}
+
 	// Store transaction
 	if err := ts.storeTransactionTx(dbTx, &tx); err != nil {
 		ts.audit.LogError(tx.TxID, tx.CardID, err)
@@ -309,22 +361,9 @@ func (ts *TransactionService) BatchTransactions(w http.ResponseWriter, r *http.R
 			continue
 		}
 
-		// Process ledger transfer
-		if err := ts.processLedgerTransfer(&tx); err != nil {
-			ts.audit.LogError(tx.TxID, tx.CardID, err)
-			failed = append(failed, map[string]any{
-				"txId":  tx.TxID,
-				"error": "Transfer failed",
-			})
-			continue
-		}
-
-		// Store
-		if err := ts.storeTransaction(&tx); err != nil {
-			failed = append(failed, map[string]any{
-				"txId":  tx.TxID,
-				"error": "Storage failed",
-			})
+		// Limits, ledger transfer and storage commit together
+		if failure := ts.processBatchTransaction(&tx); failure != nil {
+			failed = append(failed, failure)
 			continue
 		}
 
@@ -348,6 +387,54 @@ func (ts *TransactionService) BatchTransactions(w http.ResponseWriter, r *http.R
 	})
 }
 
+// processBatchTransaction posts one batch entry in its own database
+// transaction, returning the failure entry when it cannot be completed.
+func (ts *TransactionService) processBatchTransaction(tx *Transaction) map[string]any {
+	failure := func(message string, err error) map[string]any {
+		entry := map[string]any{
+			"txId":  tx.TxID,
+			"error": message,
+		}
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			entry["error"] = "Transaction limit exceeded"
+			entry["code"] = limitErr.Code
+		}
+		return entry
+	}
+
+	dbTx, err := ts.db.Begin()
+	if err != nil {
+		return failure("Transfer failed", err)
+	}
+	defer dbTx.Rollback()
+
+	if err := ts.limits.Reserve(dbTx, tx.CardID, tx.Amount); err != nil {
+		ts.audit.LogError(tx.TxID, tx.CardID, err)
+		return failure("Transfer failed", err)
+	}
+
+	if err := ts.processLedgerTransferTx(dbTx, tx); err != nil {
+		return failure("Transfer failed", err)
+	}
+
+	if err := ts.limits.CheckMaxBalance(dbTx, tx.MerchantID); err != nil {
+		ts.audit.LogError(tx.TxID, tx.CardID, err)
+		return failure("Transfer failed", err)
+	}
+
+	if err := ts.storeTransactionTx(dbTx, tx); err != nil {
+		return failure("Storage failed", err)
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		ts.audit.LogError(tx.TxID, tx.CardID, err)
+		return failure("Transfer failed", err)
+	}
+
+	return nil
+}
+
 // GetTransaction retrieves a specific transaction
 // @Summary Get transaction by ID
 // @Description Retrieve a transaction by its ID
@@ -495,54 +582,82 @@ func (ts *TransactionService) AccountNameEnquiry(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Try local DB
-	log.Printf("[ACCOUNT_ENQUIRY] Attempting local DB lookup for: %s", accountId)
-	var accountName string
-	var status string
-	err := ts.db.QueryRow(`
-		SELECT account_name, status FROM accounts 
-		WHERE card_id = $1 OR account_id = $1
-		LIMIT 1
-	`, accountId).Scan(&accountName, &status)
-
-	if err == nil {
-		log.Printf("[ACCOUNT_ENQUIRY] Found in local DB for accountId: %s, account: %s, status: %s", maskAccountID(accountId), accountName, status)
-		if status != "ACTIVE" {
-			log.Printf("[ACCOUNT_ENQUIRY] Account not active for accountId: %s, status: %s", maskAccountID(accountId), status)
-			http.Error(w, "Account not active", http.StatusForbidden)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]any{
-			"responseCode": "00",
-			"accountId":    accountId,
-			"accountName":  accountName,
-			"status":       "SUCCESS",
-			"source":       "local",
-		})
+	accountName, source, err := ts.lookupAccountName(accountId)
+	if errors.Is(err, ErrAccountNotActive) {
+		http.Error(w, "Account not active", http.StatusForbidden)
 		return
 	}
-
-	// Not found locally, try external API
-	log.Printf("[ACCOUNT_ENQUIRY] Not found in local DB, attempting external API lookup for: %s", maskAccountID(accountId))
-	accountName, err = ts.callExternalNameEnquiry(accountId)
 	if err != nil {
-		log.Printf("[ACCOUNT_ENQUIRY] External API lookup failed for accountId %s: %v", maskAccountID(accountId), err)
 		http.Error(w, "Account not found", http.StatusNotFound)
 		return
 	}
 
-	log.Printf("[ACCOUNT_ENQUIRY] Found via external API for accountId: %s, account: %s", maskAccountID(accountId), accountName)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
 		"responseCode": "00",
 		"accountId":    accountId,
 		"accountName":  accountName,
 		"status":       "SUCCESS",
-		"source":       "external",
+		"source":       source,
 	})
 }
 
+// lookupAccountName resolves the holder name of an account, first from our
+// own accounts and then through the external name enquiry. source is
+// "local" or "external".
+func (ts *TransactionService) lookupAccountName(accountId string) (accountName, source string, err error) {
+	accountName, err = ts.localAccountName(accountId)
+	if err == nil {
+		return accountName, "local", nil
+	}
+	if errors.Is(err, ErrAccountNotActive) {
+		return "", "", err
+	}
+
+	// Not found locally, try external API
+	accountName, err = ts.externalAccountName(accountId)
+	if err != nil {
+		return "", "", err
+	}
+	return accountName, "external", nil
+}
+
+// localAccountName resolves the holder name of one of our own accounts
+func (ts *TransactionService) localAccountName(accountId string) (string, error) {
+	log.Printf("[ACCOUNT_ENQUIRY] Attempting local DB lookup for: %s", maskAccountID(accountId))
+	var accountName, status string
+	err := ts.db.QueryRow(`
+		SELECT account_name, status FROM accounts 
+		WHERE card_id = $1 OR account_id = $1
+		LIMIT 1
+	`, accountId).Scan(&accountName, &status)
+	if err != nil {
+		log.Printf("[ACCOUNT_ENQUIRY] Not found in local DB for accountId: %s", maskAccountID(accountId))
+		return "", fmt.Errorf("%w: %v", ErrAccountNotFound, err)
+	}
+
+	log.Printf("[ACCOUNT_ENQUIRY] Found in local DB for accountId: %s, account: %s, status: %s", maskAccountID(accountId), accountName, status)
+	if status != "ACTIVE" {
+		log.Printf("[ACCOUNT_ENQUIRY] Account not active for accountId: %s, status: %s", maskAccountID(accountId), status)
+		return "", ErrAccountNotActive
+	}
+	return accountName, nil
+}
+
+// externalAccountName resolves the holder name of an account held with
+// another bank through the external name enquiry
+func (ts *TransactionService) externalAccountName(accountId string) (string, error) {
+	log.Printf("[ACCOUNT_ENQUIRY] Attempting external API lookup for: %s", maskAccountID(accountId))
+	accountName, err := ts.callExternalNameEnquiry(accountId)
+	if err != nil {
+		log.Printf("[ACCOUNT_ENQUIRY] External API lookup failed for accountId %s: %v", maskAccountID(accountId), err)
+		return "", fmt.Errorf("%w: %v", ErrAccountNotFound, err)
+	}
+
+	log.Printf("[ACCOUNT_ENQUIRY] Found via external API for accountId: %s, account: %s", maskAccountID(accountId), accountName)
+	return accountName, nil
+}
+
 // AccountBalanceEnquiry retrieves all accounts and cards for the authenticated user
 // @Summary Get user accounts and cards
 // @Description Retrieve all accounts and cards with balances for the authenticated user
@@ -561,6 +676,25 @@ func (ts *TransactionService) AccountBalanceEnquiry(w http.ResponseWriter, r *ht
 
 	log.Printf("[ACCOUNT_ENQUIRY] Fetching accounts for userID: %s", userID)
 
+	accounts, err := ts.userAccounts(userID)
+	if err != nil {
+		log.Printf("[ACCOUNT_ENQUIRY] Failed to fetch accounts: %v", err)
+		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[ACCOUNT_ENQUIRY] Found %d accounts for userID: %s", len(accounts), userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"responseCode": "00",
+		"accounts":     accounts,
+		"status":       "SUCCESS",
+	})
+}
+
+// userAccounts returns the accounts owned by userID with their available
+// balances, as reported by the balance enquiry.
+func (ts *TransactionService) userAccounts(userID string) ([]map[string]any, error) {
 	rows, err := ts.db.Query(`
 		SELECT id, account_id, card_id, account_name, balance, status, 
 		       COALESCE(is_primary, false) as is_primary, 
@@ -570,9 +704,7 @@ func (ts *TransactionService) AccountBalanceEnquiry(w http.ResponseWriter, r *ht
 		WHERE user_id = $1
 	`, userID)
 	if err != nil {
-		log.Printf("[ACCOUNT_ENQUIRY] Database query failed: %v", err)
-		http.Error(w, "Failed to fetch accounts", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -600,19 +732,7 @@ func (ts *TransactionService) AccountBalanceEnquiry(w http.ResponseWriter, r *ht
 		})
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("[ACCOUNT_ENQUIRY] Rows iteration error: %v", err)
-		http.Error(w, "Failed to process accounts", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("[ACCOUNT_ENQUIRY] Found %d accounts for userID: %s", len(accounts), userID)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
-		"responseCode": "00",
-		"accounts":     accounts,
-		"status":       "SUCCESS",
-	})
+	return accounts, rows.Err()
 }
 
 // Validation functions
@@ -849,23 +969,6 @@ func maskAccountID(accountID string) string {
 	return "****" + accountID[len(accountID)-4:]
 }
 
-func (ts *TransactionService) storeTransaction(tx *Transaction) error {
-	tx.Status = "COMPLETED"
-	tx.CreatedAt = time.Now()
-
-	var userID int
-	ts.db.QueryRow(`SELECT user_id FROM accounts WHERE card_id = $1`, tx.CardID).Scan(&userID)
-
-	_, err := ts.db.Exec(`
-        INSERT INTO transactions 
-        (transaction_id, from_card_id, to_card_id, amount, currency, type, signature, status, user_id, created_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-    `, tx.TxID, tx.CardID, tx.MerchantID, tx.Amount, tx.Currency,
-		tx.TxType, tx.Signature, tx.Status, userID, tx.CreatedAt)
-
-	return err
-}
-
 func (ts *TransactionService) storeTransactionTx(dbTx *sql.Tx, tx *Transaction) error {
 	tx.Status = "COMPLETED"
 	tx.CreatedAt = time.Now()
@@ -875,9 +978,9 @@ func (ts *TransactionService) storeTransactionTx(dbTx *sql.Tx, tx *Transaction)
 
 	_, err := dbTx.Exec(`
         INSERT INTO transactions 
-        (transaction_id, from_card_id, to_card_id, amount, currency, type, signature, status, user_id, created_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-    `, tx.TxID, tx.CardID, tx.MerchantID, tx.Amount, tx.Currency,
+        (transaction_id, from_card_id, to_card_id, card_id, counter, amount, currency, type, signature, status, user_id, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+    `, tx.TxID, tx.CardID, tx.MerchantID, tx.CardID, tx.Counter, tx.Amount, tx.Currency,
 		tx.TxType, tx.Signature, tx.Status, userID, tx.CreatedAt)
 
 	return err
@@ -905,9 +1008,19 @@ func (ts *TransactionService) queueForSettlement(tx *Transaction) error {
 	return ts.redis.RPush(context.Background(), "settlement_queue", data).Err()
 }
 
+// notifyTransaction sends the debit and credit alerts for a completed
+// payment
 func (ts *TransactionService) notifyTransaction(tx *Transaction) {
-	// Send notification (SMS, push, etc.)
-	log.Printf("Notification: Transaction %s completed for card %s", tx.TxID, maskCardID(tx.CardID))
+	ts.sendAlert(tx.CardID, TransactionAlert{Type: "Debit", Amount: tx.Amount, Narration: tx.Narration, Reference: tx.TxID})
+	ts.sendAlert(tx.MerchantID, TransactionAlert{Type: "Credit", Amount: tx.Amount, Narration: tx.Narration, Reference: tx.TxID})
+}
+
+func (ts *TransactionService) sendAlert(account string, alert TransactionAlert) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := ts.otp.SendTransactionAlert(ctx, account, alert); err != nil {
+		log.Printf("Failed to send %s alert for %s to %s: %v", alert.Type, alert.Reference, maskAccountID(account), err)
+	}
 }
 
 func (ts *TransactionService) batchSettlement(transactions []Transaction) {
@@ -915,31 +1028,33 @@ func (ts *TransactionService) batchSettlement(transactions []Transaction) {
 	iso20022Service := NewISO20022Service()
 
 	for _, tx := range transactions {
-		// Convert local Transaction to models.Transaction
-		modelTx := &models.Transaction{
-			TransactionID: tx.TxID,
-			ReferenceID:   tx.TxID, // Use TxID as reference
-			FromCardID:    tx.CardID,
-			ToCardID:      tx.MerchantID,
-			Amount:        float64(tx.Amount) / 100, // Convert from cents
-			Currency:      tx.Currency,
-			Status:        tx.Status,
-		}
-
 		// Create pacs.008 message
-		doc, err := iso20022Service.ConvertTransaction(modelTx)
+		doc, err := iso20022Service.ConvertTransaction(settlementModel(&tx))
 		if err != nil {
 			log.Printf("Failed to convert transaction %s: %v", tx.TxID, err)
 			continue
 		}
 
 		// Send to settlement system
-		if err := iso20022Service.SendToSettlement(doc); err != nil {
-			log.Printf("Failed to send transaction %s to settlement: %v", tx.TxID, err)
+		ack, err := iso20022Service.SendToSettlement(doc)
+		if err != nil {
+			// Hand over to the settlement worker, which retries with backoff
+			log.Printf("Failed to send transaction %s to settlement, queueing for retry: %v", tx.TxID, err)
+			if err := ts.queueForSettlement(&tx); err != nil {
+				log.Printf("Failed to queue transaction %s for settlement: %v", tx.TxID, err)
+			}
 			continue
 		}
 
-		log.Printf("Transaction %s queued for settlement", tx.TxID)
+		if !ack.Accepted() {
+			log.Printf("Settlement rejected transaction %s: %s %s", tx.TxID, ack.ReasonCode, ack.Reason)
+			_, _ = ts.db.Exec(`UPDATE transactions SET status = $1 WHERE transaction_id = $2`, StatusSettlementDeadLetter, tx.TxID)
+			ts.audit.LogOperation(tx.TxID, tx.CardID, "SETTLEMENT_REJECTED", fmt.Sprintf("%s: %s", ack.ReasonCode, ack.Reason))
+			continue
+		}
+
+		_, _ = ts.db.Exec(`UPDATE transactions SET settled_at = NOW() WHERE transaction_id = $1`, tx.TxID)
+		log.Printf("Transaction %s settled", tx.TxID)
 	}
 }
 
@@ -1255,33 +1370,27 @@ func (ts *TransactionService) verifyAccountOwnership(accountIdentifier, userID s
 
 	return nil
 }
-func (ts *TransactionService) processLedgerTransfer(tx *Transaction) error {
-	// Transfer from card account to merchant account
-	err := ts.ledger.Transfer(tx.CardID, tx.MerchantID, tx.TxID, tx.Amount)
-
-	if err != nil {
-		ts.audit.LogError(tx.TxID, tx.CardID, err)
-		return err
-	}
-
-	ts.audit.LogTransfer(tx.TxID, tx.CardID, tx.MerchantID, tx.Amount, "SUCCESS")
-	return nil
-}
-
 func (ts *TransactionService) calculateFee(amount int64) int64 {
 	fee := int64(float64(amount) * ts.feePercentage / 100)
 	return fee + ts.feeFixed
 }
 
+// ChannelExternalTransfer tags outbound transfers to other banks. Status
+// reports are only applied to transactions with this channel.
+const ChannelExternalTransfer = "EXTERNAL"
+
 // ExternalBankTransfer handles bank-to-bank transfers using ISO 20022
 // @Summary Send external bank transfer
 // @Description Process bank-to-bank transfer using ISO 20022 messaging
 // @Tags transactions
 // @Accept json
 // @Produce json
-// @Param transfer body object{fromAccount=string,toAccount=string,toBankCode=string,amount=float64,currency=string,reference=string} true "Transfer details"
+// @Param transfer body object{fromAccount=string,toAccount=string,toBankCode=string,beneficiaryId=int64,amount=float64,currency=string,reference=string,pin=string,biometric=models.BiometricAssertion} true "Transfer details; beneficiaryId replaces toAccount and toBankCode. Amounts above the step-up threshold need pin or biometric"
 // @Success 200 {object} object{success=bool,transactionId=string,status=string}
+// @Success 202 {object} object{success=bool,transactionId=string,status=string} "Settlement unreachable; the transfer stays pending and is retried"
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} ErrorResponse "Step-up required or rejected"
+// @Failure 423 {object} ErrorResponse "Transaction PIN locked"
 // @Failure 500 {object} map[string]string
 // @Router /transactions/external [post]
 func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *http.Request) {
@@ -1319,6 +1428,25 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 		return
 	}
 
+	// A saved beneficiary stands in for the raw destination details
+	if req.BeneficiaryID != 0 {
+		if req.ToAccount != "" || req.ToBankCode != "" {
+			http.Error(w, "Send either beneficiaryId or toAccount and toBankCode", http.StatusBadRequest)
+			return
+		}
+		beneficiary, err := findBeneficiary(r.Context(), ts.db, userID, req.BeneficiaryID)
+		if errors.Is(err, ErrBeneficiaryNotFound) {
+			http.Error(w, "Beneficiary not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("[EXTERNAL_TRANSFER] Beneficiary lookup failed: %v", err)
+			http.Error(w, "Failed to process transfer", http.StatusInternalServerError)
+			return
+		}
+		req.ToAccount, req.ToBankCode = beneficiary.AccountID, beneficiary.BankCode
+	}
+
 	// Validate request
 	if err := ts.validator.ValidateStruct(&req); err != nil {
 		log.Printf("[EXTERNAL_TRANSFER] Validation failed: %v", err)
@@ -1371,6 +1499,15 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 		return
 	}
 
+	// The access token alone does not authorize a large transfer
+	if err := ts.stepUp.Authorize(r.Context(), userID, int64(req.Amount), req.StepUpProof); err != nil {
+		log.Printf("[EXTERNAL_TRANSFER] Step-up failed for %s: %v", txID, err)
+		if !SendStepUpErrorResponse(w, err) {
+			http.Error(w, "Failed to process transfer", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// Begin database transaction
 	tx, err := ts.db.Begin()
 	if err != nil {
@@ -1408,9 +1545,9 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 		metadataJSON, _ := json.Marshal(metadata)
 		_, _ = tx.Exec(`
 			INSERT INTO transactions 
-			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, NOW())
-		`, txID, req.FromAccount, req.ToAccount, amount, fee, amount+fee, req.Currency, req.Narration, "FAILED_ACCOUNT_NOT_FOUND", locationJSON, metadataJSON)
+			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, channel, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, $12, NOW())
+		`, txID, req.FromAccount, req.ToAccount, amount, fee, amount+fee, req.Currency, req.Narration, "FAILED_ACCOUNT_NOT_FOUND", locationJSON, metadataJSON, ChannelExternalTransfer)
 		tx.Commit()
 		ts.audit.LogError(txID, req.FromAccount, errors.New("source account not found"))
 		http.Error(w, "Source account not found", http.StatusNotFound)
@@ -1429,9 +1566,9 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 		metadataJSON, _ := json.Marshal(metadata)
 		_, _ = tx.Exec(`
 			INSERT INTO transactions 
-			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, NOW())
-		`, txID, req.FromAccount, req.ToAccount, amount, fee, amount+fee, req.Currency, req.Narration, "FAILED_ACCOUNT_NOT_ACTIVE", locationJSON, metadataJSON)
+			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, channel, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, $12, NOW())
+		`, txID, req.FromAccount, req.ToAccount, amount, fee, amount+fee, req.Currency, req.Narration, "FAILED_ACCOUNT_NOT_ACTIVE", locationJSON, metadataJSON, ChannelExternalTransfer)
 		tx.Commit()
 		ts.audit.LogError(txID, req.FromAccount, errors.New("account not active"))
 		http.Error(w, "Source account not active", http.StatusForbidden)
@@ -1452,75 +1589,67 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 		metadataJSON, _ := json.Marshal(metadata)
 		_, _ = tx.Exec(`
 			INSERT INTO transactions 
-			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, NOW())
-		`, txID, req.FromAccount, req.ToAccount, amount, fee, totalAmount, req.Currency, req.Narration, "FAILED_INSUFFICIENT_BALANCE", locationJSON, metadataJSON)
+			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, channel, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, $12, NOW())
+		`, txID, req.FromAccount, req.ToAccount, amount, fee, totalAmount, req.Currency, req.Narration, "FAILED_INSUFFICIENT_BALANCE", locationJSON, metadataJSON, ChannelExternalTransfer)
 		tx.Commit()
 		ts.audit.LogError(txID, req.FromAccount, errors.New("insufficient balance"))
 		http.Error(w, "Insufficient balance", http.StatusBadRequest)
 		return
 	}
 
-	// Debit source account
+	// Post the debit through the ledger: principal to settlement suspense, fee to the system fee account
 	log.Printf("[EXTERNAL_TRANSFER] Debiting source account: %s, amount: %d, fee: %d, total: %d", req.FromAccount, amount, fee, totalAmount)
-	result, err := tx.Exec(`
-		UPDATE accounts 
-		SET balance = balance - $1, updated_at = NOW() 
-		WHERE (account_id = $2 OR card_id = $2) AND balance >= $1
-	`, totalAmount, req.FromAccount)
+	var locationJSON any
+	if req.Location != nil {
+		locationJSON, _ = json.Marshal(req.Location)
+	}
+	metadata := map[string]any{"ip_address": ipAddress}
+	metadataJSON, _ := json.Marshal(metadata)
 
+	err = ts.limits.Reserve(tx, req.FromAccount, amount)
+	if err == nil {
+		err = ts.ledger.appendPaymentState(tx, txID, "PENDING")
+	}
+	if err == nil {
+		err = ts.ledger.PostOutboundTransferTx(tx, req.FromAccount, txID, req.Currency, amount, fee)
+	}
 	if err != nil {
 		log.Printf("[EXTERNAL_TRANSFER] Failed to debit account: %v", err)
-		var locationJSON any
-		if req.Location != nil {
-			locationJSON, _ = json.Marshal(req.Location)
+		tx.Rollback()
+
+		var limitErr *LimitError
+		failedStatus := "FAILED_DEBIT_ERROR"
+		if errors.As(err, &limitErr) {
+			failedStatus = "FAILED_LIMIT_EXCEEDED"
+		} else if strings.Contains(err.Error(), "insufficient balance") {
+			failedStatus = "FAILED_INSUFFICIENT_BALANCE"
 		}
-		metadata := map[string]any{"ip_address": ipAddress}
-		metadataJSON, _ := json.Marshal(metadata)
-		_, _ = tx.Exec(`
+		_, _ = ts.db.Exec(`
 			INSERT INTO transactions 
-			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, NOW())
-		`, txID, req.FromAccount, req.ToAccount, amount, fee, totalAmount, req.Currency, req.Narration, "FAILED_DEBIT_ERROR", locationJSON, metadataJSON)
-		tx.Commit()
+			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, channel, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, $12, NOW())
+		`, txID, req.FromAccount, req.ToAccount, amount, fee, totalAmount, req.Currency, req.Narration, failedStatus, locationJSON, metadataJSON, ChannelExternalTransfer)
 		ts.audit.LogError(txID, req.FromAccount, err)
-		http.Error(w, "Failed to process transfer", http.StatusInternalServerError)
-		return
-	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		log.Printf("[EXTERNAL_TRANSFER] No rows affected, insufficient balance")
-		var locationJSON any
-		if req.Location != nil {
-			locationJSON, _ = json.Marshal(req.Location)
+		if limitErr != nil {
+			SendLimitErrorResponse(w, limitErr)
+			return
 		}
-		metadata := map[string]any{"ip_address": ipAddress}
-		metadataJSON, _ := json.Marshal(metadata)
-		_, _ = tx.Exec(`
-			INSERT INTO transactions 
-			(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, NOW())
-		`, txID, req.FromAccount, req.ToAccount, amount, fee, totalAmount, req.Currency, req.Narration, "FAILED_INSUFFICIENT_BALANCE", locationJSON, metadataJSON)
-		tx.Commit()
-		ts.audit.LogError(txID, req.FromAccount, errors.New("insufficient balance"))
-		http.Error(w, "Insufficient balance", http.StatusBadRequest)
+		if failedStatus == "FAILED_INSUFFICIENT_BALANCE" {
+			http.Error(w, "Insufficient balance", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to process transfer", http.StatusInternalServerError)
 		return
 	}
 
 	// Store transaction
 	log.Printf("[EXTERNAL_TRANSFER] Storing transaction record")
-	var locationJSON any
-	if req.Location != nil {
-		locationJSON, _ = json.Marshal(req.Location)
-	}
-	metadata := map[string]any{"ip_address": ipAddress}
-	metadataJSON, _ := json.Marshal(metadata)
 	_, err = tx.Exec(`
 		INSERT INTO transactions 
-		(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, NOW())
-	`, txID, req.FromAccount, req.ToAccount, amount, fee, totalAmount, req.Currency, req.Narration, "PENDING", locationJSON, metadataJSON)
+		(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, location, metadata, channel, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'DEBIT', $9, $10, $11, $12, NOW())
+	`, txID, req.FromAccount, req.ToAccount, amount, fee, totalAmount, req.Currency, req.Narration, "PENDING", locationJSON, metadataJSON, ChannelExternalTransfer)
 
 	if err != nil {
 		log.Printf("[EXTERNAL_TRANSFER] Failed to store transaction: %v", err)
@@ -1529,22 +1658,9 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 		return
 	}
 
-	// Credit fee to system account
 	if fee > 0 {
-		systemFeeAccount := ts.ledger.systemFeeAccount
-		_, err = tx.Exec(`
-			UPDATE accounts 
-			SET balance = balance + $1, updated_at = NOW() 
-			WHERE account_id = $2
-		`, fee, systemFeeAccount)
-		if err != nil {
-			log.Printf("[EXTERNAL_TRANSFER] Failed to credit fee to system account: %v", err)
-			ts.audit.LogError(txID, req.FromAccount, err)
-			http.Error(w, "Failed to process transfer", http.StatusInternalServerError)
-			return
-		}
-		log.Printf("[EXTERNAL_TRANSFER] Credited fee %d to system account %s", fee, systemFeeAccount)
-		ts.audit.LogOperation(txID, systemFeeAccount, "FEE_CREDIT", fmt.Sprintf("Fee credited: %d", fee))
+		log.Printf("[EXTERNAL_TRANSFER] Credited fee %d to system account %s", fee, ts.ledger.systemFeeAccount)
+		ts.audit.LogOperation(txID, ts.ledger.systemFeeAccount, "FEE_CREDIT", fmt.Sprintf("Fee credited: %d", fee))
 	}
 
 	// Commit transaction
@@ -1575,8 +1691,10 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 	doc, err := iso20022Service.ConvertTransaction(modelTx)
 	if err != nil {
 		log.Printf("[EXTERNAL_TRANSFER] ISO conversion failed: %v", err)
-		_, _ = ts.db.Exec(`UPDATE transactions SET status = $1 WHERE transaction_id = $2`, "FAILED_ISO_CONVERSION", txID)
 		ts.audit.LogError(txID, req.FromAccount, err)
+		if _, _, err := ts.rejectExternalTransfer(txID, "ISO_CONVERSION", "FAILED_ISO_CONVERSION"); err != nil {
+			log.Printf("[EXTERNAL_TRANSFER] Failed to reverse %s: %v", txID, err)
+		}
 		http.Error(w, "Failed to create transfer message", http.StatusInternalServerError)
 		return
 	}
@@ -1584,16 +1702,58 @@ func (ts *TransactionService) ExternalBankTransfer(w http.ResponseWriter, r *htt
 	// Send to external settlement
 	log.Printf("[EXTERNAL_TRANSFER] Sending to settlement system")
 	ts.audit.LogOperation(txID, req.FromAccount, "ISO20022_SEND", fmt.Sprintf("Sending to bank: %s", req.ToBankCode))
-	if err := iso20022Service.SendToSettlement(doc); err != nil {
-		log.Printf("[EXTERNAL_TRANSFER] Settlement send failed: %v", err)
-		_, _ = ts.db.Exec(`UPDATE transactions SET status = $1 WHERE transaction_id = $2`, "FAILED_SETTLEMENT_ERROR", txID)
+	ack, err := iso20022Service.SendToSettlement(doc)
+	if err != nil {
+		// The pacs.008 may still have reached the counterparty, so the debit
+		// stands and the settlement worker re-sends it under the same TxId.
+		// Only a rejection reverses the transfer.
+		log.Printf("[EXTERNAL_TRANSFER] Settlement send failed, queueing %s for retry: %v", txID, err)
 		ts.audit.LogError(txID, req.FromAccount, err)
-		http.Error(w, "Failed to send to settlement", http.StatusInternalServerError)
+		if err := ts.queueForSettlement(&Transaction{
+			TxID:       txID,
+			Timestamp:  time.Now().Unix(),
+			CardID:     req.FromAccount,
+			MerchantID: req.ToAccount,
+			Amount:     amount,
+			Currency:   req.Currency,
+			TxType:     "DEBIT",
+			Narration:  req.Narration,
+			Status:     "PENDING",
+		}); err != nil {
+			log.Printf("[EXTERNAL_TRANSFER] Failed to queue %s for settlement: %v", txID, err)
+			ts.audit.LogOperation(txID, req.FromAccount, "SETTLEMENT_QUEUE_FAILED", err.Error())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{
+			"success":       true,
+			"transactionId": txID,
+			"status":        "PENDING",
+		})
+		return
+	}
+
+	if !ack.Accepted() {
+		log.Printf("[EXTERNAL_TRANSFER] Settlement rejected %s: %s %s", txID, ack.ReasonCode, ack.Reason)
+		if _, _, err := ts.rejectExternalTransfer(txID, ack.ReasonCode, "FAILED_SETTLEMENT_ERROR"); err != nil {
+			log.Printf("[EXTERNAL_TRANSFER] Failed to reverse %s: %v", txID, err)
+		}
+		ts.audit.LogOperation(txID, req.FromAccount, "SETTLEMENT_REJECTED", fmt.Sprintf("%s: %s", ack.ReasonCode, ack.Reason))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{
+			"success":       false,
+			"transactionId": txID,
+			"status":        "FAILED_SETTLEMENT_ERROR",
+			"reasonCode":    ack.ReasonCode,
+			"reason":        ack.Reason,
+		})
 		return
 	}
 
 	ts.audit.LogTransfer(txID, req.FromAccount, req.ToAccount, amount, "PENDING")
 	log.Printf("[EXTERNAL_TRANSFER] Transfer successful: %s", txID)
+	go ts.sendAlert(req.FromAccount, TransactionAlert{Type: "Debit", Amount: totalAmount, Narration: req.Narration, Reference: txID})
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
 		"success":       true,
@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
@@ -10,7 +11,6 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/go-chi/chi/v5"
 	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
 )
@@ -30,8 +30,18 @@ func TestTransactionService_CreateTransaction(t *testing.T) {
 		t.Skip("Skipping complex transaction test")
 	})
 
+	t.Run("unauthenticated", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/transactions", bytes.NewBuffer([]byte("{}")))
+		w := httptest.NewRecorder()
+
+		service.CreateTransaction(w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
 	t.Run("invalid request body", func(t *testing.T) {
 		r := httptest.NewRequest("POST", "/transactions", bytes.NewBuffer([]byte("invalid")))
+		r = r.WithContext(context.WithValue(r.Context(), "userID", "7"))
 		w := httptest.NewRecorder()
 
 		service.CreateTransaction(w, r)
@@ -49,62 +59,59 @@ func TestTransactionService_AccountNameEnquiry(t *testing.T) {
 	mockHSM := &MockHSM{}
 	service := NewTransactionService(db, redisClient, mockHSM)
 
+	enquire := func(accountID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/accounts/name-enquiry?accountId="+accountID, nil)
+		w := httptest.NewRecorder()
+		service.AccountNameEnquiry(w, req)
+		return w
+	}
+
 	t.Run("successful enquiry", func(t *testing.T) {
-		cardID := "card123"
+		accountID := "0123456789"
 
-		mock.ExpectQuery("SELECT account_name, status FROM accounts WHERE card_id = \\$1").
-			WithArgs(cardID).
+		mock.ExpectQuery("SELECT account_name, status FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1").
+			WithArgs(accountID).
 			WillReturnRows(sqlmock.NewRows([]string{"account_name", "status"}).
 				AddRow("John Doe", "ACTIVE"))
 
-		r := chi.NewRouter()
-		r.Get("/accounts/{cardId}/name", service.AccountNameEnquiry)
-
-		req := httptest.NewRequest("GET", "/accounts/card123/name", nil)
-		w := httptest.NewRecorder()
-
-		r.ServeHTTP(w, req)
+		w := enquire(accountID)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 		var response map[string]any
 		json.Unmarshal(w.Body.Bytes(), &response)
 		assert.Equal(t, "00", response["responseCode"])
 		assert.Equal(t, "John Doe", response["accountName"])
+		assert.Equal(t, "local", response["source"])
 	})
 
-	t.Run("account not found", func(t *testing.T) {
-		cardID := "nonexistent"
+	t.Run("invalid account ID", func(t *testing.T) {
+		w := enquire("card123")
 
-		mock.ExpectQuery("SELECT account_name, status FROM accounts WHERE card_id = \\$1").
-			WithArgs(cardID).
-			WillReturnError(sql.ErrNoRows)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
 
-		r := chi.NewRouter()
-		r.Get("/accounts/{cardId}/name", service.AccountNameEnquiry)
+	t.Run("account not found", func(t *testing.T) {
+		accountID := "9999999999"
 
-		req := httptest.NewRequest("GET", "/accounts/nonexistent/name", nil)
-		w := httptest.NewRecorder()
+		mock.ExpectQuery("SELECT account_name, status FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1").
+			WithArgs(accountID).
+			WillReturnError(sql.ErrNoRows)
 
-		r.ServeHTTP(w, req)
+		// The external enquiry is tried next and cannot resolve the account
+		w := enquire(accountID)
 
 		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 
 	t.Run("inactive account", func(t *testing.T) {
-		cardID := "card123"
+		accountID := "0123456789"
 
-		mock.ExpectQuery("SELECT account_name, status FROM accounts WHERE card_id = \\$1").
-			WithArgs(cardID).
+		mock.ExpectQuery("SELECT account_name, status FROM accounts\\s+WHERE card_id = \\$1 OR account_id = \\$1").
+			WithArgs(accountID).
 			WillReturnRows(sqlmock.NewRows([]string{"account_name", "status"}).
 				AddRow("John Doe", "INACTIVE"))
 
-		r := chi.NewRouter()
-		r.Get("/accounts/{cardId}/name", service.AccountNameEnquiry)
-
-		req := httptest.NewRequest("GET", "/accounts/card123/name", nil)
-		w := httptest.NewRecorder()
-
-		r.ServeHTTP(w, req)
+		w := enquire(accountID)
 
 		assert.Equal(t, http.StatusForbidden, w.Code)
 	})
@@ -119,27 +126,39 @@ func TestTransactionService_AccountBalanceEnquiry(t *testing.T) {
 	mockHSM := &MockHSM{}
 	service := NewTransactionService(db, redisClient, mockHSM)
 
-	t.Run("successful balance enquiry", func(t *testing.T) {
-		cardID := "card123"
+	t.Run("unauthenticated", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/accounts/balance", nil)
+		w := httptest.NewRecorder()
 
-		mock.ExpectQuery("SELECT balance, status FROM accounts WHERE card_id = \\$1").
-			WithArgs(cardID).
-			WillReturnRows(sqlmock.NewRows([]string{"balance", "status"}).
-				AddRow(5000, "ACTIVE"))
+		service.AccountBalanceEnquiry(w, req)
 
-		r := chi.NewRouter()
-		r.Get("/accounts/{cardId}/balance", service.AccountBalanceEnquiry)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
 
-		req := httptest.NewRequest("GET", "/accounts/card123/balance", nil)
+	t.Run("successful balance enquiry", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, account_id, card_id, account_name, balance, status").
+			WithArgs("7").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "card_id", "account_name", "balance", "status", "is_primary", "bank_name", "bank_code"}).
+				AddRow("acct-1", "0123456789", "card123", "John Doe", 5000, "ACTIVE", true, "RuralPay", "999"))
+
+		req := httptest.NewRequest("GET", "/accounts/balance", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "userID", "7"))
 		w := httptest.NewRecorder()
 
-		r.ServeHTTP(w, req)
+		service.AccountBalanceEnquiry(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
-		var response map[string]any
+		var response struct {
+			ResponseCode string           `json:"responseCode"`
+			Accounts     []map[string]any `json:"accounts"`
+		}
 		json.Unmarshal(w.Body.Bytes(), &response)
-		assert.Equal(t, "00", response["responseCode"])
-		assert.Equal(t, float64(5000), response["availableBalance"])
+		assert.Equal(t, "00", response.ResponseCode)
+		if assert.Len(t, response.Accounts, 1) {
+			assert.Equal(t, "card123", response.Accounts[0]["cardId"])
+			assert.Equal(t, float64(5000), response.Accounts[0]["availableBalance"])
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
 
@@ -148,7 +167,7 @@ func TestTransactionService_validateTransaction(t *testing.T) {
 	assert.NoError(t, err)
 	defer db.Close()
 
-	redisClient, _ := redismock.NewClientMock()
+	redisClient, redisMock := redismock.NewClientMock()
 	mockHSM := &MockHSM{}
 	service := NewTransactionService(db, redisClient, mockHSM)
 
@@ -175,8 +194,40 @@ func TestTransactionService_validateTransaction(t *testing.T) {
 			WithArgs(tx.CardID).
 			WillReturnRows(sqlmock.NewRows([]string{"balance", "status"}).AddRow(5000, "ACTIVE"))
 
+		// The nonce is unused, so it is recorded
+		redisMock.ExpectExists("nonce:tx123").SetVal(0)
+		redisMock.ExpectSetEX("nonce:tx123", tx.Timestamp, 10*time.Minute).SetVal("OK")
+
 		err := service.validateTransaction(tx)
 		assert.NoError(t, err)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("replayed nonce", func(t *testing.T) {
+		tx := &Transaction{
+			TxID:       "tx123",
+			CardID:     "card123",
+			MerchantID: "merchant123",
+			Amount:     1000,
+			Currency:   "USD",
+			Counter:    1,
+			TxType:     "DEBIT",
+			Signature:  "signature",
+			Timestamp:  time.Now().Unix(),
+		}
+
+		mock.ExpectQuery("SELECT status FROM accounts WHERE card_id = \\$1").
+			WithArgs(tx.CardID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("ACTIVE"))
+		mock.ExpectQuery("SELECT balance, status FROM accounts WHERE card_id = \\$1").
+			WithArgs(tx.CardID).
+			WillReturnRows(sqlmock.NewRows([]string{"balance", "status"}).AddRow(5000, "ACTIVE"))
+		redisMock.ExpectExists("nonce:tx123").SetVal(1)
+
+		err := service.validateTransaction(tx)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "replay attack detected")
+		assert.NoError(t, redisMock.ExpectationsWereMet())
 	})
 
 	t.Run("missing transaction ID", func(t *testing.T) {
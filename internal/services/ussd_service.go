@@ -10,10 +10,13 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/ruralpay/backend/internal/models"
 )
 
 type USSDCodeType string
@@ -36,21 +39,50 @@ type USSDCode struct {
 	Currency      string       `json:"currency"`
 }
 
+var (
+	ErrUSSDMalformedCode  = errors.New("invalid code format")
+	ErrUSSDInvalidCode    = errors.New("invalid code")
+	ErrUSSDCodeUsed       = errors.New("code already used")
+	ErrUSSDCodeExpired    = errors.New("code expired")
+	ErrUSSDUnknownMobile  = errors.New("no account registered for mobile number")
+	ErrUSSDSelfRedeem     = errors.New("code cannot be redeemed by the user who generated it")
+	ErrUSSDMobileMismatch = errors.New("mobile number is not registered to the signed-in user")
+)
+
 type USSDService struct {
 	db     *sql.DB
 	redis  *redis.Client
 	config *config.USSDConfig
+	ledger *DoubleLedgerService
+	limits *LimitsService
+	stepUp *StepUpService
 }
 
-func NewUSSDService(db *sql.DB, redis *redis.Client) *USSDService {
+func NewUSSDService(db *sql.DB, redis *redis.Client, hsmInstance hsm.HSMInterface) *USSDService {
+	cfg := config.LoadUSSDConfig()
+	if !validCodePrefixes(cfg) {
+		log.Printf("[USSDService] Invalid USSD_PUSH_PREFIX/USSD_PULL_PREFIX %q/%q for code length %d, using defaults",
+			cfg.PushCodePrefix, cfg.PullCodePrefix, cfg.CodeLength)
+		cfg.PushCodePrefix, cfg.PullCodePrefix = "1", "2"
+	}
+
 	return &USSDService{
 		db:     db,
 		redis:  redis,
-		config: config.LoadUSSDConfig(),
+		config: cfg,
+		ledger: NewDoubleLedgerService(db),
+		limits: NewLimitsService(db),
+		stepUp: NewStepUpService(db, redis, hsmInstance),
 	}
 }
 
-func (s *USSDService) GeneratePushCode(ctx context.Context, userID string, amount int64) (string, error) {
+// GeneratePushCode issues a code that pays amount from userID to whoever
+// redeems it. The generator is not present at redemption, so step-up for
+// large amounts happens here.
+func (s *USSDService) GeneratePushCode(ctx context.Context, userID string, amount int64, proof models.StepUpProof) (string, error) {
+	if err := s.stepUp.Authorize(ctx, userID, amount, proof); err != nil {
+		return "", err
+	}
 	return s.generateCode(ctx, userID, amount, PushPayment)
 }
 
@@ -66,7 +98,7 @@ func (s *USSDService) generateCode(ctx context.Context, userID string, amount in
 		return "", err
 	}
 
-	code := s.generateSecureCode()
+	code := s.generateSecureCode(codeType)
 	hashedCode := s.hashCode(code)
 	transactionID := s.generateTransactionID()
 	expiresAt := time.Now().Add(s.config.CodeTimeout)
@@ -89,7 +121,37 @@ func (s *USSDService) generateCode(ctx context.Context, userID string, amount in
 	return code, nil
 }
 
-func (s *USSDService) ValidateAndConsume(ctx context.Context, code string, expectedType USSDCodeType) (*USSDCode, error) {
+// RedeemForUser redeems code for the signed-in userID. mobileNo is
+// optional; when given it must be the user's own registered number, so a
+// caller cannot redeem a PULL code against somebody else's account.
+func (s *USSDService) RedeemForUser(ctx context.Context, code, userID, mobileNo string, proof models.StepUpProof) (*USSDCode, error) {
+	var phone string
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(phone_number, '') FROM users WHERE id::text = $1`, userID).Scan(&phone)
+	if err == sql.ErrNoRows || (err == nil && phone == "") {
+		return nil, ErrUSSDUnknownMobile
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mobileNo != "" && normalizeMobileNo(mobileNo) != normalizeMobileNo(phone) {
+		return nil, ErrUSSDMobileMismatch
+	}
+	return s.ValidateAndConsume(ctx, code, phone, proof)
+}
+
+// ValidateAndConsume redeems code on behalf of the user registered to
+// mobileNo. The code type is read from the code itself and codes with a bad
+// check digit are rejected before any lookup. A PUSH code moves the amount from the generator to the redeemer
+// and a PULL code from the redeemer to the generator. The code is burned and
+// the ledger posted under the code's transaction ID in a single transaction,
+// so a failed transfer leaves the code usable. A large PULL code needs the
+// redeemer's step-up proof; PUSH codes were authorized when generated.
+func (s *USSDService) ValidateAndConsume(ctx context.Context, code, mobileNo string, proof models.StepUpProof) (*USSDCode, error) {
+	expectedType, err := s.ParseCode(code)
+	if err != nil {
+		return nil, err
+	}
+
 	hashedCode := s.hashCode(code)
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -108,18 +170,56 @@ func (s *USSDService) ValidateAndConsume(ctx context.Context, code string, expec
 	`, hashedCode, string(expectedType)).Scan(&ussdCode.TransactionID, &ussdCode.UserID, &ussdCode.Amount, &ussdCode.ExpiresAt, &used, &ussdCode.Type)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("invalid code")
+		return nil, ErrUSSDInvalidCode
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	if used {
-		return nil, errors.New("code already used")
+		return nil, ErrUSSDCodeUsed
 	}
 
 	if time.Now().After(ussdCode.ExpiresAt) {
-		return nil, errors.New("code expired")
+		return nil, ErrUSSDCodeExpired
+	}
+
+	var generatorAccount string
+	err = tx.QueryRowContext(ctx, `SELECT account_id FROM users WHERE id::text = $1`, ussdCode.UserID).Scan(&generatorAccount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve code owner: %w", err)
+	}
+
+	var redeemerID, redeemerAccount string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id::text, account_id FROM users WHERE phone_number IN ($1, $2) LIMIT 1
+	`, mobileNo, normalizeMobileNo(mobileNo)).Scan(&redeemerID, &redeemerAccount)
+	if err == sql.ErrNoRows {
+		return nil, ErrUSSDUnknownMobile
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if redeemerID == ussdCode.UserID {
+		return nil, ErrUSSDSelfRedeem
+	}
+
+	// PUSH: generator pays the redeemer. PULL: redeemer pays the generator.
+	payerID, payerAccount, payeeAccount := ussdCode.UserID, generatorAccount, redeemerAccount
+	if ussdCode.Type == PullPayment {
+		payerID, payerAccount, payeeAccount = redeemerID, redeemerAccount, generatorAccount
+		if err := s.stepUp.Authorize(ctx, redeemerID, ussdCode.Amount, proof); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("[USSDService] ValidateAndConsume - txID: %s, type: %s, payer: %s, payee: %s, amount: %d",
+		ussdCode.TransactionID, ussdCode.Type, maskAccountID(payerAccount), maskAccountID(payeeAccount), ussdCode.Amount)
+
+	narration := fmt.Sprintf("USSD %s code redemption", ussdCode.Type)
+	if err := s.postTransfer(ctx, tx, payerID, payerAccount, payeeAccount, ussdCode.TransactionID, ussdCode.Amount, narration); err != nil {
+		return nil, err
 	}
 
 	_, err = tx.ExecContext(ctx, `
@@ -137,12 +237,90 @@ func (s *USSDService) ValidateAndConsume(ctx context.Context, code string, expec
 	}
 
 	ussdCode.Code = code
+	ussdCode.Used = true
+	ussdCode.Currency = "NGN"
 	return &ussdCode, nil
 }
 
-func (s *USSDService) generateSecureCode() string {
+// SendMoney moves amount from payerAccount, owned by userID, to payeeAccount
+// and returns the transaction ID it was posted under. Amounts above the
+// step-up threshold need proof, as for every other payment channel.
+func (s *USSDService) SendMoney(ctx context.Context, userID, payerAccount, payeeAccount string, amount int64, narration string, proof models.StepUpProof) (string, error) {
+	if err := s.stepUp.Authorize(ctx, userID, amount, proof); err != nil {
+		return "", err
+	}
+
+	transactionID := s.generateTransactionID()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if err := s.postTransfer(ctx, tx, userID, payerAccount, payeeAccount, transactionID, amount, narration); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return transactionID, nil
+}
+
+// postTransfer applies the payer's limits, posts the transfer to the ledger
+// and records it in transactions, all within tx.
+func (s *USSDService) postTransfer(ctx context.Context, tx *sql.Tx, payerID, payerAccount, payeeAccount, transactionID string, amount int64, narration string) error {
+	if err := s.ledger.appendPaymentState(tx, transactionID, "PENDING"); err != nil {
+		return err
+	}
+
+	if err := s.limits.Reserve(tx, payerAccount, amount); err != nil {
+		return err
+	}
+
+	if err := s.ledger.TransferTx(tx, payerAccount, payeeAccount, transactionID, amount); err != nil {
+		log.Printf("[USSDService] postTransfer - Ledger error for %s: %v", transactionID, err)
+		return err
+	}
+
+	if err := s.limits.CheckMaxBalance(tx, payeeAccount); err != nil {
+		return err
+	}
+
+	if err := s.ledger.appendPaymentState(tx, transactionID, "SUCCESS"); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO transactions
+		(transaction_id, from_card_id, to_card_id, amount, fee, total_amount, currency, narration, type, status, user_id, created_at)
+		VALUES ($1, $2, $3, $4, 0, $4, 'NGN', $5, 'transfer', 'COMPLETED', NULLIF($6, '')::integer, NOW())
+	`, transactionID, payerAccount, payeeAccount, amount, narration, payerID)
+	return err
+}
+
+// normalizeMobileNo converts a local Nigerian number (0803...) or a bare
+// country-code number (234803...) to the +234 form stored on users.
+func normalizeMobileNo(mobileNo string) string {
+	n := strings.ReplaceAll(strings.TrimSpace(mobileNo), " ", "")
+	switch {
+	case strings.HasPrefix(n, "+"):
+		return n
+	case strings.HasPrefix(n, "234"):
+		return "+" + n
+	case strings.HasPrefix(n, "0") && len(n) == 11:
+		return "+234" + n[1:]
+	}
+	return n
+}
+
+// generateSecureCode returns a code of CodeLength digits: the type prefix,
+// random digits and a trailing Luhn check digit.
+func (s *USSDService) generateSecureCode(codeType USSDCodeType) string {
 	const charset = "0123456789"
-	code := make([]byte, s.config.CodeLength)
+	prefix := s.codePrefix(codeType)
+	code := make([]byte, s.config.CodeLength-len(prefix)-1)
 	charsetLen := big.NewInt(int64(len(charset)))
 
 	for i := range code {
@@ -150,7 +328,69 @@ func (s *USSDService) generateSecureCode() string {
 		code[i] = charset[n.Int64()]
 	}
 
-	return string(code)
+	body := prefix + string(code)
+	return body + string(luhnCheckDigit(body))
+}
+
+// ParseCode checks the length and check digit of code and returns the code
+// type encoded in its prefix.
+func (s *USSDService) ParseCode(code string) (USSDCodeType, error) {
+	if len(code) != s.config.CodeLength {
+		return "", ErrUSSDMalformedCode
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return "", ErrUSSDMalformedCode
+		}
+	}
+	if luhnCheckDigit(code[:len(code)-1]) != code[len(code)-1] {
+		return "", ErrUSSDMalformedCode
+	}
+
+	switch {
+	case strings.HasPrefix(code, s.config.PushCodePrefix):
+		return PushPayment, nil
+	case strings.HasPrefix(code, s.config.PullCodePrefix):
+		return PullPayment, nil
+	}
+	return "", ErrUSSDMalformedCode
+}
+
+// validCodePrefixes reports whether the type prefixes are distinct digit
+// strings, neither a prefix of the other, that leave room for random digits.
+func validCodePrefixes(cfg *config.USSDConfig) bool {
+	push, pull := cfg.PushCodePrefix, cfg.PullCodePrefix
+	for _, prefix := range []string{push, pull} {
+		if prefix == "" || strings.Trim(prefix, "0123456789") != "" || cfg.CodeLength-len(prefix)-1 < 4 {
+			return false
+		}
+	}
+	return !strings.HasPrefix(push, pull) && !strings.HasPrefix(pull, push)
+}
+
+func (s *USSDService) codePrefix(codeType USSDCodeType) string {
+	if codeType == PushPayment {
+		return s.config.PushCodePrefix
+	}
+	return s.config.PullCodePrefix
+}
+
+// luhnCheckDigit returns the Luhn check digit for a string of digits
+func luhnCheckDigit(digits string) byte {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return byte('0' + (10-sum%10)%10)
 }
 
 func (s *USSDService) generateTransactionID() string {
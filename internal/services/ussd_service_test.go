@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUSSDService(db *sql.DB) *USSDService {
+	return &USSDService{
+		db:     db,
+		config: &config.USSDConfig{CodeLength: 8, HashIterations: 1, PushCodePrefix: "1", PullCodePrefix: "2"},
+		ledger: NewDoubleLedgerService(db),
+		limits: NewLimitsService(db),
+		stepUp: NewStepUpService(db, nil, nil),
+	}
+}
+
+// testUSSDCode returns a well-formed code of the given type
+func testUSSDCode(codeType USSDCodeType) string {
+	body := "1234567"
+	if codeType == PullPayment {
+		body = "2234567"
+	}
+	return body + string(luhnCheckDigit(body))
+}
+
+func expectUSSDCode(mock sqlmock.Sqlmock, s *USSDService, codeType USSDCodeType, expiresAt time.Time) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT transaction_id, user_id, amount, expires_at, used, code_type").
+		WithArgs(s.hashCode(testUSSDCode(codeType)), string(codeType)).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "user_id", "amount", "expires_at", "used", "code_type"}).
+			AddRow("USSD-1", "1", 5000, expiresAt, false, string(codeType)))
+}
+
+func expectUSSDParties(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT account_id FROM users").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow("1000000001"))
+	mock.ExpectQuery("SELECT id::text, account_id FROM users WHERE phone_number").
+		WithArgs("08022222222", "+2348022222222").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_id"}).AddRow("2", "2000000002"))
+}
+
+func expectUSSDTransfer(mock sqlmock.Sqlmock, payerUser, payer, payee string) {
+	mock.ExpectExec("INSERT INTO payment_states").WithArgs("USSD-1", "PENDING", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+		WithArgs(payer).
+		WillReturnRows(limitSubjectRows().AddRow("", 0, 1, -1, -1, -1, -1, -1))
+	mock.ExpectQuery("FROM accounts").WithArgs("1000000001").WillReturnRows(ledgerLockRows("acct1", 20000, 1))
+	mock.ExpectQuery("FROM accounts").WithArgs("2000000002").WillReturnRows(ledgerLockRows("acct2", 10000, 1))
+
+	payerID, payeeID := "acct1", "acct2"
+	payerBalance, payeeBalance := int64(20000), int64(10000)
+	if payer != "1000000001" {
+		payerID, payeeID = payeeID, payerID
+		payerBalance, payeeBalance = payeeBalance, payerBalance
+	}
+	mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("USSD-1", payerID, int64(-5000), "DEBIT", payerBalance-5000, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO ledger_entries").WithArgs("USSD-1", payeeID, int64(5000), "CREDIT", payeeBalance+5000, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE accounts").WithArgs(payerBalance-5000, sqlmock.AnyArg(), payerID, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE accounts").WithArgs(payeeBalance+5000, sqlmock.AnyArg(), payeeID, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectQuery("SELECT c.max_balance, a.balance").WithArgs(payee).WillReturnRows(sqlmock.NewRows([]string{"max_balance", "balance"}))
+	mock.ExpectExec("INSERT INTO payment_states").WithArgs("USSD-1", "SUCCESS", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs("USSD-1", payer, payee, int64(5000), sqlmock.AnyArg(), payerUser).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE ussd_codes").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestUSSDService_RedeemForUser(t *testing.T) {
+	expectRedeemer := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("SELECT COALESCE\\(phone_number, ''\\) FROM users").
+			WithArgs("2").
+			WillReturnRows(sqlmock.NewRows([]string{"phone_number"}).AddRow("08022222222"))
+	}
+
+	t.Run("redeems as the signed-in user", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectRedeemer(mock)
+		expectUSSDCode(mock, s, PullPayment, time.Now().Add(time.Minute))
+		expectUSSDParties(mock)
+		expectUSSDTransfer(mock, "2", "2000000002", "1000000001")
+
+		_, err = s.RedeemForUser(context.Background(), testUSSDCode(PullPayment), "2", "+2348022222222", models.StepUpProof{})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("another user's number is refused", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectRedeemer(mock)
+
+		_, err = s.RedeemForUser(context.Background(), testUSSDCode(PullPayment), "2", "08033333333", models.StepUpProof{})
+		assert.True(t, errors.Is(err, ErrUSSDMobileMismatch))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUSSDService_ValidateAndConsume(t *testing.T) {
+	t.Run("push code pays the redeemer", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectUSSDCode(mock, s, PushPayment, time.Now().Add(time.Minute))
+		expectUSSDParties(mock)
+		expectUSSDTransfer(mock, "1", "1000000001", "2000000002")
+
+		code, err := s.ValidateAndConsume(context.Background(), testUSSDCode(PushPayment), "08022222222", models.StepUpProof{})
+		assert.NoError(t, err)
+		assert.True(t, code.Used)
+		assert.Equal(t, "USSD-1", code.TransactionID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("pull code debits the redeemer", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectUSSDCode(mock, s, PullPayment, time.Now().Add(time.Minute))
+		expectUSSDParties(mock)
+		expectUSSDTransfer(mock, "2", "2000000002", "1000000001")
+
+		_, err = s.ValidateAndConsume(context.Background(), testUSSDCode(PullPayment), "08022222222", models.StepUpProof{})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("insufficient balance leaves code unused", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectUSSDCode(mock, s, PushPayment, time.Now().Add(time.Minute))
+		expectUSSDParties(mock)
+		mock.ExpectExec("INSERT INTO payment_states").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT COALESCE\\(c.card_id, ''\\)").
+			WillReturnRows(limitSubjectRows().AddRow("", 0, 1, -1, -1, -1, -1, -1))
+		mock.ExpectQuery("FROM accounts").WithArgs("1000000001").WillReturnRows(ledgerLockRows("acct1", 100, 1))
+		mock.ExpectQuery("FROM accounts").WithArgs("2000000002").WillReturnRows(ledgerLockRows("acct2", 0, 1))
+		mock.ExpectRollback()
+
+		_, err = s.ValidateAndConsume(context.Background(), testUSSDCode(PushPayment), "08022222222", models.StepUpProof{})
+		assert.EqualError(t, err, "insufficient balance")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown mobile number", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectUSSDCode(mock, s, PushPayment, time.Now().Add(time.Minute))
+		mock.ExpectQuery("SELECT account_id FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow("1000000001"))
+		mock.ExpectQuery("SELECT id::text, account_id FROM users WHERE phone_number").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id"}))
+		mock.ExpectRollback()
+
+		_, err = s.ValidateAndConsume(context.Background(), testUSSDCode(PushPayment), "08022222222", models.StepUpProof{})
+		assert.True(t, errors.Is(err, ErrUSSDUnknownMobile))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("generator cannot redeem own code", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectUSSDCode(mock, s, PushPayment, time.Now().Add(time.Minute))
+		mock.ExpectQuery("SELECT account_id FROM users").
+			WillReturnRows(sqlmock.NewRows([]string{"account_id"}).AddRow("1000000001"))
+		mock.ExpectQuery("SELECT id::text, account_id FROM users WHERE phone_number").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id"}).AddRow("1", "1000000001"))
+		mock.ExpectRollback()
+
+		_, err = s.ValidateAndConsume(context.Background(), testUSSDCode(PushPayment), "08022222222", models.StepUpProof{})
+		assert.True(t, errors.Is(err, ErrUSSDSelfRedeem))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		s := newTestUSSDService(db)
+		expectUSSDCode(mock, s, PushPayment, time.Now().Add(-time.Minute))
+		mock.ExpectRollback()
+
+		_, err = s.ValidateAndConsume(context.Background(), testUSSDCode(PushPayment), "08022222222", models.StepUpProof{})
+		assert.True(t, errors.Is(err, ErrUSSDCodeExpired))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestUSSDService_ParseCode(t *testing.T) {
+	s := newTestUSSDService(nil)
+
+	for _, codeType := range []USSDCodeType{PushPayment, PullPayment} {
+		code := s.generateSecureCode(codeType)
+		assert.Len(t, code, 8)
+
+		parsed, err := s.ParseCode(code)
+		assert.NoError(t, err)
+		assert.Equal(t, codeType, parsed)
+	}
+
+	code := testUSSDCode(PushPayment)
+	for _, bad := range []string{
+		code[:7],   // too short
+		"1234567a", // not numeric
+		code[:3] + code[4:5] + code[3:4] + code[5:],   // transposed digits
+		"3234567" + string(luhnCheckDigit("3234567")), // unknown type
+	} {
+		_, err := s.ParseCode(bad)
+		assert.True(t, errors.Is(err, ErrUSSDMalformedCode), bad)
+	}
+}
+
+func TestUSSDService_MalformedCodeSkipsLookup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = newTestUSSDService(db).ValidateAndConsume(context.Background(), "12345670", "08022222222", models.StepUpProof{})
+	assert.True(t, errors.Is(err, ErrUSSDMalformedCode))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidCodePrefixes(t *testing.T) {
+	assert.True(t, validCodePrefixes(&config.USSDConfig{CodeLength: 8, PushCodePrefix: "1", PullCodePrefix: "2"}))
+	assert.False(t, validCodePrefixes(&config.USSDConfig{CodeLength: 8, PushCodePrefix: "PUSH", PullCodePrefix: "PULL"}))
+	assert.False(t, validCodePrefixes(&config.USSDConfig{CodeLength: 8, PushCodePrefix: "1", PullCodePrefix: "12"}))
+	assert.False(t, validCodePrefixes(&config.USSDConfig{CodeLength: 5, PushCodePrefix: "1", PullCodePrefix: "2"}))
+}
+
+func TestNormalizeMobileNo(t *testing.T) {
+	assert.Equal(t, "+2348022222222", normalizeMobileNo("08022222222"))
+	assert.Equal(t, "+2348022222222", normalizeMobileNo("2348022222222"))
+	assert.Equal(t, "+2348022222222", normalizeMobileNo("+234 802 222 2222"))
+}
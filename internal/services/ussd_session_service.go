@@ -0,0 +1,465 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/models"
+)
+
+// USSD session steps
+const (
+	ussdStepMain           = "main"
+	ussdStepSendAccount    = "send_account"
+	ussdStepSendAmount     = "send_amount"
+	ussdStepSendConfirm    = "send_confirm"
+	ussdStepSendPIN        = "send_pin"
+	ussdStepRedeemCode     = "redeem_code"
+	ussdStepRedeemPIN      = "redeem_pin"
+	ussdStepAirtimeAmount  = "airtime_amount"
+	ussdStepAirtimeConfirm = "airtime_confirm"
+	ussdStepAirtimePIN     = "airtime_pin"
+)
+
+const ussdMainMenu = "Welcome to RuralPay\n1. Check balance\n2. Send money\n3. Redeem code\n4. Buy airtime\n5. Mini statement"
+
+var (
+	ussdAccountNumber = regexp.MustCompile(`^\d{10}$`)
+	ussdNairaAmount   = regexp.MustCompile(`^\d{1,9}(\.\d{1,2})?$`)
+)
+
+// USSDSessionRequest is a callback from the USSD aggregator. Text holds every
+// input of the session so far, separated by '*'.
+type USSDSessionRequest struct {
+	SessionID   string
+	ServiceCode string
+	PhoneNumber string
+	Text        string
+}
+
+type ussdSession struct {
+	Step      string            `json:"step"`
+	UserID    string            `json:"userId"`
+	AccountID string            `json:"accountId"`
+	Data      map[string]string `json:"data,omitempty"`
+}
+
+// USSDSessionService runs the menu-driven USSD flow for feature phones.
+// Session state is kept in Redis and expires after the configured timeout.
+type USSDSessionService struct {
+	db             *sql.DB
+	redis          *redis.Client
+	ussd           *USSDService
+	accounts       *TransactionService
+	airtimeAccount string
+	bankCode       string
+}
+
+func NewUSSDSessionService(db *sql.DB, redis *redis.Client, ussd *USSDService, accounts *TransactionService) *USSDSessionService {
+	airtimeAccount := "0000000004"
+	if envAccount := os.Getenv("AIRTIME_SETTLEMENT_ACCOUNT"); envAccount != "" {
+		airtimeAccount = envAccount
+	}
+	return &USSDSessionService{
+		db:             db,
+		redis:          redis,
+		ussd:           ussd,
+		accounts:       accounts,
+		airtimeAccount: airtimeAccount,
+		bankCode:       config.InstitutionCode(),
+	}
+}
+
+// GatewayToken returns the shared token the aggregator must present, or an
+// empty string when the gateway is not configured.
+func (s *USSDSessionService) GatewayToken() string {
+	return s.ussd.config.GatewayToken
+}
+
+// Handle advances the session by one screen and returns the reply, prefixed
+// with CON when the session continues or END when it is over.
+func (s *USSDSessionService) Handle(ctx context.Context, req USSDSessionRequest) string {
+	if s.redis == nil {
+		return ussdEnd("Service unavailable. Please try again later.")
+	}
+
+	session, err := s.loadSession(ctx, req.SessionID)
+	if err != nil {
+		log.Printf("[USSD_SESSION] Failed to load session %s: %v", req.SessionID, err)
+		return ussdEnd("Service unavailable. Please try again later.")
+	}
+
+	var reply string
+	if session == nil {
+		session, err = s.startSession(ctx, req.PhoneNumber)
+		if errors.Is(err, ErrUSSDUnknownMobile) {
+			return ussdEnd("This number is not registered with RuralPay.")
+		}
+		if err != nil {
+			log.Printf("[USSD_SESSION] Failed to start session %s: %v", req.SessionID, err)
+			return ussdEnd("Service unavailable. Please try again later.")
+		}
+
+		// A code dialled directly (e.g. *565*1*12345678#) arrives as the first input
+		if code, ok := s.dialledCode(req.ServiceCode, req.Text); ok {
+			reply = s.redeem(ctx, session, req.PhoneNumber, code, "")
+			if strings.HasPrefix(reply, "END") {
+				return reply
+			}
+		}
+	}
+
+	if reply == "" {
+		input := req.Text
+		if i := strings.LastIndex(input, "*"); i >= 0 {
+			input = input[i+1:]
+		}
+		reply = s.step(ctx, session, req.PhoneNumber, strings.TrimSpace(input))
+	}
+
+	if strings.HasPrefix(reply, "END") {
+		s.redis.Del(ctx, ussdSessionKey(req.SessionID))
+		return reply
+	}
+
+	if err := s.saveSession(ctx, req.SessionID, session); err != nil {
+		log.Printf("[USSD_SESSION] Failed to save session %s: %v", req.SessionID, err)
+		return ussdEnd("Service unavailable. Please try again later.")
+	}
+	return reply
+}
+
+func (s *USSDSessionService) step(ctx context.Context, session *ussdSession, phoneNumber, input string) string {
+	switch session.Step {
+	case "":
+		session.Step = ussdStepMain
+		return ussdContinue(ussdMainMenu)
+
+	case ussdStepMain:
+		switch input {
+		case "1":
+			return s.balance(session)
+		case "2":
+			session.Step = ussdStepSendAccount
+			return ussdContinue("Enter recipient account number or beneficiary nickname")
+		case "3":
+			session.Step = ussdStepRedeemCode
+			return ussdContinue("Enter payment code")
+		case "4":
+			session.Step = ussdStepAirtimeAmount
+			return ussdContinue("Enter airtime amount (NGN)")
+		case "5":
+			return s.miniStatement(ctx, session)
+		}
+		return ussdEnd("Invalid option.")
+
+	case ussdStepSendAccount:
+		account, accountName, reply := s.sendRecipient(ctx, session, input)
+		if reply != "" {
+			return reply
+		}
+		session.Data = map[string]string{"account": account, "name": accountName}
+		session.Step = ussdStepSendAmount
+		return ussdContinue("Enter amount (NGN)")
+
+	case ussdStepSendAmount:
+		amount, ok := parseNaira(input)
+		if !ok {
+			return ussdEnd("Invalid amount.")
+		}
+		session.Data["amount"] = strconv.FormatInt(amount, 10)
+		session.Step = ussdStepSendConfirm
+		return ussdContinue(fmt.Sprintf("Send %s to %s (%s)?\n1. Confirm\n2. Cancel",
+			formatNaira(amount), session.Data["name"], session.Data["account"]))
+
+	case ussdStepSendConfirm:
+		if input != "1" {
+			return ussdEnd("Transaction cancelled.")
+		}
+		return s.send(ctx, session, "")
+
+	case ussdStepSendPIN:
+		return s.send(ctx, session, input)
+
+	case ussdStepRedeemCode:
+		return s.redeem(ctx, session, phoneNumber, input, "")
+
+	case ussdStepRedeemPIN:
+		return s.redeem(ctx, session, phoneNumber, session.Data["code"], input)
+
+	case ussdStepAirtimeAmount:
+		amount, ok := parseNaira(input)
+		if !ok {
+			return ussdEnd("Invalid amount.")
+		}
+		session.Data = map[string]string{"amount": strconv.FormatInt(amount, 10)}
+		session.Step = ussdStepAirtimeConfirm
+		return ussdContinue(fmt.Sprintf("Buy %s airtime for %s?\n1. Confirm\n2. Cancel", formatNaira(amount), phoneNumber))
+
+	case ussdStepAirtimeConfirm:
+		if input != "1" {
+			return ussdEnd("Transaction cancelled.")
+		}
+		return s.buyAirtime(ctx, session, phoneNumber, "")
+
+	case ussdStepAirtimePIN:
+		return s.buyAirtime(ctx, session, phoneNumber, input)
+	}
+
+	return ussdEnd("Invalid option.")
+}
+
+// send transfers the confirmed amount to the chosen recipient, asking for
+// the transaction PIN when the transfer needs step-up
+func (s *USSDSessionService) send(ctx context.Context, session *ussdSession, pin string) string {
+	amount, _ := strconv.ParseInt(session.Data["amount"], 10, 64)
+	txID, err := s.ussd.SendMoney(ctx, session.UserID, session.AccountID, session.Data["account"], amount, "USSD transfer", models.StepUpProof{PIN: pin})
+	if errors.Is(err, ErrStepUpRequired) {
+		session.Step = ussdStepSendPIN
+		return ussdContinue("Enter your transaction PIN")
+	}
+	if err != nil {
+		return s.paymentFailed(err)
+	}
+	return ussdEnd(fmt.Sprintf("Transfer of %s to %s successful.\nRef: %s", formatNaira(amount), session.Data["name"], txID))
+}
+
+// buyAirtime pays the confirmed amount to the airtime settlement account,
+// asking for the transaction PIN when the purchase needs step-up
+func (s *USSDSessionService) buyAirtime(ctx context.Context, session *ussdSession, phoneNumber, pin string) string {
+	amount, _ := strconv.ParseInt(session.Data["amount"], 10, 64)
+	narration := "Airtime purchase for " + phoneNumber
+	_, err := s.ussd.SendMoney(ctx, session.UserID, session.AccountID, s.airtimeAccount, amount, narration, models.StepUpProof{PIN: pin})
+	if errors.Is(err, ErrStepUpRequired) {
+		session.Step = ussdStepAirtimePIN
+		return ussdContinue("Enter your transaction PIN")
+	}
+	if err != nil {
+		return s.paymentFailed(err)
+	}
+	return ussdEnd(fmt.Sprintf("Your %s airtime request has been received.", formatNaira(amount)))
+}
+
+// sendRecipient resolves the recipient typed on the send screen, either an
+// account number or the nickname of a saved beneficiary held with us. A
+// non-empty reply ends the session with that message.
+func (s *USSDSessionService) sendRecipient(ctx context.Context, session *ussdSession, input string) (account, accountName, reply string) {
+	var err error
+	if ussdAccountNumber.MatchString(input) {
+		account = input
+		err = s.db.QueryRowContext(ctx, `SELECT account_name FROM accounts WHERE account_id = $1`, input).Scan(&accountName)
+	} else {
+		err = s.db.QueryRowContext(ctx, `
+			SELECT b.account_id, b.account_name
+			FROM beneficiaries b
+			JOIN accounts a ON a.account_id = b.account_id
+			WHERE b.user_id::text = $1 AND LOWER(b.nickname) = LOWER($2) AND b.bank_code = $3
+		`, session.UserID, strings.Join(strings.Fields(input), " "), s.bankCode).Scan(&account, &accountName)
+	}
+	if err == sql.ErrNoRows {
+		if account == "" {
+			return "", "", ussdEnd("Beneficiary not found.")
+		}
+		return "", "", ussdEnd("Account not found.")
+	}
+	if err != nil {
+		log.Printf("[USSD_SESSION] Name enquiry failed: %v", err)
+		return "", "", ussdEnd("Service unavailable. Please try again later.")
+	}
+	if account == session.AccountID {
+		return "", "", ussdEnd("You cannot send money to your own account.")
+	}
+	return account, accountName, ""
+}
+
+func (s *USSDSessionService) balance(session *ussdSession) string {
+	accounts, err := s.accounts.userAccounts(session.UserID)
+	if err != nil {
+		log.Printf("[USSD_SESSION] Balance enquiry failed: %v", err)
+		return ussdEnd("Service unavailable. Please try again later.")
+	}
+	if len(accounts) == 0 {
+		return ussdEnd("No accounts found.")
+	}
+
+	var b strings.Builder
+	for _, account := range accounts {
+		fmt.Fprintf(&b, "%s: %s\n", maskAccountID(account["accountId"].(string)), formatNaira(account["availableBalance"].(int64)))
+	}
+	return ussdEnd(strings.TrimSuffix(b.String(), "\n"))
+}
+
+func (s *USSDSessionService) miniStatement(ctx context.Context, session *ussdSession) string {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT le.amount, le.created_at
+		FROM ledger_entries le
+		JOIN accounts a ON a.id = le.account_id
+		WHERE a.account_id = $1
+		ORDER BY le.created_at DESC, le.id DESC
+		LIMIT 5
+	`, session.AccountID)
+	if err != nil {
+		log.Printf("[USSD_SESSION] Mini statement failed: %v", err)
+		return ussdEnd("Service unavailable. Please try again later.")
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	for rows.Next() {
+		var amount int64
+		var createdAt time.Time
+		if err := rows.Scan(&amount, &createdAt); err != nil {
+			log.Printf("[USSD_SESSION] Mini statement scan failed: %v", err)
+			return ussdEnd("Service unavailable. Please try again later.")
+		}
+		sign := "+"
+		if amount < 0 {
+			sign, amount = "-", -amount
+		}
+		fmt.Fprintf(&b, "%s %s%s\n", createdAt.In(watLocation).Format("02/01"), sign, formatNaira(amount))
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[USSD_SESSION] Mini statement failed: %v", err)
+		return ussdEnd("Service unavailable. Please try again later.")
+	}
+
+	if b.Len() == 0 {
+		return ussdEnd("No transactions yet.")
+	}
+	return ussdEnd("Last transactions:\n" + strings.TrimSuffix(b.String(), "\n"))
+}
+
+// redeem redeems code for phoneNumber, asking for the transaction PIN when
+// the payment needs step-up
+func (s *USSDSessionService) redeem(ctx context.Context, session *ussdSession, phoneNumber, code, pin string) string {
+	ussdCode, err := s.ussd.ValidateAndConsume(ctx, code, phoneNumber, models.StepUpProof{PIN: pin})
+	if errors.Is(err, ErrStepUpRequired) {
+		session.Step = ussdStepRedeemPIN
+		session.Data = map[string]string{"code": code}
+		return ussdContinue("Enter your transaction PIN")
+	}
+	if err != nil {
+		return s.paymentFailed(err)
+	}
+
+	if ussdCode.Type == PushPayment {
+		return ussdEnd(fmt.Sprintf("You have received %s.\nRef: %s", formatNaira(ussdCode.Amount), ussdCode.TransactionID))
+	}
+	return ussdEnd(fmt.Sprintf("Payment of %s successful.\nRef: %s", formatNaira(ussdCode.Amount), ussdCode.TransactionID))
+}
+
+func (s *USSDSessionService) paymentFailed(err error) string {
+	log.Printf("[USSD_SESSION] Payment failed: %v", err)
+
+	var limitErr *LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		return ussdEnd("Transaction limit exceeded.")
+	case err.Error() == "insufficient balance":
+		return ussdEnd("Insufficient balance.")
+	case errors.Is(err, ErrUSSDMalformedCode), errors.Is(err, ErrUSSDInvalidCode), errors.Is(err, ErrUSSDCodeUsed),
+		errors.Is(err, ErrUSSDCodeExpired), errors.Is(err, ErrUSSDSelfRedeem):
+		return ussdEnd("Invalid or expired code.")
+	case errors.Is(err, ErrInvalidPIN):
+		return ussdEnd("Incorrect PIN.")
+	case errors.Is(err, ErrPINLocked):
+		return ussdEnd("Your PIN is locked. Please try again later.")
+	case errors.Is(err, ErrPINNotSet):
+		return ussdEnd("Set a transaction PIN in the RuralPay app first.")
+	}
+	return ussdEnd("Transaction failed. Please try again later.")
+}
+
+// dialledCode extracts a payment code from a direct dial such as
+// *565*1*12345678#, using the configured dial prefix and suffix.
+func (s *USSDSessionService) dialledCode(serviceCode, text string) (string, bool) {
+	if text == "" {
+		return "", false
+	}
+	dialled := strings.TrimSuffix(serviceCode, "#") + "*" + text + s.ussd.config.DialSuffix
+	prefix, suffix := s.ussd.config.DialPrefix, s.ussd.config.DialSuffix
+	if !strings.HasPrefix(dialled, prefix) || !strings.HasSuffix(dialled, suffix) {
+		return "", false
+	}
+	code := strings.TrimSuffix(strings.TrimPrefix(dialled, prefix), suffix)
+	if code == "" || strings.Contains(code, "*") {
+		return "", false
+	}
+	return code, true
+}
+
+func (s *USSDSessionService) startSession(ctx context.Context, phoneNumber string) (*ussdSession, error) {
+	session := &ussdSession{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id::text, account_id FROM users WHERE phone_number IN ($1, $2) LIMIT 1
+	`, phoneNumber, normalizeMobileNo(phoneNumber)).Scan(&session.UserID, &session.AccountID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUSSDUnknownMobile
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *USSDSessionService) loadSession(ctx context.Context, sessionID string) (*ussdSession, error) {
+	data, err := s.redis.Get(ctx, ussdSessionKey(sessionID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session ussdSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *USSDSessionService) saveSession(ctx context.Context, sessionID string, session *ussdSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.redis.Set(ctx, ussdSessionKey(sessionID), data, s.ussd.config.SessionTimeout).Err()
+}
+
+func ussdSessionKey(sessionID string) string {
+	return fmt.Sprintf("ussd:session:%s", sessionID)
+}
+
+func ussdContinue(text string) string {
+	return "CON " + text
+}
+
+func ussdEnd(text string) string {
+	return "END " + text
+}
+
+// parseNaira converts a whole or decimal naira amount to kobo
+func parseNaira(input string) (int64, bool) {
+	if !ussdNairaAmount.MatchString(input) {
+		return 0, false
+	}
+	whole, fraction, _ := strings.Cut(input, ".")
+	naira, _ := strconv.ParseInt(whole, 10, 64)
+	kobo, _ := strconv.ParseInt((fraction + "00")[:2], 10, 64)
+	return naira*100 + kobo, naira*100+kobo > 0
+}
+
+func formatNaira(kobo int64) string {
+	return fmt.Sprintf("NGN %d.%02d", kobo/100, kobo%100)
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUSSDSessionService(db *sql.DB, redisClient *redis.Client) *USSDSessionService {
+	ussd := newTestUSSDService(db)
+	ussd.config.DialPrefix = "*565*1*"
+	ussd.config.DialSuffix = "#"
+	ussd.config.SessionTimeout = 3 * time.Minute
+	return NewUSSDSessionService(db, redisClient, ussd, &TransactionService{db: db, bankService: NewBankService()})
+}
+
+func TestUSSDSessionService_Handle(t *testing.T) {
+	t.Run("new session shows main menu", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		redisMock.ExpectGet("ussd:session:s1").RedisNil()
+		mock.ExpectQuery("SELECT id::text, account_id FROM users WHERE phone_number").
+			WithArgs("+2348011111111", "+2348011111111").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id"}).AddRow("1", "1000000001"))
+		redisMock.ExpectSet("ussd:session:s1", []byte(`{"step":"main","userId":"1","accountId":"1000000001"}`), 3*time.Minute).SetVal("OK")
+
+		reply := newTestUSSDSessionService(db, redisClient).Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "+2348011111111",
+		})
+
+		assert.Equal(t, "CON "+ussdMainMenu, reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("balance ends the session", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		redisMock.ExpectGet("ussd:session:s1").SetVal(`{"step":"main","userId":"1","accountId":"1000000001"}`)
+		mock.ExpectQuery("SELECT id, account_id, card_id, account_name, balance").
+			WithArgs("1").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id", "card_id", "account_name", "balance", "status", "is_primary", "bank_name", "bank_code"}).
+				AddRow("acct1", "1000000001", nil, "Ada Obi", 150050, "ACTIVE", true, "", ""))
+		redisMock.ExpectDel("ussd:session:s1").SetVal(1)
+
+		reply := newTestUSSDSessionService(db, redisClient).Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "+2348011111111", Text: "1",
+		})
+
+		assert.Equal(t, "END ****0001: NGN 1500.50", reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("send money asks for confirmation", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		redisMock.ExpectGet("ussd:session:s1").
+			SetVal(`{"step":"send_amount","userId":"1","accountId":"1000000001","data":{"account":"2000000002","name":"Musa Bello"}}`)
+		redisMock.ExpectSet("ussd:session:s1",
+			[]byte(`{"step":"send_confirm","userId":"1","accountId":"1000000001","data":{"account":"2000000002","amount":"50000","name":"Musa Bello"}}`),
+			3*time.Minute).SetVal("OK")
+
+		reply := newTestUSSDSessionService(db, redisClient).Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "+2348011111111", Text: "2*2000000002*500",
+		})
+
+		assert.Equal(t, "CON Send NGN 500.00 to Musa Bello (2000000002)?\n1. Confirm\n2. Cancel", reply)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("large transfer asks for the PIN", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		redisMock.ExpectGet("ussd:session:s1").
+			SetVal(`{"step":"send_confirm","userId":"1","accountId":"1000000001","data":{"account":"2000000002","amount":"600000","name":"Musa Bello"}}`)
+		redisMock.ExpectSet("ussd:session:s1",
+			[]byte(`{"step":"send_pin","userId":"1","accountId":"1000000001","data":{"account":"2000000002","amount":"600000","name":"Musa Bello"}}`),
+			3*time.Minute).SetVal("OK")
+
+		reply := newTestUSSDSessionService(db, redisClient).Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "+2348011111111", Text: "2*2000000002*6000*1",
+		})
+
+		assert.Equal(t, "CON Enter your transaction PIN", reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("large airtime purchase asks for the PIN", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		redisMock.ExpectGet("ussd:session:s1").
+			SetVal(`{"step":"airtime_confirm","userId":"1","accountId":"1000000001","data":{"amount":"600000"}}`)
+		redisMock.ExpectSet("ussd:session:s1",
+			[]byte(`{"step":"airtime_pin","userId":"1","accountId":"1000000001","data":{"amount":"600000"}}`),
+			3*time.Minute).SetVal("OK")
+
+		reply := newTestUSSDSessionService(db, redisClient).Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "+2348011111111", Text: "4*6000*1",
+		})
+
+		assert.Equal(t, "CON Enter your transaction PIN", reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("send money to a beneficiary nickname", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		redisMock.ExpectGet("ussd:session:s1").
+			SetVal(`{"step":"send_account","userId":"1","accountId":"1000000001"}`)
+		mock.ExpectQuery("FROM beneficiaries b").
+			WithArgs("1", "Mama", "999999").
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "account_name"}).AddRow("2000000002", "Grace Okafor"))
+		redisMock.ExpectSet("ussd:session:s1",
+			[]byte(`{"step":"send_amount","userId":"1","accountId":"1000000001","data":{"account":"2000000002","name":"Grace Okafor"}}`),
+			3*time.Minute).SetVal("OK")
+
+		reply := newTestUSSDSessionService(db, redisClient).Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "+2348011111111", Text: "2*Mama",
+		})
+
+		assert.Equal(t, "CON Enter amount (NGN)", reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("large pull code asks for the PIN", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+		s := newTestUSSDSessionService(db, redisClient)
+		code := testUSSDCode(PullPayment)
+
+		redisMock.ExpectGet("ussd:session:s1").SetVal(`{"step":"redeem_code","userId":"2","accountId":"2000000002"}`)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT transaction_id, user_id, amount, expires_at, used, code_type").
+			WithArgs(s.ussd.hashCode(code), string(PullPayment)).
+			WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "user_id", "amount", "expires_at", "used", "code_type"}).
+				AddRow("USSD-1", "1", 600000, time.Now().Add(time.Minute), false, string(PullPayment)))
+		expectUSSDParties(mock)
+		mock.ExpectRollback()
+		redisMock.ExpectSet("ussd:session:s1",
+			[]byte(`{"step":"redeem_pin","userId":"2","accountId":"2000000002","data":{"code":"`+code+`"}}`),
+			3*time.Minute).SetVal("OK")
+
+		reply := s.Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "08022222222", Text: "3*" + code,
+		})
+
+		assert.Equal(t, "CON Enter your transaction PIN", reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("unregistered number", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		redisClient, redisMock := redismock.NewClientMock()
+
+		redisMock.ExpectGet("ussd:session:s1").RedisNil()
+		mock.ExpectQuery("SELECT id::text, account_id FROM users WHERE phone_number").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "account_id"}))
+
+		reply := newTestUSSDSessionService(db, redisClient).Handle(context.Background(), USSDSessionRequest{
+			SessionID: "s1", ServiceCode: "*565#", PhoneNumber: "08099999999",
+		})
+
+		assert.Equal(t, "END This number is not registered with RuralPay.", reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no redis", func(t *testing.T) {
+		reply := newTestUSSDSessionService(nil, nil).Handle(context.Background(), USSDSessionRequest{SessionID: "s1"})
+		assert.Equal(t, "END Service unavailable. Please try again later.", reply)
+	})
+}
+
+func TestUSSDSessionService_dialledCode(t *testing.T) {
+	s := &USSDSessionService{ussd: &USSDService{config: &config.USSDConfig{DialPrefix: "*565*1*", DialSuffix: "#"}}}
+
+	code, ok := s.dialledCode("*565#", "1*12345678")
+	assert.True(t, ok)
+	assert.Equal(t, "12345678", code)
+
+	_, ok = s.dialledCode("*565#", "")
+	assert.False(t, ok)
+	_, ok = s.dialledCode("*565#", "2*12345678")
+	assert.False(t, ok)
+	_, ok = s.dialledCode("*565#", "1*")
+	assert.False(t, ok)
+}
+
+func TestParseNaira(t *testing.T) {
+	for input, want := range map[string]int64{"500": 50000, "12.5": 1250, "0.05": 5} {
+		got, ok := parseNaira(input)
+		assert.True(t, ok, input)
+		assert.Equal(t, want, got, input)
+	}
+	for _, input := range []string{"", "0", "-5", "1e3", "NaN", "1.234", "abc"} {
+		_, ok := parseNaira(input)
+		assert.False(t, ok, input)
+	}
+	assert.Equal(t, "NGN 1500.05", formatNaira(150005))
+}
@@ -11,6 +11,7 @@ import (
 // ErrorResponse represents error response structure
 type ErrorResponse struct {
 	Error   string            `json:"error"`             // Error message
+	Code    string            `json:"code,omitempty"`    // Machine-readable error code
 	Details map[string]string `json:"details,omitempty"` // Validation details
 }
 
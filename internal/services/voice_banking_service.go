@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -9,15 +10,24 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
-	speech "cloud.google.com/go/speech/apiv1"
-	"cloud.google.com/go/speech/apiv1/speechpb"
+	"github.com/go-redis/redis/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/hsm"
 )
 
 type VoiceBankingService struct {
-	client *speech.Client
+	recognizer SpeechRecognizer
+	db         *sql.DB
+	redis      *redis.Client
+	accounts   *TransactionService
+	ussd       *USSDService
+	cards      *CardProvisioningService
+	stepUp     *StepUpService
+	config     *config.VoiceConfig
+	audit      *hsm.AuditLogger
+	bankCode   string
 }
 
 type TranscribeRequest struct {
@@ -33,14 +43,28 @@ type TranscribeResponse struct {
 	Duration   float64 `json:"duration_seconds"`
 }
 
-func NewVoiceBankingService() *VoiceBankingService {
-	ctx := context.Background()
-	client, err := speech.NewClient(ctx)
+// NewVoiceBankingService transcribes audio and runs the parsed commands
+// against the enquiry, transfer and card services.
+func NewVoiceBankingService(db *sql.DB, redis *redis.Client, hsmInstance hsm.HSMInterface, accounts *TransactionService, ussd *USSDService, cards *CardProvisioningService) *VoiceBankingService {
+	s := &VoiceBankingService{
+		db:       db,
+		redis:    redis,
+		accounts: accounts,
+		ussd:     ussd,
+		cards:    cards,
+		stepUp:   NewStepUpService(db, redis, hsmInstance),
+		config:   config.LoadVoiceConfig(),
+		audit:    hsm.NewAuditLogger(),
+		bankCode: config.InstitutionCode(),
+	}
+
+	recognizer, err := NewSpeechRecognizer(s.config)
 	if err != nil {
-		log.Printf("Warning: Failed to initialize speech client: %v", err)
-		return &VoiceBankingService{client: nil}
+		log.Printf("Warning: Failed to initialize speech recognizer: %v", err)
+		recognizer, _ = NewFixtureRecognizer("", "")
 	}
-	return &VoiceBankingService{client: client}
+	s.recognizer = recognizer
+	return s
 }
 
 func (s *VoiceBankingService) TranscribeAudio(w http.ResponseWriter, r *http.Request) {
@@ -72,15 +96,7 @@ func (s *VoiceBankingService) TranscribeAudio(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if req.Encoding == "" {
-		req.Encoding = "LINEAR16"
-	}
-	if req.SampleRate == 0 {
-		req.SampleRate = 16000
-	}
-	if req.LanguageCode == "" {
-		req.LanguageCode = "en-US"
-	}
+	req.applyDefaults()
 
 	startTime := time.Now()
 	transcript, confidence, err := s.Transcribe(r.Context(), req)
@@ -101,100 +117,22 @@ func (s *VoiceBankingService) TranscribeAudio(w http.ResponseWriter, r *http.Req
 	})
 }
 
-func (s *VoiceBankingService) Transcribe(ctx context.Context, req TranscribeRequest) (string, float32, error) {
-	if s.client == nil {
-		return s.mockTranscribe(req)
-	}
-
-	audioBytes, err := base64.StdEncoding.DecodeString(req.Audio)
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to decode audio: %w", err)
-	}
-
-	if len(audioBytes) == 0 {
-		return "", 0, errors.New("audio data is empty")
-	}
-
-	encoding, err := parseEncoding(req.Encoding)
-	if err != nil {
-		return "", 0, err
-	}
-
-	speechReq := &speechpb.RecognizeRequest{
-		Config: &speechpb.RecognitionConfig{
-			Encoding:                   encoding,
-			SampleRateHertz:            int32(req.SampleRate),
-			LanguageCode:               req.LanguageCode,
-			EnableAutomaticPunctuation: true,
-			Model:                      "latest_long",
-			UseEnhanced:                true,
-		},
-		Audio: &speechpb.RecognitionAudio{
-			AudioSource: &speechpb.RecognitionAudio_Content{
-				Content: audioBytes,
-			},
-		},
-	}
-
-	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-
-	resp, err := s.client.Recognize(timeoutCtx, speechReq)
-	if err != nil {
-		return "", 0, fmt.Errorf("recognition failed: %w", err)
-	}
-
-	if len(resp.Results) == 0 {
-		return "", 0, errors.New("no transcription results")
-	}
-
-	var transcript strings.Builder
-	var totalConfidence float32
-	var count int
-
-	for _, result := range resp.Results {
-		if len(result.Alternatives) > 0 {
-			alternative := result.Alternatives[0]
-			transcript.WriteString(alternative.Transcript)
-			transcript.WriteString(" ")
-			totalConfidence += alternative.Confidence
-			count++
-		}
+// applyDefaults fills in the audio format most handsets record in
+func (req *TranscribeRequest) applyDefaults() {
+	if req.Encoding == "" {
+		req.Encoding = "LINEAR16"
 	}
-
-	if count == 0 {
-		return "", 0, errors.New("no alternatives in results")
+	if req.SampleRate == 0 {
+		req.SampleRate = 16000
 	}
-
-	avgConfidence := totalConfidence / float32(count)
-	finalTranscript := strings.TrimSpace(transcript.String())
-	return finalTranscript, avgConfidence, nil
-}
-
-func parseEncoding(encoding string) (speechpb.RecognitionConfig_AudioEncoding, error) {
-	switch strings.ToUpper(encoding) {
-	case "LINEAR16":
-		return speechpb.RecognitionConfig_LINEAR16, nil
-	case "FLAC":
-		return speechpb.RecognitionConfig_FLAC, nil
-	case "MULAW":
-		return speechpb.RecognitionConfig_MULAW, nil
-	case "AMR":
-		return speechpb.RecognitionConfig_AMR, nil
-	case "AMR_WB":
-		return speechpb.RecognitionConfig_AMR_WB, nil
-	case "OGG_OPUS":
-		return speechpb.RecognitionConfig_OGG_OPUS, nil
-	case "SPEEX_WITH_HEADER_BYTE":
-		return speechpb.RecognitionConfig_SPEEX_WITH_HEADER_BYTE, nil
-	case "WEBM_OPUS":
-		return speechpb.RecognitionConfig_WEBM_OPUS, nil
-	default:
-		return speechpb.RecognitionConfig_ENCODING_UNSPECIFIED, fmt.Errorf("unsupported encoding: %s", encoding)
+	if req.LanguageCode == "" {
+		req.LanguageCode = "en-US"
 	}
 }
 
-func (s *VoiceBankingService) mockTranscribe(req TranscribeRequest) (string, float32, error) {
+// Transcribe decodes the audio and passes it to the recognizer routed for
+// its language.
+func (s *VoiceBankingService) Transcribe(ctx context.Context, req TranscribeRequest) (string, float32, error) {
 	audioBytes, err := base64.StdEncoding.DecodeString(req.Audio)
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to decode audio: %w", err)
@@ -204,12 +142,14 @@ func (s *VoiceBankingService) mockTranscribe(req TranscribeRequest) (string, flo
 		return "", 0, errors.New("audio data is empty")
 	}
 
-	return "Mock transcription: Check my balance", 0.95, nil
+	return s.recognizer.Recognize(ctx, SpeechAudio{
+		Content:      audioBytes,
+		Encoding:     req.Encoding,
+		SampleRate:   req.SampleRate,
+		LanguageCode: req.LanguageCode,
+	})
 }
 
 func (s *VoiceBankingService) Close() error {
-	if s.client != nil {
-		return s.client.Close()
-	}
-	return nil
+	return s.recognizer.Close()
 }
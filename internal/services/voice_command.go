@@ -0,0 +1,602 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ruralpay/backend/internal/models"
+)
+
+// Challenges a send or block command must pass before it runs
+const (
+	VoiceChallengePIN   = "PIN"
+	VoiceChallengeVoice = "VOICE"
+)
+
+// Voice command states
+const (
+	VoiceStatusCompleted            = "COMPLETED"
+	VoiceStatusConfirmationRequired = "CONFIRMATION_REQUIRED"
+)
+
+const (
+	voicePendingPrefix     = "voice:pending:"
+	voicePhraseDigits      = 4
+	voiceTransactionsCount = 5
+)
+
+var (
+	ErrVoiceDisabled        = errors.New("voice banking is not enabled")
+	ErrVoicePayeeNotFound   = errors.New("no saved beneficiary matches that name")
+	ErrVoicePayeeAmbiguous  = errors.New("more than one saved beneficiary matches that name")
+	ErrVoiceCommandNotFound = errors.New("voice command not found or expired")
+	ErrVoiceChallengeFailed = errors.New("confirmation did not match")
+	ErrVoiceTooManyAttempts = errors.New("too many confirmation attempts")
+)
+
+type VoiceCommandRequest struct {
+	TranscribeRequest
+	// Transcript may be sent instead of audio by clients that transcribe
+	// on the device.
+	Transcript string `json:"transcript"`
+}
+
+type VoiceConfirmRequest struct {
+	TranscribeRequest
+	CommandID string `json:"commandId"`
+	PIN       string `json:"pin"`
+}
+
+type VoiceSettingsRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// VoiceChallenge tells the client how to confirm a pending command. Phrase
+// holds the digits the user must read out for a VOICE challenge, which is
+// only offered for card blocks.
+type VoiceChallenge struct {
+	Type      string    `json:"type"`
+	Phrase    string    `json:"phrase,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// VoiceCommandResponse carries Reply, a sentence the client can read back
+// to the user, alongside the structured result.
+type VoiceCommandResponse struct {
+	CommandID string          `json:"commandId,omitempty"`
+	Status    string          `json:"status"`
+	Command   *VoiceCommand   `json:"command"`
+	Reply     string          `json:"reply"`
+	Challenge *VoiceChallenge `json:"challenge,omitempty"`
+	Data      any             `json:"data,omitempty"`
+}
+
+// pendingVoiceCommand is a send or block command held in Redis until it is
+// confirmed. The beneficiary is resolved before the challenge so the user
+// confirms the exact account that will be paid.
+type pendingVoiceCommand struct {
+	UserID       string       `json:"userId"`
+	AccountID    string       `json:"accountId"`
+	Command      VoiceCommand `json:"command"`
+	PayeeAccount string       `json:"payeeAccount,omitempty"`
+	PayeeName    string       `json:"payeeName,omitempty"`
+	Challenge    string       `json:"challenge"`
+	Phrase       string       `json:"phrase,omitempty"`
+	Attempts     int          `json:"attempts"`
+}
+
+// VoiceCommand parses spoken or transcribed text into a command. Enquiries
+// are answered at once; transfers and card blocks return a challenge that
+// must be answered through ConfirmVoiceCommand.
+// @Summary Run a voice banking command
+// @Description Balance, last five transactions, send money to a saved beneficiary, or block cards
+// @Tags voice
+// @Accept json
+// @Produce json
+// @Param request body VoiceCommandRequest true "Audio or transcript"
+// @Success 200 {object} VoiceCommandResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /voice/command [post]
+func (s *VoiceBankingService) VoiceCommand(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req VoiceCommandRequest
+	if !decodeVoiceRequest(w, r, &req) {
+		return
+	}
+
+	transcript := strings.TrimSpace(req.Transcript)
+	if transcript == "" {
+		if req.Audio == "" {
+			SendErrorResponse(w, "Audio or transcript is required", http.StatusBadRequest, nil)
+			return
+		}
+		req.applyDefaults()
+		var err error
+		if transcript, _, err = s.Transcribe(r.Context(), req.TranscribeRequest); err != nil {
+			log.Printf("[VOICE] Transcription failed for user %s: %v", userID, err)
+			SendErrorResponse(w, "Failed to transcribe audio", http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	resp, err := s.RunCommand(r.Context(), userID, transcript)
+	if err != nil {
+		s.sendVoiceError(w, userID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ConfirmVoiceCommand answers the challenge of a pending command with the
+// transaction PIN or a recording of the challenge digits, then runs it.
+// @Summary Confirm a voice banking command
+// @Tags voice
+// @Accept json
+// @Produce json
+// @Param request body VoiceConfirmRequest true "Command ID with PIN or audio"
+// @Success 200 {object} VoiceCommandResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 423 {object} ErrorResponse
+// @Router /voice/confirm [post]
+func (s *VoiceBankingService) ConfirmVoiceCommand(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req VoiceConfirmRequest
+	if !decodeVoiceRequest(w, r, &req) {
+		return
+	}
+	if req.CommandID == "" {
+		SendErrorResponse(w, "Command ID is required", http.StatusBadRequest, nil)
+		return
+	}
+	if req.PIN == "" && req.Audio == "" {
+		SendErrorResponse(w, "PIN or audio is required", http.StatusBadRequest, nil)
+		return
+	}
+
+	var spoken string
+	if req.PIN == "" {
+		req.applyDefaults()
+		var err error
+		if spoken, _, err = s.Transcribe(r.Context(), req.TranscribeRequest); err != nil {
+			log.Printf("[VOICE] Confirmation transcription failed for user %s: %v", userID, err)
+			SendErrorResponse(w, "Failed to transcribe audio", http.StatusInternalServerError, nil)
+			return
+		}
+	}
+
+	resp, err := s.ConfirmCommand(r.Context(), userID, req.CommandID, req.PIN, spoken)
+	if err != nil {
+		s.sendVoiceError(w, userID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UpdateVoiceSettings turns voice banking on or off for the user
+// @Summary Enable or disable voice banking
+// @Tags voice
+// @Accept json
+// @Produce json
+// @Param request body VoiceSettingsRequest true "Voice banking setting"
+// @Success 200 {object} VoiceSettingsRequest
+// @Failure 400 {object} ErrorResponse
+// @Router /voice/settings [put]
+func (s *VoiceBankingService) UpdateVoiceSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(string)
+	if !ok || userID == "" {
+		SendErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var req VoiceSettingsRequest
+	if !decodeVoiceRequest(w, r, &req) {
+		return
+	}
+
+	res, err := s.db.ExecContext(r.Context(), `
+		UPDATE users SET voice_banking_enabled = $1, updated_at = NOW() WHERE id::text = $2
+	`, req.Enabled, userID)
+	if err != nil {
+		log.Printf("[VOICE] Failed to update settings for user %s: %v", userID, err)
+		SendErrorResponse(w, "Failed to update settings", http.StatusInternalServerError, nil)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		SendErrorResponse(w, "User not found", http.StatusNotFound, nil)
+		return
+	}
+
+	s.audit.LogOperation("", userID, "VOICE_BANKING_SETTINGS", fmt.Sprintf("enabled=%t", req.Enabled))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// RunCommand parses transcript and answers enquiries directly. Send and
+// block commands are stored pending a challenge and are not run here.
+func (s *VoiceBankingService) RunCommand(ctx context.Context, userID, transcript string) (*VoiceCommandResponse, error) {
+	accountID, pinHash, err := s.voiceProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := ParseVoiceCommand(transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cmd.Intent {
+	case VoiceIntentBalance:
+		return s.balanceReply(userID, cmd)
+	case VoiceIntentTransactions:
+		return s.transactionsReply(userID, cmd)
+	}
+
+	pending := &pendingVoiceCommand{UserID: userID, AccountID: accountID, Command: *cmd}
+	var prompt string
+	if cmd.Intent == VoiceIntentSendMoney {
+		// The spoken phrase is sent to the client with the challenge, so it
+		// proves nothing about who is paying; sends need the PIN
+		if pinHash == "" {
+			return nil, ErrPINNotSet
+		}
+		pending.PayeeAccount, pending.PayeeName, err = s.resolvePayee(ctx, userID, cmd.Payee)
+		if err != nil {
+			return nil, err
+		}
+		prompt = fmt.Sprintf("Send %s to %s (%s)?", formatNaira(cmd.Amount), pending.PayeeName, maskAccountID(pending.PayeeAccount))
+	} else {
+		prompt = "Block all your active cards?"
+	}
+	return s.challenge(ctx, pending, pinHash, prompt)
+}
+
+// ConfirmCommand checks the answer to a pending command's challenge and runs
+// the command once it matches. A PIN is checked against the user's
+// transaction PIN; spoken is the transcript of the user reading the digits.
+func (s *VoiceBankingService) ConfirmCommand(ctx context.Context, userID, commandID, pin, spoken string) (*VoiceCommandResponse, error) {
+	if s.redis == nil {
+		return nil, errors.New("redis unavailable")
+	}
+
+	key := voicePendingPrefix + commandID
+	raw, err := s.redis.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrVoiceCommandNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending pendingVoiceCommand
+	if err := json.Unmarshal(raw, &pending); err != nil {
+		return nil, err
+	}
+	if pending.UserID != userID {
+		return nil, ErrVoiceCommandNotFound
+	}
+
+	matched, err := s.checkChallenge(ctx, &pending, pin, spoken)
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		pending.Attempts++
+		if pending.Attempts >= s.config.ConfirmAttempts {
+			s.redis.Del(ctx, key)
+			s.audit.LogOperation(commandID, userID, "VOICE_COMMAND_REJECTED", pending.Command.Intent)
+			return nil, ErrVoiceTooManyAttempts
+		}
+		data, err := json.Marshal(pending)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.redis.Set(ctx, key, data, redis.KeepTTL).Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrVoiceChallengeFailed
+	}
+
+	// Claim the command so a replayed confirmation cannot run it twice
+	claimed, err := s.redis.Del(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if claimed == 0 {
+		return nil, ErrVoiceCommandNotFound
+	}
+
+	s.audit.LogOperation(commandID, userID, "VOICE_COMMAND_CONFIRMED", pending.Command.Intent)
+	if pending.Command.Intent == VoiceIntentSendMoney {
+		return s.sendMoney(ctx, &pending, pin)
+	}
+	return s.blockCards(ctx, &pending)
+}
+
+// voiceProfile returns the user's primary account and transaction PIN hash,
+// failing when voice banking is switched off.
+func (s *VoiceBankingService) voiceProfile(ctx context.Context, userID string) (accountID, pinHash string, err error) {
+	var enabled bool
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(voice_banking_enabled, false), COALESCE(account_id, ''), COALESCE(transaction_pin_hash, '')
+		FROM users WHERE id::text = $1
+	`, userID).Scan(&enabled, &accountID, &pinHash)
+	if err == sql.ErrNoRows || (err == nil && !enabled) {
+		return "", "", ErrVoiceDisabled
+	}
+	return accountID, pinHash, err
+}
+
+// resolvePayee finds the saved beneficiary a spoken name refers to. A
+// nickname match wins; otherwise the name must match exactly one verified
+// account name. Only beneficiaries held with us can be paid by voice.
+func (s *VoiceBankingService) resolvePayee(ctx context.Context, userID, name string) (accountID, accountName string, err error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT b.account_id, b.account_name, COALESCE(LOWER(b.nickname) = LOWER($2), false) AS by_nickname
+		FROM beneficiaries b
+		JOIN accounts a ON a.account_id = b.account_id
+		WHERE b.user_id::text = $1 AND b.bank_code = $3
+		  AND (LOWER(b.nickname) = LOWER($2) OR b.account_name ILIKE '%' || $2 || '%')
+		ORDER BY by_nickname DESC
+		LIMIT 2
+	`, userID, name, s.bankCode)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+
+	matches := 0
+	for rows.Next() {
+		var byNickname bool
+		var id, holder string
+		if err := rows.Scan(&id, &holder, &byNickname); err != nil {
+			return "", "", err
+		}
+		if matches == 0 {
+			accountID, accountName = id, holder
+			if byNickname {
+				return accountID, accountName, nil
+			}
+		}
+		matches++
+	}
+	if err := rows.Err(); err != nil {
+		return "", "", err
+	}
+
+	switch matches {
+	case 0:
+		return "", "", ErrVoicePayeeNotFound
+	case 1:
+		return accountID, accountName, nil
+	}
+	return "", "", ErrVoicePayeeAmbiguous
+}
+
+// challenge stores pending under a new command ID and asks for the PIN, or
+// for a random phrase to be read out when the user has no PIN set. Only
+// card blocks reach here without a PIN.
+func (s *VoiceBankingService) challenge(ctx context.Context, pending *pendingVoiceCommand, pinHash, prompt string) (*VoiceCommandResponse, error) {
+	if s.redis == nil {
+		return nil, errors.New("redis unavailable")
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	commandID := hex.EncodeToString(idBytes)
+
+	challenge := &VoiceChallenge{Type: VoiceChallengePIN, ExpiresAt: time.Now().Add(s.config.CommandTTL)}
+	reply := prompt + " Enter your transaction PIN to confirm."
+	if pinHash == "" {
+		phrase, err := voicePhrase()
+		if err != nil {
+			return nil, err
+		}
+		challenge.Type, challenge.Phrase = VoiceChallengeVoice, phrase
+		reply = fmt.Sprintf("%s Say the numbers %s to confirm.", prompt, strings.Join(strings.Split(phrase, ""), " "))
+	}
+	pending.Challenge, pending.Phrase = challenge.Type, challenge.Phrase
+
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.Set(ctx, voicePendingPrefix+commandID, data, s.config.CommandTTL).Err(); err != nil {
+		return nil, err
+	}
+
+	return &VoiceCommandResponse{
+		CommandID: commandID,
+		Status:    VoiceStatusConfirmationRequired,
+		Command:   &pending.Command,
+		Reply:     reply,
+		Challenge: challenge,
+	}, nil
+}
+
+func (s *VoiceBankingService) checkChallenge(ctx context.Context, pending *pendingVoiceCommand, pin, spoken string) (bool, error) {
+	if pending.Challenge == VoiceChallengeVoice {
+		if pending.Command.Intent == VoiceIntentSendMoney {
+			return false, ErrPINNotSet
+		}
+		heard := spokenDigits(spoken)
+		return subtle.ConstantTimeCompare([]byte(heard), []byte(pending.Phrase)) == 1, nil
+	}
+
+	if pin == "" {
+		return false, nil
+	}
+	// Step-up loads the current hash and shares the PIN lockout with every
+	// other channel
+	err := s.stepUp.VerifyPIN(ctx, pending.UserID, pin)
+	if errors.Is(err, ErrInvalidPIN) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *VoiceBankingService) balanceReply(userID string, cmd *VoiceCommand) (*VoiceCommandResponse, error) {
+	accounts, err := s.accounts.userAccounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := "You have no accounts yet."
+	if len(accounts) > 0 {
+		parts := make([]string, 0, len(accounts))
+		for _, account := range accounts {
+			parts = append(parts, fmt.Sprintf("%s in account %s", formatNaira(account["availableBalance"].(int64)), maskAccountID(account["accountId"].(string))))
+		}
+		reply = "Your balance is " + strings.Join(parts, ", ") + "."
+	}
+	return &VoiceCommandResponse{Status: VoiceStatusCompleted, Command: cmd, Reply: reply, Data: accounts}, nil
+}
+
+func (s *VoiceBankingService) transactionsReply(userID string, cmd *VoiceCommand) (*VoiceCommandResponse, error) {
+	transactions, err := s.accounts.fetchRecentTransactions(userID, voiceTransactionsCount)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := "You have no transactions yet."
+	if len(transactions) > 0 {
+		parts := make([]string, 0, len(transactions))
+		for _, tx := range transactions {
+			parts = append(parts, fmt.Sprintf("%s to %s on %s", formatNaira(tx.Amount), maskAccountID(tx.MerchantID), tx.CreatedAt.In(watLocation).Format("02/01")))
+		}
+		reply = fmt.Sprintf("Your last %d transactions: %s.", len(transactions), strings.Join(parts, "; "))
+		if len(transactions) == 1 {
+			reply = fmt.Sprintf("Your last transaction: %s.", parts[0])
+		}
+	}
+	return &VoiceCommandResponse{Status: VoiceStatusCompleted, Command: cmd, Reply: reply, Data: transactions}, nil
+}
+
+// sendMoney pays the confirmed beneficiary, passing the challenge PIN on as
+// the step-up proof for amounts above the threshold
+func (s *VoiceBankingService) sendMoney(ctx context.Context, pending *pendingVoiceCommand, pin string) (*VoiceCommandResponse, error) {
+	cmd := &pending.Command
+	txID, err := s.ussd.SendMoney(ctx, pending.UserID, pending.AccountID, pending.PayeeAccount, cmd.Amount,
+		"Voice transfer to "+pending.PayeeName, models.StepUpProof{PIN: pin})
+	if err != nil {
+		s.audit.LogError(txID, pending.AccountID, err)
+		return nil, err
+	}
+
+	s.audit.LogTransfer(txID, pending.AccountID, pending.PayeeAccount, cmd.Amount, "COMPLETED")
+	return &VoiceCommandResponse{
+		Status:  VoiceStatusCompleted,
+		Command: cmd,
+		Reply:   fmt.Sprintf("Sent %s to %s.", formatNaira(cmd.Amount), pending.PayeeName),
+		Data:    map[string]string{"transactionId": txID},
+	}, nil
+}
+
+func (s *VoiceBankingService) blockCards(ctx context.Context, pending *pendingVoiceCommand) (*VoiceCommandResponse, error) {
+	cardIDs, err := s.cards.BlockUserCards(ctx, pending.UserID, "voice")
+	if err != nil {
+		return nil, err
+	}
+
+	reply := "You have no active cards to block."
+	if len(cardIDs) == 1 {
+		reply = "Your card has been blocked."
+	} else if len(cardIDs) > 1 {
+		reply = fmt.Sprintf("Your %d cards have been blocked.", len(cardIDs))
+	}
+	return &VoiceCommandResponse{
+		Status:  VoiceStatusCompleted,
+		Command: &pending.Command,
+		Reply:   reply,
+		Data:    map[string][]string{"cardIds": cardIDs},
+	}, nil
+}
+
+// sendVoiceError maps command errors to a status code and a message the
+// client can read out.
+func (s *VoiceBankingService) sendVoiceError(w http.ResponseWriter, userID string, err error) {
+	if SendStepUpErrorResponse(w, err) {
+		return
+	}
+
+	var limitErr *LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		SendLimitErrorResponse(w, limitErr)
+	case errors.Is(err, ErrVoiceDisabled), errors.Is(err, ErrVoiceTooManyAttempts):
+		SendErrorResponse(w, err.Error(), http.StatusForbidden, nil)
+	case errors.Is(err, ErrVoiceCommandNotFound), errors.Is(err, ErrVoicePayeeNotFound):
+		SendErrorResponse(w, err.Error(), http.StatusNotFound, nil)
+	case errors.Is(err, ErrVoiceIntentUnknown), errors.Is(err, ErrVoiceNoAmount), errors.Is(err, ErrVoiceNoPayee),
+		errors.Is(err, ErrVoicePayeeAmbiguous), errors.Is(err, ErrVoiceChallengeFailed):
+		SendErrorResponse(w, err.Error(), http.StatusBadRequest, nil)
+	case err.Error() == "insufficient balance":
+		SendErrorResponse(w, "Insufficient balance", http.StatusUnprocessableEntity, nil)
+	default:
+		log.Printf("[VOICE] Command failed for user %s: %v", userID, err)
+		SendErrorResponse(w, "Voice command failed", http.StatusInternalServerError, nil)
+	}
+}
+
+// decodeVoiceRequest decodes a single JSON object into dst, writing the
+// error response itself when the body is invalid.
+func decodeVoiceRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 10*1024*1024)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		SendErrorResponse(w, "Invalid request", http.StatusBadRequest, nil)
+		return false
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		SendErrorResponse(w, "Request body must only contain a single JSON object", http.StatusBadRequest, nil)
+		return false
+	}
+	return true
+}
+
+// voicePhrase returns voicePhraseDigits random digits for a VOICE challenge
+func voicePhrase() (string, error) {
+	var b strings.Builder
+	for i := 0; i < voicePhraseDigits; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(n.String())
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/ruralpay/backend/internal/config"
+	"github.com/ruralpay/backend/internal/hsm"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestVoiceService(t *testing.T) (*VoiceBankingService, sqlmock.Sqlmock, redismock.ClientMock, *MockHSM) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	redisClient, redisMock := redismock.NewClientMock()
+	mockHSM := &MockHSM{}
+	stepUp := &StepUpService{
+		db:     db,
+		redis:  redisClient,
+		hsm:    mockHSM,
+		config: &config.StepUpConfig{Threshold: 500000, PINMaxAttempts: 5, PINLockout: 30 * time.Minute},
+		audit:  hsm.NewAuditLogger(),
+	}
+	return &VoiceBankingService{
+		db:       db,
+		redis:    redisClient,
+		ussd:     &USSDService{db: db, ledger: NewDoubleLedgerService(db), limits: NewLimitsService(db), stepUp: stepUp},
+		cards:    &CardProvisioningService{db: db, audit: hsm.NewAuditLogger()},
+		stepUp:   stepUp,
+		config:   &config.VoiceConfig{CommandTTL: 2 * time.Minute, ConfirmAttempts: 3},
+		audit:    hsm.NewAuditLogger(),
+		bankCode: "999999",
+	}, mock, redisMock, mockHSM
+}
+
+func expectVoiceProfile(mock sqlmock.Sqlmock, enabled bool, pinHash string) {
+	mock.ExpectQuery("SELECT COALESCE\\(voice_banking_enabled").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"enabled", "account_id", "pin"}).AddRow(enabled, "0123456789", pinHash))
+}
+
+func TestVoiceBankingService_RunCommand(t *testing.T) {
+	t.Run("voice banking disabled", func(t *testing.T) {
+		s, mock, _, _ := newTestVoiceService(t)
+		expectVoiceProfile(mock, false, "")
+
+		_, err := s.RunCommand(context.Background(), "7", "Check my balance")
+		assert.ErrorIs(t, err, ErrVoiceDisabled)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("send money needs a transaction PIN", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestVoiceService(t)
+		expectVoiceProfile(mock, true, "")
+
+		_, err := s.RunCommand(context.Background(), "7", "Send 5000 naira to Musa")
+		assert.ErrorIs(t, err, ErrPINNotSet)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("block cards asks for a spoken phrase without a PIN", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestVoiceService(t)
+		expectVoiceProfile(mock, true, "")
+		redisMock.Regexp().ExpectSet("voice:pending:[0-9a-f]{32}", ".*", 2*time.Minute).SetVal("OK")
+
+		resp, err := s.RunCommand(context.Background(), "7", "Block my card")
+		assert.NoError(t, err)
+		assert.Equal(t, VoiceStatusConfirmationRequired, resp.Status)
+		assert.Equal(t, VoiceChallengeVoice, resp.Challenge.Type)
+		assert.Len(t, resp.Challenge.Phrase, voicePhraseDigits)
+		assert.Contains(t, resp.Reply, "Block all your active cards?")
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("ambiguous beneficiary", func(t *testing.T) {
+		s, mock, _, _ := newTestVoiceService(t)
+		expectVoiceProfile(mock, true, "hash")
+		mock.ExpectQuery("FROM beneficiaries b").
+			WithArgs("7", "musa", "999999").
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "account_name", "by_nickname"}).
+				AddRow("9000000009", "Musa Bello", false).AddRow("9000000010", "Musa Ali", false))
+
+		_, err := s.RunCommand(context.Background(), "7", "Send 5000 naira to Musa")
+		assert.ErrorIs(t, err, ErrVoicePayeeAmbiguous)
+	})
+
+	t.Run("nickname wins over account names", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestVoiceService(t)
+		expectVoiceProfile(mock, true, "hash")
+		mock.ExpectQuery("FROM beneficiaries b").
+			WithArgs("7", "mama", "999999").
+			WillReturnRows(sqlmock.NewRows([]string{"account_id", "account_name", "by_nickname"}).
+				AddRow("9000000011", "Grace Okafor", true).AddRow("9000000012", "Mama Cass Stores", false))
+		redisMock.Regexp().ExpectSet("voice:pending:[0-9a-f]{32}", ".*", 2*time.Minute).SetVal("OK")
+
+		resp, err := s.RunCommand(context.Background(), "7", "Abeg send 2k give mama")
+		assert.NoError(t, err)
+		assert.Equal(t, VoiceChallengePIN, resp.Challenge.Type)
+		assert.Contains(t, resp.Reply, "Send NGN 2000.00 to Grace Okafor (****0011)?")
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
+
+func TestVoiceBankingService_ConfirmCommand(t *testing.T) {
+	pending := pendingVoiceCommand{
+		UserID:    "7",
+		AccountID: "0123456789",
+		Command:   VoiceCommand{Intent: VoiceIntentBlockCard, Transcript: "Block my card"},
+		Challenge: VoiceChallengePIN,
+	}
+	data, _ := json.Marshal(pending)
+
+	t.Run("wrong PIN counts an attempt", func(t *testing.T) {
+		s, mock, redisMock, mockHSM := newTestVoiceService(t)
+		redisMock.ExpectGet("voice:pending:cmd1").SetVal(string(data))
+		expectPINHash(mock, redisMock)
+		mockHSM.On("VerifyPIN", "0000", "hash").Return(false, nil)
+		redisMock.ExpectIncr("pin:failures:7").SetVal(1)
+		redisMock.ExpectExpire("pin:failures:7", 30*time.Minute).SetVal(true)
+
+		retried := pending
+		retried.Attempts = 1
+		retriedData, _ := json.Marshal(retried)
+		redisMock.ExpectSet("voice:pending:cmd1", retriedData, redis.KeepTTL).SetVal("OK")
+
+		_, err := s.ConfirmCommand(context.Background(), "7", "cmd1", "0000", "")
+		assert.ErrorIs(t, err, ErrVoiceChallengeFailed)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("last attempt discards the command", func(t *testing.T) {
+		s, mock, redisMock, mockHSM := newTestVoiceService(t)
+		exhausted := pending
+		exhausted.Attempts = 2
+		exhaustedData, _ := json.Marshal(exhausted)
+		redisMock.ExpectGet("voice:pending:cmd1").SetVal(string(exhaustedData))
+		expectPINHash(mock, redisMock)
+		mockHSM.On("VerifyPIN", "0000", "hash").Return(false, nil)
+		redisMock.ExpectIncr("pin:failures:7").SetVal(2)
+		redisMock.ExpectDel("voice:pending:cmd1").SetVal(1)
+
+		_, err := s.ConfirmCommand(context.Background(), "7", "cmd1", "0000", "")
+		assert.ErrorIs(t, err, ErrVoiceTooManyAttempts)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("locked PIN is refused", func(t *testing.T) {
+		s, _, redisMock, _ := newTestVoiceService(t)
+		redisMock.ExpectGet("voice:pending:cmd1").SetVal(string(data))
+		redisMock.ExpectExists("pin:lock:7").SetVal(1)
+
+		_, err := s.ConfirmCommand(context.Background(), "7", "cmd1", "1357", "")
+		assert.ErrorIs(t, err, ErrPINLocked)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("large send needs step-up", func(t *testing.T) {
+		s, mock, redisMock, mockHSM := newTestVoiceService(t)
+		send := pendingVoiceCommand{
+			UserID:       "7",
+			AccountID:    "0123456789",
+			Command:      VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 900000},
+			PayeeAccount: "9000000009",
+			PayeeName:    "Musa Bello",
+			Challenge:    VoiceChallengePIN,
+		}
+		sendData, _ := json.Marshal(send)
+		redisMock.ExpectGet("voice:pending:cmd1").SetVal(string(sendData))
+		expectPINHash(mock, redisMock)
+		mockHSM.On("VerifyPIN", "1357", "hash").Return(true, nil)
+		redisMock.ExpectDel("pin:failures:7").SetVal(0)
+		redisMock.ExpectDel("voice:pending:cmd1").SetVal(1)
+		expectPINHash(mock, redisMock)
+		redisMock.ExpectDel("pin:failures:7").SetVal(0)
+		mock.ExpectBegin().WillReturnError(errors.New("stop after step-up"))
+
+		_, err := s.ConfirmCommand(context.Background(), "7", "cmd1", "1357", "")
+		assert.EqualError(t, err, "stop after step-up")
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("other users cannot confirm", func(t *testing.T) {
+		s, _, redisMock, _ := newTestVoiceService(t)
+		redisMock.ExpectGet("voice:pending:cmd1").SetVal(string(data))
+
+		_, err := s.ConfirmCommand(context.Background(), "8", "cmd1", "1234", "")
+		assert.ErrorIs(t, err, ErrVoiceCommandNotFound)
+	})
+
+	t.Run("spoken phrase blocks cards", func(t *testing.T) {
+		s, mock, redisMock, _ := newTestVoiceService(t)
+		spoken := pending
+		spoken.Challenge, spoken.Phrase = VoiceChallengeVoice, "4719"
+		spokenData, _ := json.Marshal(spoken)
+		redisMock.ExpectGet("voice:pending:cmd1").SetVal(string(spokenData))
+		redisMock.ExpectDel("voice:pending:cmd1").SetVal(1)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE accounts SET status").
+			WithArgs(accountStatusSuspended, "7", "active").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("UPDATE cards SET status").
+			WithArgs("blocked", "7", "active").
+			WillReturnRows(sqlmock.NewRows([]string{"card_id"}).AddRow("CARD1"))
+		mock.ExpectCommit()
+
+		resp, err := s.ConfirmCommand(context.Background(), "7", "cmd1", "", "four seven one nine")
+		assert.NoError(t, err)
+		assert.Equal(t, VoiceStatusCompleted, resp.Status)
+		assert.Equal(t, "Your card has been blocked.", resp.Reply)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("expired command", func(t *testing.T) {
+		s, _, redisMock, _ := newTestVoiceService(t)
+		redisMock.ExpectGet("voice:pending:cmd1").RedisNil()
+
+		_, err := s.ConfirmCommand(context.Background(), "7", "cmd1", "1234", "")
+		assert.ErrorIs(t, err, ErrVoiceCommandNotFound)
+	})
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Voice intents recognised in transcripts
+const (
+	VoiceIntentBalance      = "BALANCE_ENQUIRY"
+	VoiceIntentSendMoney    = "SEND_MONEY"
+	VoiceIntentTransactions = "LAST_TRANSACTIONS"
+	VoiceIntentBlockCard    = "BLOCK_CARD"
+)
+
+var (
+	ErrVoiceIntentUnknown = errors.New("command not understood")
+	ErrVoiceNoAmount      = errors.New("no amount heard")
+	ErrVoiceNoPayee       = errors.New("no beneficiary heard")
+)
+
+// VoiceCommand is a transcript parsed into a structured command. Amount is
+// in kobo and Payee is the spoken beneficiary name; both are only set for
+// SEND_MONEY.
+type VoiceCommand struct {
+	Intent     string `json:"intent"`
+	Amount     int64  `json:"amount,omitempty"`
+	Payee      string `json:"payee,omitempty"`
+	Transcript string `json:"transcript"`
+}
+
+// voiceIntentPatterns are checked in order; the first match wins. Block
+// comes first so "stop my card, don't send anything" never moves money.
+// Phrases cover English and Nigerian Pidgin.
+var voiceIntentPatterns = []struct {
+	intent  string
+	pattern *regexp.Regexp
+}{
+	{VoiceIntentBlockCard, regexp.MustCompile(`\b(block|freeze|stop|hold|disable|cancel)\b.*\bcard\b|\bcard\b.*\b(don|has|is|got)?\s*(lost|loss|missing|stolen|thief|tiff)\b`)},
+	{VoiceIntentTransactions, regexp.MustCompile(`\b(last|recent|latest)\b.*\b(transactions?|transfers?|payments?)\b|\b(transaction|account) history\b|\bmini statement\b|\bstatement\b|\bwetin i (don )?(spend|do)\b`)},
+	{VoiceIntentSendMoney, regexp.MustCompile(`\b(send|transfer|pay|give|dash|move)\b`)},
+	{VoiceIntentBalance, regexp.MustCompile(`\bbalance\b|\bhow much\b.*\b(i get|i have|dey|remain|left|for my account|in my account)\b|\bwetin remain\b|\bcheck my account\b`)},
+}
+
+var (
+	voiceNonWord      = regexp.MustCompile(`[^a-z0-9.\s]+`)
+	voiceDigitGroups  = regexp.MustCompile(`(\d),(\d{3})`)
+	voiceNairaPrefix  = regexp.MustCompile(`\b(?:n|ngn)(\d)`)
+	voiceDigitsSuffix = regexp.MustCompile(`^(\d+(?:\.\d+)?)(k|m)?$`)
+)
+
+// voiceNumberWords are the English number words, which Pidgin speakers use
+// as well.
+var voiceNumberWords = map[string]int64{
+	"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7,
+	"eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12, "thirteen": 13,
+	"fourteen": 14, "fifteen": 15, "sixteen": 16, "seventeen": 17, "eighteen": 18,
+	"nineteen": 19, "twenty": 20, "thirty": 30, "forty": 40, "fifty": 50, "sixty": 60,
+	"seventy": 70, "eighty": 80, "ninety": 90,
+}
+
+var voiceMultipliers = map[string]int64{
+	"hundred": 100, "thousand": 1_000, "k": 1_000, "grand": 1_000, "million": 1_000_000, "m": 1_000_000,
+}
+
+// voiceFillerWords are dropped when reading the beneficiary from a send
+// command.
+var voiceFillerWords = map[string]bool{
+	"abeg": true, "please": true, "pls": true, "kindly": true, "i": true, "want": true, "wan": true,
+	"to": true, "make": true, "you": true, "u": true, "help": true, "me": true, "send": true,
+	"transfer": true, "pay": true, "give": true, "dash": true, "move": true, "am": true, "naira": true,
+	"money": true, "for": true, "the": true, "of": true, "my": true, "sum": true, "now": true,
+	"and": true, "a": true, "go": true, "oya": true, "o": true, "sharp": true, "ngn": true,
+}
+
+// ParseVoiceCommand turns a transcript into a VoiceCommand. Send commands
+// must carry an amount and a beneficiary name.
+func ParseVoiceCommand(transcript string) (*VoiceCommand, error) {
+	text := normalizeTranscript(transcript)
+	cmd := &VoiceCommand{Transcript: strings.TrimSpace(transcript)}
+
+	for _, p := range voiceIntentPatterns {
+		if p.pattern.MatchString(text) {
+			cmd.Intent = p.intent
+			break
+		}
+	}
+	if cmd.Intent == "" {
+		return nil, ErrVoiceIntentUnknown
+	}
+	if cmd.Intent != VoiceIntentSendMoney {
+		return cmd, nil
+	}
+
+	words := strings.Fields(text)
+	amount, start, end, ok := parseSpokenAmount(words)
+	if !ok {
+		return nil, ErrVoiceNoAmount
+	}
+	cmd.Amount = amount
+
+	cmd.Payee = spokenPayee(append(append([]string{}, words[:start]...), words[end:]...))
+	if cmd.Payee == "" {
+		return nil, ErrVoiceNoPayee
+	}
+	return cmd, nil
+}
+
+// normalizeTranscript lower-cases the text, joins digit groups (5,000) and
+// splits currency prefixes (N5000, ₦5000) from the number.
+func normalizeTranscript(transcript string) string {
+	text := strings.ToLower(transcript)
+	text = strings.ReplaceAll(text, "₦", " n")
+	for voiceDigitGroups.MatchString(text) {
+		text = voiceDigitGroups.ReplaceAllString(text, "$1$2")
+	}
+	text = voiceNairaPrefix.ReplaceAllString(text, "$1")
+	text = strings.ReplaceAll(text, "-", " ")
+	text = voiceNonWord.ReplaceAllString(text, " ")
+
+	// Drop sentence full stops but keep decimal points
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = strings.Trim(w, ".")
+	}
+	return strings.Join(words, " ")
+}
+
+// parseSpokenAmount finds the first amount in words, spoken as digits
+// ("5000", "2.5k", "5 thousand") or as words ("two thousand five hundred"),
+// and returns it in kobo with the span of words it occupied.
+func parseSpokenAmount(words []string) (kobo int64, start, end int, ok bool) {
+	for i := 0; i < len(words); i++ {
+		if m := voiceDigitsSuffix.FindStringSubmatch(words[i]); m != nil {
+			value, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				continue
+			}
+			j := i + 1
+			multiplier := float64(1)
+			if m[2] != "" {
+				multiplier = float64(voiceMultipliers[m[2]])
+			} else {
+				// "5 thousand", "1.5 million", "2 thousand 500"
+				for j < len(words) {
+					mult, isMult := voiceMultipliers[words[j]]
+					if !isMult {
+						break
+					}
+					multiplier *= float64(mult)
+					j++
+				}
+			}
+			naira := value * multiplier
+			// A trailing smaller number completes the amount: "2 thousand 500"
+			if multiplier >= 1000 && j < len(words) {
+				if rest, err := strconv.ParseInt(words[j], 10, 64); err == nil && float64(rest) < multiplier {
+					naira += float64(rest)
+					j++
+				}
+			}
+			if naira > 0 {
+				return int64(naira*100 + 0.5), i, j, true
+			}
+			continue
+		}
+
+		if _, isNumber := voiceNumberWords[words[i]]; isNumber || words[i] == "hundred" {
+			naira, j := parseNumberWords(words, i)
+			if naira > 0 {
+				return naira * 100, i, j, true
+			}
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// parseNumberWords reads a run of number words starting at words[i], such
+// as "one hundred and fifty thousand", and returns the value and the index
+// after the run.
+func parseNumberWords(words []string, i int) (int64, int) {
+	var total, current int64
+	j := i
+	for ; j < len(words); j++ {
+		w := words[j]
+		if n, ok := voiceNumberWords[w]; ok {
+			current += n
+			continue
+		}
+		if w == "and" && j > i && j+1 < len(words) {
+			if _, next := voiceNumberWords[words[j+1]]; next {
+				continue
+			}
+		}
+		mult, ok := voiceMultipliers[w]
+		if !ok || w == "m" {
+			break
+		}
+		if current == 0 {
+			current = 1
+		}
+		if mult == 100 {
+			current *= 100
+		} else {
+			total += current * mult
+			current = 0
+		}
+	}
+	return total + current, j
+}
+
+// spokenPayee reads the beneficiary name from the words left after the
+// amount is removed. A name introduced by "to", "give" or "for" is
+// preferred; otherwise every non-filler word is used.
+func spokenPayee(words []string) string {
+	for i, w := range words {
+		if w == "to" || w == "give" || w == "for" {
+			if name := joinNameWords(words[i+1:]); name != "" {
+				return name
+			}
+		}
+	}
+	return joinNameWords(words)
+}
+
+func joinNameWords(words []string) string {
+	var name []string
+	for _, w := range words {
+		if voiceFillerWords[w] {
+			continue
+		}
+		name = append(name, w)
+	}
+	return strings.Join(name, " ")
+}
+
+// spokenDigits returns the digits read out in a transcript, whether
+// recognised as numerals ("4 7 1 9", "4719") or as words ("four seven").
+func spokenDigits(transcript string) string {
+	var b strings.Builder
+	for _, w := range strings.Fields(normalizeTranscript(transcript)) {
+		if n, ok := voiceNumberWords[w]; ok && n < 10 {
+			b.WriteString(strconv.FormatInt(n, 10))
+			continue
+		}
+		if w == "oh" {
+			b.WriteString("0")
+			continue
+		}
+		for _, r := range w {
+			if r >= '0' && r <= '9' {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVoiceCommand(t *testing.T) {
+	tests := []struct {
+		transcript string
+		want       VoiceCommand
+	}{
+		{"Check my balance", VoiceCommand{Intent: VoiceIntentBalance}},
+		{"How much I get for my account?", VoiceCommand{Intent: VoiceIntentBalance}},
+		{"Wetin remain for my account", VoiceCommand{Intent: VoiceIntentBalance}},
+		{"Show my last five transactions", VoiceCommand{Intent: VoiceIntentTransactions}},
+		{"Mini statement", VoiceCommand{Intent: VoiceIntentTransactions}},
+		{"Block my card", VoiceCommand{Intent: VoiceIntentBlockCard}},
+		{"My card don lost", VoiceCommand{Intent: VoiceIntentBlockCard}},
+		{"Send 5000 naira to Musa", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 500000, Payee: "musa"}},
+		{"Transfer N5,000 to Ada Obi", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 500000, Payee: "ada obi"}},
+		{"Send ₦2,500.50 to Chidi", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 250050, Payee: "chidi"}},
+		{"Abeg send 5k give Musa", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 500000, Payee: "musa"}},
+		{"Send two thousand five hundred naira to Bola", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 250000, Payee: "bola"}},
+		{"Pay Emeka one hundred and fifty thousand", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 15000000, Payee: "emeka"}},
+		{"Send 1.5 million to Tunde", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 150000000, Payee: "tunde"}},
+		{"Send 2 thousand 500 to Ngozi", VoiceCommand{Intent: VoiceIntentSendMoney, Amount: 250000, Payee: "ngozi"}},
+		{"Stop my card, don't send anything", VoiceCommand{Intent: VoiceIntentBlockCard}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.transcript, func(t *testing.T) {
+			cmd, err := ParseVoiceCommand(tt.transcript)
+			assert.NoError(t, err)
+			tt.want.Transcript = tt.transcript
+			assert.Equal(t, &tt.want, cmd)
+		})
+	}
+
+	errorTests := map[string]error{
+		"Good morning":          ErrVoiceIntentUnknown,
+		"Send money to Musa":    ErrVoiceNoAmount,
+		"Transfer 5000 naira":   ErrVoiceNoPayee,
+		"Abeg send 2k for me o": ErrVoiceNoPayee,
+	}
+	for transcript, wantErr := range errorTests {
+		t.Run(transcript, func(t *testing.T) {
+			_, err := ParseVoiceCommand(transcript)
+			assert.ErrorIs(t, err, wantErr)
+		})
+	}
+}
+
+func TestSpokenDigits(t *testing.T) {
+	assert.Equal(t, "4719", spokenDigits("four seven one nine"))
+	assert.Equal(t, "4719", spokenDigits("4 7 1 9."))
+	assert.Equal(t, "4719", spokenDigits("4719"))
+	assert.Equal(t, "0305", spokenDigits("Oh three zero five"))
+	assert.Equal(t, "", spokenDigits("yes please"))
+}
@@ -0,0 +1,9 @@
+-- Columns used by card counter checks and offline card sync
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS card_id VARCHAR(255);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS counter BIGINT;
+
+-- Backfill card_id from the originating card
+UPDATE transactions SET card_id = from_card_id WHERE card_id IS NULL;
+
+-- Create index for counter lookups
+CREATE INDEX IF NOT EXISTS idx_transactions_card_counter ON transactions(card_id, counter);
@@ -0,0 +1,5 @@
+-- Per-card auth key and one-time activation code for card provisioning
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS cak VARCHAR(64);
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS activation_code_hash VARCHAR(64);
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS activation_expires_at TIMESTAMP;
+ALTER TABLE cards ADD COLUMN IF NOT EXISTS activation_attempts INTEGER NOT NULL DEFAULT 0;
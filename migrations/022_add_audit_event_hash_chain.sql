@@ -0,0 +1,21 @@
+-- Hash chain columns for tamper-evident audit events
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS hash VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_hash ON audit_events(hash);
+
+-- Statuses are free text (e.g. "HSM initialized successfully"), too long for
+-- the original VARCHAR(20); one long status would fail its whole batch
+ALTER TABLE audit_events ALTER COLUMN status TYPE VARCHAR(255);
+
+-- Audit events are append-only
+CREATE OR REPLACE FUNCTION prevent_audit_event_mutation()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION prevent_audit_event_mutation();
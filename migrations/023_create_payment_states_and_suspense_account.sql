@@ -0,0 +1,14 @@
+-- Append-only payment state history written by the double ledger
+CREATE TABLE IF NOT EXISTS payment_states (
+    id SERIAL PRIMARY KEY,
+    transaction_id VARCHAR(255) NOT NULL,
+    state VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_states_transaction_id ON payment_states(transaction_id);
+
+-- Settlement suspense (nostro) account holding outbound transfers until they settle
+INSERT INTO accounts (account_name, account_id, balance, version, updated_at)
+SELECT 'Settlement Suspense', '0000000003', 0, 1, NOW()
+WHERE NOT EXISTS (SELECT 1 FROM accounts WHERE account_id = '0000000003');
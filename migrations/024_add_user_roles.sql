@@ -0,0 +1,5 @@
+-- Roles gate administrative endpoints such as the ledger trial balance
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role) WHERE role <> 'user';
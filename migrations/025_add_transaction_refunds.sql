@@ -0,0 +1,8 @@
+-- Refunds and reversals are linked to the transaction they return
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_transaction_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_original_transaction_id ON transactions(original_transaction_id);
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_status_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_status_check
+    CHECK (status IN ('PENDING', 'PROCESSING', 'COMPLETED', 'FAILED', 'CANCELLED', 'REVERSED', 'PARTIALLY_REFUNDED', 'REFUNDED', 'FAILED_ACCOUNT_NOT_FOUND', 'FAILED_ACCOUNT_NOT_ACTIVE', 'FAILED_INSUFFICIENT_BALANCE', 'FAILED_DEBIT_ERROR', 'FAILED_ISO_CONVERSION', 'FAILED_SETTLEMENT_ERROR'));
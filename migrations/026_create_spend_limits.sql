@@ -0,0 +1,59 @@
+-- Spend limits engine: per card type / KYC tier limits and period counters.
+-- All amounts are in minor units; 0 means unlimited.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS kyc_tier SMALLINT NOT NULL DEFAULT 1;
+
+UPDATE cards SET card_type = 'DEBIT' WHERE card_type = 'standard';
+ALTER TABLE cards ALTER COLUMN card_type SET DEFAULT 'DEBIT';
+
+CREATE TABLE IF NOT EXISTS limit_profiles (
+    card_type VARCHAR(20) NOT NULL CHECK (card_type IN ('DEBIT', 'CREDIT', 'PREPAID')),
+    kyc_tier SMALLINT NOT NULL,
+    per_transaction_limit BIGINT NOT NULL DEFAULT 0,
+    daily_limit BIGINT NOT NULL DEFAULT 0,
+    monthly_limit BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (card_type, kyc_tier)
+);
+
+CREATE TABLE IF NOT EXISTS kyc_tier_limits (
+    kyc_tier SMALLINT PRIMARY KEY,
+    daily_limit BIGINT NOT NULL DEFAULT 0,
+    monthly_limit BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- One row per subject and WAT day/month; a new period starts a new row,
+-- so counters reset at midnight WAT without a batch job
+CREATE TABLE IF NOT EXISTS spend_counters (
+    subject_type VARCHAR(10) NOT NULL CHECK (subject_type IN ('card', 'user')),
+    subject_id VARCHAR(255) NOT NULL,
+    period VARCHAR(10) NOT NULL CHECK (period IN ('day', 'month')),
+    period_start DATE NOT NULL,
+    amount BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (subject_type, subject_id, period, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_spend_counters_period_start ON spend_counters(period_start);
+
+INSERT INTO limit_profiles (card_type, kyc_tier, per_transaction_limit, daily_limit, monthly_limit) VALUES
+    ('DEBIT',   1,   5000000,   5000000,  30000000),
+    ('DEBIT',   2,  10000000,  20000000, 100000000),
+    ('DEBIT',   3, 100000000, 500000000,         0),
+    ('CREDIT',  1,   5000000,   5000000,  30000000),
+    ('CREDIT',  2,  10000000,  20000000, 100000000),
+    ('CREDIT',  3, 100000000, 500000000,         0),
+    ('PREPAID', 1,   2000000,   5000000,  20000000),
+    ('PREPAID', 2,   5000000,  10000000,  50000000),
+    ('PREPAID', 3,  20000000,  50000000, 200000000)
+ON CONFLICT (card_type, kyc_tier) DO NOTHING;
+
+INSERT INTO kyc_tier_limits (kyc_tier, daily_limit, monthly_limit) VALUES
+    (1,   5000000,  30000000),
+    (2,  20000000, 100000000),
+    (3, 500000000,         0)
+ON CONFLICT (kyc_tier) DO NOTHING;
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_status_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_status_check
+    CHECK (status IN ('PENDING', 'PROCESSING', 'COMPLETED', 'FAILED', 'CANCELLED', 'REVERSED', 'PARTIALLY_REFUNDED', 'REFUNDED', 'FAILED_ACCOUNT_NOT_FOUND', 'FAILED_ACCOUNT_NOT_ACTIVE', 'FAILED_INSUFFICIENT_BALANCE', 'FAILED_DEBIT_ERROR', 'FAILED_ISO_CONVERSION', 'FAILED_SETTLEMENT_ERROR', 'FAILED_LIMIT_EXCEEDED'));
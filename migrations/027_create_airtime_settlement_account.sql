@@ -0,0 +1,4 @@
+-- Airtime settlement account credited by USSD airtime purchases until vended
+INSERT INTO accounts (account_name, account_id, balance, version, updated_at)
+SELECT 'Airtime Settlement', '0000000004', 0, 1, NOW()
+WHERE NOT EXISTS (SELECT 1 FROM accounts WHERE account_id = '0000000004');
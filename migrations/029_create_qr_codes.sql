@@ -0,0 +1,21 @@
+-- Merchant QR codes and their payment state. Dynamic codes move from PENDING
+-- to PAID or EXPIRED; static codes stay ACTIVE and are paid many times.
+CREATE TABLE IF NOT EXISTS qr_codes (
+    reference VARCHAR(25) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    qr_type VARCHAR(10) NOT NULL CHECK (qr_type IN ('STATIC', 'DYNAMIC')),
+    amount BIGINT NOT NULL DEFAULT 0,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('ACTIVE', 'PENDING', 'PAID', 'EXPIRED')),
+    transaction_id VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP,
+    paid_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_qr_codes_user_id ON qr_codes(user_id);
+CREATE INDEX IF NOT EXISTS idx_qr_codes_status_expires ON qr_codes(status, expires_at);
+
+-- Channel a transaction originated from (QR, USSD, NFC, ...)
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS channel VARCHAR(20);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_reference_id ON transactions(reference_id);
@@ -0,0 +1,3 @@
+-- Voice banking opt-in and the transaction PIN used to confirm voice commands
+ALTER TABLE users ADD COLUMN IF NOT EXISTS voice_banking_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS transaction_pin_hash TEXT;
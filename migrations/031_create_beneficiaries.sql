@@ -0,0 +1,17 @@
+-- Saved transfer recipients. account_name and bank_name are the values
+-- verified by name enquiry when the beneficiary was added.
+CREATE TABLE IF NOT EXISTS beneficiaries (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    account_id VARCHAR(20) NOT NULL,
+    bank_code VARCHAR(20) NOT NULL,
+    bank_name VARCHAR(255) NOT NULL,
+    account_name VARCHAR(255) NOT NULL,
+    nickname VARCHAR(30),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, account_id, bank_code)
+);
+
+-- Nicknames are matched case-insensitively by voice and USSD
+CREATE UNIQUE INDEX IF NOT EXISTS idx_beneficiaries_user_nickname ON beneficiaries(user_id, LOWER(nickname)) WHERE nickname IS NOT NULL;
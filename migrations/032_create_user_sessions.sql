@@ -0,0 +1,33 @@
+-- Device the user last signed in from
+ALTER TABLE users ADD COLUMN IF NOT EXISTS device_id VARCHAR(255);
+
+-- One row per signed-in device. The id is carried as the sid claim of access
+-- tokens, and revoking the session invalidates every token issued under it.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(255) NOT NULL,
+    device_name VARCHAR(100),
+    ip_address VARCHAR(64),
+    user_agent VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    revoke_reason VARCHAR(50)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_device ON user_sessions(user_id, device_id) WHERE revoked_at IS NULL;
+
+-- Refresh tokens are single use; each refresh marks the presented token used
+-- and issues the next in the same session. A used token presented again
+-- revokes the session, so only SHA-256 hashes of tokens are stored.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    session_id VARCHAR(64) NOT NULL REFERENCES user_sessions(id) ON DELETE CASCADE,
+    issued_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
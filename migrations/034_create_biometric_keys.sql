@@ -0,0 +1,13 @@
+-- Biometric step-up. A device registers the public half of a key its secure
+-- hardware only uses after a biometric check; large payments are confirmed
+-- by signing a server challenge with it in place of the transaction PIN.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS biometric_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS transaction_pin_updated_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS biometric_keys (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(255) NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_id)
+);
@@ -0,0 +1,20 @@
+-- Delivery tracking for OTPs and transaction alerts. Each message is
+-- recorded when its provider accepts or refuses it, and updated from the
+-- provider's delivery reports, which quote provider_ref. Recipients are
+-- stored masked.
+CREATE TABLE IF NOT EXISTS message_deliveries (
+    id UUID PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    purpose VARCHAR(50) NOT NULL,
+    channel VARCHAR(10) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    provider_ref VARCHAR(255),
+    status VARCHAR(20) NOT NULL CHECK (status IN ('SENT', 'DELIVERED', 'FAILED')),
+    error VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_deliveries_provider_ref ON message_deliveries(provider_ref);
+CREATE INDEX IF NOT EXISTS idx_message_deliveries_user ON message_deliveries(user_id, created_at DESC);
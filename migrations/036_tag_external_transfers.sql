@@ -0,0 +1,7 @@
+-- Status reports only apply to transactions tagged as outbound external
+-- transfers. Tag the ones recorded before the channel was written; they are
+-- the only DEBIT rows without a card counter that carry request metadata.
+UPDATE transactions SET channel = 'EXTERNAL'
+WHERE channel IS NULL AND type = 'DEBIT' AND counter IS NULL AND metadata ? 'ip_address';
+
+CREATE INDEX IF NOT EXISTS idx_transactions_channel ON transactions(channel);
@@ -0,0 +1,8 @@
+-- Transactions whose funds moved but could not be settled get their own
+-- status. FAILED_SETTLEMENT_ERROR reads as unposted to card sync, which
+-- would let the card's copy be posted again.
+ALTER TABLE transactions ALTER COLUMN status TYPE VARCHAR(30);
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_status_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_status_check
+    CHECK (status IN ('PENDING', 'PROCESSING', 'COMPLETED', 'FAILED', 'CANCELLED', 'REVERSED', 'PARTIALLY_REFUNDED', 'REFUNDED', 'FAILED_ACCOUNT_NOT_FOUND', 'FAILED_ACCOUNT_NOT_ACTIVE', 'FAILED_INSUFFICIENT_BALANCE', 'FAILED_DEBIT_ERROR', 'FAILED_ISO_CONVERSION', 'FAILED_SETTLEMENT_ERROR', 'FAILED_LIMIT_EXCEEDED', 'SETTLEMENT_DEAD_LETTER'));